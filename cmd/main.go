@@ -1,20 +1,52 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"os"
+
 	"github.com/jkaninda/goma-http-provider/internal/config"
 	"github.com/jkaninda/goma-http-provider/internal/provider"
 	"github.com/jkaninda/goma-http-provider/internal/routes"
+	"github.com/jkaninda/goma-http-provider/internal/scaffold"
 	"github.com/jkaninda/logger"
 	"github.com/jkaninda/okapi"
 	"github.com/jkaninda/okapi/okapicli"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		runInit(os.Args[2:])
+		return
+	}
 	app := okapi.New()
 	// Create CLI instance
 	cli := okapicli.New(app, "Goma").
 		String("config", "c", "config.yaml", "Path to configuration file").
-		Int("port", "p", 8080, "HTTP server port")
+		Int("port", "p", 8080, "HTTP server port").
+		Bool("report-stale", "", false, "Expose stale indicators when a config reload fails").
+		Bool("health-summary", "", false, "Include the ready flag, config and route counts and last reload age in health checks").
+		String("cache-ttl", "", config.DefaultCacheTTL.String(), "Cached config lifetime, 0 means never expire").
+		Bool("strict-json", "", false, "Reject comments and trailing commas in JSON config files").
+		Bool("strict-fields", "", false, "Reject unknown fields in config files").
+		Bool("strict-tls", "", false, "Reject routes whose TLS certificates do not cover their hosts").
+		Bool("passthrough-fields", "", false, "Serve unknown top-level config file fields unchanged").
+		String("max-request-bytes", "", config.DefaultMaxRequestBytes, "Maximum request body size").
+		Int("max-concurrent-requests", "", 0, "Maximum in-flight requests across all clients, 0 means unlimited").
+		Int("max-concurrent-fetches", "", 0, "Maximum in-flight config requests per client, 0 means unlimited").
+		Int("bundle-history", "", provider.DefaultBundleHistory, "Number of recent bundles retained per configuration for deltas and pinned version reads").
+		Bool("compress-cache", "", false, "Keep cached routes and middlewares gzip compressed in memory").
+		Bool("runtime-stats", "", false, "Include Go runtime and resource stats in the stats endpoint").
+		Bool("stream-responses", "", false, "Stream config bundles to clients instead of encoding them in memory first").
+		Bool("hash-cache-keys", "", false, "Hash configuration IDs built from metadata into fixed length IDs").
+		String("audit-file", "", "", "File to append admin audit entries to").
+		String("last-good-file", "", "", "File persisting the last good configs, served when startup loading fails").
+		String("jwt-signing-key", "", "", "Ed25519 private key PEM file signing the X-Goma-Config-JWT header").
+		String("startup-mode", "", config.StartupFailFast, "Startup behavior when configs fail to load: fail-fast or degraded").
+		String("slow-load-threshold", "", "0s", "Load duration above which a load is logged and counted as slow, 0 disables it").
+		String("negative-cache-ttl", "", "0s", "How long metadata matching no configuration is answered from cache, 0 disables it").
+		String("config-watch-interval", "", "0s", "Poll interval of the config file, reconciling its configurations on change, 0 disables it").
+		String("checksum-grace", "", "0s", "How long the previous checksum still yields 304 after a reload")
 	logger.Info("Starting Goma Gateway HTTP Provider")
 	conf, err := config.New(app, cli)
 	if err != nil {
@@ -24,6 +56,9 @@ func main() {
 	if err != nil {
 		logger.Fatal("Failed to initialize HTTPProvider", "error", err)
 	}
+	httpProvider.WatchKubernetes(context.Background())
+	httpProvider.StartPeriodicReload(context.Background())
+	httpProvider.WatchProviderConfig(context.Background())
 	route := routes.New(app, httpProvider, conf.Secutity)
 	route.RegisterRoutes()
 
@@ -32,3 +67,22 @@ func main() {
 		panic(err)
 	}
 }
+
+// runInit writes an example configuration scaffold: goma-provider init [--force] [dir]
+func runInit(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	force := flags.Bool("force", false, "Overwrite existing files")
+	_ = flags.Parse(args)
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	written, err := scaffold.Write(dir, *force)
+	if err != nil {
+		logger.Fatal("Failed to write configuration scaffold", "error", err)
+	}
+	for _, file := range written {
+		logger.Info("Created", "file", file)
+	}
+	logger.Info("Configuration scaffold ready, start the provider from its directory", "dir", dir)
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/json"
+)
+
+// MarshalJSON encodes the bundle with its passthrough fields,
+// which never override a modeled field
+func (b ConfigBundle) MarshalJSON() ([]byte, error) {
+	type bundle ConfigBundle
+	data, err := json.Marshal(bundle(b))
+	if err != nil {
+		return nil, err
+	}
+	return mergeJSONFields(data, b.Extra)
+}
+
+// MarshalJSON encodes the v2 bundle, keeping the fields of the embedded bundle
+// encoder, including passthrough fields
+func (b ConfigBundleV2) MarshalJSON() ([]byte, error) {
+	var data []byte
+	var err error
+	if b.ConfigBundle != nil {
+		if data, err = json.Marshal(b.ConfigBundle); err != nil {
+			return nil, err
+		}
+	} else {
+		data = []byte("{}")
+	}
+	type v2 struct {
+		ConfigID   string   `json:"configId"`
+		Warnings   []string `json:"warnings,omitempty"`
+		StaleSince any      `json:"staleSince,omitempty"`
+	}
+	fields := v2{ConfigID: b.ConfigID, Warnings: b.Warnings}
+	if b.StaleSince != nil {
+		fields.StaleSince = b.StaleSince
+	}
+	extra, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var extraFields map[string]json.RawMessage
+	if err := json.Unmarshal(extra, &extraFields); err != nil {
+		return nil, err
+	}
+	return mergeJSONFields(data, extraFields)
+}
+
+// mergeJSONFields adds the fields to a JSON object that doesn't already define them
+func mergeJSONFields(data []byte, fields map[string]json.RawMessage) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		if _, ok := object[k]; !ok {
+			object[k] = v
+		}
+	}
+	return json.Marshal(object)
+}
@@ -1,19 +1,143 @@
 package config
 
 import (
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	goutils "github.com/jkaninda/go-utils"
+	"github.com/jkaninda/goma-http-provider/internal/middlewares"
 	"github.com/jkaninda/goma-http-provider/internal/models"
 	"github.com/jkaninda/goma-http-provider/utils"
 	"github.com/jkaninda/logger"
 	"github.com/jkaninda/okapi"
 	"github.com/jkaninda/okapi/okapicli"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+const (
+	// DefaultCacheTTL is the default lifetime of a cached configuration bundle
+	DefaultCacheTTL = 5 * time.Minute
+	// DefaultMaxRequestBytes is the default request body size limit
+	DefaultMaxRequestBytes = "4MB"
+)
+
+// Fallback levels tried in order when no configuration matches the request metadata
+const (
+	// FallbackScoped selects a default configuration whose defaultScope matches the request
+	FallbackScoped = "scoped"
+	// FallbackGlobal selects the default configuration without a defaultScope
+	FallbackGlobal = "global"
+	// FallbackEmpty serves a built-in empty bundle
+	FallbackEmpty = "empty"
+)
+
+// Metadata key conventions applied before matching
+const (
+	// MetadataKeysSnake maps tenant-id and tenantId to tenant_id
+	MetadataKeysSnake = "snake"
+	// MetadataKeysKebab maps tenant_id and tenantId to tenant-id
+	MetadataKeysKebab = "kebab"
+)
+
+// Metadata precedence policies, resolving a key set by both the configuration and its files
+const (
+	// MetadataPrecedenceConfig lets the configuration metadata override the file metadata
+	MetadataPrecedenceConfig = "config"
+	// MetadataPrecedenceFile keeps the file metadata, the configuration only fills in missing keys
+	MetadataPrecedenceFile = "file"
+)
+
+// Route conflict policies, resolving a route name defined again by a later file of a configuration
+const (
+	// RouteConflictsReplace replaces the earlier route by the later one
+	RouteConflictsReplace = "replace"
+	// RouteConflictsDeepMerge overrides the earlier route field by field with the later one
+	RouteConflictsDeepMerge = "deep-merge"
+	// RouteConflictsError fails the load
+	RouteConflictsError = "error"
+)
+
+// Duplicate default policies, applied when several configurations are the global default at load time
+const (
+	// DuplicateDefaultsFirst picks the first default by sorted ID and logs a warning
+	DuplicateDefaultsFirst = "first"
+	// DuplicateDefaultsStrict fails the load
+	DuplicateDefaultsStrict = "strict"
+)
+
+// Built-in metadata extractors
+const (
+	// ExtractorQuery extracts the query parameters
+	ExtractorQuery = "query"
+	// ExtractorHeader extracts the X-Goma-Meta- prefixed headers
+	ExtractorHeader = "header"
+)
+
+// Metadata conflict policies, applied when metadata extractors set a key to different values
+const (
+	// MetadataConflictsLastWins keeps the value of the later extractor
+	MetadataConflictsLastWins = "last-wins"
+	// MetadataConflictsReject rejects the request with 400
+	MetadataConflictsReject = "reject"
+)
+
+// DefaultMetadataExtractors is used when no metadata extractors are configured
+var DefaultMetadataExtractors = []string{ExtractorQuery, ExtractorHeader}
+
+// Route path normalization policies, applied at load time
+const (
+	// PathNormalizationStrip collapses duplicate slashes and strips trailing slashes
+	PathNormalizationStrip = "strip"
+	// PathNormalizationKeep collapses duplicate slashes and keeps trailing slashes as authored
+	PathNormalizationKeep = "keep"
+)
+
+// Metadata match strategies
+const (
+	// MatchBest selects the configuration sharing the most metadata values with the request,
+	// falling back along the fallback chain when none does
+	MatchBest = "best"
+	// MatchStrict requires every metadata key of the configuration to match the request
+	MatchStrict = "strict"
+	// MatchExact requires the request metadata to equal the configuration metadata
+	MatchExact = "exact"
+)
+
+// MatchStrategies lists the supported metadata match strategies
+var MatchStrategies = []string{MatchBest, MatchStrict, MatchExact}
+
+// Built-in configuration source types
+const (
+	SourceDirectory  = "directory"
+	SourceKubernetes = "kubernetes"
+)
+
+// Startup modes applied when configurations fail to load at startup
+const (
+	// StartupFailFast fails the provider start
+	StartupFailFast = "fail-fast"
+	// StartupDegraded starts with the configurations that loaded
+	StartupDegraded = "degraded"
+)
+
+// Operations whose auth a configuration can override
+const (
+	OperationRead   = "read"
+	OperationStats  = "stats"
+	OperationReload = "reload"
+)
+
+// DefaultFallbackChain is used when no fallback chain is configured
+var DefaultFallbackChain = []string{FallbackScoped, FallbackGlobal}
+
 type Config struct {
 	app           *okapi.Okapi
 	path          string
@@ -24,27 +148,273 @@ type Config struct {
 	Secutity      []map[string][]string
 }
 type ServerConfig struct {
-	port       int
-	enableDocs bool
-	tls        Tls
+	port            int
+	enableDocs      bool
+	tls             Tls
+	maxRequestBytes int64
+	// maxConcurrentRequests bounds in-flight requests across all clients, 0 means unlimited
+	maxConcurrentRequests int
 }
 type Tls struct {
 	Cert string
 	Key  string
+	// ClientCA verifies the client certificates presented to the server when set, without requiring them
+	ClientCA string
 }
 type (
 	ProviderConfig struct {
 		Version        string           `json:"version" yaml:"version"`
 		Configurations []*Configuration `yaml:"configurations"`
+		// Admin protects provider wide admin endpoints, which are disabled when unset
+		Admin *HTTPAuth `yaml:"admin,omitempty" json:"admin,omitempty"`
+		// Discovery scans a parent directory for tenant configurations
+		Discovery *Discovery `yaml:"discovery,omitempty" json:"discovery,omitempty"`
+		// Flags is the static feature flag state, routes with a disabled flag are not served
+		Flags map[string]bool `yaml:"flags,omitempty" json:"flags,omitempty"`
+		// Fallback is the ordered chain tried when no configuration matches
+		Fallback []string `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+		// MetadataKeys normalizes request and config metadata keys before matching,
+		// either "snake" or "kebab", keys are compared as is when unset
+		MetadataKeys string `yaml:"metadataKeys,omitempty" json:"metadataKeys,omitempty"`
+		// MetadataDefaults fills in request metadata keys absent from the request
+		MetadataDefaults map[string]string `yaml:"metadataDefaults,omitempty" json:"metadataDefaults,omitempty"`
+		// AllowedMetadataKeys lists the metadata keys requests may set through query parameters,
+		// headers or request bodies, others are ignored. Every key is allowed when unset.
+		AllowedMetadataKeys []string `yaml:"allowedMetadataKeys,omitempty" json:"allowedMetadataKeys,omitempty"`
+		// MetadataTransforms rewrites the request metadata values of a key before matching,
+		// each step applied in order to the output of the previous one
+		MetadataTransforms map[string][]MetadataTransform `yaml:"metadataTransforms,omitempty" json:"metadataTransforms,omitempty"`
+		// MetadataExtractors lists the extractors deriving request metadata in precedence order,
+		// later ones overriding the keys of earlier ones. Defaults to query then header.
+		MetadataExtractors []string `yaml:"metadataExtractors,omitempty" json:"metadataExtractors,omitempty"`
+		// MetadataConflicts handles extractors setting a key to different values, such as a query
+		// parameter and a header disagreeing, either "last-wins" or "reject", last-wins when unset
+		MetadataConflicts string `yaml:"metadataConflicts,omitempty" json:"metadataConflicts,omitempty"`
+		// MetadataPrecedence resolves a metadata key set by both a configuration and its files
+		// in the served bundle, either "config" or "file", config when unset
+		MetadataPrecedence string `yaml:"metadataPrecedence,omitempty" json:"metadataPrecedence,omitempty"`
+		// PathNormalization canonicalizes route paths at load time, either "strip" or "keep"
+		// for trailing slashes, paths are served as authored when unset
+		PathNormalization string `yaml:"pathNormalization,omitempty" json:"pathNormalization,omitempty"`
+		// WarnOrphanMiddlewares warns at load time on middleware paths matching no route
+		// and on middlewares protecting nothing
+		WarnOrphanMiddlewares bool `yaml:"warnOrphanMiddlewares,omitempty" json:"warnOrphanMiddlewares,omitempty"`
+		// RouteSecrets resolves ${env:NAME}, ${file:/path} and registered secret references
+		// in the route TLS certificates and keys of config files, which are then trusted
+		// to read the provider environment and files
+		RouteSecrets bool `yaml:"routeSecrets,omitempty" json:"routeSecrets,omitempty"`
+		// AllowPathEscape lets configurations load files symlinked from outside of their directory,
+		// and route file secrets reference any file. Files are confined to the directory when unset.
+		AllowPathEscape bool `yaml:"allowPathEscape,omitempty" json:"allowPathEscape,omitempty"`
+		// Vault reads vault://path#key secret references from HashiCorp Vault
+		Vault *Vault `yaml:"vault,omitempty" json:"vault,omitempty"`
+		// Validator runs an external validator command against each loaded bundle
+		Validator *Validator `yaml:"validator,omitempty" json:"validator,omitempty"`
+		// RouteConflicts resolves a route name defined again by a later file of a configuration,
+		// either "replace", "deep-merge" or "error". Both routes are kept when unset.
+		RouteConflicts string `yaml:"routeConflicts,omitempty" json:"routeConflicts,omitempty"`
+		// DuplicateDefaults handles several configurations marked default without a defaultScope
+		// once dynamic sources are merged in, either "first" or "strict", first when unset
+		DuplicateDefaults string `yaml:"duplicateDefaults,omitempty" json:"duplicateDefaults,omitempty"`
+		// MatchStrategy is the default metadata match strategy, best when unset
+		MatchStrategy string `yaml:"matchStrategy,omitempty" json:"matchStrategy,omitempty"`
+		// MinMatchScore is the lowest score selecting a configuration, below which the request
+		// takes the no-match path: an absolute number of shared metadata values when 1 or more,
+		// else a fraction of the request metadata keys. 0 selects any configuration sharing a value.
+		MinMatchScore float64 `yaml:"minMatchScore,omitempty" json:"minMatchScore,omitempty"`
+		// AllowedMatchStrategies bounds the strategies requests may select with ?match=,
+		// all strategies are allowed when unset
+		AllowedMatchStrategies []string `yaml:"allowedMatchStrategies,omitempty" json:"allowedMatchStrategies,omitempty"`
+		// ReloadInterval periodically reloads configurations, 0 disables it
+		ReloadInterval time.Duration `yaml:"reloadInterval,omitempty" json:"reloadInterval,omitempty"`
+		// ReloadJitter adds a random delay up to this duration to each periodic reload
+		ReloadJitter time.Duration `yaml:"reloadJitter,omitempty" json:"reloadJitter,omitempty"`
+		// QuietPeriod defers the periodic and config file watch reloads for this long after startup,
+		// changes made meanwhile being picked up by a single reload once it elapses
+		QuietPeriod time.Duration `yaml:"quietPeriod,omitempty" json:"quietPeriod,omitempty"`
+		// ReportStale exposes the X-Goma-Config-Stale header and the staleSince
+		// stats field when the last reload of a config failed
+		ReportStale bool `yaml:"-" json:"-"`
+		// HealthSummary includes a compact provider summary in every health check response
+		HealthSummary bool `yaml:"-" json:"-"`
+		// CacheTTL is the lifetime of a cached bundle, 0 means never expire
+		CacheTTL time.Duration `yaml:"-" json:"-"`
+		// StrictJSON disables comments and trailing commas in JSON config files
+		StrictJSON bool `yaml:"-" json:"-"`
+		// StrictFields rejects unknown fields in config files
+		StrictFields bool `yaml:"-" json:"-"`
+		// StrictTLS rejects bundles with a route host not covered by the route certificates
+		StrictTLS bool `yaml:"-" json:"-"`
+		// PassthroughFields serves unknown top-level config file fields unchanged
+		PassthroughFields bool `yaml:"-" json:"-"`
+		// MaxConcurrentFetches bounds in-flight config requests per client IP, 0 means unlimited
+		MaxConcurrentFetches int `yaml:"-" json:"-"`
+		// BundleHistory is the number of recent bundles retained per configuration for deltas and pinned
+		// version reads, the provider default when 0
+		BundleHistory int `yaml:"-" json:"-"`
+		// CompressCache keeps cached routes and middlewares gzip compressed in memory
+		CompressCache bool `yaml:"-" json:"-"`
+		// RuntimeStats adds Go runtime and resource stats to the stats endpoint
+		RuntimeStats bool `yaml:"-" json:"-"`
+		// StreamResponses streams config bundles to clients instead of encoding them in memory first
+		StreamResponses bool `yaml:"-" json:"-"`
+		// HashCacheKeys hashes the configuration IDs and cache keys built from metadata into
+		// fixed length IDs, so long or sensitive label values don't show up in logs and errors
+		HashCacheKeys bool `yaml:"-" json:"-"`
+		// AuditFile receives admin audit entries as JSON lines, when set
+		AuditFile string `yaml:"-" json:"-"`
+		// LastGoodFile persists the last successfully loaded bundles, served on startup
+		// when their live load fails, when set
+		LastGoodFile string `yaml:"-" json:"-"`
+		// StartupMode is fail-fast or degraded when configurations fail to load at startup
+		StartupMode string `yaml:"-" json:"-"`
+		// SlowLoadThreshold is the load duration above which a load is logged and counted as slow,
+		// 0 disables it
+		SlowLoadThreshold time.Duration `yaml:"-" json:"-"`
+		// JWTSigningKey is the PEM encoded Ed25519 private key file signing
+		// the X-Goma-Config-JWT header of config responses, when set
+		JWTSigningKey string `yaml:"-" json:"-"`
+		// ConfigFile is the path the provider config was loaded from
+		ConfigFile string `yaml:"-" json:"-"`
+		// ConfigWatchInterval polls the provider config file and reconciles its
+		// configurations when it changes, 0 disables it
+		ConfigWatchInterval time.Duration `yaml:"-" json:"-"`
+		// NegativeCacheTTL is how long a metadata set matching no configuration is
+		// answered from the negative cache, 0 disables it
+		NegativeCacheTTL time.Duration `yaml:"-" json:"-"`
+		// ChecksumGrace is how long the previous checksum of a reloaded bundle
+		// still yields 304 on If-None-Match, 0 disables it
+		ChecksumGrace time.Duration `yaml:"-" json:"-"`
 	}
 	Configuration struct {
 		ID string `yaml:"id"`
 
+		// Source is the type of source the configuration is loaded from,
+		// defaults to kubernetes when Kubernetes is set and directory otherwise
+		Source    string    `yaml:"source,omitempty" json:"source,omitempty"`
 		Directory string    `yaml:"directory"`
 		Auth      *HTTPAuth `yaml:"auth,omitempty" json:"auth,omitempty"`
 		// If the config in this path is default
-		Default  bool              `yaml:"default"`
-		Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+		Default bool `yaml:"default"`
+		// DefaultScope restricts a default config to requests carrying these metadata values
+		DefaultScope map[string]string `yaml:"defaultScope,omitempty" json:"defaultScope,omitempty"`
+		Metadata     map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+		// Tags group configurations for admin bulk operations, such as region:eu.
+		// They are not matched against request metadata.
+		Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+		// Recursive controls whether subdirectories are loaded, defaults to true
+		Recursive *bool `yaml:"recursive,omitempty" json:"recursive,omitempty"`
+		// MaxDepth limits how many subdirectory levels are loaded, 0 means unlimited
+		MaxDepth int `yaml:"maxDepth,omitempty" json:"maxDepth,omitempty"`
+		// RequireNonEmpty fails loading the configuration when it yields no routes
+		RequireNonEmpty bool `yaml:"requireNonEmpty,omitempty" json:"requireNonEmpty,omitempty"`
+		// LoadRetry retries loading the directory on filesystem errors
+		LoadRetry *LoadRetry `yaml:"loadRetry,omitempty" json:"loadRetry,omitempty"`
+		// Kubernetes loads the configuration from labeled ConfigMaps and Secrets instead of Directory
+		Kubernetes *Kubernetes `yaml:"kubernetes,omitempty" json:"kubernetes,omitempty"`
+		// HTTPClient overrides the HTTP client settings of the configuration source, such as the Kubernetes API server requests
+		HTTPClient *HTTPClient `yaml:"httpClient,omitempty" json:"httpClient,omitempty"`
+		// Canary is served instead of this configuration to a percentage of matching requests
+		Canary *Canary `yaml:"canary,omitempty" json:"canary,omitempty"`
+		// MatchStrategy overrides the default metadata match strategy for this configuration
+		MatchStrategy string `yaml:"matchStrategy,omitempty" json:"matchStrategy,omitempty"`
+		// StaleAlarm flags the configuration when it has not loaded successfully for too long
+		StaleAlarm *StaleAlarm `yaml:"staleAlarm,omitempty" json:"staleAlarm,omitempty"`
+		// MaxStaleAge stops serving a stale bundle once its last successful load is older,
+		// requests then fail with 503. Stale bundles are served indefinitely when unset.
+		MaxStaleAge time.Duration `yaml:"maxStaleAge,omitempty" json:"maxStaleAge,omitempty"`
+		// Discovered is set for configurations built by directory discovery
+		Discovered bool `yaml:"-" json:"-"`
+	}
+	// Vault is the HashiCorp Vault secret references are read from, with a token
+	// or the Kubernetes auth method
+	Vault struct {
+		// Address defaults to the VAULT_ADDR environment variable
+		Address string `yaml:"address,omitempty" json:"address,omitempty"`
+		// Token defaults to the VAULT_TOKEN environment variable and may be an env or file secret reference
+		Token string `yaml:"token,omitempty" json:"-"`
+		// Role logs in with the Kubernetes auth method and the service account token
+		Role string `yaml:"role,omitempty" json:"role,omitempty"`
+		// AuthPath is the mount of the Kubernetes auth method, defaults to kubernetes
+		AuthPath string `yaml:"authPath,omitempty" json:"authPath,omitempty"`
+		// CacheTTL is how long a read secret is reused, defaults to 5m. Secrets are read again on reload.
+		CacheTTL time.Duration `yaml:"cacheTTL,omitempty" json:"cacheTTL,omitempty"`
+	}
+	// Validator is an external command, such as a policy linter, receiving the merged bundle
+	// JSON on stdin. A non-zero exit fails the load, with the command stderr in the error.
+	Validator struct {
+		Command []string `yaml:"command" json:"command"`
+		// Timeout bounds a validator run, defaults to 10s
+		Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	}
+	// LoadRetry retries a directory load failing on a transient filesystem error, such as
+	// an NFS hiccup, waiting a backoff doubling after each attempt
+	LoadRetry struct {
+		// Attempts is the number of retries after the first load
+		Attempts int `yaml:"attempts" json:"attempts"`
+		// Backoff is the wait before the first retry, defaults to 100ms
+		Backoff time.Duration `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+		// MaxBackoff caps the wait between retries, defaults to 5s
+		MaxBackoff time.Duration `yaml:"maxBackoff,omitempty" json:"maxBackoff,omitempty"`
+	}
+	// HTTPClient holds the settings of the HTTP client a source fetches a configuration with,
+	// for a tenant behind its own proxy or serving a certificate from a private CA
+	HTTPClient struct {
+		// Timeout bounds a request, long-lived watches excepted, defaults to 30s
+		Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+		// Proxy is the URL fetches go through, such as http://proxy:3128,
+		// defaults to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+		Proxy string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+		// CAFile is a PEM bundle of CAs trusted in addition to the system ones
+		CAFile string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+	}
+	// MetadataTransform is a step of a metadata value transform pipeline, setting a single transform
+	MetadataTransform struct {
+		// Lowercase lowercases the value
+		Lowercase bool `yaml:"lowercase,omitempty" json:"lowercase,omitempty"`
+		// Trim trims the leading and trailing white space of the value
+		Trim bool `yaml:"trim,omitempty" json:"trim,omitempty"`
+		// TrimPrefix removes the prefix from the value
+		TrimPrefix string `yaml:"trimPrefix,omitempty" json:"trimPrefix,omitempty"`
+		// TrimSuffix removes the suffix from the value
+		TrimSuffix string `yaml:"trimSuffix,omitempty" json:"trimSuffix,omitempty"`
+		// Map replaces aliases by their value, such as production: prod. Other values are kept.
+		Map map[string]string `yaml:"map,omitempty" json:"map,omitempty"`
+	}
+	// StaleAlarm fires when a configuration has not loaded successfully within After
+	StaleAlarm struct {
+		After time.Duration `yaml:"after" json:"after"`
+		// FailReadiness reports the provider as not ready while the alarm fires
+		FailReadiness bool `yaml:"failReadiness,omitempty" json:"failReadiness,omitempty"`
+	}
+	// Kubernetes selects ConfigMaps, and optionally Secrets, whose data keys are config files
+	Kubernetes struct {
+		Namespace     string `yaml:"namespace" json:"namespace"`
+		LabelSelector string `yaml:"labelSelector" json:"labelSelector"`
+		// Secrets also loads matching Secrets
+		Secrets bool `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+		// APIServer defaults to the in-cluster API server
+		APIServer string `yaml:"apiServer,omitempty" json:"apiServer,omitempty"`
+		// TokenFile and CAFile default to the in-cluster service account files
+		TokenFile string `yaml:"tokenFile,omitempty" json:"tokenFile,omitempty"`
+		CAFile    string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+	}
+	// Canary defines a bundle rolled out to a stable slice of requests
+	Canary struct {
+		Directory string `yaml:"directory" json:"directory"`
+		// Percent of matching requests served the canary, from 0 to 100
+		Percent int `yaml:"percent" json:"percent"`
+		// Key is the metadata key used to bucket requests,
+		// all request metadata is used when unset
+		Key string `yaml:"key,omitempty" json:"key,omitempty"`
+	}
+	// Discovery defines a parent directory where each subdirectory is a tenant configuration
+	Discovery struct {
+		Directory string `yaml:"directory" json:"directory"`
+		// MetadataKey is set to the subdirectory name, defaults to "tenant"
+		MetadataKey string `yaml:"metadataKey,omitempty" json:"metadataKey,omitempty"`
+		// Auth applies to discovered tenants without their own auth
+		Auth *HTTPAuth `yaml:"auth,omitempty" json:"auth,omitempty"`
 	}
 	ConfigBundle struct {
 		Version     string              `json:"version" yaml:"version"`
@@ -53,11 +423,76 @@ type (
 		Metadata    map[string]string   `json:"metadata,omitempty" yaml:"metadata,omitempty"`
 		Checksum    string              `json:"checksum,omitempty" yaml:"checksum,omitempty"`
 		Timestamp   time.Time           `json:"timestamp" yaml:"timestamp"`
+		// Warnings lists deprecation warnings found while loading the bundle
+		Warnings []string `json:"-" yaml:"-"`
+		// Canary is set for bundles loaded from a canary directory
+		Canary bool `json:"-" yaml:"-"`
+		// Sources records the file each route and middleware was loaded from,
+		// and is not part of the checksum
+		Sources []Source `json:"-" yaml:"-"`
+		// Extra holds unknown top-level fields passed through to the served bundle
+		Extra map[string]json.RawMessage `json:"-" yaml:"-"`
+		// Transformations lists the changes the provider applied to the authored config files
+		Transformations []Transformation `json:"-" yaml:"-"`
+		// ModTime is the latest modification time of the config files, zero for sources without one
+		ModTime time.Time `json:"-" yaml:"-"`
+	}
+	// Transformation is a change the provider applied to a bundle on its way to the gateway
+	Transformation struct {
+		Kind   string `json:"kind" yaml:"kind"`
+		Detail string `json:"detail" yaml:"detail"`
+	}
+	// Source is the file a route or middleware of a bundle was loaded from
+	Source struct {
+		// Kind is "route" or "middleware"
+		Kind string `json:"kind" yaml:"kind"`
+		Name string `json:"name" yaml:"name"`
+		File string `json:"file" yaml:"file"`
+	}
+
+	// ConfigBundleV2 is the /api/v2 bundle shape, extending the v1 bundle
+	// with details about how it was resolved
+	ConfigBundleV2 struct {
+		*ConfigBundle `yaml:",inline"`
+		ConfigID      string     `json:"configId" yaml:"configId"`
+		Warnings      []string   `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+		StaleSince    *time.Time `json:"staleSince,omitempty" yaml:"staleSince,omitempty"`
+	}
+
+	// GomaConfigBundle is the bundle format consumed by the Goma Gateway HTTP provider client,
+	// kept apart from the internal bundle so the wire contract doesn't follow internal changes
+	GomaConfigBundle struct {
+		Version     string              `json:"version" yaml:"version"`
+		Routes      []models.Route      `json:"routes" yaml:"routes"`
+		Middlewares []models.Middleware `json:"middlewares" yaml:"middlewares"`
+		Metadata    map[string]string   `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+		Timestamp   time.Time           `json:"timestamp" yaml:"timestamp"`
+		Checksum    string              `json:"checksum" yaml:"checksum"`
+	}
+
+	// ConfigEnvelope wraps a served bundle with details about the response.
+	// Checksum is the checksum of the wrapped bundle, also served as ETag,
+	// and does not cover the envelope fields.
+	ConfigEnvelope struct {
+		ConfigID        string    `json:"configId"`
+		Checksum        string    `json:"checksum"`
+		ServerTime      time.Time `json:"serverTime"`
+		ProviderVersion string    `json:"providerVersion"`
+		Bundle          any       `json:"bundle"`
 	}
 
 	HTTPAuth struct {
 		APIKey    string     `yaml:"apiKey,omitempty"`
 		BasicAuth *BasicAuth `yaml:"basicAuth,omitempty" `
+		// Operations overrides the auth of the read, stats or reload operation
+		Operations map[string]*OperationAuth `yaml:"operations,omitempty" json:"-"`
+	}
+	// OperationAuth is the auth of a single operation of a configuration
+	OperationAuth struct {
+		// Anonymous allows the operation without credentials
+		Anonymous bool       `yaml:"anonymous,omitempty"`
+		APIKey    string     `yaml:"apiKey,omitempty"`
+		BasicAuth *BasicAuth `yaml:"basicAuth,omitempty"`
 	}
 	BasicAuth struct {
 		Username string `yaml:"username,omitempty" json:"username,omitempty"`
@@ -65,47 +500,316 @@ type (
 	}
 )
 
+// ForOperation returns the auth required by an operation, nil when it allows anonymous requests.
+// Operations without an override use the configuration auth.
+func (a *HTTPAuth) ForOperation(operation string) *HTTPAuth {
+	if a == nil {
+		return nil
+	}
+	override, ok := a.Operations[operation]
+	if !ok || override == nil {
+		return a
+	}
+	if override.Anonymous {
+		return nil
+	}
+	return &HTTPAuth{APIKey: override.APIKey, BasicAuth: override.BasicAuth}
+}
+
+// MetadataKeyOrDefault returns the metadata key set to discovered directory names
+func (d *Discovery) MetadataKeyOrDefault() string {
+	if d.MetadataKey == "" {
+		return "tenant"
+	}
+	return d.MetadataKey
+}
+
+// SourceType returns the type of source the configuration is loaded from
+func (c *Configuration) SourceType() string {
+	switch {
+	case c.Source != "":
+		return c.Source
+	case c.Kubernetes != nil:
+		return SourceKubernetes
+	default:
+		return SourceDirectory
+	}
+}
+
+// Validate checks the client settings: a non-negative timeout, an absolute proxy URL
+// and a readable CA file holding at least one PEM certificate
+func (h *HTTPClient) Validate() error {
+	if h.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if h.Proxy != "" {
+		if _, err := h.ProxyURL(); err != nil {
+			return err
+		}
+	}
+	if h.CAFile != "" {
+		if _, err := h.CertPool(nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProxyURL parses the proxy, an absolute http, https or socks5 URL
+func (h *HTTPClient) ProxyURL() (*url.URL, error) {
+	u, err := url.Parse(h.Proxy)
+	if err != nil || !slices.Contains([]string{"http", "https", "socks5"}, u.Scheme) || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, an absolute http, https or socks5 url is required", h.Proxy)
+	}
+	return u, nil
+}
+
+// CertPool returns the roots along with the CAs of the CA file, the roots defaulting to the system CAs
+func (h *HTTPClient) CertPool(roots *x509.CertPool) (*x509.CertPool, error) {
+	ca, err := os.ReadFile(h.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := roots
+	if pool == nil {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			pool = x509.NewCertPool()
+		}
+	} else {
+		pool = pool.Clone()
+	}
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid CA file %s", h.CAFile)
+	}
+	return pool, nil
+}
+
+// transforms returns the number of transforms the step sets
+func (t MetadataTransform) transforms() int {
+	n := 0
+	for _, set := range []bool{t.Lowercase, t.Trim, t.TrimPrefix != "", t.TrimSuffix != "", len(t.Map) > 0} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// IsRecursive reports whether subdirectories of the configuration directory are loaded
+func (c *Configuration) IsRecursive() bool {
+	return c.Recursive == nil || *c.Recursive
+}
+
 func (c *Config) validate() error {
-	if len(c.ProviderConf.Configurations) == 0 {
+	if discovery := c.ProviderConf.Discovery; discovery != nil {
+		if discovery.Directory == "" {
+			return fmt.Errorf("discovery: directory is required")
+		}
+		if _, err := os.Stat(discovery.Directory); os.IsNotExist(err) {
+			return fmt.Errorf("discovery: directory does not exist: %s", discovery.Directory)
+		}
+		if err := c.validateAuth(discovery.Auth); err != nil {
+			return fmt.Errorf("discovery: %w", err)
+		}
+	} else if len(c.ProviderConf.Configurations) == 0 {
 		return fmt.Errorf("at least one configuration is required")
 	}
 
+	switch c.ProviderConf.MetadataKeys {
+	case "", MetadataKeysSnake, MetadataKeysKebab:
+	default:
+		return fmt.Errorf("invalid metadataKeys %q, must be %s or %s", c.ProviderConf.MetadataKeys, MetadataKeysSnake, MetadataKeysKebab)
+	}
+
+	switch c.ProviderConf.MetadataConflicts {
+	case "", MetadataConflictsLastWins, MetadataConflictsReject:
+	default:
+		return fmt.Errorf("invalid metadataConflicts %q, must be %s or %s", c.ProviderConf.MetadataConflicts, MetadataConflictsLastWins, MetadataConflictsReject)
+	}
+
+	switch c.ProviderConf.DuplicateDefaults {
+	case "", DuplicateDefaultsFirst, DuplicateDefaultsStrict:
+	default:
+		return fmt.Errorf("invalid duplicateDefaults %q, must be %s or %s", c.ProviderConf.DuplicateDefaults, DuplicateDefaultsFirst, DuplicateDefaultsStrict)
+	}
+
+	switch c.ProviderConf.RouteConflicts {
+	case "", RouteConflictsReplace, RouteConflictsDeepMerge, RouteConflictsError:
+	default:
+		return fmt.Errorf("invalid routeConflicts %q, must be %s, %s or %s", c.ProviderConf.RouteConflicts, RouteConflictsReplace, RouteConflictsDeepMerge, RouteConflictsError)
+	}
+
+	for _, key := range c.ProviderConf.AllowedMetadataKeys {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("allowedMetadataKeys must not contain empty keys")
+		}
+	}
+
+	for key, steps := range c.ProviderConf.MetadataTransforms {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("metadataTransforms must not contain empty keys")
+		}
+		for i, step := range steps {
+			if n := step.transforms(); n != 1 {
+				return fmt.Errorf("metadataTransforms: %s[%d] must set exactly one transform, got %d", key, i, n)
+			}
+		}
+	}
+
+	switch c.ProviderConf.MetadataPrecedence {
+	case "", MetadataPrecedenceConfig, MetadataPrecedenceFile:
+	default:
+		return fmt.Errorf("invalid metadataPrecedence %q, must be %s or %s", c.ProviderConf.MetadataPrecedence, MetadataPrecedenceConfig, MetadataPrecedenceFile)
+	}
+
+	switch c.ProviderConf.PathNormalization {
+	case "", PathNormalizationStrip, PathNormalizationKeep:
+	default:
+		return fmt.Errorf("invalid pathNormalization %q, must be %s or %s", c.ProviderConf.PathNormalization, PathNormalizationStrip, PathNormalizationKeep)
+	}
+
+	for _, strategy := range append([]string{c.ProviderConf.MatchStrategy}, c.ProviderConf.AllowedMatchStrategies...) {
+		if strategy != "" && !slices.Contains(MatchStrategies, strategy) {
+			return fmt.Errorf("invalid match strategy %q, must be one of %s", strategy, strings.Join(MatchStrategies, ", "))
+		}
+	}
+
+	if c.ProviderConf.MinMatchScore < 0 {
+		return fmt.Errorf("minMatchScore must not be negative")
+	}
+
+	if c.ProviderConf.ReloadInterval < 0 || c.ProviderConf.ReloadJitter < 0 {
+		return fmt.Errorf("reloadInterval and reloadJitter must not be negative")
+	}
+	if c.ProviderConf.QuietPeriod < 0 {
+		return fmt.Errorf("quietPeriod must not be negative")
+	}
+
+	if vault := c.ProviderConf.Vault; vault != nil && vault.CacheTTL < 0 {
+		return fmt.Errorf("vault: cacheTTL must not be negative")
+	}
+
+	if validator := c.ProviderConf.Validator; validator != nil {
+		if len(validator.Command) == 0 || validator.Command[0] == "" {
+			return fmt.Errorf("validator: command is required")
+		}
+		if validator.Timeout < 0 {
+			return fmt.Errorf("validator: timeout must not be negative")
+		}
+	}
+
+	if admin := c.ProviderConf.Admin; admin != nil {
+		if admin.APIKey == "" && admin.BasicAuth == nil {
+			return fmt.Errorf("admin: apiKey or basicAuth is required")
+		}
+		if err := c.validateAuth(admin); err != nil {
+			return fmt.Errorf("admin: %w", err)
+		}
+	}
+
 	defaultCount := 0
 	for i, cfg := range c.ProviderConf.Configurations {
-		if cfg.Directory == "" {
-			return fmt.Errorf("configuration[%d]: directory is required", i)
-		}
 		if len(cfg.Metadata) == 0 {
 			logger.Warn("Empty metadata", "config", i)
 		}
-		// Check if directory exists
-		if _, err := os.Stat(cfg.Directory); os.IsNotExist(err) {
-			return fmt.Errorf("configuration[%d]: directory does not exist: %s", i, cfg.Directory)
+		switch cfg.SourceType() {
+		case SourceKubernetes:
+			if k8s := cfg.Kubernetes; k8s == nil || k8s.Namespace == "" || k8s.LabelSelector == "" {
+				return fmt.Errorf("configuration[%d]: kubernetes namespace and labelSelector are required", i)
+			}
+		case SourceDirectory:
+			if cfg.Directory == "" {
+				return fmt.Errorf("configuration[%d]: directory is required", i)
+			}
+			// Check if directory exists
+			if _, err := os.Stat(cfg.Directory); os.IsNotExist(err) {
+				return fmt.Errorf("configuration[%d]: directory does not exist: %s", i, cfg.Directory)
+			}
+		}
+		if err := c.validateAuth(cfg.Auth); err != nil {
+			return err
+		}
+		if cfg.MatchStrategy != "" && !slices.Contains(MatchStrategies, cfg.MatchStrategy) {
+			return fmt.Errorf("configuration[%d]: invalid matchStrategy %q, must be one of %s", i, cfg.MatchStrategy, strings.Join(MatchStrategies, ", "))
+		}
+		if cfg.StaleAlarm != nil && cfg.StaleAlarm.After <= 0 {
+			return fmt.Errorf("configuration[%d]: staleAlarm.after must be positive", i)
 		}
-		if cfg.Auth != nil {
-			if cfg.Auth.APIKey != "" {
-				c.hasApiKeyAuth = true
+		if cfg.MaxStaleAge < 0 {
+			return fmt.Errorf("configuration[%d]: maxStaleAge must not be negative", i)
+		}
+		if cfg.MaxDepth < 0 {
+			return fmt.Errorf("configuration[%d]: maxDepth must not be negative", i)
+		}
+		if retry := cfg.LoadRetry; retry != nil && (retry.Attempts < 0 || retry.Backoff < 0 || retry.MaxBackoff < 0) {
+			return fmt.Errorf("configuration[%d]: loadRetry attempts, backoff and maxBackoff must not be negative", i)
+		}
+		if client := cfg.HTTPClient; client != nil {
+			if err := client.Validate(); err != nil {
+				return fmt.Errorf("configuration[%d]: httpClient: %w", i, err)
 			}
-			if cfg.Auth.BasicAuth != nil {
-				if cfg.Auth.BasicAuth.Username == "" || cfg.Auth.BasicAuth.Password == "" {
-					return fmt.Errorf("error, basic auth, username or password missing")
-				}
-				c.hasBasicAuth = true
+		}
+		if canary := cfg.Canary; canary != nil {
+			if _, err := os.Stat(canary.Directory); canary.Directory == "" || os.IsNotExist(err) {
+				return fmt.Errorf("configuration[%d]: canary directory does not exist: %s", i, canary.Directory)
+			}
+			if canary.Percent < 0 || canary.Percent > 100 {
+				return fmt.Errorf("configuration[%d]: canary percent must be between 0 and 100", i)
 			}
-
 		}
 
-		if cfg.Default {
+		if len(cfg.DefaultScope) > 0 && !cfg.Default {
+			return fmt.Errorf("configuration[%d]: defaultScope requires default to be true", i)
+		}
+		if cfg.Default && len(cfg.DefaultScope) == 0 {
 			defaultCount++
 		}
 	}
 
 	if defaultCount > 1 {
-		return fmt.Errorf("only one configuration can be marked as default")
+		return fmt.Errorf("only one configuration can be marked as default without a defaultScope")
+	}
+	for _, level := range c.ProviderConf.Fallback {
+		switch level {
+		case FallbackScoped, FallbackGlobal, FallbackEmpty:
+		default:
+			return fmt.Errorf("invalid fallback level: %s", level)
+		}
 	}
 
 	return nil
 }
+
+// validateAuth checks the auth credentials and records the schemes in use for the docs
+func (c *Config) validateAuth(auth *HTTPAuth) error {
+	if auth == nil {
+		return nil
+	}
+	if auth.APIKey != "" {
+		c.hasApiKeyAuth = true
+	}
+	if auth.BasicAuth != nil {
+		if auth.BasicAuth.Username == "" || auth.BasicAuth.Password == "" {
+			return fmt.Errorf("error, basic auth, username or password missing")
+		}
+		c.hasBasicAuth = true
+	}
+	for operation, override := range auth.Operations {
+		switch operation {
+		case OperationRead, OperationStats, OperationReload:
+		default:
+			return fmt.Errorf("invalid auth operation %q, must be %s, %s or %s", operation, OperationRead, OperationStats, OperationReload)
+		}
+		if override == nil || override.Anonymous {
+			continue
+		}
+		if err := c.validateAuth(auth.ForOperation(operation)); err != nil {
+			return fmt.Errorf("operation %s: %w", operation, err)
+		}
+	}
+	return nil
+}
+
 func New(app *okapi.Okapi, cli *okapicli.CLI) (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load()
@@ -123,8 +827,9 @@ func New(app *okapi.Okapi, cli *okapicli.CLI) (*Config, error) {
 			enableDocs: goutils.EnvBool("ENABLE_DOCS", true),
 			port:       goutils.EnvInt("PORT", port),
 			tls: Tls{
-				Cert: goutils.Env("TLS_CERT_PATH", ""),
-				Key:  goutils.Env("TLS_KEY_PATH", ""),
+				Cert:     goutils.Env("TLS_CERT_PATH", ""),
+				Key:      goutils.Env("TLS_KEY_PATH", ""),
+				ClientCA: goutils.Env("TLS_CLIENT_CA_PATH", ""),
 			},
 		},
 		Secutity:     []map[string][]string{},
@@ -134,17 +839,118 @@ func New(app *okapi.Okapi, cli *okapicli.CLI) (*Config, error) {
 	if err != nil {
 		return cfg, fmt.Errorf("failed to load provider config file, error=%v", err)
 	}
+	cfg.ProviderConf.ConfigFile = cfg.path
+	cfg.ProviderConf.ReportStale = goutils.EnvBool("REPORT_STALE", cli.GetBool("report-stale"))
+	cfg.ProviderConf.HealthSummary = goutils.EnvBool("HEALTH_SUMMARY", cli.GetBool("health-summary"))
+	cacheTTL, err := time.ParseDuration(goutils.Env("CACHE_TTL", cli.GetString("cache-ttl")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache ttl, error=%v", err)
+	}
+	if cacheTTL < 0 {
+		return nil, fmt.Errorf("invalid cache ttl, must not be negative")
+	}
+	cfg.ProviderConf.CacheTTL = cacheTTL
+	cfg.ProviderConf.StrictJSON = goutils.EnvBool("STRICT_JSON", cli.GetBool("strict-json"))
+	cfg.ProviderConf.StrictFields = goutils.EnvBool("STRICT_FIELDS", cli.GetBool("strict-fields"))
+	cfg.ProviderConf.StrictTLS = goutils.EnvBool("STRICT_TLS", cli.GetBool("strict-tls"))
+	cfg.ProviderConf.PassthroughFields = goutils.EnvBool("PASSTHROUGH_FIELDS", cli.GetBool("passthrough-fields"))
+	cfg.ProviderConf.MaxConcurrentFetches = goutils.EnvInt("MAX_CONCURRENT_FETCHES", cli.GetInt("max-concurrent-fetches"))
+	if cfg.ProviderConf.MaxConcurrentFetches < 0 {
+		return nil, fmt.Errorf("invalid max concurrent fetches, must not be negative")
+	}
+	cfg.ProviderConf.BundleHistory = goutils.EnvInt("BUNDLE_HISTORY", cli.GetInt("bundle-history"))
+	if cfg.ProviderConf.BundleHistory < 0 {
+		return nil, fmt.Errorf("invalid bundle history, must not be negative")
+	}
+	cfg.ProviderConf.CompressCache = goutils.EnvBool("COMPRESS_CACHE", cli.GetBool("compress-cache"))
+	cfg.ProviderConf.RuntimeStats = goutils.EnvBool("RUNTIME_STATS", cli.GetBool("runtime-stats"))
+	cfg.ProviderConf.StreamResponses = goutils.EnvBool("STREAM_RESPONSES", cli.GetBool("stream-responses"))
+	cfg.ProviderConf.HashCacheKeys = goutils.EnvBool("HASH_CACHE_KEYS", cli.GetBool("hash-cache-keys"))
+	cfg.ProviderConf.AuditFile = goutils.Env("AUDIT_FILE", cli.GetString("audit-file"))
+	cfg.ProviderConf.LastGoodFile = goutils.Env("LAST_GOOD_FILE", cli.GetString("last-good-file"))
+	cfg.ProviderConf.JWTSigningKey = goutils.Env("JWT_SIGNING_KEY_PATH", cli.GetString("jwt-signing-key"))
+	cfg.ProviderConf.StartupMode = goutils.Env("STARTUP_MODE", cli.GetString("startup-mode"))
+	if cfg.ProviderConf.StartupMode != StartupFailFast && cfg.ProviderConf.StartupMode != StartupDegraded {
+		return nil, fmt.Errorf("invalid startup mode %q, must be %s or %s", cfg.ProviderConf.StartupMode, StartupFailFast, StartupDegraded)
+	}
+	slowLoadThreshold, err := time.ParseDuration(goutils.Env("SLOW_LOAD_THRESHOLD", cli.GetString("slow-load-threshold")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid slow load threshold, error=%v", err)
+	}
+	if slowLoadThreshold < 0 {
+		return nil, fmt.Errorf("invalid slow load threshold, must not be negative")
+	}
+	cfg.ProviderConf.SlowLoadThreshold = slowLoadThreshold
+	negativeCacheTTL, err := time.ParseDuration(goutils.Env("NEGATIVE_CACHE_TTL", cli.GetString("negative-cache-ttl")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid negative cache ttl, error=%v", err)
+	}
+	if negativeCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid negative cache ttl, must not be negative")
+	}
+	cfg.ProviderConf.NegativeCacheTTL = negativeCacheTTL
+	configWatchInterval, err := time.ParseDuration(goutils.Env("CONFIG_WATCH_INTERVAL", cli.GetString("config-watch-interval")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config watch interval, error=%v", err)
+	}
+	if configWatchInterval < 0 {
+		return nil, fmt.Errorf("invalid config watch interval, must not be negative")
+	}
+	cfg.ProviderConf.ConfigWatchInterval = configWatchInterval
+	checksumGrace, err := time.ParseDuration(goutils.Env("CHECKSUM_GRACE", cli.GetString("checksum-grace")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum grace, error=%v", err)
+	}
+	if checksumGrace < 0 {
+		return nil, fmt.Errorf("invalid checksum grace, must not be negative")
+	}
+	cfg.ProviderConf.ChecksumGrace = checksumGrace
+	maxRequestBytes, err := goutils.ConvertToBytes(goutils.Env("MAX_REQUEST_BYTES", cli.GetString("max-request-bytes")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid max request bytes, error=%v", err)
+	}
+	cfg.server.maxRequestBytes = maxRequestBytes
+	cfg.server.maxConcurrentRequests = goutils.EnvInt("MAX_CONCURRENT_REQUESTS", cli.GetInt("max-concurrent-requests"))
+	if cfg.server.maxConcurrentRequests < 0 {
+		return nil, fmt.Errorf("invalid max concurrent requests, must not be negative")
+	}
 	if err := cfg.initialize(); err != nil {
 		return nil, err
 	}
 	cfg.enableDocs()
 	return cfg, nil
 }
+
+// ReadProviderConfig reads and validates the provider config file at path,
+// without the settings only set by flags and environment variables
+func ReadProviderConfig(path string) (*ProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider config file: %w", err)
+	}
+	conf := &ProviderConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, conf)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, conf)
+	default:
+		return nil, fmt.Errorf("unsupported provider config file format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse provider config file: %w", err)
+	}
+	if err := (&Config{ProviderConf: conf}).validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
 func (c *Config) initialize() error {
 
 	// Init TLS
 	if len(c.server.tls.Cert) > 0 && len(c.server.tls.Key) > 0 {
-		tls, err := okapi.LoadTLSConfig(c.server.tls.Cert, c.server.tls.Key, "", false)
+		tls, err := okapi.LoadTLSConfig(c.server.tls.Cert, c.server.tls.Key, c.server.tls.ClientCA, false)
 		if err != nil {
 			return fmt.Errorf("failed to load tls, error=%v", err)
 		} else {
@@ -157,6 +963,11 @@ func (c *Config) initialize() error {
 	}
 	addr := fmt.Sprintf(":%d", c.server.port)
 	c.app.With(okapi.WithAddr(addr))
+	c.app.Use(middlewares.MaxBytes(c.server.maxRequestBytes))
+	// Operators must still reach the admin endpoints, reloads and probes while load is shed,
+	// and idle watches must not hold in-flight slots
+	exempt := append(slices.Clone(middlewares.OperatorPaths), middlewares.LongPollPaths...)
+	c.app.Use(middlewares.InFlightLimit(c.server.maxConcurrentRequests, exempt...))
 
 	if err := c.validate(); err != nil {
 		return err
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+)
+
+// EncodeJSON streams the bundle as JSON to w, encoding routes and middlewares one at a time
+// so the encoded bundle is never held in memory as a whole. It encodes the same object as
+// MarshalJSON, followed by a newline like json.Encoder.
+func (b *ConfigBundle) EncodeJSON(w io.Writer) error {
+	return b.encodeJSON(w, b.Extra)
+}
+
+// EncodeJSON streams the v2 bundle as JSON to w, see ConfigBundle.EncodeJSON
+func (b *ConfigBundleV2) EncodeJSON(w io.Writer) error {
+	if b.ConfigBundle == nil {
+		return json.NewEncoder(w).Encode(b)
+	}
+	// The v2 fields never override a field of the embedded bundle, passthrough fields included
+	extra := maps.Clone(b.Extra)
+	if extra == nil {
+		extra = map[string]json.RawMessage{}
+	}
+	add := func(key string, v any) error {
+		if _, ok := extra[key]; ok {
+			return nil
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		extra[key] = data
+		return nil
+	}
+	if err := add("configId", b.ConfigID); err != nil {
+		return err
+	}
+	if len(b.Warnings) > 0 {
+		if err := add("warnings", b.Warnings); err != nil {
+			return err
+		}
+	}
+	if b.StaleSince != nil {
+		if err := add("staleSince", b.StaleSince); err != nil {
+			return err
+		}
+	}
+	return b.ConfigBundle.encodeJSON(w, extra)
+}
+
+// encodeJSON streams the bundle fields followed by the extra fields it doesn't define
+func (b *ConfigBundle) encodeJSON(w io.Writer, extra map[string]json.RawMessage) error {
+	e := &objectEncoder{w: w, enc: json.NewEncoder(w), written: map[string]struct{}{}}
+	e.write("{")
+	e.field("version", b.Version)
+	encodeArray(e, "routes", b.Routes)
+	encodeArray(e, "middlewares", b.Middlewares)
+	if len(b.Metadata) > 0 {
+		e.field("metadata", b.Metadata)
+	}
+	if b.Checksum != "" {
+		e.field("checksum", b.Checksum)
+	}
+	e.field("timestamp", b.Timestamp)
+	for _, k := range slices.Sorted(maps.Keys(extra)) {
+		if _, ok := e.written[k]; !ok {
+			e.key(k)
+			e.write(string(extra[k]))
+		}
+	}
+	e.write("}\n")
+	return e.err
+}
+
+// objectEncoder writes the members of a JSON object, keeping the first write error.
+// Values go through a json.Encoder, which reuses its encoding buffers across values.
+type objectEncoder struct {
+	w       io.Writer
+	enc     *json.Encoder
+	err     error
+	written map[string]struct{}
+}
+
+func (e *objectEncoder) write(s string) {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+// value writes an encoded value, followed by the newline json.Encoder ends values with
+func (e *objectEncoder) value(v any) {
+	if e.err == nil {
+		e.err = e.enc.Encode(v)
+	}
+}
+
+// key writes the member name, preceded by a comma after the first member
+func (e *objectEncoder) key(k string) {
+	if len(e.written) > 0 {
+		e.write(",")
+	}
+	e.written[k] = struct{}{}
+	e.value(k)
+	e.write(":")
+}
+
+func (e *objectEncoder) field(k string, v any) {
+	e.key(k)
+	e.value(v)
+}
+
+// encodeArray writes an array member one element at a time, nil slices as null
+func encodeArray[T any](e *objectEncoder, k string, items []T) {
+	e.key(k)
+	if items == nil {
+		e.write("null")
+		return
+	}
+	e.write("[")
+	for i := range items {
+		if i > 0 {
+			e.write(",")
+		}
+		e.value(&items[i])
+	}
+	e.write("]")
+}
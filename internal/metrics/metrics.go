@@ -0,0 +1,327 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets covers latencies from sub-millisecond to several seconds
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	// GetConfigDuration tracks GetConfig request latency by configuration and outcome
+	GetConfigDuration = NewHistogram("goma_provider_get_config_duration_seconds",
+		"GetConfig request latency in seconds", DefaultBuckets, "config_id", "outcome")
+	// ReloadDuration tracks full reload latency by outcome
+	ReloadDuration = NewHistogram("goma_provider_reload_duration_seconds",
+		"Configuration reload latency in seconds", DefaultBuckets, "outcome")
+	// LoadDuration tracks the directory load step by configuration and outcome
+	LoadDuration = NewHistogram("goma_provider_load_duration_seconds",
+		"Configuration directory load latency in seconds", DefaultBuckets, "config_id", "outcome")
+	// ConfigLastChange tracks when the checksum of each configuration last changed
+	ConfigLastChange = NewGauge("goma_provider_config_last_change_timestamp_seconds",
+		"Unix time of the last checksum change of the configuration", "config_id")
+	// DefaultFallbacks counts the requests served a fallback configuration because no configuration matched
+	DefaultFallbacks = NewCounter("goma_provider_default_fallbacks_total",
+		"Requests served a fallback configuration because no configuration matched", "level", "config_id")
+)
+
+// collector is a metric that can write itself in the Prometheus text format
+type collector interface {
+	write(w io.Writer) error
+}
+
+var (
+	registry   []collector
+	registryMu sync.Mutex
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// Write writes all registered metrics in the Prometheus text exposition format
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+
+	for _, c := range collectors {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram is a labeled histogram with fixed buckets
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogram creates and registers a histogram
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+// Observe records a value for the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// ObserveSince records the time elapsed since start in seconds
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of observations for the given label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, upper := range h.buckets {
+			labels := formatLabels(h.labels, s.labelValues, "le", formatFloat(upper))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i]); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(h.labels, s.labelValues, "le", "+Inf")
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count); err != nil {
+			return err
+		}
+		labels = formatLabels(h.labels, s.labelValues)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, labels, formatFloat(s.sum), h.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge is a labeled value that can go up and down
+type Gauge struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*gaugeSeries
+}
+
+type gaugeSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// NewGauge creates and registers a gauge
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*gaugeSeries),
+	}
+	register(g)
+	return g
+}
+
+// Set sets the value for the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.series[key]
+	if !ok {
+		s = &gaugeSeries{labelValues: labelValues}
+		g.series[key] = s
+	}
+	s.value = value
+}
+
+// SetToTime sets the value to the Unix time t in seconds
+func (g *Gauge) SetToTime(t time.Time, labelValues ...string) {
+	g.Set(float64(t.UnixNano())/float64(time.Second), labelValues...)
+}
+
+// Value returns the value for the given label values, and whether it was set
+func (g *Gauge) Value(labelValues ...string) (float64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s, ok := g.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.value, true
+	}
+	return 0, false
+}
+
+func (g *Gauge) write(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(g.series))
+	for k := range g.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := g.series[k]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, s.labelValues), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counter is a labeled value that only goes up
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*gaugeSeries
+}
+
+// NewCounter creates and registers a counter
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*gaugeSeries),
+	}
+	register(c)
+	return c
+}
+
+// Inc increments the value for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &gaugeSeries{labelValues: labelValues}
+		c.series[key] = s
+	}
+	s.value++
+}
+
+// Value returns the value for the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(c.series))
+	for k := range c.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := c.series[k]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatLabels renders label pairs, with optional extra name/value pairs appended
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, escapeLabel(value)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabel drops characters that %q would escape differently than Prometheus expects
+func escapeLabel(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+func formatFloat(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Test latency", DefaultBuckets, "config_id", "outcome")
+	h.ObserveSince(time.Now().Add(-3*time.Millisecond), "env=prod", "hit")
+	h.Observe(0.0002, "env=prod", "hit")
+
+	if got := h.Count("env=prod", "hit"); got != 2 {
+		t.Fatalf("expected 2 observations, got %d", got)
+	}
+	if got := h.Count("env=prod", "miss"); got != 0 {
+		t.Fatalf("expected no observations for another outcome, got %d", got)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{config_id="env=prod",outcome="hit",le="0.0005"} 1`,
+		`test_duration_seconds_bucket{config_id="env=prod",outcome="hit",le="+Inf"} 2`,
+		`test_duration_seconds_count{config_id="env=prod",outcome="hit"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q\n%s", want, out)
+		}
+	}
+}
+
+func TestGaugeSet(t *testing.T) {
+	g := NewGauge("test_last_change_timestamp_seconds", "Test timestamp", "config_id")
+	if _, ok := g.Value("env=prod"); ok {
+		t.Fatal("expected no value before Set")
+	}
+	g.Set(1, "env=prod")
+	g.SetToTime(time.Unix(1700000000, 0), "env=prod")
+
+	if got, ok := g.Value("env=prod"); !ok || got != 1700000000 {
+		t.Fatalf("expected the last set value, got %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE test_last_change_timestamp_seconds gauge",
+		`test_last_change_timestamp_seconds{config_id="env=prod"} 1.7e+09`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q\n%s", want, out)
+		}
+	}
+}
+
+func TestCounterInc(t *testing.T) {
+	c := NewCounter("test_fallbacks_total", "Test counter", "level")
+	c.Inc("global")
+	c.Inc("global")
+	if got := c.Value("global"); got != 2 {
+		t.Fatalf("expected 2, got %v", got)
+	}
+	if got := c.Value("empty"); got != 0 {
+		t.Fatalf("expected 0 for an unseen series, got %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE test_fallbacks_total counter",
+		`test_fallbacks_total{level="global"} 2`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected output to contain %q\n%s", want, buf.String())
+		}
+	}
+}
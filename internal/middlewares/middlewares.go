@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/okapi"
+)
+
+// MaxBytes limits request bodies to the given number of bytes.
+// Requests declaring a larger Content-Length are rejected with 413,
+// other bodies are wrapped so reading past the limit fails.
+func MaxBytes(limit int64) okapi.Middleware {
+	return func(next okapi.HandlerFunc) okapi.HandlerFunc {
+		return func(c *okapi.Context) error {
+			if limit <= 0 {
+				return next(c)
+			}
+			r := c.Request()
+			if r.ContentLength > limit {
+				return c.AbortRequestEntityTooLarge("Request body too large",
+					fmt.Errorf("request body exceeds %d bytes", limit))
+			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(c.ResponseWriter(), r.Body, limit)
+			}
+			return next(c)
+		}
+	}
+}
+
+// IsBodyTooLarge reports whether err was caused by reading past the MaxBytes limit
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// ConcurrencyLimit bounds the number of in-flight requests per client IP.
+// Requests beyond the limit are rejected with 429, a limit of 0 disables it.
+func ConcurrencyLimit(limit int) okapi.Middleware {
+	var mu sync.Mutex
+	inFlight := map[string]int{}
+	return func(next okapi.HandlerFunc) okapi.HandlerFunc {
+		return func(c *okapi.Context) error {
+			if limit <= 0 {
+				return next(c)
+			}
+			client := c.RealIP()
+			mu.Lock()
+			if inFlight[client] >= limit {
+				mu.Unlock()
+				return c.AbortTooManyRequests("Too many concurrent requests",
+					fmt.Errorf("client exceeds %d concurrent requests", limit))
+			}
+			inFlight[client]++
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				if inFlight[client]--; inFlight[client] == 0 {
+					delete(inFlight, client)
+				}
+				mu.Unlock()
+			}()
+			return next(c)
+		}
+	}
+}
+
+// InFlightRetryAfter is the Retry-After advertised when the in-flight limit is reached
+const InFlightRetryAfter = time.Second
+
+// OperatorPaths are the path prefixes of the endpoints operators intervene with: the admin
+// endpoints, the reloads and the health probe. They stay reachable while load is shed.
+var OperatorPaths = []string{"/api/v1/admin", "/api/v1/config/reload", "/api/v2/config/reload", "/healthz"}
+
+// LongPollPaths are the path prefixes of the watch endpoints, which hold the request until the
+// configuration changes and would otherwise exhaust the in-flight limit while idle. They stay
+// bounded by the per-client concurrency limit.
+var LongPollPaths = []string{"/api/v1/config/watch", "/api/v2/config/watch"}
+
+// InFlightLimit bounds the number of in-flight requests across all clients, shedding load
+// under a thundering herd. Requests beyond the limit are rejected with 503 and a Retry-After
+// header, requests whose path starts with one of the exempt prefixes are never limited.
+// A limit of 0 disables it.
+func InFlightLimit(limit int, exempt ...string) okapi.Middleware {
+	semaphore := make(chan struct{}, max(limit, 0))
+	return func(next okapi.HandlerFunc) okapi.HandlerFunc {
+		return func(c *okapi.Context) error {
+			if limit <= 0 {
+				return next(c)
+			}
+			path := c.Request().URL.Path
+			for _, prefix := range exempt {
+				if strings.HasPrefix(path, prefix) {
+					return next(c)
+				}
+			}
+			select {
+			case semaphore <- struct{}{}:
+			default:
+				c.SetHeader("Retry-After", strconv.Itoa(int(InFlightRetryAfter.Seconds())))
+				return c.AbortServiceUnavailable("Server is busy",
+					fmt.Errorf("server exceeds %d in-flight requests", limit))
+			}
+			defer func() { <-semaphore }()
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jkaninda/okapi"
+	"github.com/jkaninda/okapi/okapitest"
+)
+
+func TestMaxBytes(t *testing.T) {
+	app := okapi.NewTestServer(t)
+	app.Use(MaxBytes(16))
+	app.Post("/validate", func(c *okapi.Context) error {
+		if _, err := io.ReadAll(c.Request().Body); err != nil {
+			if IsBodyTooLarge(err) {
+				return c.AbortRequestEntityTooLarge("Request body too large", err)
+			}
+			return c.AbortBadRequest("Invalid body", err)
+		}
+		return c.OK(okapi.M{"status": "ok"})
+	})
+
+	okapitest.POST(t, app.BaseURL+"/validate").
+		Body(strings.NewReader("small")).
+		ExpectStatusOK()
+	okapitest.POST(t, app.BaseURL+"/validate").
+		Body(strings.NewReader(strings.Repeat("x", 64))).
+		ExpectStatus(http.StatusRequestEntityTooLarge)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	const limit = 3
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	app := okapi.NewTestServer(t)
+	app.Use(ConcurrencyLimit(limit))
+	app.Get("/config", func(c *okapi.Context) error {
+		if c.Query("block") == "true" {
+			entered <- struct{}{}
+			<-release
+		}
+		return c.OK(okapi.M{"status": "ok"})
+	})
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, limit)
+	for range limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(app.BaseURL + "/config?block=true")
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			_ = resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	for range limit {
+		<-entered
+	}
+
+	okapitest.GET(t, app.BaseURL+"/config").ExpectStatus(http.StatusTooManyRequests)
+
+	close(release)
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("expected requests within the limit to succeed, got %d", status)
+		}
+	}
+	okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK()
+}
+
+func TestInFlightLimit(t *testing.T) {
+	const limit = 2
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	app := okapi.NewTestServer(t)
+	app.Use(InFlightLimit(limit, OperatorPaths...))
+	block := func(c *okapi.Context) error {
+		if c.Query("block") == "true" {
+			entered <- struct{}{}
+			<-release
+		}
+		return c.OK(okapi.M{"status": "ok"})
+	}
+	app.Get("/config", block)
+	app.Get("/api/v1/admin/stats", block)
+	app.Get("/api/v1/config/reload", block)
+	app.Get("/api/v1/config/reload/{id}", block)
+
+	var wg sync.WaitGroup
+	for range limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := http.Get(app.BaseURL + "/config?block=true"); err == nil {
+				_ = resp.Body.Close()
+			}
+		}()
+	}
+	for range limit {
+		<-entered
+	}
+
+	for range 3 {
+		okapitest.GET(t, app.BaseURL+"/config").
+			ExpectStatus(http.StatusServiceUnavailable).
+			ExpectHeader("Retry-After", "1")
+	}
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/stats").ExpectStatusOK()
+	// Operators can still reload and follow the reload
+	okapitest.GET(t, app.BaseURL+"/api/v1/config/reload").ExpectStatusOK()
+	okapitest.GET(t, app.BaseURL+"/api/v1/config/reload/job-1").ExpectStatusOK()
+
+	close(release)
+	wg.Wait()
+	okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK()
+}
@@ -30,6 +30,8 @@ type (
 		Security       Security         `yaml:"security,omitempty" json:"security,omitempty"`
 		DisableMetrics bool             `yaml:"disableMetrics,omitempty" json:"disableMetrics,omitempty"`
 		Middlewares    []string         `yaml:"middlewares,omitempty" json:"middlewares,omitempty"`
+		// Flag names the feature flag that must be enabled for the route to be served
+		Flag string `yaml:"flag,omitempty" json:"flag,omitempty"`
 	}
 	Middleware struct {
 		// Name specifies the unique name of the middleware.
@@ -81,3 +83,10 @@ type Backend struct {
 	Weight    int  `yaml:"weight,omitempty" json:"weight,omitempty"`
 	Exclusive bool `yaml:"exclusive,omitempty" json:"exclusive,omitempty"`
 }
+
+// RateLimitRule is the Rule of a rateLimit middleware
+type RateLimitRule struct {
+	// Unit is the period requestsPerUnit applies to: second, minute or hour
+	Unit            string `yaml:"unit,omitempty" json:"unit,omitempty" default:"second"`
+	RequestsPerUnit int    `yaml:"requestsPerUnit" json:"requestsPerUnit"`
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/logger"
+)
+
+// Audited admin actions
+const (
+	AuditReload     = "reload"
+	AuditDrainEnter = "drain.enter"
+	AuditDrainExit  = "drain.exit"
+	AuditFlagSet    = "flag.set"
+	AuditRouteSet   = "route.set"
+	// AuditValidateRemote is not a mutation, but fetches an operator supplied URL
+	AuditValidateRemote = "validate.remote"
+)
+
+// AuditEntry records an admin mutation
+type AuditEntry struct {
+	Time      time.Time         `json:"time"`
+	Subject   string            `json:"subject"`
+	Action    string            `json:"action"`
+	ConfigIDs []string          `json:"configIds,omitempty"`
+	SourceIP  string            `json:"sourceIp"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// auditFileMu serializes appends to the audit file
+var auditFileMu sync.Mutex
+
+// Audit logs an admin mutation and appends it to the audit file when configured
+func (p *HTTPProvider) Audit(r *http.Request, sourceIP, action string, configIDs []string, details map[string]string) {
+	entry := AuditEntry{
+		Time:      time.Now().UTC(),
+		Subject:   requestSubject(r),
+		Action:    action,
+		ConfigIDs: configIDs,
+		SourceIP:  sourceIP,
+		Details:   details,
+	}
+	logger.Info("Audit", "subject", entry.Subject, "action", action, "configIds", configIDs, "sourceIp", sourceIP, "details", details)
+
+	if p.config.AuditFile == "" {
+		return
+	}
+	if err := appendAuditEntry(p.config.AuditFile, entry); err != nil {
+		logger.Error("Failed to write audit entry", "file", p.config.AuditFile, "error", err)
+	}
+}
+
+// appendAuditEntry appends the entry to the file as a JSON line
+func appendAuditEntry(file string, entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditFileMu.Lock()
+	defer auditFileMu.Unlock()
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// requestSubject identifies who made the request, without exposing secrets.
+// API keys are reported by a short fingerprint.
+func requestSubject(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return "basic:" + user
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return fmt.Sprintf("apikey:%s", hex.EncodeToString(sum[:])[:8])
+	}
+	return "anonymous"
+}
@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+// loadCanary loads the canary bundle of a configuration through the same pipeline as the
+// primary bundle, so that its secrets are resolved and it is validated alike.
+// A canary that fails to load keeps its previous bundle, if any.
+func (p *HTTPProvider) loadCanary(cfg *config.Configuration, previous *CachedConfig) (*CachedConfig, error) {
+	canaryCfg := *cfg
+	canaryCfg.Source = config.SourceDirectory
+	canaryCfg.Directory = cfg.Canary.Directory
+	canaryCfg.Kubernetes = nil
+	canaryCfg.Canary = nil
+	bundle, _, err := p.loadConfiguration(context.Background(), &canaryCfg)
+	if err != nil {
+		logger.Error("Failed to load canary config", "id", cfg.ID, "error", err)
+		if previous != nil && previous.Canary != nil {
+			return previous.Canary, err
+		}
+		return nil, err
+	}
+	bundle.Canary = true
+	bundle.Checksum = calculateChecksum(bundle)
+	if previous != nil && previous.Canary != nil && previous.Canary.ETag == bundle.Checksum {
+		// Unchanged content keeps the canary entry, including its timestamp
+		return previous.Canary, nil
+	}
+	bundle.Timestamp = time.Now()
+	return p.newCachedConfig(cfg.ID, bundle)
+}
+
+// inCanary reports whether the request metadata falls in the canary percentage.
+// The decision is a hash of the canary key, so the same client is stable.
+func inCanary(cfg *config.Configuration, metadata map[string]string) bool {
+	canary := cfg.Canary
+	if canary.Percent <= 0 {
+		return false
+	}
+	if canary.Percent >= 100 {
+		return true
+	}
+
+	var key string
+	if canary.Key != "" {
+		key = metadata[canary.Key]
+	} else {
+		pairs := make([]string, 0, len(metadata))
+		for k, v := range metadata {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		key = strings.Join(pairs, "&")
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(cfg.ID + "\x00" + key))
+	return int(h.Sum32()%100) < canary.Percent
+}
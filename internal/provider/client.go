@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"sync"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// configClients caches the HTTP client of each configuration, so that its connections are
+// reused across loads, change checks and watch retries. A client is rebuilt when the
+// settings it was built from change on reload.
+type configClients struct {
+	mu      sync.Mutex
+	clients map[string]configClient
+}
+
+// configClientKey holds the settings a configuration client is built from
+type configClientKey struct {
+	settings config.HTTPClient
+	// caFile is the source CA file trusted along with the settings CAs
+	caFile string
+}
+
+type configClient struct {
+	key    configClientKey
+	client *http.Client
+}
+
+// client returns the cached client of the configuration, building it when missing or when
+// key differs from the settings it was built from
+func (c *configClients) client(id string, key configClientKey, build func() (*http.Client, error)) (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.clients[id]; ok && cached.key == key {
+		return cached.client, nil
+	}
+	client, err := build()
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := c.clients[id]; ok {
+		cached.client.CloseIdleConnections()
+	}
+	if c.clients == nil {
+		c.clients = map[string]configClient{}
+	}
+	c.clients[id] = configClient{key: key, client: client}
+	return client, nil
+}
+
+// retain closes and drops the clients of the configurations no longer configured
+func (c *configClients) retain(configurations []*config.Configuration) {
+	ids := make(map[string]struct{}, len(configurations))
+	for _, cfg := range configurations {
+		ids[cfg.ID] = struct{}{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, cached := range c.clients {
+		if _, ok := ids[id]; !ok {
+			cached.client.CloseIdleConnections()
+			delete(c.clients, id)
+		}
+	}
+}
+
+// newTransport returns a transport trusting the roots, the system CAs when nil, with the proxy
+// and the additional CAs of the settings, if any
+func newTransport(settings *config.HTTPClient, roots *x509.CertPool) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if settings != nil && settings.Proxy != "" {
+		proxy, err := settings.ProxyURL()
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if settings != nil && settings.CAFile != "" {
+		var err error
+		if roots, err = settings.CertPool(roots); err != nil {
+			return nil, err
+		}
+	}
+	if roots != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	return transport, nil
+}
+
+func (c *configClients) closeIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cached := range c.clients {
+		cached.client.CloseIdleConnections()
+	}
+}
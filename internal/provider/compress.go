@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/models"
+)
+
+// compressedRoutes is the part of a bundle kept gzip compressed in memory,
+// the rest of the bundle is small and kept as is
+type compressedRoutes struct {
+	Routes      []models.Route      `json:"routes"`
+	Middlewares []models.Middleware `json:"middlewares"`
+}
+
+// newCachedConfig builds the cache entry of a bundle loaded for the configuration id,
+// compressing its routes and middlewares when cache compression is enabled
+func (p *HTTPProvider) newCachedConfig(id string, bundle *config.ConfigBundle) (*CachedConfig, error) {
+	cached := &CachedConfig{
+		configID:   id,
+		Bundle:     bundle,
+		ExpiresAt:  p.expiresAt(time.Now()),
+		ETag:       bundle.Checksum,
+		variants:   &sync.Map{},
+		routeFlags: routeFlags(bundle.Routes),
+	}
+	if p.config.CompressCache {
+		if err := cached.compress(); err != nil {
+			return nil, err
+		}
+	}
+	p.history.add(cached)
+	return cached, nil
+}
+
+// compress replaces the routes and middlewares of the cached bundle by their gzip compressed JSON
+func (c *CachedConfig) compress() error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(compressedRoutes{Routes: c.Bundle.Routes, Middlewares: c.Bundle.Middlewares}); err != nil {
+		return fmt.Errorf("failed to compress bundle %s: %w", c.ETag, err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress bundle %s: %w", c.ETag, err)
+	}
+	shell := *c.Bundle
+	shell.Routes = nil
+	shell.Middlewares = nil
+	c.Bundle = &shell
+	c.compressed = buf.Bytes()
+	return nil
+}
+
+// bundle returns the full bundle, decompressing it when the cache is compressed
+func (c *CachedConfig) bundle() (*config.ConfigBundle, error) {
+	if c.compressed == nil {
+		return c.Bundle, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(c.compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress bundle %s: %w", c.ETag, err)
+	}
+	var routes compressedRoutes
+	if err := json.NewDecoder(zr).Decode(&routes); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decompress bundle %s: %w", c.ETag, err)
+	}
+	bundle := *c.Bundle
+	bundle.Routes = routes.Routes
+	bundle.Middlewares = routes.Middlewares
+	if bundle.Routes == nil {
+		bundle.Routes = make([]models.Route, 0)
+	}
+	if bundle.Middlewares == nil {
+		bundle.Middlewares = make([]models.Middleware, 0)
+	}
+	return &bundle, nil
+}
+
+// routeFlags returns the feature flags referenced by the routes
+func routeFlags(routes []models.Route) []string {
+	var flags []string
+	for _, route := range routes {
+		if route.Flag != "" {
+			flags = appendUnique(flags, route.Flag)
+		}
+	}
+	return flags
+}
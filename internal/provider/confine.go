@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// ErrPathEscape is returned when a file referenced by a configuration resolves outside of its directory
+var ErrPathEscape = errors.New("path escapes the configuration directory")
+
+// confinePaths reports whether the files of configurations are confined to their directory
+func (p *HTTPProvider) confinePaths() bool {
+	return !p.config.AllowPathEscape
+}
+
+// realRoot returns the directory with its symlinks evaluated, or the directory itself
+// when it can't be evaluated, reading it then reports the error
+func realRoot(directory string) string {
+	if root, err := filepath.EvalSymlinks(directory); err == nil {
+		return root
+	}
+	return directory
+}
+
+// confinedPath returns the path with its symlinks evaluated, relative paths being relative to root,
+// or ErrPathEscape when it resolves outside of root, through .. elements or a symlink.
+// The symlinks of root must already be evaluated.
+func confinedPath(root, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	if !withinRoot(root, filepath.Clean(path)) {
+		return "", fmt.Errorf("%w: %s", ErrPathEscape, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(root, resolved) {
+		return "", fmt.Errorf("%w: %s resolves to %s", ErrPathEscape, path, resolved)
+	}
+	return resolved, nil
+}
+
+// withinRoot reports whether the clean path is root or below it
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// confineFileSecret rewrites a ${file:path} reference of a config file to the path it resolves to,
+// within the configuration directory. Relative paths are relative to the directory.
+func (p *HTTPProvider) confineFileSecret(cfg *config.Configuration, value string) (string, error) {
+	scheme, ref, ok := secretReference(value)
+	if !ok || scheme != "file" || !p.confinePaths() {
+		return value, nil
+	}
+	if cfg.Directory == "" {
+		return "", fmt.Errorf("%w: %s, configuration %s has no directory", ErrPathEscape, ref, cfg.ID)
+	}
+	path, err := confinedPath(realRoot(cfg.Directory), ref)
+	if err != nil {
+		return "", err
+	}
+	return "${file:" + path + "}", nil
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/models"
+)
+
+// mergeRoutes merges the routes of a file into the bundle, resolving the names
+// already defined by earlier files with the route conflicts policy
+func (p *HTTPProvider) mergeRoutes(bundle *config.ConfigBundle, path string, routes []models.Route) error {
+	policy := p.config.RouteConflicts
+	for _, route := range routes {
+		i := slices.IndexFunc(bundle.Routes, func(r models.Route) bool { return r.Name == route.Name })
+		if policy == "" || i < 0 {
+			bundle.Routes = append(bundle.Routes, route)
+			bundle.Sources = append(bundle.Sources, config.Source{Kind: "route", Name: route.Name, File: path})
+			continue
+		}
+		previous := routeSource(bundle, route.Name)
+		switch policy {
+		case config.RouteConflictsError:
+			return fmt.Errorf("route %s in %s is already defined in %s", route.Name, path, previous)
+		case config.RouteConflictsReplace:
+			bundle.Routes[i] = route
+		case config.RouteConflictsDeepMerge:
+			bundle.Routes[i] = mergeRoute(bundle.Routes[i], route)
+		}
+		setRouteSource(bundle, route.Name, path)
+		bundle.Transformations = append(bundle.Transformations, config.Transformation{
+			Kind:   TransformRouteConflict,
+			Detail: fmt.Sprintf("route %s of %s resolved over %s with %s", route.Name, path, previous, policy),
+		})
+	}
+	return nil
+}
+
+// routeSource returns the file the named route was loaded from
+func routeSource(bundle *config.ConfigBundle, name string) string {
+	for _, source := range bundle.Sources {
+		if source.Kind == "route" && source.Name == name {
+			return source.File
+		}
+	}
+	return ""
+}
+
+// setRouteSource records the file the named route is now loaded from
+func setRouteSource(bundle *config.ConfigBundle, name, file string) {
+	for i, source := range bundle.Sources {
+		if source.Kind == "route" && source.Name == name {
+			bundle.Sources[i].File = file
+			return
+		}
+	}
+}
+
+// mergeRoute overrides the base route field by field with the fields the overlay sets.
+// Hosts, methods and middlewares are merged as sets, backends by endpoint, nested
+// blocks are replaced when set. Zero values don't override, so an overlay can't
+// disable a flag the base enables.
+func mergeRoute(base, overlay models.Route) models.Route {
+	merged := base
+	override(&merged.Path, overlay.Path)
+	override(&merged.Rewrite, overlay.Rewrite)
+	override(&merged.Priority, overlay.Priority)
+	override(&merged.Enabled, overlay.Enabled)
+	override(&merged.Target, overlay.Target)
+	override(&merged.DisableMetrics, overlay.DisableMetrics)
+	override(&merged.Flag, overlay.Flag)
+	override(&merged.Maintenance, overlay.Maintenance)
+	override(&merged.TLS, overlay.TLS)
+	override(&merged.HealthCheck, overlay.HealthCheck)
+	override(&merged.Security, overlay.Security)
+	merged.Hosts = union(base.Hosts, overlay.Hosts)
+	merged.Methods = union(base.Methods, overlay.Methods)
+	merged.Middlewares = union(base.Middlewares, overlay.Middlewares)
+	merged.Backends = mergeBackends(base.Backends, overlay.Backends)
+	return merged
+}
+
+// override sets the field to the overlay value unless it is the zero value
+func override[T any](field *T, overlay T) {
+	if !reflect.ValueOf(&overlay).Elem().IsZero() {
+		*field = overlay
+	}
+}
+
+// union returns the base values followed by the overlay values it lacks
+func union(base, overlay []string) []string {
+	merged := slices.Clone(base)
+	for _, v := range overlay {
+		if !slices.Contains(merged, v) {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
+// mergeBackends replaces the base backends sharing an endpoint with an overlay backend
+// and appends the other overlay backends
+func mergeBackends(base, overlay []models.Backend) []models.Backend {
+	merged := slices.Clone(base)
+	for _, backend := range overlay {
+		i := slices.IndexFunc(merged, func(b models.Backend) bool { return b.Endpoint == backend.Endpoint })
+		if i >= 0 {
+			merged[i] = backend
+			continue
+		}
+		merged = append(merged, backend)
+	}
+	return merged
+}
@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeYAML parses a YAML config file into v.
+// With knownFields set, keys that do not map to a field are rejected.
+func decodeYAML(path string, data []byte, knownFields bool, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(knownFields)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse YAML %s: %w", path, err)
+	}
+	return nil
+}
+
+// splitYAMLDocuments returns the documents of a YAML config file. A single document
+// file is returned as is, keeping line numbers in decoding errors.
+func splitYAMLDocuments(path string, data []byte) ([][]byte, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var documents [][]byte
+	for {
+		var node yaml.Node
+		if err := dec.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse YAML %s: %w", path, err)
+		}
+		document, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML %s: %w", path, err)
+		}
+		documents = append(documents, document)
+	}
+	if len(documents) <= 1 {
+		return [][]byte{data}, nil
+	}
+	return documents, nil
+}
+
+// decodeJSON parses a JSON config file into v.
+// Unless strict is set, comments and trailing commas are tolerated.
+// With knownFields set, keys that do not map to a field are rejected.
+// Errors name the file and the line where parsing failed.
+func decodeJSON(path string, data []byte, strict, knownFields bool, v any) error {
+	if !strict {
+		data = stripTrailingCommas(stripJSONComments(data))
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if knownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return fmt.Errorf("failed to parse JSON %s (line %d): %w", path, lineAt(data, syntaxErr.Offset), err)
+		case errors.As(err, &typeErr):
+			return fmt.Errorf("failed to parse JSON %s (line %d): %w", path, lineAt(data, typeErr.Offset), err)
+		}
+		return fmt.Errorf("failed to parse JSON %s: %w", path, err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse JSON %s (line %d): unexpected data after top-level value", path, lineAt(data, dec.InputOffset()))
+	}
+	return nil
+}
+
+// stripJSONComments blanks out // and /* */ comments outside of strings.
+// Comments are replaced with spaces, keeping newlines, so byte offsets
+// and line numbers still match the original file.
+func stripJSONComments(data []byte) []byte {
+	out := bytes.Clone(data)
+	inString, escaped := false, false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			continue
+		}
+		if c != '/' || i+1 >= len(out) {
+			continue
+		}
+		switch out[i+1] {
+		case '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case '*':
+			out[i], out[i+1] = ' ', ' '
+			for i += 2; i < len(out); i++ {
+				if out[i] == '*' && i+1 < len(out) && out[i+1] == '/' {
+					out[i], out[i+1] = ' ', ' '
+					i++
+					break
+				}
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		}
+	}
+	return out
+}
+
+// stripTrailingCommas blanks out commas directly followed by a closing
+// bracket or brace, ignoring commas inside strings.
+func stripTrailingCommas(data []byte) []byte {
+	inString, escaped := false, false
+	for i, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case ',':
+			j := i + 1
+			for j < len(data) && isJSONSpace(data[j]) {
+				j++
+			}
+			if j < len(data) && (data[j] == '}' || data[j] == ']') {
+				data[i] = ' '
+			}
+		}
+	}
+	return data
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// lineAt returns the 1-based line number of the given byte offset
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// bundleFields are the top-level fields modeled by config.ConfigBundle
+var bundleFields = []string{"version", "routes", "middlewares", "metadata", "checksum", "timestamp"}
+
+// unknownFields returns the top-level fields of a config file that the bundle doesn't model
+func unknownFields(path string, data []byte, isJSON bool) (map[string]json.RawMessage, error) {
+	var raw map[string]any
+	var err error
+	if isJSON {
+		err = decodeJSON(path, data, false, false, &raw)
+	} else {
+		err = decodeYAML(path, data, false, &raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	extra := map[string]json.RawMessage{}
+	for k, v := range raw {
+		if slices.Contains(bundleFields, k) {
+			continue
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: field %s cannot be passed through: %w", path, k, err)
+		}
+		extra[k] = encoded
+	}
+	return extra, nil
+}
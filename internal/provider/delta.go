@@ -0,0 +1,219 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// DefaultBundleHistory is the number of recent bundles retained per configuration to
+// compute deltas and serve pinned versions
+const DefaultBundleHistory = 16
+
+// VersionQueryParam pins a request to a retained bundle by checksum, "latest" serves the current one
+const VersionQueryParam = "version"
+
+// VersionLatest is the version of the current bundle
+const VersionLatest = "latest"
+
+// ErrVersionNotFound is returned when a pinned version is not retained for the configuration
+var ErrVersionNotFound = errors.New("version not found")
+
+// PatchOperation is a JSON Patch (RFC 6902) operation
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// MarshalJSON omits the value of remove operations, keeping null values of the others
+func (o PatchOperation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	type operation PatchOperation
+	return json.Marshal(operation(o))
+}
+
+// bundleHistory retains the recently served cache entries of each configuration keyed by
+// checksum, in a ring buffer per configuration so that configurations do not evict each other
+type bundleHistory struct {
+	mu    sync.RWMutex
+	size  int
+	rings map[string]*bundleRing
+}
+
+type bundleRing struct {
+	order   []string
+	entries map[string]*CachedConfig
+}
+
+func newBundleHistory(size int) *bundleHistory {
+	return &bundleHistory{size: size, rings: map[string]*bundleRing{}}
+}
+
+// add retains the cache entry in the ring of its configuration, evicting the oldest one when full
+func (h *bundleHistory) add(cached *CachedConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring := h.rings[cached.configID]
+	if ring == nil {
+		ring = &bundleRing{entries: make(map[string]*CachedConfig, h.size)}
+		h.rings[cached.configID] = ring
+	}
+	if _, ok := ring.entries[cached.ETag]; ok {
+		return
+	}
+	if len(ring.order) >= h.size {
+		delete(ring.entries, ring.order[0])
+		ring.order = ring.order[1:]
+	}
+	ring.order = append(ring.order, cached.ETag)
+	ring.entries[cached.ETag] = cached
+}
+
+func (h *bundleHistory) get(id, checksum string) (*CachedConfig, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ring := h.rings[id]
+	if ring == nil {
+		return nil, false
+	}
+	cached, ok := ring.entries[checksum]
+	return cached, ok
+}
+
+// retain drops the rings of the configurations no longer loaded
+func (h *bundleHistory) retain(cache map[string]*CachedConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id := range h.rings {
+		if _, ok := cache[id]; !ok {
+			delete(h.rings, id)
+		}
+	}
+}
+
+// PreviousBundle returns a recently served bundle of the configuration by checksum, if still
+// retained. Bundles of other configurations are not served.
+func (p *HTTPProvider) PreviousBundle(id, checksum string) (*config.ConfigBundle, bool) {
+	cached, ok := p.history.get(id, checksum)
+	if !ok {
+		return nil, false
+	}
+	bundle, err := cached.bundle()
+	if err != nil {
+		return nil, false
+	}
+	return bundle, true
+}
+
+// VersionedBundle returns a retained bundle of the configuration by checksum, including
+// its canary and feature flag variants. Bundles of other configurations are not served.
+func (p *HTTPProvider) VersionedBundle(id, checksum string) (*config.ConfigBundle, error) {
+	cached, ok := p.history.get(id, checksum)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, checksum)
+	}
+	return cached.bundle()
+}
+
+// Diff returns the JSON Patch turning the JSON encoding of from into the JSON encoding of to
+func Diff(from, to any) ([]PatchOperation, error) {
+	a, err := toJSONValue(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toJSONValue(to)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]PatchOperation, 0)
+	diffValues("", a, b, &ops)
+	return ops, nil
+}
+
+func toJSONValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode bundle: %w", err)
+	}
+	return value, nil
+}
+
+func diffValues(path string, a, b any, ops *[]PatchOperation) {
+	switch av := a.(type) {
+	case map[string]any:
+		if bv, ok := b.(map[string]any); ok {
+			diffObjects(path, av, bv, ops)
+			return
+		}
+	case []any:
+		if bv, ok := b.([]any); ok {
+			diffArrays(path, av, bv, ops)
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*ops = append(*ops, PatchOperation{Op: "replace", Path: path, Value: b})
+	}
+}
+
+func diffObjects(path string, a, b map[string]any, ops *[]PatchOperation) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := path + "/" + escapePointer(k)
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inB:
+			*ops = append(*ops, PatchOperation{Op: "remove", Path: child})
+		case !inA:
+			*ops = append(*ops, PatchOperation{Op: "add", Path: child, Value: bv})
+		default:
+			diffValues(child, av, bv, ops)
+		}
+	}
+}
+
+// diffArrays patches the common prefix element wise, then appends or
+// removes the tail, removing from the end so indexes stay valid
+func diffArrays(path string, a, b []any, ops *[]PatchOperation) {
+	common := min(len(a), len(b))
+	for i := 0; i < common; i++ {
+		diffValues(fmt.Sprintf("%s/%d", path, i), a[i], b[i], ops)
+	}
+	for i := common; i < len(b); i++ {
+		*ops = append(*ops, PatchOperation{Op: "add", Path: fmt.Sprintf("%s/%d", path, i), Value: b[i]})
+	}
+	for i := len(a) - 1; i >= common; i-- {
+		*ops = append(*ops, PatchOperation{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+	}
+}
+
+// escapePointer escapes a JSON Pointer reference token
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
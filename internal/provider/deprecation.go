@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/jkaninda/logger"
+)
+
+var (
+	// deprecatedFields maps config field paths to a hint pointing to the replacement.
+	// Paths are dot separated and lists are traversed transparently,
+	// so "routes.target" matches the target of any route.
+	deprecatedFields   = map[string]string{}
+	deprecatedFieldsMu sync.RWMutex
+)
+
+// DeprecateField registers a deprecated config field path with a hint
+// pointing operators to its replacement
+func DeprecateField(path, hint string) {
+	deprecatedFieldsMu.Lock()
+	defer deprecatedFieldsMu.Unlock()
+	deprecatedFields[path] = hint
+}
+
+// checkDeprecations returns a warning for each registered deprecated field
+// used in the given config file
+func checkDeprecations(file string, data []byte, isJSON bool) []string {
+	deprecatedFieldsMu.RLock()
+	defer deprecatedFieldsMu.RUnlock()
+	if len(deprecatedFields) == 0 {
+		return nil
+	}
+
+	var raw any
+	var err error
+	if isJSON {
+		err = decodeJSON(file, data, false, false, &raw)
+	} else {
+		err = decodeYAML(file, data, false, &raw)
+	}
+	if err != nil {
+		return nil
+	}
+
+	used := map[string]struct{}{}
+	walkFieldPaths(raw, "", func(path string) {
+		if _, ok := deprecatedFields[path]; ok {
+			used[path] = struct{}{}
+		}
+	})
+
+	warnings := make([]string, 0, len(used))
+	for path := range used {
+		hint := deprecatedFields[path]
+		logger.Warn("Deprecated config field", "file", file, "field", path, "hint", hint)
+		warnings = append(warnings, fmt.Sprintf("%s is deprecated: %s", path, hint))
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// walkFieldPaths calls visit with the dot separated path of every map key in node
+func walkFieldPaths(node any, prefix string, visit func(path string)) {
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			visit(path)
+			walkFieldPaths(v, path, visit)
+		}
+	case []any:
+		for _, v := range n {
+			walkFieldPaths(v, prefix, visit)
+		}
+	}
+}
+
+// appendUnique appends the values not already present in dst
+func appendUnique(dst []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(dst, v) {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
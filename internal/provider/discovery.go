@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// discoveryMetaFile optionally defines a discovered tenant's metadata and auth
+const discoveryMetaFile = "meta.yaml"
+
+// discoverConfigurations returns the static configurations followed by one
+// configuration per tenant subdirectory of the discovery directory
+func (p *HTTPProvider) discoverConfigurations() ([]*config.Configuration, error) {
+	configurations := append([]*config.Configuration(nil), p.config.Configurations...)
+	discovery := p.config.Discovery
+	if discovery == nil {
+		return configurations, nil
+	}
+
+	entries, err := os.ReadDir(discovery.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover configurations in %s: %w", discovery.Directory, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		cfg, err := p.discoveredConfiguration(discovery, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		configurations = append(configurations, cfg)
+	}
+	return configurations, nil
+}
+
+// discoveredConfiguration builds the configuration of a tenant subdirectory.
+// Its metadata defaults to the discovery metadata key set to the directory name,
+// and is replaced by the metadata of its meta.yaml when present.
+func (p *HTTPProvider) discoveredConfiguration(discovery *config.Discovery, name string) (*config.Configuration, error) {
+	directory := filepath.Join(discovery.Directory, name)
+	cfg := &config.Configuration{
+		Directory:  directory,
+		Auth:       discovery.Auth,
+		Metadata:   map[string]string{discovery.MetadataKeyOrDefault(): name},
+		Discovered: true,
+	}
+
+	metaPath := filepath.Join(directory, discoveryMetaFile)
+	if p.confinePaths() {
+		if _, err := confinedPath(realRoot(directory), discoveryMetaFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(metaPath)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", metaPath, err)
+	}
+	var meta config.Configuration
+	if err := decodeYAML(metaPath, data, p.config.StrictFields, &meta); err != nil {
+		return nil, err
+	}
+	if len(meta.Metadata) > 0 {
+		cfg.Metadata = meta.Metadata
+	}
+	if meta.Auth != nil {
+		cfg.Auth = meta.Auth
+	}
+	cfg.Default = meta.Default
+	cfg.DefaultScope = meta.DefaultScope
+	return cfg, nil
+}
@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"time"
+
+	"github.com/jkaninda/logger"
+)
+
+// DefaultDrainRetryAfter is the Retry-After duration advertised while draining
+const DefaultDrainRetryAfter = 30 * time.Second
+
+// SetDraining puts the provider in or out of drain mode.
+// While draining, config endpoints ask clients to retry after the given duration.
+func (p *HTTPProvider) SetDraining(draining bool, retryAfter time.Duration) {
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+	if retryAfter <= 0 {
+		retryAfter = DefaultDrainRetryAfter
+	}
+	p.draining = draining
+	p.drainRetryAfter = retryAfter
+	logger.Info("Provider drain mode changed", "draining", draining, "retryAfter", retryAfter)
+}
+
+// Draining reports whether the provider is draining, and the Retry-After duration to advertise
+func (p *HTTPProvider) Draining() (bool, time.Duration) {
+	p.drainMu.RLock()
+	defer p.drainMu.RUnlock()
+	return p.draining, p.drainRetryAfter
+}
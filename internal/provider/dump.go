@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/utils"
+)
+
+// StateDump is a snapshot of the provider internal state for debugging.
+// It never holds secrets: auths are reduced to the methods they enable.
+type StateDump struct {
+	GeneratedAt    time.Time     `json:"generatedAt"`
+	Version        string        `json:"version"`
+	Configurations []ConfigState `json:"configurations"`
+	// Reloads lists the most recent reloads, newest first
+	Reloads []ReloadRecord `json:"reloads"`
+}
+
+// ConfigState is the state of a configuration and of its cached bundle
+type ConfigState struct {
+	ID            string            `json:"id"`
+	Source        string            `json:"source"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	MatchStrategy string            `json:"matchStrategy,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Default       bool              `json:"default,omitempty"`
+	// Auth lists the auth methods the configuration enables, such as apiKey
+	Auth   []string `json:"auth,omitempty"`
+	Loaded bool     `json:"loaded"`
+	// The fields below describe the cached bundle, served without request metadata
+	Checksum    string     `json:"checksum,omitempty"`
+	Routes      int        `json:"routes"`
+	Middlewares int        `json:"middlewares"`
+	LoadedAt    *time.Time `json:"loadedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	StaleSince  *time.Time `json:"staleSince,omitempty"`
+	// Loads is the number of successful loads since startup
+	Loads int64 `json:"loads"`
+}
+
+// DumpState returns a sanitized snapshot of the configurations, their cache entries and
+// the recent reloads, in configuration order
+func (p *HTTPProvider) DumpState() (*StateDump, error) {
+	p.cacheMu.RLock()
+	configurations := p.configurations
+	cache := p.cache
+	p.cacheMu.RUnlock()
+
+	dump := &StateDump{
+		GeneratedAt:    time.Now(),
+		Version:        utils.Version,
+		Configurations: make([]ConfigState, 0, len(configurations)),
+		Reloads:        p.stats.reloadHistory(),
+	}
+	for _, cfg := range configurations {
+		state := ConfigState{
+			ID:            cfg.ID,
+			Source:        cfg.SourceType(),
+			Metadata:      cfg.Metadata,
+			MatchStrategy: cfg.MatchStrategy,
+			Tags:          cfg.Tags,
+			Default:       cfg.Default,
+			Loads:         p.stats.loadCount(cfg.ID),
+		}
+		if auth := cfg.Auth; auth != nil {
+			if auth.APIKey != "" {
+				state.Auth = append(state.Auth, "apiKey")
+			}
+			if auth.BasicAuth != nil {
+				state.Auth = append(state.Auth, "basicAuth")
+			}
+		}
+		if cached := cache[cfg.ID]; cached != nil {
+			bundle, err := p.applyFlags(cfg.ID, cached)
+			if err != nil {
+				return nil, err
+			}
+			state.Loaded = true
+			state.Checksum = bundle.Checksum
+			state.Routes = len(bundle.Routes)
+			state.Middlewares = len(bundle.Middlewares)
+			state.LoadedAt = timeOrNil(cached.LoadedAt)
+			state.ExpiresAt = timeOrNil(cached.ExpiresAt)
+			state.StaleSince = timeOrNil(cached.StaleSince)
+		}
+		dump.Configurations = append(dump.Configurations, state)
+	}
+	return dump, nil
+}
+
+// timeOrNil returns nil for the zero time, so that it is omitted
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// Transformation kinds, telling what changed a bundle between its files and the served view
+const (
+	// TransformSchemaMigration is a config file upgraded from an older schema version
+	TransformSchemaMigration = "schemaMigration"
+	// TransformPathNormalization is a route path rewritten by the path normalization policy
+	TransformPathNormalization = "pathNormalization"
+	// TransformRouteConflict is a route defined again by a later file, resolved by the route conflicts policy
+	TransformRouteConflict = "routeConflict"
+	// TransformMetadataMerge is configuration metadata merged into the bundle metadata
+	TransformMetadataMerge = "metadataMerge"
+	// TransformFeatureFlag is a route removed because its feature flag is disabled
+	TransformFeatureFlag = "featureFlag"
+	// TransformRouteDisabled is a route removed because it is disabled at runtime
+	TransformRouteDisabled = "routeDisabled"
+	// TransformMetadataDefault is a request metadata key filled in from the metadata defaults
+	TransformMetadataDefault = "metadataDefault"
+	// TransformFallback is a bundle of the fallback chain, served because no configuration matched
+	TransformFallback = "fallback"
+	// TransformCanary is the canary variant, served because the request falls in the canary bucket
+	TransformCanary = "canary"
+)
+
+// EffectiveConfig is the fully resolved view of the bundle served for a request,
+// along with every transformation applied to produce it
+type EffectiveConfig struct {
+	ConfigID        string                  `json:"configId"`
+	Source          string                  `json:"source,omitempty"`
+	Metadata        map[string]string       `json:"metadata"`
+	Transformations []config.Transformation `json:"transformations"`
+	Bundle          *config.ConfigBundle    `json:"bundle"`
+}
+
+// mergeConfigMetadata merges the configuration metadata into the bundle metadata. A key also
+// set by the config files is overridden, unless the metadata precedence keeps the file value.
+func (p *HTTPProvider) mergeConfigMetadata(cfg *config.Configuration, bundle *config.ConfigBundle) {
+	if len(cfg.Metadata) == 0 {
+		return
+	}
+	keepFile := p.config.MetadataPrecedence == config.MetadataPrecedenceFile
+	var merged, overridden, kept []string
+	for k, v := range cfg.Metadata {
+		fileValue, ok := bundle.Metadata[k]
+		switch {
+		case !ok:
+			merged = append(merged, k)
+		case fileValue == v:
+			continue
+		case keepFile:
+			kept = append(kept, k)
+			continue
+		default:
+			overridden = append(overridden, k)
+		}
+		bundle.Metadata[k] = v
+	}
+	merged = append(merged, overridden...)
+	if len(merged) == 0 && len(kept) == 0 {
+		return
+	}
+	sort.Strings(merged)
+	detail := fmt.Sprintf("configuration %s metadata %v merged", cfg.ID, merged)
+	if len(overridden) > 0 {
+		sort.Strings(overridden)
+		detail += fmt.Sprintf(", overriding file metadata %v", overridden)
+	}
+	if len(kept) > 0 {
+		sort.Strings(kept)
+		detail += fmt.Sprintf(", file metadata %v kept", kept)
+	}
+	bundle.Transformations = append(bundle.Transformations, config.Transformation{
+		Kind:   TransformMetadataMerge,
+		Detail: detail,
+	})
+}
+
+// Effective returns the effective view of a bundle resolved for the request.
+// The load time transformations recorded on the bundle are completed with
+// the ones applied while resolving the request.
+func (p *HTTPProvider) Effective(r *http.Request, bundle *config.ConfigBundle, cfg *config.Configuration, source string) *EffectiveConfig {
+	transformations := slices.Clone(bundle.Transformations)
+
+	requested := p.normalizeMetadata(p.requestMetadata(r))
+	defaults := make([]string, 0, len(p.config.MetadataDefaults))
+	for k := range p.config.MetadataDefaults {
+		if _, ok := requested[normalizeKey(p.config.MetadataKeys, k)]; !ok {
+			defaults = append(defaults, k)
+		}
+	}
+	sort.Strings(defaults)
+	for _, k := range defaults {
+		transformations = append(transformations, config.Transformation{
+			Kind:   TransformMetadataDefault,
+			Detail: fmt.Sprintf("request metadata %s defaulted to %s", k, p.config.MetadataDefaults[k]),
+		})
+	}
+	if source == SourceDefaultFallback {
+		transformations = append(transformations, config.Transformation{
+			Kind:   TransformFallback,
+			Detail: fmt.Sprintf("no configuration matched, served fallback configuration %s", cfg.ID),
+		})
+	}
+	if bundle.Canary {
+		transformations = append(transformations, config.Transformation{
+			Kind:   TransformCanary,
+			Detail: fmt.Sprintf("served canary variant of configuration %s", cfg.ID),
+		})
+	}
+	if transformations == nil {
+		transformations = []config.Transformation{}
+	}
+	return &EffectiveConfig{
+		ConfigID:        cfg.ID,
+		Source:          source,
+		Metadata:        p.ExtractMetadata(r),
+		Transformations: transformations,
+		Bundle:          bundle,
+	}
+}
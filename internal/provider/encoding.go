@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content encodings of served bundles
+const (
+	// EncodingBrotli is the brotli content encoding, preferred when accepted
+	EncodingBrotli = "br"
+	// EncodingGzip is the gzip content encoding
+	EncodingGzip = "gzip"
+)
+
+// maxEncodedBodies bounds the number of encoded bodies kept between reloads
+const maxEncodedBodies = 256
+
+// encodedBodies caches the compressed encodings of served bodies, keyed by encoding
+// and checksum of the JSON body, so that a bundle is compressed once per change
+type encodedBodies struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func (e *encodedBodies) get(key string) ([]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	data, ok := e.entries[key]
+	return data, ok
+}
+
+// add caches an encoded body. When the cache is full, it is emptied first.
+func (e *encodedBodies) add(key string, data []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.entries == nil || len(e.entries) >= maxEncodedBodies {
+		e.entries = map[string][]byte{}
+	}
+	e.entries[key] = data
+}
+
+func (e *encodedBodies) clear() {
+	e.mu.Lock()
+	e.entries = nil
+	e.mu.Unlock()
+}
+
+// Encode returns the JSON body compressed with the given content encoding. Encoded bodies
+// are cached until the body changes or the next reload.
+func (p *HTTPProvider) Encode(data []byte, encoding string) ([]byte, error) {
+	sum := sha256.Sum256(data)
+	key := encoding + ":" + hex.EncodeToString(sum[:])
+	if encoded, ok := p.encoded.get(key); ok {
+		return encoded, nil
+	}
+	var buf bytes.Buffer
+	var err error
+	switch encoding {
+	case EncodingBrotli:
+		bw := brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+		if _, err = bw.Write(data); err == nil {
+			err = bw.Close()
+		}
+	case EncodingGzip:
+		gw := gzip.NewWriter(&buf)
+		if _, err = gw.Write(data); err == nil {
+			err = gw.Close()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle with %s: %w", encoding, err)
+	}
+	p.encoded.add(key, buf.Bytes())
+	return buf.Bytes(), nil
+}
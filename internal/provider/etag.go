@@ -0,0 +1,35 @@
+package provider
+
+import (
+	"slices"
+	"strings"
+)
+
+// WeakETag returns the weak entity tag of a checksum. The same bundle is served in
+// several representations, such as versioned or enveloped, which are equal semantically
+// but not byte for byte.
+func WeakETag(checksum string) string {
+	return `W/"` + checksum + `"`
+}
+
+// ETagChecksums returns the checksums listed by an If-None-Match or If-Match header.
+// Weak and strong entity tags are compared weakly, and bare checksums are accepted
+// for clients predating entity tags.
+func ETagChecksums(header string) []string {
+	var checksums []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		tag = strings.Trim(tag, `"`)
+		if tag != "" {
+			checksums = append(checksums, tag)
+		}
+	}
+	return checksums
+}
+
+// ETagMatches reports whether an If-None-Match or If-Match header lists the checksum,
+// or is the * wildcard
+func ETagMatches(header, checksum string) bool {
+	checksums := ETagChecksums(header)
+	return slices.Contains(checksums, "*") || slices.Contains(checksums, checksum)
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// Extractor contributes request metadata keys
+type Extractor interface {
+	// Extract adds the metadata it derives from the request, overriding
+	// the keys set by the extractors before it
+	Extract(r *http.Request, metadata map[string]string)
+}
+
+// ExtractorFunc adapts a function to an Extractor
+type ExtractorFunc func(r *http.Request, metadata map[string]string)
+
+// Extract calls f(r, metadata)
+func (f ExtractorFunc) Extract(r *http.Request, metadata map[string]string) {
+	f(r, metadata)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]Extractor{
+		config.ExtractorQuery:  ExtractorFunc(queryMetadata),
+		config.ExtractorHeader: ExtractorFunc(headerMetadata),
+	}
+)
+
+// RegisterExtractor makes a metadata extractor available to the metadataExtractors
+// of the provider config, replacing any extractor registered with the same name
+func RegisterExtractor(name string, extractor Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors[name] = extractor
+}
+
+// metadataExtractors returns the extractors of the provider config in precedence order,
+// the built-in ones when unset
+func metadataExtractors(names []string) ([]Extractor, error) {
+	if len(names) == 0 {
+		names = config.DefaultMetadataExtractors
+	}
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	resolved := make([]Extractor, 0, len(names))
+	for _, name := range names {
+		extractor, ok := extractors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown metadata extractor %q", name)
+		}
+		resolved = append(resolved, extractor)
+	}
+	return resolved, nil
+}
+
+// ErrMetadataConflict is returned when metadata extractors set a key to different values
+var ErrMetadataConflict = errors.New("conflicting request metadata")
+
+// MetadataConflicts returns an ErrMetadataConflict listing the keys the extractors set to
+// different values, compared in the metadata key convention, when conflicts are rejected.
+// Otherwise the later extractor wins and it returns nil.
+func (p *HTTPProvider) MetadataConflicts(r *http.Request) error {
+	if p.config.MetadataConflicts != config.MetadataConflictsReject {
+		return nil
+	}
+	seen := map[string]string{}
+	var conflicts []string
+	for _, extractor := range p.extractors {
+		extracted := map[string]string{}
+		extractor.Extract(r, extracted)
+		for k, v := range extracted {
+			if !p.metadataKeyAllowed(k) {
+				continue
+			}
+			key := strings.ToLower(normalizeKey(p.config.MetadataKeys, k))
+			if previous, ok := seen[key]; ok && previous != v {
+				conflicts = append(conflicts, fmt.Sprintf("%s is both %q and %q", key, previous, v))
+			}
+			seen[key] = v
+		}
+	}
+	if len(conflicts) > 0 {
+		slices.Sort(conflicts)
+		return fmt.Errorf("%w: %s", ErrMetadataConflict, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// queryMetadata extracts the query parameters other than request options
+func queryMetadata(r *http.Request, metadata map[string]string) {
+	for key, values := range r.URL.Query() {
+		if slices.Contains(reservedQueryParams, key) {
+			continue
+		}
+		if len(values) > 0 {
+			metadata[key] = values[0]
+		}
+	}
+}
+
+// headerMetadata extracts the X-Goma-Meta- prefixed headers
+func headerMetadata(r *http.Request, metadata map[string]string) {
+	for key, values := range r.Header {
+		if strings.HasPrefix(key, "X-Goma-Meta-") && len(values) > 0 {
+			metaKey := strings.ToLower(strings.TrimPrefix(key, "X-Goma-Meta-"))
+			metadata[metaKey] = values[0]
+		}
+	}
+}
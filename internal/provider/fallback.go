@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/metrics"
+	"github.com/jkaninda/logger"
+)
+
+// emptyConfig is the built-in configuration served by the empty fallback level
+var emptyConfig = &config.Configuration{ID: "builtin-empty"}
+
+func emptyCachedConfig() *CachedConfig {
+	bundle := newBundle()
+	bundle.Timestamp = time.Now()
+	bundle.Checksum = calculateChecksum(bundle)
+	return &CachedConfig{Bundle: bundle, ETag: bundle.Checksum, variants: &sync.Map{}}
+}
+
+// selectDefault returns the global default among the IDs of the configurations marked
+// default without a scope. Dynamic sources may add defaults the static config validation
+// doesn't see: the strict policy rejects them, otherwise the first by sorted ID wins.
+func (p *HTTPProvider) selectDefault(defaults []string) (string, error) {
+	switch len(defaults) {
+	case 0:
+		return "", nil
+	case 1:
+		return defaults[0], nil
+	}
+	sorted := slices.Sorted(slices.Values(defaults))
+	if p.config.DuplicateDefaults == config.DuplicateDefaultsStrict {
+		return "", fmt.Errorf("only one configuration can be marked as default without a defaultScope, got %s", strings.Join(sorted, ", "))
+	}
+	logger.Warn("Several configurations are marked as default without a defaultScope, using the first by ID", "default", sorted[0], "ids", strings.Join(sorted, ", "))
+	return sorted[0], nil
+}
+
+// fallbackChain returns the configured fallback chain or the default one
+func (p *HTTPProvider) fallbackChain() []string {
+	if len(p.config.Fallback) > 0 {
+		return p.config.Fallback
+	}
+	return config.DefaultFallbackChain
+}
+
+// fallbackLogInterval is the minimum interval between two default fallback warnings
+const fallbackLogInterval = 10 * time.Second
+
+// logSampler lets one log through per interval, counting the suppressed ones
+type logSampler struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// allow reports whether to log now, along with the number of logs suppressed since the last one
+func (s *logSampler) allow(now time.Time, interval time.Duration) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.last.IsZero() && now.Sub(s.last) < interval {
+		s.suppressed++
+		return false, 0
+	}
+	suppressed := s.suppressed
+	s.last, s.suppressed = now, 0
+	return true, suppressed
+}
+
+// fallbackConfiguration walks the fallback chain for a request that matched no configuration
+func (p *HTTPProvider) fallbackConfiguration(metadata map[string]string) *config.Configuration {
+	for _, level := range p.fallbackChain() {
+		var cfg *config.Configuration
+		switch level {
+		case config.FallbackScoped:
+			cfg = p.scopedDefault(metadata)
+		case config.FallbackGlobal:
+			p.cacheMu.RLock()
+			defaultID := p.defaultID
+			p.cacheMu.RUnlock()
+			cfg = p.configurationByID(defaultID)
+		case config.FallbackEmpty:
+			cfg = emptyConfig
+		}
+		if cfg != nil {
+			metrics.DefaultFallbacks.Inc(level, cfg.ID)
+			if ok, suppressed := p.fallbackLog.allow(p.now(), fallbackLogInterval); ok {
+				// Metadata values may be sensitive, only the requested keys are logged
+				logger.Warn("Config not found, fallback to default", "level", level, "ID", cfg.ID,
+					"keys", strings.Join(slices.Sorted(maps.Keys(metadata)), ","), "suppressed", suppressed)
+			}
+			return cfg
+		}
+		logger.Debug("Fallback level did not resolve", "level", level)
+	}
+	return nil
+}
+
+// scopedDefault returns the scoped default whose scope is fully matched by the
+// request metadata, preferring the most specific scope
+func (p *HTTPProvider) scopedDefault(metadata map[string]string) *config.Configuration {
+	var best *config.Configuration
+	metadata = p.normalizeMetadata(metadata)
+	for _, cfg := range p.Configurations() {
+		if !cfg.Default || len(cfg.DefaultScope) == 0 {
+			continue
+		}
+		matched := true
+		for k, v := range p.normalizeMetadata(cfg.DefaultScope) {
+			if metadata[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched && (best == nil || len(cfg.DefaultScope) > len(best.DefaultScope)) {
+			best = cfg
+		}
+	}
+	return best
+}
+
+// configurationByID returns the configuration with the given ID
+func (p *HTTPProvider) configurationByID(id string) *config.Configuration {
+	if id == "" {
+		return nil
+	}
+	for _, cfg := range p.Configurations() {
+		if cfg.ID == id {
+			return cfg
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/models"
+	"github.com/jkaninda/logger"
+)
+
+// featureFlagsEnv lists enabled feature flags, comma separated
+const featureFlagsEnv = "FEATURE_FLAGS"
+
+// flagState resolves feature flags from runtime overrides, the FEATURE_FLAGS
+// environment variable and the static flags of the provider config, in that order
+type flagState struct {
+	mu        sync.RWMutex
+	static    map[string]bool
+	overrides map[string]bool
+}
+
+func newFlagState(static map[string]bool) *flagState {
+	return &flagState{static: static, overrides: map[string]bool{}}
+}
+
+// enabled reports whether the named flag is enabled
+func (f *flagState) enabled(name string) bool {
+	f.mu.RLock()
+	enabled, ok := f.overrides[name]
+	f.mu.RUnlock()
+	if ok {
+		return enabled
+	}
+	for _, env := range strings.Split(os.Getenv(featureFlagsEnv), ",") {
+		if strings.TrimSpace(env) == name {
+			return true
+		}
+	}
+	return f.static[name]
+}
+
+// SetFlag overrides the state of a feature flag until the provider restarts
+func (p *HTTPProvider) SetFlag(name string, enabled bool) {
+	p.flags.mu.Lock()
+	p.flags.overrides[name] = enabled
+	p.flags.mu.Unlock()
+	// Subscribers re-check their served checksum, a flag may filter any bundle
+	p.changes.notifyAll()
+	logger.Info("Feature flag changed", "flag", name, "enabled", enabled)
+}
+
+// FlagEnabled reports whether the named feature flag is enabled
+func (p *HTTPProvider) FlagEnabled(name string) bool {
+	return p.flags.enabled(name)
+}
+
+// applyFlags returns the cached bundle of a configuration without the routes whose flag is
+// disabled or that are disabled at runtime. Filtered bundles carry their own checksum and
+// are memoized per active flag set and disabled routes.
+func (p *HTTPProvider) applyFlags(id string, cached *CachedConfig) (*config.ConfigBundle, error) {
+	disabled := p.routeToggles.routes(id)
+	if len(cached.routeFlags) == 0 && len(disabled) == 0 {
+		return cached.bundle()
+	}
+
+	active := make([]string, 0, len(cached.routeFlags))
+	for _, flag := range cached.routeFlags {
+		if p.flags.enabled(flag) {
+			active = append(active, flag)
+		}
+	}
+	sort.Strings(active)
+	key := strings.Join(active, ",")
+	if len(disabled) > 0 {
+		key += "|" + strings.Join(disabled, ",")
+	}
+	if v, ok := cached.variants.Load(key); ok {
+		return v.(*CachedConfig).bundle()
+	}
+
+	bundle, err := cached.bundle()
+	if err != nil {
+		return nil, err
+	}
+	filtered := *bundle
+	filtered.Routes = make([]models.Route, 0, len(bundle.Routes))
+	dropped := map[string]struct{}{}
+	for _, route := range bundle.Routes {
+		switch {
+		case slices.Contains(disabled, route.Name):
+			dropped[route.Name] = struct{}{}
+			filtered.Transformations = append(slices.Clip(filtered.Transformations), config.Transformation{
+				Kind:   TransformRouteDisabled,
+				Detail: fmt.Sprintf("route %s removed, disabled at runtime", route.Name),
+			})
+		case route.Flag != "" && !slices.Contains(active, route.Flag):
+			dropped[route.Name] = struct{}{}
+			filtered.Transformations = append(slices.Clip(filtered.Transformations), config.Transformation{
+				Kind:   TransformFeatureFlag,
+				Detail: fmt.Sprintf("route %s removed, flag %s is disabled", route.Name, route.Flag),
+			})
+		default:
+			filtered.Routes = append(filtered.Routes, route)
+		}
+	}
+	filtered.Sources = make([]config.Source, 0, len(bundle.Sources))
+	for _, source := range bundle.Sources {
+		if _, ok := dropped[source.Name]; !ok || source.Kind != "route" {
+			filtered.Sources = append(filtered.Sources, source)
+		}
+	}
+	filtered.Checksum = calculateChecksum(&filtered)
+
+	variant, err := p.newCachedConfig(id, &filtered)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := cached.variants.LoadOrStore(key, variant)
+	return v.(*CachedConfig).bundle()
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/models"
+)
+
+// GomaBundle converts a bundle to the Goma Gateway HTTP provider format, dropping the
+// provider only fields, and validates it against what Goma Gateway requires
+func GomaBundle(bundle *config.ConfigBundle) (*config.GomaConfigBundle, error) {
+	goma := &config.GomaConfigBundle{
+		Version:     bundle.Version,
+		Routes:      make([]models.Route, 0, len(bundle.Routes)),
+		Middlewares: make([]models.Middleware, 0, len(bundle.Middlewares)),
+		Metadata:    bundle.Metadata,
+		Timestamp:   bundle.Timestamp,
+		Checksum:    bundle.Checksum,
+	}
+	for _, route := range bundle.Routes {
+		// Flags are resolved by the provider, served routes are enabled ones
+		route.Flag = ""
+		goma.Routes = append(goma.Routes, route)
+	}
+	goma.Middlewares = append(goma.Middlewares, bundle.Middlewares...)
+	if err := validateGomaBundle(goma); err != nil {
+		return nil, err
+	}
+	return goma, nil
+}
+
+// validateGomaBundle checks the fields Goma Gateway requires to load a bundle
+func validateGomaBundle(bundle *config.GomaConfigBundle) error {
+	var errs []error
+	if bundle.Version == "" {
+		errs = append(errs, fmt.Errorf("version is required"))
+	}
+	routes := map[string]bool{}
+	for i, route := range bundle.Routes {
+		switch {
+		case route.Name == "":
+			errs = append(errs, fmt.Errorf("routes[%d]: name is required", i))
+		case routes[route.Name]:
+			errs = append(errs, fmt.Errorf("routes[%d]: duplicate route name %s", i, route.Name))
+		}
+		routes[route.Name] = true
+		if route.Path == "" {
+			errs = append(errs, fmt.Errorf("routes[%d]: path is required", i))
+		}
+		if route.Target == "" && len(route.Backends) == 0 {
+			errs = append(errs, fmt.Errorf("routes[%d]: target or backends is required", i))
+		}
+	}
+	middlewares := map[string]bool{}
+	for i, middleware := range bundle.Middlewares {
+		switch {
+		case middleware.Name == "":
+			errs = append(errs, fmt.Errorf("middlewares[%d]: name is required", i))
+		case middlewares[middleware.Name]:
+			errs = append(errs, fmt.Errorf("middlewares[%d]: duplicate middleware name %s", i, middleware.Name))
+		}
+		middlewares[middleware.Name] = true
+		if middleware.Type == "" {
+			errs = append(errs, fmt.Errorf("middlewares[%d]: type is required", i))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("bundle does not match the goma schema: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// Graph node kinds
+const (
+	GraphNodeRoute      = "route"
+	GraphNodeMiddleware = "middleware"
+)
+
+// BundleGraph is the graph of the routes of a bundle and the middlewares they reference
+type BundleGraph struct {
+	ConfigID string      `json:"configId"`
+	Nodes    []GraphNode `json:"nodes"`
+	// Edges go from a route to each middleware it references
+	Edges []GraphEdge `json:"edges"`
+	// Orphans lists the middlewares no route references
+	Orphans []string `json:"orphans"`
+	// Missing lists the middlewares referenced by a route but not defined by the bundle
+	Missing []string `json:"missing"`
+}
+
+// GraphNode is a route or a middleware, identified by its kind and name, such as route:api
+type GraphNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Type is the middleware type
+	Type string `json:"type,omitempty"`
+	// Missing is set on middlewares referenced but not defined
+	Missing bool `json:"missing,omitempty"`
+}
+
+// GraphEdge is a reference from a route to a middleware
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func graphNodeID(kind, name string) string {
+	return kind + ":" + name
+}
+
+// Graph returns the graph of the bundle routes and the middlewares they reference, in bundle order.
+// References to undefined middlewares get a missing node so that they show in the graph.
+func Graph(id string, bundle *config.ConfigBundle) BundleGraph {
+	graph := BundleGraph{ConfigID: id, Nodes: []GraphNode{}, Edges: []GraphEdge{}, Orphans: []string{}, Missing: []string{}}
+	seen := map[string]bool{}
+	addNode := func(node GraphNode) {
+		if !seen[node.ID] {
+			seen[node.ID] = true
+			graph.Nodes = append(graph.Nodes, node)
+		}
+	}
+
+	defined := map[string]bool{}
+	for _, middleware := range bundle.Middlewares {
+		defined[middleware.Name] = true
+	}
+	referenced := map[string]bool{}
+	for _, route := range bundle.Routes {
+		from := graphNodeID(GraphNodeRoute, route.Name)
+		addNode(GraphNode{ID: from, Kind: GraphNodeRoute, Name: route.Name})
+		for _, name := range route.Middlewares {
+			referenced[name] = true
+			if !defined[name] && !slices.Contains(graph.Missing, name) {
+				graph.Missing = append(graph.Missing, name)
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{From: from, To: graphNodeID(GraphNodeMiddleware, name)})
+		}
+	}
+	for _, middleware := range bundle.Middlewares {
+		addNode(GraphNode{ID: graphNodeID(GraphNodeMiddleware, middleware.Name), Kind: GraphNodeMiddleware, Name: middleware.Name, Type: middleware.Type})
+		if !referenced[middleware.Name] && !slices.Contains(graph.Orphans, middleware.Name) {
+			graph.Orphans = append(graph.Orphans, middleware.Name)
+		}
+	}
+	for _, name := range graph.Missing {
+		addNode(GraphNode{ID: graphNodeID(GraphNodeMiddleware, name), Kind: GraphNodeMiddleware, Name: name, Missing: true})
+	}
+	return graph
+}
+
+// DOT renders the graph in the Graphviz DOT language. Routes are boxes, middlewares
+// ellipses, and missing middlewares dashed red ellipses.
+func (g BundleGraph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(g.ConfigID))
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range g.Nodes {
+		label := node.Name
+		if node.Type != "" {
+			label += "\n(" + node.Type + ")"
+		}
+		attrs := "shape=ellipse"
+		switch {
+		case node.Kind == GraphNodeRoute:
+			attrs = "shape=box"
+		case node.Missing:
+			attrs = "shape=ellipse, style=dashed, color=red"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, %s];\n", strconv.Quote(node.ID), strconv.Quote(label), attrs)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(edge.From), strconv.Quote(edge.To))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
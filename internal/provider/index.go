@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"slices"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// metadataPair is a normalized metadata key and value
+type metadataPair struct {
+	key, value string
+}
+
+// matchIndex is an inverted index from metadata pairs to the configurations declaring them,
+// built on reload so matching only scores the configurations sharing a value with the request
+type matchIndex struct {
+	configs []*config.Configuration
+	// metadata holds the normalized metadata of each configuration
+	metadata []map[string]string
+	// postings maps each pair to the positions of the configurations declaring it, in order
+	postings map[metadataPair][]int
+}
+
+// newMatchIndex indexes the configurations by their normalized metadata
+func (p *HTTPProvider) newMatchIndex(configurations []*config.Configuration) *matchIndex {
+	index := &matchIndex{
+		configs:  configurations,
+		metadata: make([]map[string]string, len(configurations)),
+		postings: map[metadataPair][]int{},
+	}
+	for i, cfg := range configurations {
+		metadata := p.normalizeMetadata(cfg.Metadata)
+		index.metadata[i] = metadata
+		for k, v := range metadata {
+			pair := metadataPair{key: k, value: v}
+			index.postings[pair] = append(index.postings[pair], i)
+		}
+	}
+	return index
+}
+
+// candidate is a configuration position and its score for the request
+type candidate struct {
+	position, score int
+}
+
+// candidates returns, in configuration order, the configurations that may score
+// above zero for the normalized request metadata, along with their score
+func (idx *matchIndex) candidates(metadata map[string]string) []candidate {
+	if idx == nil {
+		return nil
+	}
+	var positions []int
+	for k, v := range metadata {
+		if v == "" {
+			// An empty value also matches the configurations without the key,
+			// which the index doesn't list, so all of them are scored
+			all := make([]candidate, len(idx.configs))
+			for i := range all {
+				all[i] = candidate{position: i, score: matchScore(metadata, idx.metadata[i])}
+			}
+			return all
+		}
+		positions = append(positions, idx.postings[metadataPair{key: k, value: v}]...)
+	}
+	// Each request pair lists a configuration at most once, so the number of
+	// times a position appears is its score
+	slices.Sort(positions)
+	var candidates []candidate
+	for i := 0; i < len(positions); {
+		j := i + 1
+		for j < len(positions) && positions[j] == positions[i] {
+			j++
+		}
+		candidates = append(candidates, candidate{position: positions[i], score: j - i})
+		i = j
+	}
+	return candidates
+}
+
+// matchScore returns the number of request metadata values shared by a configuration
+func matchScore(metadata, cfgMetadata map[string]string) int {
+	score := 0
+	for k, v := range metadata {
+		if cfgMetadata[k] == v {
+			score++
+		}
+	}
+	return score
+}
+
+// currentMatchIndex returns the index of the current configurations
+func (p *HTTPProvider) currentMatchIndex() *matchIndex {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	return p.index
+}
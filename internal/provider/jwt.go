@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// ConfigJWTHeader is the response header carrying the signed config JWT
+const ConfigJWTHeader = "X-Goma-Config-JWT"
+
+// JWTIssuer is the issuer of the config JWTs
+const JWTIssuer = "goma-http-provider"
+
+// ConfigClaims are the claims of the config JWT, binding the served bundle to its configuration
+type ConfigClaims struct {
+	ConfigID string `json:"configId"`
+	Checksum string `json:"checksum"`
+	jwt.RegisteredClaims
+}
+
+// loadSigningKey reads the PEM encoded Ed25519 private key signing the config JWTs
+func loadSigningKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwt signing key: %w", err)
+	}
+	key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt signing key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ConfigJWT returns the EdDSA signed JWT of a served bundle,
+// or an empty string when no signing key is configured
+func (p *HTTPProvider) ConfigJWT(cfg *config.Configuration, bundle *config.ConfigBundle) (string, error) {
+	if p.signingKey == nil {
+		return "", nil
+	}
+	claims := ConfigClaims{
+		ConfigID: cfg.ID,
+		Checksum: bundle.Checksum,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   JWTIssuer,
+			IssuedAt: jwt.NewNumericDate(p.now()),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(p.signingKey)
+}
@@ -0,0 +1,380 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+const (
+	kubeTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubeCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	// kubeWatchRetry is the delay before re-establishing a failed watch
+	kubeWatchRetry = 5 * time.Second
+	// kubeListTimeout bounds a list request when the configuration sets no client timeout
+	kubeListTimeout = 30 * time.Second
+)
+
+// Kubernetes object kinds loaded as config files
+const (
+	kindConfigMaps = "configmaps"
+	kindSecrets    = "secrets"
+)
+
+// kubeClient is a minimal Kubernetes API client listing and watching
+// ConfigMaps and Secrets
+type kubeClient struct {
+	server string
+	token  string
+	http   *http.Client
+	// listTimeout bounds list requests, watches stay open until they end
+	listTimeout time.Duration
+}
+
+type kubeObjectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []kubeObject `json:"items"`
+}
+
+type kubeObject struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+type kubeWatchEvent struct {
+	Type string `json:"type"`
+}
+
+// kubeClient returns a client for the configured API server, defaulting to the in-cluster
+// API server and service account. The configuration httpClient settings add their proxy,
+// CAs and list timeout. The HTTP client is cached per configuration.
+func (p *HTTPProvider) kubeClient(cfg *config.Configuration) (*kubeClient, error) {
+	source := cfg.Kubernetes
+	server := source.APIServer
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("kubernetes: apiServer is required when not running in-cluster")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+
+	client := &kubeClient{server: server, listTimeout: kubeListTimeout}
+	if cfg.HTTPClient != nil && cfg.HTTPClient.Timeout > 0 {
+		client.listTimeout = cfg.HTTPClient.Timeout
+	}
+	tokenFile := source.TokenFile
+	if tokenFile == "" {
+		tokenFile = kubeTokenFile
+	}
+	if token, err := os.ReadFile(tokenFile); err == nil {
+		client.token = string(token)
+	} else if source.TokenFile != "" {
+		return nil, fmt.Errorf("kubernetes: failed to read token: %w", err)
+	}
+
+	caFile := source.CAFile
+	if caFile == "" {
+		caFile = kubeCAFile
+	}
+	key := configClientKey{caFile: caFile}
+	if cfg.HTTPClient != nil {
+		key.settings = *cfg.HTTPClient
+	}
+	httpClient, err := p.clients.client(cfg.ID, key, func() (*http.Client, error) {
+		var roots *x509.CertPool
+		if ca, err := os.ReadFile(caFile); err == nil {
+			roots = x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("kubernetes: invalid CA file %s", caFile)
+			}
+		} else if source.CAFile != "" {
+			return nil, fmt.Errorf("kubernetes: failed to read CA: %w", err)
+		}
+		if roots == nil && cfg.HTTPClient == nil {
+			return &http.Client{}, nil
+		}
+		transport, err := newTransport(cfg.HTTPClient, roots)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: httpClient: %w", err)
+		}
+		return &http.Client{Transport: transport}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	client.http = httpClient
+	return client, nil
+}
+
+// get sends an authenticated GET for the objects of a kind matching the selector
+func (k *kubeClient) get(ctx context.Context, source *config.Kubernetes, kind string, query url.Values) (*http.Response, error) {
+	query.Set("labelSelector", source.LabelSelector)
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/%s?%s", k.server, url.PathEscape(source.Namespace), kind, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: failed to get %s: %w", kind, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes: failed to get %s: %s", kind, resp.Status)
+	}
+	return resp, nil
+}
+
+// list returns the objects of a kind matching the selector
+func (k *kubeClient) list(ctx context.Context, source *config.Kubernetes, kind string) (*kubeObjectList, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.listTimeout)
+	defer cancel()
+	resp, err := k.get(ctx, source, kind, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var list kubeObjectList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("kubernetes: failed to decode %s: %w", kind, err)
+	}
+	return &list, nil
+}
+
+// watch calls onChange for every change to the objects of a kind after resourceVersion,
+// until the stream ends or ctx is done
+func (k *kubeClient) watch(ctx context.Context, source *config.Kubernetes, kind, resourceVersion string, onChange func()) error {
+	resp, err := k.get(ctx, source, kind, url.Values{"watch": {"true"}, "resourceVersion": {resourceVersion}})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event kubeWatchEvent
+		if err := dec.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("kubernetes: %s watch ended: %w", kind, err)
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			onChange()
+		case "ERROR":
+			return fmt.Errorf("kubernetes: %s watch error", kind)
+		}
+	}
+}
+
+// sourceKinds returns the object kinds loaded for a source
+func sourceKinds(source *config.Kubernetes) []string {
+	if source.Secrets {
+		return []string{kindConfigMaps, kindSecrets}
+	}
+	return []string{kindConfigMaps}
+}
+
+// kubernetesSource loads a configuration from labeled ConfigMaps and Secrets.
+// Its version is a hash of the resource versions of the selected objects.
+type kubernetesSource struct {
+	p      *HTTPProvider
+	cfg    *config.Configuration
+	source *config.Kubernetes
+}
+
+func newKubernetesSource(p *HTTPProvider, cfg *config.Configuration) (Source, error) {
+	if cfg.Kubernetes == nil {
+		return nil, fmt.Errorf("kubernetes settings are required for source %s", config.SourceKubernetes)
+	}
+	return &kubernetesSource{p: p, cfg: cfg, source: cfg.Kubernetes}, nil
+}
+
+// list returns the objects of every loaded kind, sorted by name, and their version
+func (s *kubernetesSource) list(ctx context.Context) (map[string][]kubeObject, string, error) {
+	client, err := s.p.kubeClient(s.cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := map[string][]kubeObject{}
+	h := sha256.New()
+	for _, kind := range sourceKinds(s.source) {
+		list, err := client.list(ctx, s.source, kind)
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Metadata.Name < list.Items[j].Metadata.Name })
+		for _, item := range list.Items {
+			_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00", kind, item.Metadata.Name, item.Metadata.ResourceVersion)
+		}
+		objects[kind] = list.Items
+	}
+	return objects, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Load builds a bundle from the matching ConfigMaps and Secrets.
+// Each data key with a YAML or JSON extension is a config file, other keys are ignored.
+func (s *kubernetesSource) Load(ctx context.Context) (*config.ConfigBundle, string, error) {
+	objects, version, err := s.list(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	bundle := newBundle()
+	for _, kind := range sourceKinds(s.source) {
+		for _, item := range objects[kind] {
+			keys := make([]string, 0, len(item.Data))
+			for key := range item.Data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if !isConfigFile(key) {
+					continue
+				}
+				path := fmt.Sprintf("%s/%s/%s/%s", kind, s.source.Namespace, item.Metadata.Name, key)
+				data := []byte(item.Data[key])
+				if kind == kindSecrets {
+					if data, err = base64.StdEncoding.DecodeString(item.Data[key]); err != nil {
+						return nil, "", fmt.Errorf("failed to decode %s: %w", path, err)
+					}
+				}
+				if err := s.p.mergeConfigFile(bundle, path, data); err != nil {
+					return nil, "", err
+				}
+			}
+		}
+	}
+	return bundle, version, nil
+}
+
+// Changed reports whether an object was added, modified or removed since version
+func (s *kubernetesSource) Changed(ctx context.Context, version string) (bool, error) {
+	_, current, err := s.list(ctx)
+	if err != nil {
+		return false, err
+	}
+	return current != version, nil
+}
+
+// kubeWatches holds the running watches of the Kubernetes configurations by configuration ID
+type kubeWatches struct {
+	mu sync.Mutex
+	// ctx is the context of WatchKubernetes, nil until it is called
+	ctx     context.Context
+	running map[string]kubeWatch
+}
+
+type kubeWatch struct {
+	cfg    *config.Configuration
+	cancel context.CancelFunc
+}
+
+// WatchKubernetes reloads the provider whenever a ConfigMap or Secret
+// selected by a Kubernetes configuration changes, until ctx is done.
+// Watches follow the configurations of provider config reloads.
+func (p *HTTPProvider) WatchKubernetes(ctx context.Context) {
+	p.kubeWatches.mu.Lock()
+	p.kubeWatches.ctx = ctx
+	p.kubeWatches.mu.Unlock()
+	p.reloadMu.Lock()
+	configurations := p.config.Configurations
+	p.reloadMu.Unlock()
+	p.reconcileKubeWatches(configurations)
+}
+
+// reconcileKubeWatches starts the watches of the added Kubernetes configurations and of the
+// ones whose source settings changed, and stops the others. It is a no-op until WatchKubernetes.
+func (p *HTTPProvider) reconcileKubeWatches(configurations []*config.Configuration) {
+	w := &p.kubeWatches
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ctx == nil {
+		return
+	}
+	wanted := map[string]*config.Configuration{}
+	for _, cfg := range configurations {
+		if cfg.Kubernetes != nil {
+			wanted[cfg.ID] = cfg
+		}
+	}
+	for id, watch := range w.running {
+		if cfg, ok := wanted[id]; !ok || !sameKubeSource(watch.cfg, cfg) {
+			watch.cancel()
+			delete(w.running, id)
+		}
+	}
+	for id, cfg := range wanted {
+		if _, ok := w.running[id]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(w.ctx)
+		if w.running == nil {
+			w.running = map[string]kubeWatch{}
+		}
+		w.running[id] = kubeWatch{cfg: cfg, cancel: cancel}
+		for _, kind := range sourceKinds(cfg.Kubernetes) {
+			go p.watchKubernetes(ctx, cfg, kind, func() {
+				if err := p.Reload(); err != nil {
+					logger.Error("Failed to reload after kubernetes change", "error", err)
+				}
+			})
+		}
+	}
+}
+
+// sameKubeSource reports whether two configurations watch the same objects with the same client settings
+func sameKubeSource(a, b *config.Configuration) bool {
+	if *a.Kubernetes != *b.Kubernetes || (a.HTTPClient == nil) != (b.HTTPClient == nil) {
+		return false
+	}
+	return a.HTTPClient == nil || *a.HTTPClient == *b.HTTPClient
+}
+
+// watchKubernetes keeps a watch on a kind open, re-listing and retrying on failure
+func (p *HTTPProvider) watchKubernetes(ctx context.Context, cfg *config.Configuration, kind string, onChange func()) {
+	source := cfg.Kubernetes
+	for ctx.Err() == nil {
+		err := func() error {
+			client, err := p.kubeClient(cfg)
+			if err != nil {
+				return err
+			}
+			list, err := client.list(ctx, source, kind)
+			if err != nil {
+				return err
+			}
+			return client.watch(ctx, source, kind, list.Metadata.ResourceVersion, onChange)
+		}()
+		if err != nil {
+			logger.Error("Kubernetes watch failed", "namespace", source.Namespace, "kind", kind, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(kubeWatchRetry):
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+// lastGoodEntry is the persisted copy of a successfully loaded bundle
+type lastGoodEntry struct {
+	Bundle *config.ConfigBundle `json:"bundle"`
+	// Extra keeps the passthrough fields, which the bundle decoder drops
+	Extra    map[string]json.RawMessage `json:"extra,omitempty"`
+	LoadedAt time.Time                  `json:"loadedAt"`
+}
+
+// persistLastGood writes the loaded bundles to the last good file, replacing it atomically
+func (p *HTTPProvider) persistLastGood(cache map[string]*CachedConfig) error {
+	entries := make(map[string]lastGoodEntry, len(cache))
+	for id, cached := range cache {
+		if id == emptyConfig.ID {
+			continue
+		}
+		bundle, err := cached.bundle()
+		if err != nil {
+			return err
+		}
+		entries[id] = lastGoodEntry{Bundle: bundle, Extra: bundle.Extra, LoadedAt: cached.LoadedAt}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p.config.LastGoodFile), ".last-good-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p.config.LastGoodFile)
+}
+
+// readLastGood reads the persisted bundles, an absent file has none
+func (p *HTTPProvider) readLastGood() (map[string]lastGoodEntry, error) {
+	data, err := os.ReadFile(p.config.LastGoodFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries map[string]lastGoodEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid last good file %s: %w", p.config.LastGoodFile, err)
+	}
+	return entries, nil
+}
+
+// lastGoodConfig returns the persisted bundle of a config as a stale cache entry
+func (p *HTTPProvider) lastGoodConfig(id string) (*CachedConfig, bool) {
+	if p.config.LastGoodFile == "" {
+		return nil, false
+	}
+	entries, err := p.readLastGood()
+	if err != nil {
+		logger.Error("Failed to read last good file", "file", p.config.LastGoodFile, "error", err)
+		return nil, false
+	}
+	entry, ok := entries[id]
+	if !ok || entry.Bundle == nil {
+		return nil, false
+	}
+	entry.Bundle.Extra = entry.Extra
+	cached, err := p.newCachedConfig(id, entry.Bundle)
+	if err != nil {
+		logger.Error("Failed to restore last good config", "id", id, "error", err)
+		return nil, false
+	}
+	cached.LoadedAt = entry.LoadedAt
+	cached.StaleSince = time.Now()
+	return cached, true
+}
@@ -0,0 +1,180 @@
+package provider
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+// Metadata keys set from the verified client certificate of mTLS requests.
+// Requests can't set them through query parameters or headers.
+const (
+	// MetadataClientCN is the subject common name of the client certificate
+	MetadataClientCN = "clientCN"
+	// MetadataClientSAN is the first DNS name of the client certificate
+	MetadataClientSAN = "clientSAN"
+)
+
+// clientCertMetadata replaces the client certificate keys of the metadata by the
+// attributes of the verified client certificate, if any
+func (p *HTTPProvider) clientCertMetadata(r *http.Request, metadata map[string]string) {
+	synthetic := []string{MetadataClientCN, MetadataClientSAN}
+	for k := range metadata {
+		for _, key := range synthetic {
+			if strings.EqualFold(normalizeKey(p.config.MetadataKeys, k), normalizeKey(p.config.MetadataKeys, key)) {
+				delete(metadata, k)
+			}
+		}
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName != "" {
+		metadata[MetadataClientCN] = cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		metadata[MetadataClientSAN] = cert.DNSNames[0]
+	}
+}
+
+// metadataKeyAllowed reports whether requests may set the metadata key, compared
+// in the metadata key convention. Every key is allowed without an allowlist.
+func (p *HTTPProvider) metadataKeyAllowed(key string) bool {
+	allowed := p.config.AllowedMetadataKeys
+	if len(allowed) == 0 {
+		return true
+	}
+	key = normalizeKey(p.config.MetadataKeys, key)
+	for _, k := range allowed {
+		if strings.EqualFold(key, normalizeKey(p.config.MetadataKeys, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterMetadataKeys drops the request metadata keys missing from the allowlist
+func (p *HTTPProvider) filterMetadataKeys(metadata map[string]string) {
+	for k := range metadata {
+		if !p.metadataKeyAllowed(k) {
+			logger.Debug("Ignoring metadata key missing from the allowlist", "key", k)
+			delete(metadata, k)
+		}
+	}
+}
+
+// transformMetadata rewrites the request metadata values through the transform pipeline
+// of their key, compared in the metadata key convention
+func (p *HTTPProvider) transformMetadata(metadata map[string]string) {
+	if len(p.config.MetadataTransforms) == 0 {
+		return
+	}
+	for k, v := range metadata {
+		key := normalizeKey(p.config.MetadataKeys, k)
+		for name, steps := range p.config.MetadataTransforms {
+			if !strings.EqualFold(key, normalizeKey(p.config.MetadataKeys, name)) {
+				continue
+			}
+			for _, step := range steps {
+				v = transformValue(step, v)
+			}
+			metadata[k] = v
+		}
+	}
+}
+
+// transformValue applies a transform step to a metadata value
+func transformValue(step config.MetadataTransform, value string) string {
+	switch {
+	case step.Lowercase:
+		return strings.ToLower(value)
+	case step.Trim:
+		return strings.TrimSpace(value)
+	case step.TrimPrefix != "":
+		return strings.TrimPrefix(value, step.TrimPrefix)
+	case step.TrimSuffix != "":
+		return strings.TrimSuffix(value, step.TrimSuffix)
+	case len(step.Map) > 0:
+		if mapped, ok := step.Map[value]; ok {
+			return mapped
+		}
+	}
+	return value
+}
+
+// ResolveMetadata returns metadata given in a request body as ExtractMetadata would
+// have extracted it: client certificate keys come from the request, defaults are filled in
+func (p *HTTPProvider) ResolveMetadata(r *http.Request, metadata map[string]string) map[string]string {
+	resolved := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		resolved[k] = v
+	}
+	p.filterMetadataKeys(resolved)
+	p.transformMetadata(resolved)
+	p.clientCertMetadata(r, resolved)
+	p.applyMetadataDefaults(resolved)
+	return resolved
+}
+
+// normalizeKey rewrites a metadata key in the given convention. Dashes, underscores
+// and camelCase word boundaries all become the convention separator.
+func normalizeKey(mode, key string) string {
+	var sep rune
+	switch mode {
+	case config.MetadataKeysSnake:
+		sep = '_'
+	case config.MetadataKeysKebab:
+		sep = '-'
+	default:
+		return key
+	}
+
+	var b strings.Builder
+	var prev rune
+	for _, r := range key {
+		switch {
+		case r == '-' || r == '_':
+			b.WriteRune(sep)
+		case unicode.IsUpper(r):
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				b.WriteRune(sep)
+			}
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+// normalizeMetadata returns the metadata with keys in the configured convention
+func (p *HTTPProvider) normalizeMetadata(metadata map[string]string) map[string]string {
+	mode := p.config.MetadataKeys
+	if mode == "" || len(metadata) == 0 {
+		return metadata
+	}
+	normalized := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		normalized[normalizeKey(mode, k)] = v
+	}
+	return normalized
+}
+
+// applyMetadataDefaults fills in the metadata defaults for the keys absent from
+// the request metadata, compared in the metadata key convention
+func (p *HTTPProvider) applyMetadataDefaults(metadata map[string]string) {
+	if len(p.config.MetadataDefaults) == 0 {
+		return
+	}
+	present := p.normalizeMetadata(metadata)
+	for k, v := range p.config.MetadataDefaults {
+		if _, ok := present[normalizeKey(p.config.MetadataKeys, k)]; !ok {
+			metadata[k] = v
+		}
+	}
+}
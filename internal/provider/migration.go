@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Migration upgrades a parsed config file from its schema version to the next one
+type Migration func(doc map[string]any) error
+
+var (
+	// migrations maps a schema version to the migration upgrading it to the next version
+	migrations   = map[int]Migration{}
+	migrationsMu sync.RWMutex
+)
+
+// RegisterMigration registers the migration upgrading config files from schema version
+// from to from+1, replacing any migration registered for the same version.
+// Version 1 is the oldest schema, assumed for files without a version.
+func RegisterMigration(from int, migration Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	migrations[from] = migration
+}
+
+// SchemaVersion returns the current config file schema version,
+// reached by the registered migrations from version 1
+func SchemaVersion() int {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	return schemaVersion()
+}
+
+func schemaVersion() int {
+	version := 1
+	for migrations[version] != nil {
+		version++
+	}
+	return version
+}
+
+// migrateConfigFile upgrades a config file declaring an older schema version to the
+// current one, and returns it re-encoded as YAML along with the version it was migrated from.
+// Files already current are returned unchanged, with a version of 0.
+func migrateConfigFile(path string, data []byte, isJSON bool) ([]byte, bool, int, error) {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	if len(migrations) == 0 {
+		return data, isJSON, 0, nil
+	}
+
+	var doc map[string]any
+	var err error
+	if isJSON {
+		err = decodeJSON(path, data, false, false, &doc)
+	} else {
+		err = decodeYAML(path, data, false, &doc)
+	}
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if doc == nil {
+		return data, isJSON, 0, nil
+	}
+	version, err := fileSchemaVersion(doc["version"])
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	current := schemaVersion()
+	if version > current {
+		return nil, false, 0, fmt.Errorf("%s: unsupported schema version %d, current is %d", path, version, current)
+	}
+	if version == current {
+		return data, isJSON, 0, nil
+	}
+	from := version
+	for ; version < current; version++ {
+		if err := migrations[version](doc); err != nil {
+			return nil, false, 0, fmt.Errorf("%s: failed to migrate from schema version %d: %w", path, version, err)
+		}
+	}
+	doc["version"] = strconv.Itoa(current)
+	migrated, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("%s: failed to encode migrated config: %w", path, err)
+	}
+	return migrated, false, from, nil
+}
+
+// fileSchemaVersion parses the major schema version declared by a config file,
+// such as 1, "2" or "1.0". A file without a version uses the oldest schema.
+func fileSchemaVersion(v any) (int, error) {
+	if v == nil {
+		return 1, nil
+	}
+	s := fmt.Sprint(v)
+	major, _, _ := strings.Cut(s, ".")
+	version, err := strconv.Atoi(major)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid schema version %q", s)
+	}
+	return version, nil
+}
@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+// maxNegativeEntries bounds the negative cache, so clients sending ever changing
+// metadata can't grow it without limit
+const maxNegativeEntries = 10000
+
+// negativeCache remembers the metadata sets that matched no configuration until they expire
+type negativeCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// hit reports whether the key is cached as a no match at the given time
+func (n *negativeCache) hit(key string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	expiresAt, ok := n.entries[key]
+	if !ok {
+		return false
+	}
+	if !now.Before(expiresAt) {
+		delete(n.entries, key)
+		return false
+	}
+	return true
+}
+
+// add caches the key as a no match until expiresAt. When the cache is full,
+// expired entries are dropped and the key is not cached if none were.
+func (n *negativeCache) add(key string, now, expiresAt time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.entries == nil {
+		n.entries = map[string]time.Time{}
+	}
+	if len(n.entries) >= maxNegativeEntries {
+		for k, exp := range n.entries {
+			if !now.Before(exp) {
+				delete(n.entries, k)
+			}
+		}
+		if len(n.entries) >= maxNegativeEntries {
+			return
+		}
+	}
+	n.entries[key] = expiresAt
+}
+
+// clear drops all entries, configurations may match them after a reload
+func (n *negativeCache) clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entries = nil
+}
+
+// negativeKey returns the negative cache key of a request
+func (p *HTTPProvider) negativeKey(metadata map[string]string, strategy string) string {
+	return p.BuildCacheKey(p.normalizeMetadata(metadata)) + "|" + strategy
+}
+
+// NegativeCacheHits returns the number of requests answered from the negative cache
+func (p *HTTPProvider) NegativeCacheHits() int64 {
+	return p.stats.negativeHits.Load()
+}
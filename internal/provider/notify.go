@@ -0,0 +1,90 @@
+package provider
+
+import "sync"
+
+// changeNotifier signals the subscribers of a configuration when its bundle changes
+type changeNotifier struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+// Subscribe returns a channel signaled whenever the bundle of the configuration
+// changes, and a function to cancel the subscription. Signals are coalesced,
+// a subscriber slower than the changes receives a single pending signal.
+func (p *HTTPProvider) Subscribe(id string) (<-chan struct{}, func()) {
+	n := &p.changes
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	if n.subs == nil {
+		n.subs = map[string]map[chan struct{}]struct{}{}
+	}
+	if n.subs[id] == nil {
+		n.subs[id] = map[chan struct{}]struct{}{}
+	}
+	n.subs[id][ch] = struct{}{}
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.subs[id], ch)
+		if len(n.subs[id]) == 0 {
+			delete(n.subs, id)
+		}
+	}
+}
+
+// notify signals the subscribers of a configuration without blocking
+func (n *changeNotifier) notify(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subs[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notifyAll signals the subscribers of every configuration without blocking
+func (n *changeNotifier) notifyAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, subs := range n.subs {
+		for ch := range subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// notifyChanged signals the subscribers of the configurations whose served
+// bundle moved between two cache generations, including removed ones
+func (n *changeNotifier) notifyChanged(previous, current map[string]*CachedConfig) {
+	for id, cached := range current {
+		if bundleChanged(previous[id], cached) {
+			n.notify(id)
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			n.notify(id)
+		}
+	}
+}
+
+// bundleChanged reports whether a cache entry serves a different bundle or canary
+func bundleChanged(previous, current *CachedConfig) bool {
+	if previous == nil || current == nil {
+		return previous != current
+	}
+	if previous.ETag != current.ETag {
+		return true
+	}
+	if (previous.Canary == nil) != (current.Canary == nil) {
+		return true
+	}
+	return current.Canary != nil && previous.Canary.ETag != current.Canary.ETag
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+// normalizeRoutePaths canonicalizes the route paths of a bundle per the path normalization policy
+func (p *HTTPProvider) normalizeRoutePaths(bundle *config.ConfigBundle) {
+	policy := p.config.PathNormalization
+	if policy == "" {
+		return
+	}
+	for i, route := range bundle.Routes {
+		normalized := normalizePath(route.Path, policy)
+		if normalized == route.Path {
+			continue
+		}
+		bundle.Routes[i].Path = normalized
+		bundle.Transformations = append(bundle.Transformations, config.Transformation{
+			Kind:   TransformPathNormalization,
+			Detail: fmt.Sprintf("route %s path %s normalized to %s", route.Name, route.Path, normalized),
+		})
+	}
+}
+
+// normalizePath adds the leading slash, collapses duplicate slashes and, with
+// the strip policy, strips trailing slashes. The root path stays "/".
+func normalizePath(path, policy string) string {
+	if path == "" {
+		return path
+	}
+	var b strings.Builder
+	b.Grow(len(path) + 1)
+	if path[0] != '/' {
+		b.WriteByte('/')
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(path[i])
+	}
+	normalized := b.String()
+	if policy == config.PathNormalizationStrip && len(normalized) > 1 {
+		normalized = strings.TrimSuffix(normalized, "/")
+	}
+	return normalized
+}
+
+// checkPathPattern returns why a route or middleware path is not a well formed path pattern.
+// Segments are literals, {name} parameters or a trailing * wildcard.
+func checkPathPattern(path string) error {
+	if path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if i := strings.IndexFunc(path, func(r rune) bool { return unicode.IsSpace(r) || r == '?' || r == '#' }); i >= 0 {
+		return fmt.Errorf("path %q contains %q", path, path[i])
+	}
+	segments := pathSegments(path)
+	for i, segment := range segments {
+		switch {
+		case strings.Contains(segment, "*"):
+			if segment != "*" || i != len(segments)-1 {
+				return fmt.Errorf("path %q has a wildcard that is not its last segment", path)
+			}
+		case strings.ContainsAny(segment, "{}"):
+			if !isPathParameter(segment) {
+				return fmt.Errorf("path %q has a malformed parameter segment %q", path, segment)
+			}
+		}
+	}
+	return nil
+}
+
+// pathSegments returns the segments of a normalized path, none for the root path
+func pathSegments(path string) []string {
+	path = strings.Trim(normalizePath(path, config.PathNormalizationStrip), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// isPathParameter reports whether a segment is a {name} parameter
+func isPathParameter(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' &&
+		!strings.ContainsAny(segment[1:len(segment)-1], "{}")
+}
+
+// pathsOverlap reports whether a middleware path pattern covers some of the requests served by
+// a route, whose path is a prefix: the pattern is the route path or below it, or its wildcard
+// covers the route path. Parameters match any segment.
+func pathsOverlap(pattern, route string) bool {
+	patternSegments, routeSegments := pathSegments(pattern), pathSegments(route)
+	for i, segment := range patternSegments {
+		if segment == "*" || i >= len(routeSegments) {
+			return true
+		}
+		if segment != routeSegments[i] && !isPathParameter(segment) && !isPathParameter(routeSegments[i]) {
+			return false
+		}
+	}
+	return len(patternSegments) == len(routeSegments)
+}
+
+// validateMiddlewarePaths warns on middleware paths that are not well formed path patterns and,
+// when orphan middleware warnings are enabled, on paths matching no route and on middlewares
+// protecting nothing. Problems are bundle warnings.
+func (p *HTTPProvider) validateMiddlewarePaths(bundle *config.ConfigBundle) {
+	var problems []string
+	for _, middleware := range bundle.Middlewares {
+		live := 0
+		for _, path := range middleware.Paths {
+			if err := checkPathPattern(path); err != nil {
+				problems = append(problems, fmt.Sprintf("middleware %s: %v", middleware.Name, err))
+				continue
+			}
+			matched := false
+			for _, route := range bundle.Routes {
+				if pathsOverlap(path, route.Path) {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				live++
+			} else if p.config.WarnOrphanMiddlewares {
+				problems = append(problems, fmt.Sprintf("middleware %s: path %s matches no route", middleware.Name, path))
+			}
+		}
+		if live == 0 && len(middleware.Paths) > 0 && p.config.WarnOrphanMiddlewares {
+			problems = append(problems, fmt.Sprintf("middleware %s protects no route", middleware.Name))
+		}
+	}
+	for _, problem := range problems {
+		logger.Warn("Invalid middleware path", "problem", problem)
+	}
+	bundle.Warnings = appendUnique(bundle.Warnings, problems...)
+}
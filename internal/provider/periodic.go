@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jkaninda/logger"
+)
+
+// StartPeriodicReload reloads the provider every reload interval plus a random
+// jitter, skipping the reload when no configuration source changed.
+// It is a no-op when the reload interval is 0.
+func (p *HTTPProvider) StartPeriodicReload(ctx context.Context) {
+	interval := p.config.ReloadInterval
+	if interval <= 0 {
+		return
+	}
+	last, err := p.layoutFingerprint()
+	if err != nil {
+		logger.Warn("Failed to fingerprint configuration directories", "error", err)
+	}
+	go func() {
+		if !p.awaitQuietPeriod(ctx) {
+			return
+		}
+		// Changes made during the quiet period are checked for right after it
+		delay := time.Duration(0)
+		if p.config.QuietPeriod <= 0 {
+			delay = interval + jitter(p.config.ReloadJitter)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = interval + jitter(p.config.ReloadJitter)
+
+			layout, err := p.layoutFingerprint()
+			if err == nil && layout == last && !p.sourcesChanged(ctx) {
+				logger.Debug("Configuration sources unchanged, skipping periodic reload")
+				continue
+			}
+			if err := p.Reload(); err != nil {
+				logger.Error("Periodic reload failed", "error", err)
+				// Retry on the next tick, the last good bundles are kept meanwhile
+				continue
+			}
+			last = layout
+		}
+	}()
+}
+
+// awaitQuietPeriod blocks until the quiet period after startup has elapsed, so that the changes
+// made meanwhile, such as by a sidecar still generating files, coalesce into a single reload.
+// Manual reloads are not deferred. It returns false when ctx is done first.
+func (p *HTTPProvider) awaitQuietPeriod(ctx context.Context) bool {
+	remaining := time.Until(p.quietUntil)
+	if remaining <= 0 {
+		return true
+	}
+	logger.Debug("Deferring watch reloads until the quiet period elapses", "remaining", remaining.String())
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(remaining):
+		return true
+	}
+}
+
+// sourcesChanged reports whether the source of a configuration changed since its
+// bundle was loaded. A source that cannot tell, or a configuration without a
+// loaded bundle, counts as changed.
+func (p *HTTPProvider) sourcesChanged(ctx context.Context) bool {
+	for _, cfg := range p.Configurations() {
+		p.cacheMu.RLock()
+		cached := p.cache[cfg.ID]
+		p.cacheMu.RUnlock()
+		if cached == nil {
+			return true
+		}
+		source, err := p.source(cfg)
+		if err != nil {
+			return true
+		}
+		changed, err := source.Changed(ctx, cached.sourceVersion)
+		if err != nil {
+			logger.Warn("Failed to check configuration source", "id", cfg.ID, "error", err)
+			return true
+		}
+		if changed {
+			return true
+		}
+	}
+	return false
+}
+
+// jitter returns a random duration in [0, max)
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// layoutFingerprint fingerprints the directories read besides configuration
+// sources, the discovery root and the canary directories
+func (p *HTTPProvider) layoutFingerprint() (string, error) {
+	var directories []string
+	if p.config.Discovery != nil {
+		directories = append(directories, p.config.Discovery.Directory)
+	}
+	for _, cfg := range p.Configurations() {
+		if cfg.Canary != nil {
+			directories = append(directories, cfg.Canary.Directory)
+		}
+	}
+	return fingerprint(directories...)
+}
+
+// fingerprint hashes the path, size and modification time of every file of the directories
+func fingerprint(directories ...string) (string, error) {
+	h := sha256.New()
+	for _, directory := range directories {
+		err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(h, "%s\x00%d\x00%d\x00", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// MetadataPreview is the simulated effect of adding a configuration with the proposed metadata
+type MetadataPreview struct {
+	// ConfigID is the ID the proposed configuration would get
+	ConfigID string `json:"configId"`
+	// Collides is the existing configuration with the same ID, the proposed one would be rejected
+	Collides string `json:"collides,omitempty"`
+	// CurrentMatch is the configuration requests carrying the proposed metadata are served today,
+	// empty when they fall back
+	CurrentMatch string `json:"currentMatch,omitempty"`
+	// Shadowed lists the configurations whose requests the proposed one would take over
+	Shadowed []ShadowedConfig `json:"shadowed"`
+	// Safe is set when the proposed configuration neither collides nor shadows
+	Safe bool `json:"safe"`
+}
+
+// ShadowedConfig is an existing configuration losing requests to the proposed one
+type ShadowedConfig struct {
+	ConfigID string `json:"configId"`
+	// Request is the request metadata that would be served the proposed configuration instead
+	Request map[string]string `json:"request"`
+}
+
+// PreviewMetadata simulates adding a configuration with the proposed metadata and match strategy
+// to the current ones, reporting the existing configurations it would collide with or shadow.
+// The requests of an existing configuration are simulated by its metadata, alone and combined with
+// the proposed metadata, for clients that would also send the new labels.
+func (p *HTTPProvider) PreviewMetadata(metadata map[string]string, strategy string) (*MetadataPreview, error) {
+	if len(metadata) == 0 {
+		return nil, fmt.Errorf("metadata is required")
+	}
+	if strategy != "" && !slices.Contains(config.MatchStrategies, strategy) {
+		return nil, fmt.Errorf("%w: %q", ErrMatchStrategy, strategy)
+	}
+	p.cacheMu.RLock()
+	configurations := p.configurations
+	current := p.index
+	p.cacheMu.RUnlock()
+
+	proposed := &config.Configuration{ID: p.BuildCacheKey(metadata), Metadata: metadata, MatchStrategy: strategy}
+	hypothetical := p.newMatchIndex(append(slices.Clip(configurations), proposed))
+	preview := &MetadataPreview{ConfigID: proposed.ID, Shadowed: []ShadowedConfig{}}
+
+	requested := p.normalizeMetadata(metadata)
+	if cfg := p.bestMatch(current, requested, ""); cfg != nil {
+		preview.CurrentMatch = cfg.ID
+	}
+	for _, cfg := range configurations {
+		if cfg.ID == proposed.ID {
+			preview.Collides = cfg.ID
+			continue
+		}
+		if len(cfg.Metadata) == 0 {
+			continue
+		}
+		own := p.normalizeMetadata(cfg.Metadata)
+		requests := []map[string]string{own}
+		if combined, ok := combineMetadata(own, requested); ok {
+			requests = append(requests, combined)
+		}
+		for _, request := range requests {
+			before := p.bestMatch(current, request, "")
+			if before == nil || before.ID != cfg.ID || p.bestMatch(hypothetical, request, "") != proposed {
+				continue
+			}
+			preview.Shadowed = append(preview.Shadowed, ShadowedConfig{ConfigID: cfg.ID, Request: request})
+			break
+		}
+	}
+	preview.Safe = preview.Collides == "" && len(preview.Shadowed) == 0
+	return preview, nil
+}
+
+// combineMetadata returns the union of two metadata sets, or false when they set a key
+// to different values or the second one adds nothing
+func combineMetadata(a, b map[string]string) (map[string]string, bool) {
+	combined := maps.Clone(a)
+	for k, v := range b {
+		if existing, ok := combined[k]; ok && existing != v {
+			return nil, false
+		}
+		combined[k] = v
+	}
+	return combined, len(combined) > len(a)
+}
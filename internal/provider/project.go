@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"strings"
+)
+
+// fieldTree is a set of dotted field paths, a nil subtree selects the whole field
+type fieldTree map[string]fieldTree
+
+// Project returns the JSON encoding of v reduced to the given dot separated field paths.
+// Lists are traversed transparently, so "routes.path" keeps the path of every route.
+func Project(v any, fields []string) (any, error) {
+	value, err := toJSONValue(v)
+	if err != nil {
+		return nil, err
+	}
+	tree := fieldTree{}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		tree.add(strings.Split(field, "."))
+	}
+	return project(value, tree), nil
+}
+
+// add selects the field path, a shorter path selecting a parent wins over longer ones
+func (t fieldTree) add(path []string) {
+	key := path[0]
+	sub, ok := t[key]
+	if ok && sub == nil {
+		return
+	}
+	if len(path) == 1 {
+		t[key] = nil
+		return
+	}
+	if sub == nil {
+		sub = fieldTree{}
+		t[key] = sub
+	}
+	sub.add(path[1:])
+}
+
+func project(value any, tree fieldTree) any {
+	if tree == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		projected := make(map[string]any, len(tree))
+		for key, sub := range tree {
+			if child, ok := v[key]; ok {
+				projected[key] = project(child, sub)
+			}
+		}
+		return projected
+	case []any:
+		projected := make([]any, len(v))
+		for i, item := range v {
+			projected[i] = project(item, tree)
+		}
+		return projected
+	default:
+		return value
+	}
+}
@@ -1,40 +1,138 @@
 package provider
 
 import (
+	"cmp"
 	"context"
+	"crypto"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/metrics"
 	"github.com/jkaninda/goma-http-provider/internal/models"
+	"github.com/jkaninda/goma-http-provider/utils"
 	"github.com/jkaninda/logger"
-	"gopkg.in/yaml.v3"
 )
 
 type HTTPProvider struct {
-	config     *config.ProviderConfig
-	client     *http.Client
-	cache      map[string]*CachedConfig
-	cacheMu    sync.RWMutex
-	defaultID  string
-	reloadMu   sync.Mutex
-	lastReload time.Time
-	startTime  time.Time
-	metadata   map[string]string
+	config    *config.ProviderConfig
+	client    *http.Client
+	cache     map[string]*CachedConfig
+	cacheMu   sync.RWMutex
+	defaultID string
+	reloadMu  sync.Mutex
+	startTime time.Time
+	metadata  map[string]string
+	// quietUntil is the end of the quiet period after the initial load
+	quietUntil time.Time
+
+	// configurations holds the static and discovered configurations, guarded by cacheMu
+	configurations []*config.Configuration
+	// vault reads Vault secret references, when configured
+	vault *vaultClient
+	// auths holds the configuration auths with their secrets resolved, guarded by cacheMu
+	auths map[string]*config.HTTPAuth
+	// keyLabels maps the hashed configuration IDs to their human-readable form, guarded by cacheMu
+	keyLabels map[string]string
+	// index is the match index of configurations, guarded by cacheMu
+	index      *matchIndex
+	flags      *flagState
+	history    *bundleHistory
+	refreshes  flightGroup
+	changes    changeNotifier
+	reloadJobs reloadJobs
+	// now is the clock of the stale alarms
+	now func() time.Time
+	// readFile reads the config files of directories
+	readFile func(name string) ([]byte, error)
+	// stats holds the counters reported by GetStats
+	stats providerStats
+	// negative caches the metadata sets that matched no configuration
+	negative negativeCache
+	// signingKey signs the config JWTs, when configured
+	signingKey crypto.PrivateKey
+	// extractors derive the request metadata, in precedence order
+	extractors []Extractor
+	// routeToggles holds the routes disabled at runtime until the next reload
+	routeToggles routeToggles
+	// fallbackLog samples the warnings logged when a request falls back to a default
+	fallbackLog logSampler
+	// encoded caches the brotli and gzip encodings of the served bodies
+	encoded encodedBodies
+	// clients holds the HTTP clients of the configurations fetching from an API server
+	clients configClients
+	// kubeWatches holds the watches of the Kubernetes configurations
+	kubeWatches kubeWatches
+
+	drainMu         sync.RWMutex
+	draining        bool
+	drainRetryAfter time.Duration
 }
 
+// ConfigMtimeHeader is the response header exposing the latest modification time of the config files
+const ConfigMtimeHeader = "X-Goma-Config-Mtime"
+
+// Request options selecting a configuration by ID instead of metadata
+const (
+	ConfigIDHeader     = "X-Goma-Config-Id"
+	ConfigIDQueryParam = "configId"
+)
+
+// reservedQueryParams are request options, not metadata
+var reservedQueryParams = []string{"fields", "envelope", "async", "match", "timeFormat", "durationFormat", VersionQueryParam, ConfigIDQueryParam}
+
+// ErrMatchStrategy is returned when a request selects an unknown or disallowed match strategy
+var ErrMatchStrategy = errors.New("match strategy not allowed")
+
+// ErrStaleExpired is returned when the bundle of a configuration is stale for longer than its max stale age
+var ErrStaleExpired = errors.New("stale config exceeded its max stale age")
+
+// ErrAdminDisabled is returned when admin auth is not configured
+var ErrAdminDisabled = errors.New("admin access is not configured")
+
+// ErrConfigNotFound is returned when no configuration has the requested ID
+var ErrConfigNotFound = errors.New("configuration not found")
+
 type CachedConfig struct {
+	// configID is the configuration the bundle was loaded for
+	configID  string
 	Bundle    *config.ConfigBundle
 	ExpiresAt time.Time
 	ETag      string
+	// StaleSince is set when the last reload of this config failed
+	// and the previously loaded bundle is kept.
+	StaleSince time.Time
+	// variants memoizes the cache entry filtered per active feature flag set
+	variants *sync.Map
+	// routeFlags lists the feature flags referenced by the bundle routes
+	routeFlags []string
+	// compressed holds the gzip compressed routes and middlewares when cache
+	// compression is enabled, Bundle then only holds the other fields
+	compressed []byte
+	// Canary is served to the canary percentage of matching requests
+	Canary *CachedConfig
+	// PreviousETag is the checksum replaced by the last reload,
+	// still accepted on If-None-Match until PreviousUntil
+	PreviousETag  string
+	PreviousUntil time.Time
+	// sourceVersion is the version of the source the bundle was loaded from
+	sourceVersion string
+	// LoadedAt is when the bundle was last loaded successfully
+	LoadedAt time.Time
+	// ModTime is the latest modification time of the config files as of the last load
+	ModTime time.Time
 }
 
 type ProviderStats struct {
@@ -43,6 +141,37 @@ type ProviderStats struct {
 	Uptime        string    `json:"uptime"`
 	CacheHits     int64     `json:"cacheHits"`
 	CacheMisses   int64     `json:"cacheMisses"`
+	// Reloads counts the completed reloads, including the initial load
+	Reloads int64 `json:"reloads"`
+	// Loads counts the successful loads of the configuration, by full, tagged or lazy reloads
+	Loads      int64      `json:"loads"`
+	StaleSince *time.Time `json:"staleSince,omitempty"`
+	// StaleAlarm is set when the config has not loaded successfully within its staleAlarm threshold
+	StaleAlarm bool `json:"staleAlarm,omitempty"`
+	// SlowLoads counts the loads slower than the slow load threshold
+	SlowLoads int64 `json:"slowLoads,omitempty"`
+	// ConfigMtime is the latest modification time of the config files as of the last reload
+	ConfigMtime *time.Time `json:"configMtime,omitempty"`
+	// NegativeCacheHits counts the requests answered from the negative cache
+	NegativeCacheHits int64 `json:"negativeCacheHits,omitempty"`
+	// Runtime is only reported when runtime stats are enabled
+	Runtime *RuntimeStats `json:"runtime,omitempty"`
+}
+
+// RoutesSection is the routes subset of a bundle
+type RoutesSection struct {
+	Routes []models.Route `json:"routes"`
+}
+
+// MiddlewaresSection is the middlewares subset of a bundle
+type MiddlewaresSection struct {
+	Middlewares []models.Middleware `json:"middlewares"`
+}
+
+// BundleSources lists the file each route and middleware of a bundle was loaded from
+type BundleSources struct {
+	ConfigID string          `json:"configId"`
+	Sources  []config.Source `json:"sources"`
 }
 
 // NewHTTPProvider creates a new HTTP configuration provider
@@ -55,29 +184,84 @@ func NewHTTPProvider(config *config.ProviderConfig) (*HTTPProvider, error) {
 		cache:     make(map[string]*CachedConfig),
 		startTime: time.Now(),
 		metadata:  map[string]string{},
+		flags:     newFlagState(config.Flags),
+		history:   newBundleHistory(cmp.Or(config.BundleHistory, DefaultBundleHistory)),
+		now:       time.Now,
+		readFile:  os.ReadFile,
+	}
+	extractors, err := metadataExtractors(config.MetadataExtractors)
+	if err != nil {
+		return nil, err
+	}
+	provider.extractors = extractors
+	if config.Vault != nil {
+		if provider.vault, err = provider.newVaultClient(config.Vault); err != nil {
+			return nil, err
+		}
+	}
+	if config.JWTSigningKey != "" {
+		key, err := loadSigningKey(config.JWTSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		provider.signingKey = key
 	}
 
 	// Load and cache all configurations at startup
-	if err := provider.initialize(); err != nil {
-		return nil, fmt.Errorf("failed to initialize provider: %w", err)
+	start := time.Now()
+	err = provider.initialize()
+	provider.stats.recordReload("startup", start, err)
+	if err != nil {
+		// A degraded start tolerates configurations that failed to load,
+		// not a failure to complete the initial load
+		if !provider.degradedStartup() || provider.GetReloadTimestamp().IsZero() {
+			return nil, fmt.Errorf("failed to initialize provider: %w", err)
+		}
+		logger.Error("Provider started degraded, some configurations failed to load", "error", err)
 	}
+	provider.quietUntil = time.Now().Add(config.QuietPeriod)
 
 	return provider, nil
 }
 
-// initialize loads all configurations and identifies the default
+// degradedStartup reports whether the provider starts with the configurations
+// that loaded when others fail to
+func (p *HTTPProvider) degradedStartup() bool {
+	return p.config.StartupMode == config.StartupDegraded
+}
+
+// initialize loads all configurations and identifies the default.
+// On reload, a configuration that fails to load keeps its last good bundle
+// and is marked as stale.
 func (p *HTTPProvider) initialize() error {
 	p.reloadMu.Lock()
 	defer p.reloadMu.Unlock()
 
-	p.cacheMu.Lock()
-	p.cache = make(map[string]*CachedConfig)
-	p.cacheMu.Unlock()
+	p.cacheMu.RLock()
+	previous := p.cache
+	p.cacheMu.RUnlock()
+	p.vault.clear()
 
+	configurations, err := p.discoverConfigurations()
+	if err != nil {
+		return err
+	}
+
+	initialLoad := p.GetReloadTimestamp().IsZero()
+	cache := make(map[string]*CachedConfig)
 	seenIDs := map[string]struct{}{}
+	keyLabels := map[string]string{}
+	auths := map[string]*config.HTTPAuth{}
+	var defaults []string
+	var errs []error
 
-	for _, cfg := range p.config.Configurations {
-		cfg.ID = p.BuildCacheKey(cfg.Metadata)
+	for _, cfg := range configurations {
+		if id := p.BuildCacheKey(cfg.Metadata); cfg.ID != id {
+			cfg.ID = id
+		}
+		if p.config.HashCacheKeys {
+			keyLabels[cfg.ID] = cacheKeyLabel(cfg.Metadata)
+		}
 		if cfg.ID == "" {
 			return fmt.Errorf("configuration id is required")
 		}
@@ -86,35 +270,169 @@ func (p *HTTPProvider) initialize() error {
 		}
 		seenIDs[cfg.ID] = struct{}{}
 
-		bundle, err := p.loadConfigFromDirectory(cfg.Directory)
+		if cfg.Default && len(cfg.DefaultScope) == 0 {
+			defaults = append(defaults, cfg.ID)
+		}
+
+		loadStart := time.Now()
+		bundle, version, err := p.loadConfiguration(context.Background(), cfg)
+		metrics.LoadDuration.ObserveSince(loadStart, cfg.ID, outcome(err))
+		var auth *config.HTTPAuth
+		if err == nil {
+			auth, err = p.resolveAuth(context.Background(), cfg.Auth)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to load config %s: %w", cfg.ID, err)
+			last, ok := previous[cfg.ID]
+			if !ok {
+				if initialLoad {
+					if stale, ok := p.lastGoodConfig(cfg.ID); ok {
+						logger.Error("Failed to load config, serving the last good copy from disk", "id", cfg.ID, "error", err)
+						cache[cfg.ID] = stale
+						continue
+					}
+					if !p.degradedStartup() {
+						return fmt.Errorf("failed to load config %s: %w", cfg.ID, err)
+					}
+				}
+				logger.Error("Failed to load new config", "id", cfg.ID, "error", err)
+				errs = append(errs, fmt.Errorf("failed to load config %s: %w", cfg.ID, err))
+				continue
+			}
+			logger.Error("Failed to reload config, keeping last good", "id", cfg.ID, "error", err)
+			if auth, ok := p.auths[cfg.ID]; ok {
+				auths[cfg.ID] = auth
+			}
+			stale := *last
+			if stale.StaleSince.IsZero() {
+				stale.StaleSince = time.Now()
+			}
+			cache[cfg.ID] = &stale
+			errs = append(errs, fmt.Errorf("failed to load config %s: %w", cfg.ID, err))
+			continue
 		}
 
-		// merge metadata
+		auths[cfg.ID] = auth
 		for k, v := range cfg.Metadata {
-			bundle.Metadata[k] = v
 			p.metadata[k] = v
 		}
+		cached, canaryErr, err := p.cacheBundle(cfg, bundle, version, previous[cfg.ID])
+		if err != nil {
+			return err
+		}
+		if canaryErr != nil {
+			errs = append(errs, canaryErr)
+		}
+		observeChecksumChange(cfg.ID, previous[cfg.ID], cached)
+		cache[cfg.ID] = cached
+	}
 
-		bundle.Checksum = p.calculateChecksum(bundle)
-		bundle.Timestamp = time.Now()
+	if slices.Contains(p.fallbackChain(), config.FallbackEmpty) {
+		// The empty bundle never changes, so its timestamp is the one of the first load
+		if empty := previous[emptyConfig.ID]; empty != nil {
+			cache[emptyConfig.ID] = empty
+		} else {
+			cache[emptyConfig.ID] = emptyCachedConfig()
+		}
+	}
+	defaultID, err := p.selectDefault(defaults)
+	if err != nil {
+		return err
+	}
+	if defaultID != "" && cache[defaultID] == nil {
+		logger.Warn("Default configuration is not loaded, requests falling back to it will fail", "id", defaultID)
+	}
 
-		p.cacheMu.Lock()
-		p.cache[cfg.ID] = &CachedConfig{
-			Bundle:    bundle,
-			ExpiresAt: time.Now().Add(5 * time.Minute),
-			ETag:      bundle.Checksum,
+	index := p.newMatchIndex(configurations)
+	p.cacheMu.Lock()
+	p.cache = cache
+	p.configurations = configurations
+	p.keyLabels = keyLabels
+	p.auths = auths
+	p.index = index
+	p.defaultID = defaultID
+	p.cacheMu.Unlock()
+	p.negative.clear()
+	p.routeToggles.clear()
+	p.encoded.clear()
+	p.history.retain(cache)
+	p.clients.retain(configurations)
+	p.changes.notifyChanged(previous, cache)
+	if p.config.LastGoodFile != "" {
+		if err := p.persistLastGood(cache); err != nil {
+			logger.Error("Failed to persist last good configs", "file", p.config.LastGoodFile, "error", err)
 		}
-		p.cacheMu.Unlock()
+	}
+
+	p.stats.reloaded(time.Now(), len(cache))
+	return errors.Join(errs...)
+}
 
-		if cfg.Default {
-			p.defaultID = cfg.ID
+// cacheBundle builds the cache entry of a freshly loaded bundle, along with its canary.
+// A canary that fails to load is returned as canaryErr without failing the entry.
+func (p *HTTPProvider) cacheBundle(cfg *config.Configuration, bundle *config.ConfigBundle, version string, previous *CachedConfig) (cached *CachedConfig, canaryErr, err error) {
+	p.stats.addLoad(cfg.ID)
+	if previous != nil && previous.ETag == bundle.Checksum {
+		// Unchanged content keeps the cached entry, including its timestamp
+		unchanged := *previous
+		unchanged.StaleSince = time.Time{}
+		unchanged.ExpiresAt = p.expiresAt(time.Now())
+		cached = &unchanged
+	} else {
+		bundle.Timestamp = time.Now()
+		cached, err = p.newCachedConfig(cfg.ID, bundle)
+		if err != nil {
+			return nil, nil, err
 		}
+		p.keepPreviousETag(cached, previous)
 	}
+	cached.sourceVersion = version
+	cached.LoadedAt = p.now()
+	cached.ModTime = bundle.ModTime
+	if cfg.Canary != nil {
+		canary, err := p.loadCanary(cfg, previous)
+		if err != nil {
+			canaryErr = fmt.Errorf("failed to load canary config %s: %w", cfg.ID, err)
+		}
+		cached.Canary = canary
+	}
+	return cached, canaryErr, nil
+}
 
-	p.lastReload = time.Now()
-	return nil
+// observeChecksumChange records the time of a checksum change, including the first load
+func observeChecksumChange(id string, previous, cached *CachedConfig) {
+	if previous == nil || previous.ETag != cached.ETag {
+		metrics.ConfigLastChange.SetToTime(cached.LoadedAt, id)
+	}
+}
+
+// keepPreviousETag remembers the checksum replaced by a reload for the grace window
+func (p *HTTPProvider) keepPreviousETag(cached, previous *CachedConfig) {
+	if p.config.ChecksumGrace <= 0 || previous == nil {
+		return
+	}
+	if previous.ETag != cached.ETag {
+		cached.PreviousETag = previous.ETag
+		cached.PreviousUntil = time.Now().Add(p.config.ChecksumGrace)
+		return
+	}
+	cached.PreviousETag = previous.PreviousETag
+	cached.PreviousUntil = previous.PreviousUntil
+}
+
+// NotModified reports whether the If-None-Match header matches the served bundle,
+// or the previous checksum of the config within the grace window
+func (p *HTTPProvider) NotModified(id string, bundle *config.ConfigBundle, ifNoneMatch string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if ETagMatches(ifNoneMatch, bundle.Checksum) {
+		return true
+	}
+	p.cacheMu.RLock()
+	cached := p.cache[id]
+	p.cacheMu.RUnlock()
+	return cached != nil && cached.PreviousETag != "" && ETagMatches(ifNoneMatch, cached.PreviousETag) &&
+		time.Now().Before(cached.PreviousUntil)
 }
 
 // GetConfig retrieves configuration based on metadata filters
@@ -123,31 +441,176 @@ func (p *HTTPProvider) GetConfig(
 	metadata map[string]string,
 ) (*config.ConfigBundle, *config.Configuration, error) {
 
-	cfg := p.matchConfiguration(metadata)
+	return p.GetConfigMatching(ctx, metadata, "")
+}
+
+// GetConfigMatching returns the bundle of the configuration matching the metadata
+// with the given match strategy, or with the configured strategies when empty
+func (p *HTTPProvider) GetConfigMatching(
+	ctx context.Context,
+	metadata map[string]string,
+	strategy string,
+) (*config.ConfigBundle, *config.Configuration, error) {
+	if strategy != "" && !p.matchStrategyAllowed(strategy) {
+		return nil, nil, fmt.Errorf("%w: %q", ErrMatchStrategy, strategy)
+	}
+	negativeTTL := p.config.NegativeCacheTTL
+	key := ""
+	if negativeTTL > 0 {
+		key = p.negativeKey(metadata, strategy)
+		if p.negative.hit(key, time.Now()) {
+			p.stats.negativeHits.Add(1)
+			logger.Debug("no configuration matched metadata, from negative cache")
+			return nil, nil, fmt.Errorf("no configuration matched metadata")
+		}
+	}
+	cfg, fallback := p.matchConfiguration(metadata, strategy)
 	if cfg == nil {
 		logger.Debug("no configuration matched metadata")
+		if negativeTTL > 0 {
+			now := time.Now()
+			p.negative.add(key, now, now.Add(negativeTTL))
+		}
 
 		return nil, nil, fmt.Errorf("no configuration matched metadata")
 	}
+	bundle, cfg, err := p.cachedBundle(ctx, cfg, metadata)
+	if err == nil && fallback {
+		recordConfigSource(ctx, SourceDefaultFallback)
+	}
+	return bundle, cfg, err
+}
 
+// GetConfigByID returns the bundle of the configuration with the given ID,
+// bypassing metadata matching. Metadata still selects the canary bucket.
+func (p *HTTPProvider) GetConfigByID(
+	ctx context.Context,
+	id string,
+	metadata map[string]string,
+) (*config.ConfigBundle, *config.Configuration, error) {
+	for _, cfg := range p.Configurations() {
+		if cfg.ID == id {
+			return p.cachedBundle(ctx, cfg, metadata)
+		}
+	}
+	logger.Debug("no configuration with the requested ID", "id", id)
+	return nil, nil, fmt.Errorf("config %s not found", id)
+}
+
+// cachedBundle returns the cached bundle of a configuration, refreshed when expired
+func (p *HTTPProvider) cachedBundle(
+	ctx context.Context,
+	cfg *config.Configuration,
+	metadata map[string]string,
+) (*config.ConfigBundle, *config.Configuration, error) {
 	p.cacheMu.RLock()
 	cached := p.cache[cfg.ID]
 	p.cacheMu.RUnlock()
 
 	if cached == nil {
+		p.stats.cacheMisses.Add(1)
 		return nil, nil, fmt.Errorf("config %s not loaded", cfg.ID)
 	}
-	logger.Debug("cached configuration matched metadata")
-	return cached.Bundle, cfg, nil
+	logger.Debug("cached configuration selected", "id", cfg.ID)
+	source := SourceCache
+	if cached.expired(time.Now()) {
+		p.stats.cacheMisses.Add(1)
+		cached = p.refresh(cfg, cached)
+		source = SourceLazyReload
+	} else {
+		p.stats.cacheHits.Add(1)
+	}
+	if p.staleExpired(cfg, cached) {
+		logger.Error("Refusing to serve stale config past its max stale age", "id", cfg.ID, "loadedAt", cached.LoadedAt, "maxStaleAge", cfg.MaxStaleAge.String())
+		return nil, nil, fmt.Errorf("%w: config %s last loaded at %s", ErrStaleExpired, cfg.ID, cached.LoadedAt.Format(time.RFC3339))
+	}
+	if cached.Canary != nil && inCanary(cfg, metadata) {
+		cached = cached.Canary
+	}
+	bundle, err := p.applyFlags(cfg.ID, cached)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordConfigSource(ctx, source)
+	return bundle, cfg, nil
 }
 
-func (p *HTTPProvider) loadConfigFromDirectory(directory string) (*config.ConfigBundle, error) {
-	bundle := &config.ConfigBundle{
-		Version:     "1.0",
-		Routes:      make([]models.Route, 0),
-		Middlewares: make([]models.Middleware, 0),
-		Metadata:    make(map[string]string),
+// descend returns filepath.SkipDir when the subdirectory at path is beyond
+// the recursion settings of the configuration
+func (p *HTTPProvider) descend(cfg *config.Configuration, path string) error {
+	if !cfg.IsRecursive() {
+		return filepath.SkipDir
+	}
+	if cfg.MaxDepth > 0 {
+		rel, err := filepath.Rel(cfg.Directory, path)
+		if err != nil {
+			return err
+		}
+		if depth := strings.Count(rel, string(filepath.Separator)) + 1; depth > cfg.MaxDepth {
+			return filepath.SkipDir
+		}
+	}
+	return nil
+}
+
+// loadConfiguration loads the bundle of a configuration and its version from its source
+func (p *HTTPProvider) loadConfiguration(ctx context.Context, cfg *config.Configuration) (*config.ConfigBundle, string, error) {
+	source, err := p.source(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	start := time.Now()
+	bundle, version, err := source.Load(ctx)
+	p.observeLoadDuration(cfg, time.Since(start))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := p.prepareBundle(ctx, cfg, bundle); err != nil {
+		return nil, "", err
+	}
+	return bundle, version, nil
+}
+
+// prepareBundle runs a decoded bundle through the checks and rewrites of a load, then merges
+// the configuration metadata and sets the checksum the bundle is served with
+func (p *HTTPProvider) prepareBundle(ctx context.Context, cfg *config.Configuration, bundle *config.ConfigBundle) error {
+	if cfg.RequireNonEmpty && len(bundle.Routes) == 0 {
+		return fmt.Errorf("configuration %s has no routes", cfg.ID)
+	}
+	p.normalizeRoutePaths(bundle)
+	p.validateMiddlewarePaths(bundle)
+	if err := p.resolveRouteSecrets(ctx, cfg, bundle); err != nil {
+		return err
 	}
+	if err := p.validateRouteTLS(bundle); err != nil {
+		return err
+	}
+	if err := p.runValidator(ctx, bundle); err != nil {
+		return err
+	}
+	p.mergeConfigMetadata(cfg, bundle)
+	bundle.Checksum = calculateChecksum(bundle)
+	return nil
+}
+
+// observeLoadDuration logs and counts a load slower than the slow load threshold
+func (p *HTTPProvider) observeLoadDuration(cfg *config.Configuration, elapsed time.Duration) {
+	if p.config.SlowLoadThreshold <= 0 || elapsed <= p.config.SlowLoadThreshold {
+		return
+	}
+	logger.Warn("Slow configuration load", "id", cfg.ID, "source", cfg.SourceType(), "path", cfg.Directory, "duration", elapsed.String())
+	p.stats.addSlowLoad(cfg.ID)
+}
+
+// SlowLoads returns the number of loads of the config slower than the slow load threshold
+func (p *HTTPProvider) SlowLoads(id string) int64 {
+	return p.stats.slowLoadCount(id)
+}
+
+func (p *HTTPProvider) loadConfigFromDirectory(cfg *config.Configuration) (*config.ConfigBundle, error) {
+	directory := cfg.Directory
+	bundle := newBundle()
+	root := realRoot(directory)
 
 	// Walk through directory
 	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
@@ -156,43 +619,42 @@ func (p *HTTPProvider) loadConfigFromDirectory(directory string) (*config.Config
 		}
 
 		if info.IsDir() {
-			return nil
+			if path == directory {
+				return nil
+			}
+			return p.descend(cfg, path)
 		}
 
-		// Only process YAML/JSON files
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+		// The metadata file of a discovered tenant is not part of its bundle
+		if cfg.Discovered && path == filepath.Join(directory, discoveryMetaFile) {
 			return nil
 		}
 
-		// Read file
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read %s: %w", path, err)
+		// Only process YAML/JSON files
+		if !isConfigFile(path) {
+			return nil
 		}
 
-		// Parse based on file type
-		var tempBundle config.ConfigBundle
-		if ext == ".json" {
-			if err := json.Unmarshal(data, &tempBundle); err != nil {
-				return fmt.Errorf("failed to parse JSON %s: %w", path, err)
+		// Files symlinked from outside of the directory are not loaded
+		if p.confinePaths() {
+			rel, err := filepath.Rel(directory, path)
+			if err != nil {
+				return err
 			}
-		} else {
-			if err := yaml.Unmarshal(data, &tempBundle); err != nil {
-				return fmt.Errorf("failed to parse YAML %s: %w", path, err)
+			if _, err := confinedPath(root, rel); err != nil {
+				return err
 			}
 		}
 
-		// Merge into main bundle
-		bundle.Routes = append(bundle.Routes, tempBundle.Routes...)
-		bundle.Middlewares = append(bundle.Middlewares, tempBundle.Middlewares...)
-
-		// Merge metadata
-		for k, v := range tempBundle.Metadata {
-			bundle.Metadata[k] = v
+		// Read file
+		data, err := p.readFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
 		}
-
-		return nil
+		if modTime := info.ModTime(); modTime.After(bundle.ModTime) {
+			bundle.ModTime = modTime
+		}
+		return p.mergeConfigFile(bundle, path, data)
 	})
 
 	if err != nil {
@@ -202,65 +664,188 @@ func (p *HTTPProvider) loadConfigFromDirectory(directory string) (*config.Config
 	return bundle, nil
 }
 
-// ExtractMetadata extracts metadata from request
-func (p *HTTPProvider) ExtractMetadata(r *http.Request) map[string]string {
-	metadata := make(map[string]string)
+// newBundle returns an empty bundle to merge config files into
+func newBundle() *config.ConfigBundle {
+	return &config.ConfigBundle{
+		Version:     "1.0",
+		Routes:      make([]models.Route, 0),
+		Middlewares: make([]models.Middleware, 0),
+		Metadata:    make(map[string]string),
+	}
+}
+
+// isConfigFile reports whether the file name has a YAML or JSON extension
+func isConfigFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml" || ext == ".json"
+}
+
+// mergeConfigFile decodes a YAML or JSON config file and merges it into the bundle.
+// Each document of a multi-document YAML file is merged in order.
+func (p *HTTPProvider) mergeConfigFile(bundle *config.ConfigBundle, path string, data []byte) error {
+	// Parse based on file type
+	isJSON := strings.ToLower(filepath.Ext(path)) == ".json"
+	if isJSON {
+		return p.mergeConfigDocument(bundle, path, data, true)
+	}
+	documents, err := splitYAMLDocuments(path, data)
+	if err != nil {
+		return err
+	}
+	for _, document := range documents {
+		if err := p.mergeConfigDocument(bundle, path, document, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeConfigDocument decodes a single config document and merges it into the bundle
+func (p *HTTPProvider) mergeConfigDocument(bundle *config.ConfigBundle, path string, data []byte, isJSON bool) error {
+	bundle.Warnings = appendUnique(bundle.Warnings, checkDeprecations(path, data, isJSON)...)
 
-	// From query parameters
-	for key, values := range r.URL.Query() {
-		if len(values) > 0 {
-			metadata[key] = values[0]
+	// Upgrade files declaring an older schema version
+	data, isJSON, from, err := migrateConfigFile(path, data, isJSON)
+	if err != nil {
+		return err
+	}
+	if from > 0 {
+		bundle.Transformations = append(bundle.Transformations, config.Transformation{
+			Kind:   TransformSchemaMigration,
+			Detail: fmt.Sprintf("%s migrated from schema version %d to %d", path, from, SchemaVersion()),
+		})
+	}
+
+	var tempBundle config.ConfigBundle
+	if isJSON {
+		if err := decodeJSON(path, data, p.config.StrictJSON, p.config.StrictFields, &tempBundle); err != nil {
+			return err
+		}
+	} else {
+		if err := decodeYAML(path, data, p.config.StrictFields, &tempBundle); err != nil {
+			return err
 		}
 	}
 
-	// From headers (with X-Goma-Meta- prefix)
-	for key, values := range r.Header {
-		if strings.HasPrefix(key, "X-Goma-Meta-") && len(values) > 0 {
-			metaKey := strings.ToLower(strings.TrimPrefix(key, "X-Goma-Meta-"))
-			metadata[metaKey] = values[0]
+	// Merge into main bundle
+	if err := p.mergeRoutes(bundle, path, tempBundle.Routes); err != nil {
+		return err
+	}
+	bundle.Middlewares = append(bundle.Middlewares, tempBundle.Middlewares...)
+	for _, middleware := range tempBundle.Middlewares {
+		bundle.Sources = append(bundle.Sources, config.Source{Kind: "middleware", Name: middleware.Name, File: path})
+	}
+
+	// Merge metadata
+	for k, v := range tempBundle.Metadata {
+		bundle.Metadata[k] = v
+	}
+
+	if p.config.PassthroughFields {
+		extra, err := unknownFields(path, data, isJSON)
+		if err != nil {
+			return err
 		}
+		for k, v := range extra {
+			if bundle.Extra == nil {
+				bundle.Extra = make(map[string]json.RawMessage)
+			}
+			bundle.Extra[k] = v
+		}
+	}
+	return nil
+}
+
+// ExtractMetadata extracts metadata from request
+func (p *HTTPProvider) ExtractMetadata(r *http.Request) map[string]string {
+	metadata := p.requestMetadata(r)
+	p.applyMetadataDefaults(metadata)
+	return metadata
+}
+
+// requestMetadata extracts the metadata carried by the request, without the defaults
+func (p *HTTPProvider) requestMetadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for _, extractor := range p.extractors {
+		extractor.Extract(r, metadata)
 	}
+	p.filterMetadataKeys(metadata)
+	p.transformMetadata(metadata)
+	p.clientCertMetadata(r, metadata)
 	return metadata
 }
 
-// Authenticate validates the request based on configured auth
+// ExtractConfigID returns the configuration ID requested explicitly by header
+// or query parameter, or an empty string when the request relies on metadata
+func (p *HTTPProvider) ExtractConfigID(r *http.Request) string {
+	if id := r.Header.Get(ConfigIDHeader); id != "" {
+		return id
+	}
+	return r.URL.Query().Get(ConfigIDQueryParam)
+}
+
+// Authenticate validates the request against the auth the configuration requires for the operation
 func (p *HTTPProvider) Authenticate(
 	r *http.Request,
 	cfg *config.Configuration,
+	operation string,
 ) error {
-	if cfg.Auth == nil {
+	if err := authenticate(r, p.configAuth(cfg).ForOperation(operation)); err != nil {
+		return fmt.Errorf("authentication failed for config")
+	}
+	return nil
+}
+
+// AuthenticateAdmin validates the request against the provider admin auth
+func (p *HTTPProvider) AuthenticateAdmin(r *http.Request) error {
+	if p.config.Admin == nil {
+		return ErrAdminDisabled
+	}
+	if err := authenticate(r, p.config.Admin); err != nil {
+		return fmt.Errorf("authentication failed for admin")
+	}
+	return nil
+}
+
+// authenticate checks the request credentials against the given auth,
+// a nil auth allows every request
+func authenticate(r *http.Request, auth *config.HTTPAuth) error {
+	if auth == nil {
 		return nil
 	}
 	// API Key authentication
-	if key := cfg.Auth.APIKey; key != "" {
-		if r.Header.Get("X-API-Key") != key {
-			return fmt.Errorf("authentication failed for config")
+	if key := auth.APIKey; key != "" {
+		// An unresolved secret reference is never a credential
+		if isSecretReference(key) || r.Header.Get("X-API-Key") != key {
+			return fmt.Errorf("invalid api key")
 		}
 		return nil
 	}
 
 	// Basic Auth authentication
-	ba := cfg.Auth.BasicAuth
+	ba := auth.BasicAuth
 	if ba == nil || ba.Username == "" {
 		return nil
 	}
 
 	u, pass, ok := r.BasicAuth()
-	if !ok || u != ba.Username || pass != ba.Password {
-		return fmt.Errorf("authentication failed for config")
+	if !ok || isSecretReference(ba.Password) || u != ba.Username || pass != ba.Password {
+		return fmt.Errorf("invalid basic auth credentials")
 	}
 
 	return nil
 }
 
 func (p *HTTPProvider) GetMetadata() map[string]string {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
 	if p.cache[p.defaultID] != nil {
 		return p.cache[p.defaultID].Bundle.Metadata
 
 	}
 	return p.metadata
 }
-func (p *HTTPProvider) calculateChecksum(bundle *config.ConfigBundle) string {
+func calculateChecksum(bundle *config.ConfigBundle) string {
 	temp := *bundle
 	temp.Checksum = ""
 	temp.Timestamp = time.Time{}
@@ -269,18 +854,55 @@ func (p *HTTPProvider) calculateChecksum(bundle *config.ConfigBundle) string {
 	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:])
 }
+
+// SectionChecksum returns the checksum of a bundle subset, used as its ETag
+func SectionChecksum(section any) string {
+	data, _ := json.Marshal(section)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// matchConfiguration returns the configuration sharing the most metadata values with
+// the request among those satisfying their match strategy. The requested strategy
+// overrides the configured ones, and a strict or exact request never falls back.
+// It reports whether the configuration comes from the fallback chain.
 func (p *HTTPProvider) matchConfiguration(
 	metadata map[string]string,
-) *config.Configuration {
+	requested string,
+) (*config.Configuration, bool) {
+
+	metadata = p.normalizeMetadata(metadata)
+	if best := p.bestMatch(p.currentMatchIndex(), metadata, requested); best != nil {
+		return best, false
+	}
+	if requested != "" && requested != config.MatchBest {
+		return nil, false
+	}
+	cfg := p.fallbackConfiguration(metadata)
+	return cfg, cfg != nil
+}
 
+// bestMatch returns the indexed configuration scoring best for the normalized request
+// metadata under its match strategy, the first one on ties, or nil when none qualifies
+func (p *HTTPProvider) bestMatch(index *matchIndex, metadata map[string]string, requested string) *config.Configuration {
 	var best *config.Configuration
 	bestScore := 0
+	minScore := p.minMatchScore(len(metadata))
 
-	for _, cfg := range p.config.Configurations {
-		score := 0
-		for k, v := range metadata {
-			if cfg.Metadata[k] == v {
-				score++
+	// Configurations sharing no value with the request score zero and are never selected
+	for _, c := range index.candidates(metadata) {
+		cfg, cfgMetadata, score := index.configs[c.position], index.metadata[c.position], c.score
+		if score < minScore {
+			continue
+		}
+		switch p.matchStrategy(cfg, requested) {
+		case config.MatchStrict:
+			if score < len(cfgMetadata) {
+				continue
+			}
+		case config.MatchExact:
+			if score < len(cfgMetadata) || len(metadata) != len(cfgMetadata) {
+				continue
 			}
 		}
 		if score > bestScore {
@@ -288,56 +910,279 @@ func (p *HTTPProvider) matchConfiguration(
 			best = cfg
 		}
 	}
+	return best
+}
 
-	if best != nil {
-		return best
+// minMatchScore returns the lowest score selecting a configuration for a request with the given
+// number of metadata keys, a fractional threshold being rounded up
+func (p *HTTPProvider) minMatchScore(requested int) int {
+	threshold := p.config.MinMatchScore
+	if threshold < 1 {
+		threshold *= float64(requested)
 	}
+	return int(math.Ceil(threshold))
+}
 
-	// fallback to default
-	if p.defaultID != "" {
-		for _, cfg := range p.config.Configurations {
-			if cfg.ID == p.defaultID {
-				logger.Info("Config not found, fallback to default", "ID", cfg.ID)
-				return cfg
-			}
-		}
+// matchStrategy returns the match strategy applied to a configuration:
+// the requested one, else the configuration one, else the provider default
+func (p *HTTPProvider) matchStrategy(cfg *config.Configuration, requested string) string {
+	switch {
+	case requested != "":
+		return requested
+	case cfg.MatchStrategy != "":
+		return cfg.MatchStrategy
+	case p.config.MatchStrategy != "":
+		return p.config.MatchStrategy
+	default:
+		return config.MatchBest
 	}
-	return nil
+}
+
+// matchStrategyAllowed reports whether requests may select the match strategy
+func (p *HTTPProvider) matchStrategyAllowed(strategy string) bool {
+	if !slices.Contains(config.MatchStrategies, strategy) {
+		return false
+	}
+	return len(p.config.AllowedMatchStrategies) == 0 || slices.Contains(p.config.AllowedMatchStrategies, strategy)
+}
+
+// expiresAt returns the cache expiry for an entry loaded at the given time,
+// or the zero time when cached configs never expire
+func (p *HTTPProvider) expiresAt(loadedAt time.Time) time.Time {
+	if p.config.CacheTTL <= 0 {
+		return time.Time{}
+	}
+	return loadedAt.Add(p.config.CacheTTL)
 }
 
 // Reload refreshes all configurations
 func (p *HTTPProvider) Reload() error {
-	return p.initialize()
+	start := time.Now()
+	err := p.initialize()
+	metrics.ReloadDuration.ObserveSince(start, outcome(err))
+	p.stats.recordReload("full", start, err)
+	return err
+}
+
+// outcome returns the metrics outcome label for an operation result
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
 }
 
 // getReloadTimestamp returns the last reload timestamp
 func (p *HTTPProvider) GetReloadTimestamp() time.Time {
-	p.reloadMu.Lock()
-	defer p.reloadMu.Unlock()
-	return p.lastReload
+	return p.stats.lastReloadTime()
 }
 
-// GetStats returns provider statistics
-func (p *HTTPProvider) GetStats() ProviderStats {
-	p.cacheMu.RLock()
-	configCount := len(p.cache)
-	p.cacheMu.RUnlock()
+// GetStats returns provider statistics for the given configuration
+func (p *HTTPProvider) GetStats(id string) ProviderStats {
+	stats := ProviderStats{
+		ConfigsLoaded: int(p.stats.configsLoaded.Load()),
+		LastReload:    p.GetReloadTimestamp(),
+		Uptime:        time.Since(p.startTime).String(),
+		CacheHits:     p.stats.cacheHits.Load(),
+		CacheMisses:   p.stats.cacheMisses.Load(),
+		Reloads:       p.stats.reloads.Load(),
+		Loads:         p.stats.loadCount(id),
+	}
+	if p.ReportStale() {
+		if since := p.StaleSince(id); !since.IsZero() {
+			stats.StaleSince = &since
+		}
+	}
+	stats.StaleAlarm = p.staleAlarm(id)
+	stats.SlowLoads = p.SlowLoads(id)
+	if mtime := p.ConfigMtime(id); !mtime.IsZero() {
+		stats.ConfigMtime = &mtime
+	}
+	stats.NegativeCacheHits = p.NegativeCacheHits()
+	if p.config.RuntimeStats {
+		stats.Runtime = collectRuntimeStats()
+	}
+	return stats
+}
+
+// ProviderStatus is the aggregate state of the provider
+type ProviderStatus struct {
+	Service       string    `json:"service"`
+	Version       string    `json:"version"`
+	Ready         bool      `json:"ready"`
+	Draining      bool      `json:"draining"`
+	ConfigsLoaded int       `json:"configsLoaded"`
+	LastReload    time.Time `json:"lastReload"`
+	Uptime        string    `json:"uptime"`
+	// StaleAlarms lists the configurations whose stale alarm fires
+	StaleAlarms []string `json:"staleAlarms,omitempty"`
+	// FailedConfigs lists the configurations that never loaded, such as after a degraded start
+	FailedConfigs []string `json:"failedConfigs,omitempty"`
+}
 
-	return ProviderStats{
+// Status reports the aggregate provider state. The provider is ready when at least
+// one configuration is loaded, it is not draining and no readiness failing stale alarm fires.
+func (p *HTTPProvider) Status() ProviderStatus {
+	configCount := int(p.stats.configsLoaded.Load())
+	draining, _ := p.Draining()
+
+	ready := configCount > 0 && !draining
+	var alarms, failed []string
+	for _, cfg := range p.Configurations() {
+		if !p.loaded(cfg.ID) {
+			failed = append(failed, cfg.ID)
+		}
+		if p.staleAlarm(cfg.ID) {
+			alarms = append(alarms, cfg.ID)
+			ready = ready && !cfg.StaleAlarm.FailReadiness
+		}
+	}
+
+	return ProviderStatus{
+		Service:       "http-provider",
+		Version:       utils.Version,
+		Ready:         ready,
+		StaleAlarms:   alarms,
+		FailedConfigs: failed,
+		Draining:      draining,
 		ConfigsLoaded: configCount,
 		LastReload:    p.GetReloadTimestamp(),
-		Uptime:        time.Since(p.startTime).String(),
+		Uptime:        time.Since(p.startTime).Round(time.Second).String(),
+	}
+}
+
+// HealthSummary is the compact provider state a health check includes on request
+type HealthSummary struct {
+	Ready   bool `json:"ready"`
+	Configs int  `json:"configs"`
+	// Routes is the number of routes served across the loaded configurations
+	Routes        int    `json:"routes"`
+	LastReloadAge string `json:"lastReloadAge"`
+}
+
+// HealthSummary summarizes the provider state for health checks
+func (p *HTTPProvider) HealthSummary() HealthSummary {
+	status := p.Status()
+	summary := HealthSummary{Ready: status.Ready, Configs: status.ConfigsLoaded}
+	if !status.LastReload.IsZero() {
+		summary.LastReloadAge = time.Since(status.LastReload).Round(time.Second).String()
+	}
+	p.cacheMu.RLock()
+	configurations := p.configurations
+	cache := p.cache
+	p.cacheMu.RUnlock()
+	for _, cfg := range configurations {
+		cached := cache[cfg.ID]
+		if cached == nil {
+			continue
+		}
+		// Routes are counted as served, after decompression, feature flags and route toggles.
+		// A bundle failing to decompress is left out rather than failing the health check.
+		if bundle, err := p.applyFlags(cfg.ID, cached); err == nil {
+			summary.Routes += len(bundle.Routes)
+		}
+	}
+	return summary
+}
+
+// HealthSummaryEnabled reports whether every health check includes the provider summary
+func (p *HTTPProvider) HealthSummaryEnabled() bool {
+	return p.config.HealthSummary
+}
+
+// loaded reports whether the config has a cached bundle
+func (p *HTTPProvider) loaded(id string) bool {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	return p.cache[id] != nil
+}
+
+// staleAlarm reports whether the config has not loaded successfully within its
+// staleAlarm threshold. A config that never loaded counts from the provider start.
+func (p *HTTPProvider) staleAlarm(id string) bool {
+	var alarm *config.StaleAlarm
+	for _, cfg := range p.Configurations() {
+		if cfg.ID == id {
+			alarm = cfg.StaleAlarm
+		}
+	}
+	if alarm == nil {
+		return false
+	}
+	p.cacheMu.RLock()
+	cached := p.cache[id]
+	p.cacheMu.RUnlock()
+	loadedAt := p.startTime
+	if cached != nil {
+		loadedAt = cached.LoadedAt
+	}
+	return p.now().Sub(loadedAt) > alarm.After
+}
+
+// staleExpired reports whether the cached bundle is stale and its last successful
+// load is older than the max stale age of the configuration
+func (p *HTTPProvider) staleExpired(cfg *config.Configuration, cached *CachedConfig) bool {
+	if cfg.MaxStaleAge <= 0 || cached.StaleSince.IsZero() {
+		return false
+	}
+	return p.now().Sub(cached.LoadedAt) > cfg.MaxStaleAge
+}
+
+// StaleSince returns when the config started being served stale,
+// or the zero time if its last reload succeeded
+func (p *HTTPProvider) StaleSince(id string) time.Time {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	if cached := p.cache[id]; cached != nil {
+		return cached.StaleSince
 	}
+	return time.Time{}
+}
+
+// ConfigMtime returns the latest modification time of the config files as of the
+// last reload, zero when the config is not loaded or its source has no modification time
+func (p *HTTPProvider) ConfigMtime(id string) time.Time {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	if cached := p.cache[id]; cached != nil {
+		return cached.ModTime
+	}
+	return time.Time{}
+}
+
+// StreamResponses reports whether config bundles are streamed to clients
+func (p *HTTPProvider) StreamResponses() bool {
+	return p.config.StreamResponses
+}
+
+// ReportStale reports whether stale indicators are exposed to clients
+func (p *HTTPProvider) ReportStale() bool {
+	return p.config.ReportStale
 }
 
 // Close cleanup resources
 func (p *HTTPProvider) Close() error {
 	p.client.CloseIdleConnections()
+	p.clients.closeIdleConnections()
 	return nil
 }
 
-// buildCacheKey creates a consistent cache key from metadata
+// hashedKeyLength is the length of the hashed cache keys, in hex characters
+const hashedKeyLength = 16
+
+// BuildCacheKey creates a consistent cache key from metadata, hashed into a
+// fixed length ID when cache key hashing is enabled
 func (p *HTTPProvider) BuildCacheKey(metadata map[string]string) string {
+	key := cacheKeyLabel(metadata)
+	if !p.config.HashCacheKeys || len(metadata) == 0 {
+		return key
+	}
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])[:hashedKeyLength]
+}
+
+// cacheKeyLabel returns the human-readable cache key of metadata
+func cacheKeyLabel(metadata map[string]string) string {
 	if len(metadata) == 0 {
 		return "default"
 	}
@@ -346,6 +1191,82 @@ func (p *HTTPProvider) BuildCacheKey(metadata map[string]string) string {
 	for k, v := range metadata {
 		keys = append(keys, fmt.Sprintf("%s=%s", k, v))
 	}
+	sort.Strings(keys)
 
 	return strings.ToLower(strings.Join(keys, "&"))
 }
+
+// CacheKeyLabel returns the human-readable form of a configuration ID for diagnostics,
+// the ID itself when cache key hashing is disabled or no configuration has it
+func (p *HTTPProvider) CacheKeyLabel(id string) string {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	if label, ok := p.keyLabels[id]; ok {
+		return label
+	}
+	return id
+}
+
+// Configurations returns the static and discovered configurations
+func (p *HTTPProvider) Configurations() []*config.Configuration {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	return p.configurations
+}
+
+// ConfigSummary describes a configuration and the bundle it serves
+type ConfigSummary struct {
+	ID       string            `json:"id"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Default  bool              `json:"default,omitempty"`
+	Loaded   bool              `json:"loaded"`
+	// Checksum, Routes and Middlewares describe the bundle served without request metadata
+	Checksum    string     `json:"checksum,omitempty"`
+	Routes      int        `json:"routes"`
+	Middlewares int        `json:"middlewares"`
+	StaleSince  *time.Time `json:"staleSince,omitempty"`
+}
+
+// Summaries returns the summary of each configuration, in configuration order
+func (p *HTTPProvider) Summaries() ([]ConfigSummary, error) {
+	p.cacheMu.RLock()
+	configurations := p.configurations
+	cache := p.cache
+	p.cacheMu.RUnlock()
+
+	summaries := make([]ConfigSummary, 0, len(configurations))
+	for _, cfg := range configurations {
+		summary := ConfigSummary{ID: cfg.ID, Metadata: cfg.Metadata, Tags: cfg.Tags, Default: cfg.Default}
+		if cached := cache[cfg.ID]; cached != nil {
+			bundle, err := p.applyFlags(cfg.ID, cached)
+			if err != nil {
+				return nil, err
+			}
+			summary.Loaded = true
+			summary.Checksum = bundle.Checksum
+			summary.Routes = len(bundle.Routes)
+			summary.Middlewares = len(bundle.Middlewares)
+			if !cached.StaleSince.IsZero() {
+				summary.StaleSince = &cached.StaleSince
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// MaxConcurrentFetches returns the in-flight config request limit per client, 0 means unlimited
+func (p *HTTPProvider) MaxConcurrentFetches() int {
+	return p.config.MaxConcurrentFetches
+}
+
+// ConfigurationIDs returns the IDs of the current configurations
+func (p *HTTPProvider) ConfigurationIDs() []string {
+	configurations := p.Configurations()
+	ids := make([]string, 0, len(configurations))
+	for _, cfg := range configurations {
+		ids = append(ids, cfg.ID)
+	}
+	return ids
+}
@@ -0,0 +1,3243 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/metrics"
+	"github.com/jkaninda/goma-http-provider/internal/models"
+)
+
+const testRoutes = `
+routes:
+  - name: api
+    path: /api
+    target: http://api:8080
+`
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestProvider(t *testing.T, conf *config.ProviderConfig) *HTTPProvider {
+	t.Helper()
+	p, err := NewHTTPProvider(conf)
+	if err != nil {
+		t.Fatalf("NewHTTPProvider: %v", err)
+	}
+	return p
+}
+
+func TestReloadFailureMarksConfigStale(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{Directory: dir, Metadata: map[string]string{"env": "prod"}}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+		ReportStale:    true,
+	})
+
+	if since := p.StaleSince(cfg.ID); !since.IsZero() {
+		t.Fatalf("expected fresh config, stale since %v", since)
+	}
+	if stats := p.GetStats(cfg.ID); stats.StaleSince != nil {
+		t.Fatalf("expected no staleSince in stats, got %v", stats.StaleSince)
+	}
+
+	writeFile(t, dir, "broken.yaml", "routes: [")
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected reload to fail")
+	}
+
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{"env": "prod"})
+	if err != nil {
+		t.Fatalf("expected last good config to be served: %v", err)
+	}
+	if len(bundle.Routes) != 1 {
+		t.Fatalf("expected last good bundle with 1 route, got %d", len(bundle.Routes))
+	}
+	since := p.StaleSince(cfg.ID)
+	if since.IsZero() {
+		t.Fatal("expected config to be marked stale")
+	}
+	stats := p.GetStats(cfg.ID)
+	if stats.StaleSince == nil || !stats.StaleSince.Equal(since) {
+		t.Fatalf("expected staleSince %v in stats, got %v", since, stats.StaleSince)
+	}
+
+	// A second failure keeps the original staleSince
+	_ = p.Reload()
+	if got := p.StaleSince(cfg.ID); !got.Equal(since) {
+		t.Fatalf("expected staleSince to stay %v, got %v", since, got)
+	}
+
+	// Recovery clears the stale marker
+	if err := os.Remove(filepath.Join(dir, "broken.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := p.StaleSince(cfg.ID); !got.IsZero() {
+		t.Fatalf("expected stale marker to be cleared, got %v", got)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		expiry bool
+	}{
+		{name: "default", ttl: config.DefaultCacheTTL, expiry: true},
+		{name: "custom", ttl: time.Hour, expiry: true},
+		{name: "never expire", ttl: 0, expiry: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "routes.yaml", testRoutes)
+			cfg := &config.Configuration{Directory: dir, Default: true}
+			p := newTestProvider(t, &config.ProviderConfig{
+				Configurations: []*config.Configuration{cfg},
+				CacheTTL:       tt.ttl,
+			})
+
+			for _, step := range []string{"initial load", "reload"} {
+				if step == "reload" {
+					if err := p.Reload(); err != nil {
+						t.Fatalf("Reload: %v", err)
+					}
+				}
+				cached := p.cache[cfg.ID]
+				if !tt.expiry {
+					if !cached.ExpiresAt.IsZero() {
+						t.Fatalf("%s: expected no expiry, got %v", step, cached.ExpiresAt)
+					}
+					continue
+				}
+				got := cached.ExpiresAt.Sub(cached.Bundle.Timestamp)
+				if got < tt.ttl || got > tt.ttl+time.Second {
+					t.Fatalf("%s: expected expiry ~%v after load, got %v", step, tt.ttl, got)
+				}
+			}
+		})
+	}
+}
+
+func TestExpiredConfigRefreshCoalesced(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	metadata := map[string]string{"tenant": "coalesce"}
+	cfg := &config.Configuration{Directory: dir, Metadata: metadata}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+		CacheTTL:       50 * time.Millisecond,
+	})
+	loads := metrics.LoadDuration.Count(cfg.ID, "success")
+
+	writeFile(t, dir, "extra.yaml", `
+routes:
+  - name: extra
+    path: /extra
+    target: http://extra:8080
+`)
+	time.Sleep(100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bundle, _, err := p.GetConfig(t.Context(), metadata)
+			if err != nil {
+				t.Errorf("GetConfig: %v", err)
+				return
+			}
+			if len(bundle.Routes) != 2 {
+				t.Errorf("expected refreshed bundle with 2 routes, got %d", len(bundle.Routes))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := metrics.LoadDuration.Count(cfg.ID, "success") - loads; got != 1 {
+		t.Fatalf("expected exactly 1 refresh load, got %d", got)
+	}
+}
+
+const commentedJSON = `{
+  // gateway routes
+  "routes": [
+    {
+      "name": "api", /* inline */
+      "path": "/api",
+      "target": "http://api:8080/*not-a-comment*/",
+    },
+  ],
+}`
+
+func TestLoadCommentedJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.json", commentedJSON)
+	cfg := &config.Configuration{Directory: dir, Default: true}
+
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{cfg}})
+	bundle := p.cache[cfg.ID].Bundle
+	if len(bundle.Routes) != 1 {
+		t.Fatalf("expected 1 route, got %d", len(bundle.Routes))
+	}
+	if got := bundle.Routes[0].Target; got != "http://api:8080/*not-a-comment*/" {
+		t.Fatalf("unexpected target %q", got)
+	}
+
+	_, err := NewHTTPProvider(&config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+		StrictJSON:     true,
+	})
+	if err == nil {
+		t.Fatal("expected strict JSON parsing to reject comments")
+	}
+}
+
+func TestJSONErrorNamesFileAndLine(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.json", "{\n  // comment\n  \"routes\": [\n    {\"name\": \"api\",,}\n  ]\n}")
+	_, err := NewHTTPProvider(&config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+	if !strings.Contains(err.Error(), "routes.json (line 4)") {
+		t.Fatalf("expected error to name file and line, got %v", err)
+	}
+}
+
+func TestStrictFieldsRejectsUnknownFields(t *testing.T) {
+	tests := []struct {
+		file    string
+		content string
+	}{
+		{file: "routes.yaml", content: "routes:\n  - name: api\n    path: /api\n    tagret: http://api:8080\n"},
+		{file: "routes.json", content: `{"routes": [{"name": "api", "path": "/api", "tagret": "http://api:8080"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, tt.file, tt.content)
+			configurations := []*config.Configuration{{Directory: dir, Default: true}}
+
+			if _, err := NewHTTPProvider(&config.ProviderConfig{Configurations: configurations}); err != nil {
+				t.Fatalf("expected lenient loading to succeed: %v", err)
+			}
+
+			_, err := NewHTTPProvider(&config.ProviderConfig{
+				Configurations: configurations,
+				StrictFields:   true,
+			})
+			if err == nil {
+				t.Fatal("expected strict loading to reject the misspelled field")
+			}
+			if !strings.Contains(err.Error(), tt.file) || !strings.Contains(err.Error(), "tagret") {
+				t.Fatalf("expected error to name file and field, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	newProvider := func(t *testing.T, chain []string, withGlobal bool) *HTTPProvider {
+		configurations := []*config.Configuration{
+			{Directory: t.TempDir(), Metadata: map[string]string{"env": "prod"}},
+			{
+				Directory:    t.TempDir(),
+				Default:      true,
+				DefaultScope: map[string]string{"region": "eu"},
+				Metadata:     map[string]string{"tenant": "eu-fallback"},
+			},
+		}
+		if withGlobal {
+			configurations = append(configurations, &config.Configuration{
+				Directory: t.TempDir(),
+				Default:   true,
+				Metadata:  map[string]string{"tenant": "global-fallback"},
+			})
+		}
+		return newTestProvider(t, &config.ProviderConfig{Configurations: configurations, Fallback: chain})
+	}
+
+	tests := []struct {
+		name       string
+		chain      []string
+		withGlobal bool
+		metadata   map[string]string
+		want       string
+	}{
+		{name: "match", withGlobal: true, metadata: map[string]string{"env": "prod"}, want: "env=prod"},
+		{name: "scoped default", withGlobal: true, metadata: map[string]string{"region": "eu"}, want: "tenant=eu-fallback"},
+		{name: "global default", withGlobal: true, metadata: map[string]string{"region": "us"}, want: "tenant=global-fallback"},
+		{name: "empty bundle", chain: []string{"scoped", "global", "empty"}, metadata: map[string]string{"region": "us"}, want: "builtin-empty"},
+		{name: "no fallback", metadata: map[string]string{"region": "us"}, want: ""},
+		{name: "global only", chain: []string{"global"}, withGlobal: true, metadata: map[string]string{"region": "eu"}, want: "tenant=global-fallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider(t, tt.chain, tt.withGlobal)
+			bundle, cfg, err := p.GetConfig(t.Context(), tt.metadata)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("expected no configuration, got %s", cfg.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetConfig: %v", err)
+			}
+			if cfg.ID != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, cfg.ID)
+			}
+			if tt.want == "builtin-empty" && (len(bundle.Routes) != 0 || bundle.Checksum == "") {
+				t.Fatalf("expected an empty bundle with a checksum, got %+v", bundle)
+			}
+		})
+	}
+}
+
+func TestDiscoverConfigurations(t *testing.T) {
+	parent := t.TempDir()
+	writeFile(t, parent, "acme/routes.yaml", testRoutes)
+	writeFile(t, parent, "globex/routes.yaml", testRoutes)
+	writeFile(t, parent, "globex/meta.yaml", "metadata:\n  tenant: globex\n  env: staging\nauth:\n  apiKey: globex-key\n")
+	writeFile(t, parent, "README.md", "not a tenant")
+
+	p := newTestProvider(t, &config.ProviderConfig{
+		Discovery:    &config.Discovery{Directory: parent},
+		StrictFields: true,
+	})
+
+	if got := len(p.Configurations()); got != 2 {
+		t.Fatalf("expected 2 discovered configurations, got %d", got)
+	}
+	_, cfg, err := p.GetConfig(t.Context(), map[string]string{"tenant": "acme"})
+	if err != nil || cfg.ID != "tenant=acme" {
+		t.Fatalf("expected acme to match, got %v, %v", cfg, err)
+	}
+	bundle, cfg, err := p.GetConfig(t.Context(), map[string]string{"tenant": "globex", "env": "staging"})
+	if err != nil || cfg.ID != "env=staging&tenant=globex" {
+		t.Fatalf("expected globex to match from meta.yaml, got %v, %v", cfg, err)
+	}
+	if cfg.Auth == nil || cfg.Auth.APIKey != "globex-key" {
+		t.Fatalf("expected globex auth from meta.yaml, got %+v", cfg.Auth)
+	}
+	if len(bundle.Routes) != 1 {
+		t.Fatalf("expected meta.yaml to be excluded from the bundle, got %d routes", len(bundle.Routes))
+	}
+
+	// A tenant added after startup is picked up on reload
+	if _, _, err := p.GetConfig(t.Context(), map[string]string{"tenant": "initech"}); err == nil {
+		t.Fatal("expected initech to be unknown before reload")
+	}
+	writeFile(t, parent, "initech/routes.yaml", testRoutes)
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := len(p.Configurations()); got != 3 {
+		t.Fatalf("expected 3 discovered configurations after reload, got %d", got)
+	}
+	if _, cfg, err := p.GetConfig(t.Context(), map[string]string{"tenant": "initech"}); err != nil || cfg.ID != "tenant=initech" {
+		t.Fatalf("expected initech to match after reload, got %v, %v", cfg, err)
+	}
+}
+
+func TestFeatureFlaggedRoutes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", `
+routes:
+  - name: api
+    path: /api
+  - name: beta
+    path: /beta
+    flag: beta
+  - name: legacy
+    path: /legacy
+    flag: legacy
+`)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		Flags:          map[string]bool{"legacy": true},
+	})
+	routeNames := func() ([]string, string) {
+		bundle, _, err := p.GetConfig(t.Context(), nil)
+		if err != nil {
+			t.Fatalf("GetConfig: %v", err)
+		}
+		names := make([]string, 0, len(bundle.Routes))
+		for _, route := range bundle.Routes {
+			names = append(names, route.Name)
+		}
+		return names, bundle.Checksum
+	}
+
+	names, initial := routeNames()
+	if strings.Join(names, ",") != "api,legacy" {
+		t.Fatalf("expected static flags to apply, got %v", names)
+	}
+
+	t.Setenv("FEATURE_FLAGS", "beta")
+	names, withBeta := routeNames()
+	if strings.Join(names, ",") != "api,beta,legacy" {
+		t.Fatalf("expected env flag to enable beta, got %v", names)
+	}
+	if withBeta == initial {
+		t.Fatal("expected the checksum to change when a flag flips")
+	}
+
+	p.SetFlag("beta", false)
+	p.SetFlag("legacy", false)
+	names, _ = routeNames()
+	if strings.Join(names, ",") != "api" {
+		t.Fatalf("expected runtime overrides to disable flagged routes, got %v", names)
+	}
+
+	p.SetFlag("legacy", true)
+	if names, checksum := routeNames(); strings.Join(names, ",") != "api,legacy" || checksum != initial {
+		t.Fatalf("expected the initial routes and checksum back, got %v %s", names, checksum)
+	}
+}
+
+func TestRuntimeStats(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{Directory: dir, Default: true}
+
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{cfg}})
+	if stats := p.GetStats(cfg.ID); stats.Runtime != nil {
+		t.Fatalf("expected no runtime stats by default, got %+v", stats.Runtime)
+	}
+
+	p = newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+		RuntimeStats:   true,
+	})
+	runtimeStats := p.GetStats(cfg.ID).Runtime
+	if runtimeStats == nil {
+		t.Fatal("expected runtime stats when enabled")
+	}
+	if runtimeStats.Goroutines < 1 {
+		t.Errorf("expected at least one goroutine, got %d", runtimeStats.Goroutines)
+	}
+	if runtimeStats.HeapAllocBytes == 0 {
+		t.Error("expected a non-zero heap allocation")
+	}
+	if runtimeStats.OpenFDs == 0 || runtimeStats.OpenFDs < -1 {
+		t.Errorf("expected open file descriptors to be positive or -1, got %d", runtimeStats.OpenFDs)
+	}
+	if runtimeStats.NumGC > 0 && runtimeStats.TotalGCPauseNs < runtimeStats.LastGCPauseNs {
+		t.Errorf("expected total GC pause %d to cover the last pause %d", runtimeStats.TotalGCPauseNs, runtimeStats.LastGCPauseNs)
+	}
+}
+
+func TestDirectoryDepth(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{"root", "a/one", "a/b/two", "a/b/c/three"} {
+		name := filepath.Base(path)
+		writeFile(t, dir, path+".yaml", "routes:\n  - name: "+name+"\n    path: /"+name+"\n")
+	}
+	recursive := func(v bool) *bool { return &v }
+
+	tests := []struct {
+		name      string
+		recursive *bool
+		maxDepth  int
+		want      []string
+	}{
+		{name: "default loads everything", want: []string{"root", "one", "two", "three"}},
+		{name: "not recursive", recursive: recursive(false), want: []string{"root"}},
+		{name: "not recursive ignores max depth", recursive: recursive(false), maxDepth: 2, want: []string{"root"}},
+		{name: "depth 1", maxDepth: 1, want: []string{"root", "one"}},
+		{name: "depth 2", maxDepth: 2, want: []string{"root", "one", "two"}},
+		{name: "depth beyond tree", recursive: recursive(true), maxDepth: 10, want: []string{"root", "one", "two", "three"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Configuration{Directory: dir, Default: true, Recursive: tt.recursive, MaxDepth: tt.maxDepth}
+			p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{cfg}})
+			bundle, _, err := p.GetConfig(t.Context(), nil)
+			if err != nil {
+				t.Fatalf("GetConfig() error = %v", err)
+			}
+			var got []string
+			for _, route := range bundle.Routes {
+				got = append(got, route.Name)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("routes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCanarySplit(t *testing.T) {
+	dir, canaryDir := t.TempDir(), t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	writeFile(t, canaryDir, "routes.yaml", "routes:\n  - name: api-canary\n    path: /api\n")
+	cfg := &config.Configuration{
+		Directory: dir,
+		Default:   true,
+		Canary:    &config.Canary{Directory: canaryDir, Percent: 20, Key: "client"},
+	}
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{cfg}})
+
+	const requests = 5000
+	canary := 0
+	for i := range requests {
+		metadata := map[string]string{"client": fmt.Sprintf("client-%d", i)}
+		bundle, _, err := p.GetConfig(t.Context(), metadata)
+		if err != nil {
+			t.Fatalf("GetConfig() error = %v", err)
+		}
+		if bundle.Canary != (bundle.Routes[0].Name == "api-canary") {
+			t.Fatalf("canary marker does not match the served bundle")
+		}
+		if bundle.Canary {
+			canary++
+		}
+		again, _, _ := p.GetConfig(t.Context(), metadata)
+		if again.Canary != bundle.Canary {
+			t.Fatalf("client-%d switched between canary and stable", i)
+		}
+	}
+	if ratio := float64(canary) / requests; ratio < 0.17 || ratio > 0.23 {
+		t.Errorf("canary ratio = %.3f, want about 0.20", ratio)
+	}
+}
+
+func TestCanaryPipeline(t *testing.T) {
+	dir, canaryDir := t.TempDir(), t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	writeFile(t, canaryDir, "routes.yaml", "routes:\n  - name: api-canary\n    path: /api\n    tls:\n      certificates:\n        - cert: /etc/certs/api.crt\n          key: ${env:CANARY_TLS_KEY}\n")
+	t.Setenv("CANARY_TLS_KEY", "canary-private-key")
+	configurations := []*config.Configuration{{
+		Directory: dir,
+		Default:   true,
+		Canary:    &config.Canary{Directory: canaryDir, Percent: 100},
+	}}
+
+	// The canary TLS key is resolved like the ones of the primary bundle
+	p := newTestProvider(t, &config.ProviderConfig{RouteSecrets: true, Configurations: configurations})
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bundle.Canary || bundle.Routes[0].TLS.Certificates[0].Key != "canary-private-key" {
+		t.Fatalf("expected the canary TLS key to be resolved, got %+v", bundle.Routes)
+	}
+
+	// The validator sees the canary bundle too, a rejected canary is not served
+	_, err = NewHTTPProvider(&config.ProviderConfig{
+		RouteSecrets:   true,
+		Configurations: configurations,
+		Validator: &config.Validator{Command: []string{"sh", "-c",
+			`bundle=$(cat); case "$bundle" in *api-canary*) echo 'canary rejected' >&2; exit 1;; esac`}},
+	})
+	if !errors.Is(err, ErrValidatorRejected) || !strings.Contains(err.Error(), "canary rejected") {
+		t.Fatalf("expected the validator to reject the canary, got %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := map[string]any{
+		"version": "1.0",
+		"routes":  []any{map[string]any{"name": "api", "path": "/api"}, map[string]any{"name": "old"}},
+		"a/b":     "x",
+		"gone":    true,
+	}
+	to := map[string]any{
+		"version": "1.0",
+		"routes":  []any{map[string]any{"name": "api", "path": "/v2/api"}},
+		"a/b":     "y",
+		"added":   nil,
+	}
+	ops, err := Diff(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(ops)
+	want := `[{"op":"replace","path":"/a~1b","value":"y"},` +
+		`{"op":"add","path":"/added","value":null},` +
+		`{"op":"remove","path":"/gone"},` +
+		`{"op":"replace","path":"/routes/0/path","value":"/v2/api"},` +
+		`{"op":"remove","path":"/routes/1"}]`
+	if string(got) != want {
+		t.Errorf("Diff() =\n%s\nwant\n%s", got, want)
+	}
+
+	ops, _ = Diff(to, to)
+	if len(ops) != 0 {
+		t.Errorf("expected no operations for equal values, got %v", ops)
+	}
+}
+
+func TestBundleHistoryEviction(t *testing.T) {
+	h := newBundleHistory(2)
+	for _, checksum := range []string{"a", "b", "a", "c"} {
+		h.add(&CachedConfig{configID: "acme", ETag: checksum})
+	}
+	for _, checksum := range []string{"d", "e", "f"} {
+		h.add(&CachedConfig{configID: "globex", ETag: checksum})
+	}
+	if _, ok := h.get("acme", "a"); ok {
+		t.Error("expected the oldest bundle to be evicted")
+	}
+	for _, checksum := range []string{"b", "c"} {
+		if _, ok := h.get("acme", checksum); !ok {
+			t.Errorf("expected bundle %s to be retained despite the loads of another configuration", checksum)
+		}
+	}
+	if _, ok := h.get("globex", "b"); ok {
+		t.Error("expected the bundles of a configuration not to be served to another one")
+	}
+
+	h.retain(map[string]*CachedConfig{"globex": nil})
+	if _, ok := h.get("acme", "c"); ok {
+		t.Error("expected the history of a removed configuration to be dropped")
+	}
+}
+
+func TestPreviousChecksumExpires(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{Directory: dir, Default: true}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+		ChecksumGrace:  50 * time.Millisecond,
+	})
+	previous, _, _ := p.GetConfig(t.Context(), nil)
+
+	writeFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /v2/api\n")
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	current, _, _ := p.GetConfig(t.Context(), nil)
+	if !p.NotModified(cfg.ID, current, previous.Checksum) {
+		t.Fatal("expected the previous checksum to be accepted within the grace window")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if p.NotModified(cfg.ID, current, previous.Checksum) {
+		t.Fatal("expected the previous checksum to expire after the grace window")
+	}
+	if !p.NotModified(cfg.ID, current, current.Checksum) {
+		t.Fatal("expected the current checksum to be accepted")
+	}
+}
+
+// fakeKubeAPI serves ConfigMaps and Secrets lists, and a watch stream
+// emitting a single change event
+func fakeKubeAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	secret := base64.StdEncoding.EncodeToString([]byte("routes:\n  - name: secret\n    path: /secret\n"))
+	lists := map[string]string{
+		"/api/v1/namespaces/gateway/configmaps": `{"metadata":{"resourceVersion":"10"},"items":[
+			{"metadata":{"name":"b-routes"},"data":{"routes.yaml":"routes:\n  - name: b\n    path: /b\n"}},
+			{"metadata":{"name":"a-routes"},"data":{"routes.json":"{\"routes\":[{\"name\":\"a\",\"path\":\"/a\"}]}","README":"ignored"}}]}`,
+		"/api/v1/namespaces/gateway/secrets": `{"metadata":{"resourceVersion":"11"},"items":[
+			{"metadata":{"name":"routes"},"data":{"routes.yaml":"` + secret + `","tls.crt":"Y2VydA=="}}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelSelector") != "app=goma" {
+			http.Error(w, "unexpected selector", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, ok := lists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("watch") == "true" {
+			_, _ = fmt.Fprintln(w, `{"type":"MODIFIED","object":{}}`)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		_, _ = fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func kubeSource(t *testing.T, server string) *config.Kubernetes {
+	t.Helper()
+	tokenDir := t.TempDir()
+	writeFile(t, tokenDir, "token", "test-token")
+	return &config.Kubernetes{
+		Namespace:     "gateway",
+		LabelSelector: "app=goma",
+		Secrets:       true,
+		APIServer:     server,
+		TokenFile:     filepath.Join(tokenDir, "token"),
+	}
+}
+
+func TestLoadFromKubernetes(t *testing.T) {
+	server := fakeKubeAPI(t)
+	cfg := &config.Configuration{Default: true, Kubernetes: kubeSource(t, server.URL)}
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{cfg}})
+
+	bundle, _, err := p.GetConfig(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, route := range bundle.Routes {
+		got = append(got, route.Name)
+	}
+	if want := []string{"a", "b", "secret"}; !slices.Equal(got, want) {
+		t.Errorf("routes = %v, want %v", got, want)
+	}
+
+	cfg.Kubernetes.Secrets = false
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	bundle, _, _ = p.GetConfig(t.Context(), nil)
+	if len(bundle.Routes) != 2 {
+		t.Errorf("expected secrets to be skipped, got %d routes", len(bundle.Routes))
+	}
+}
+
+func TestWatchKubernetes(t *testing.T) {
+	server := fakeKubeAPI(t)
+	source := kubeSource(t, server.URL)
+	p := &HTTPProvider{config: &config.ProviderConfig{}}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go p.watchKubernetes(ctx, &config.Configuration{Kubernetes: source}, kindConfigMaps, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change notification from the watch")
+	}
+}
+
+func TestPeriodicReload(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{Directory: dir, Default: true}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+		ReloadInterval: 20 * time.Millisecond,
+		ReloadJitter:   10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	p.StartPeriodicReload(ctx)
+
+	// Unchanged sources are not reloaded
+	initial := p.GetReloadTimestamp()
+	time.Sleep(100 * time.Millisecond)
+	if !p.GetReloadTimestamp().Equal(initial) {
+		t.Fatal("expected no reload while sources are unchanged")
+	}
+
+	writeFile(t, dir, "extra.yaml", "routes:\n  - name: extra\n    path: /extra\n")
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		bundle, _, err := p.GetConfig(t.Context(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bundle.Routes) == 2 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("expected a periodic reload to pick up the new file")
+}
+
+func TestMetadataKeyNormalization(t *testing.T) {
+	tests := []struct {
+		mode  string
+		match bool
+	}{
+		{mode: "", match: false},
+		{mode: config.MetadataKeysSnake, match: true},
+		{mode: config.MetadataKeysKebab, match: true},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "routes.yaml", testRoutes)
+			cfg := &config.Configuration{Directory: dir, Metadata: map[string]string{"tenant_id": "acme"}}
+			p := newTestProvider(t, &config.ProviderConfig{
+				Configurations: []*config.Configuration{cfg},
+				MetadataKeys:   tt.mode,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/config", nil)
+			req.Header.Set("X-Goma-Meta-Tenant-Id", "acme")
+			metadata := p.ExtractMetadata(req)
+			if _, ok := metadata["tenant-id"]; !ok {
+				t.Fatalf("expected the extracted key to be tenant-id, got %v", metadata)
+			}
+
+			_, matched, err := p.GetConfig(t.Context(), metadata)
+			if tt.match && (err != nil || matched.ID != cfg.ID) {
+				t.Fatalf("expected tenant-id to match tenant_id, got %v, %v", matched, err)
+			}
+			if !tt.match && err == nil {
+				t.Fatalf("expected no match without normalization, got %s", matched.ID)
+			}
+		})
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	for _, key := range []string{"tenant-id", "tenant_id", "tenantId", "TenantID"} {
+		if got := normalizeKey(config.MetadataKeysSnake, key); got != "tenant_id" {
+			t.Errorf("normalizeKey(snake, %q) = %q", key, got)
+		}
+		if got := normalizeKey(config.MetadataKeysKebab, key); got != "tenant-id" {
+			t.Errorf("normalizeKey(kebab, %q) = %q", key, got)
+		}
+	}
+}
+
+func TestPassthroughFieldsChecksum(t *testing.T) {
+	load := func(t *testing.T, passthrough bool, extra string) *config.ConfigBundle {
+		dir := t.TempDir()
+		writeFile(t, dir, "routes.yaml", testRoutes+extra)
+		p := newTestProvider(t, &config.ProviderConfig{
+			Configurations:    []*config.Configuration{{Directory: dir, Default: true}},
+			PassthroughFields: passthrough,
+		})
+		bundle, _, err := p.GetConfig(t.Context(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bundle
+	}
+
+	a := load(t, true, "future: 1\n")
+	b := load(t, true, "future: 2\n")
+	if a.Checksum == b.Checksum {
+		t.Error("expected passthrough fields to be part of the checksum")
+	}
+	if string(a.Extra["future"]) != "1" {
+		t.Errorf("expected the unknown field to be captured, got %v", a.Extra)
+	}
+	if dropped := load(t, false, "future: 1\n"); dropped.Extra != nil {
+		t.Errorf("expected unknown fields to be dropped by default, got %v", dropped.Extra)
+	}
+}
+
+func TestMaxStaleAge(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{Directory: dir, Metadata: map[string]string{"env": "prod"}, MaxStaleAge: time.Hour}
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{cfg}})
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	metadata := map[string]string{"env": "prod"}
+
+	// A fresh bundle is served past the max stale age
+	now = now.Add(2 * time.Hour)
+	if _, _, err := p.GetConfig(t.Context(), metadata); err != nil {
+		t.Fatalf("expected a fresh config to be served: %v", err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	writeFile(t, dir, "broken.yaml", "routes: [")
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected reload to fail")
+	}
+	now = now.Add(30 * time.Minute)
+	if _, _, err := p.GetConfig(t.Context(), metadata); err != nil {
+		t.Fatalf("expected the stale config to be served within its max stale age: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, _, err := p.GetConfig(t.Context(), metadata); !errors.Is(err, ErrStaleExpired) {
+		t.Fatalf("expected the stale config to be refused, got %v", err)
+	}
+
+	// Recovery serves the config again
+	if err := os.Remove(filepath.Join(dir, "broken.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, _, err := p.GetConfig(t.Context(), metadata); err != nil {
+		t.Fatalf("expected the recovered config to be served: %v", err)
+	}
+}
+
+func TestWarnWhenDefaultNotLoaded(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	parent := t.TempDir()
+	writeFile(t, parent, "acme/routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{Discovery: &config.Discovery{Directory: parent}})
+	if strings.Contains(logs.String(), "Default configuration is not loaded") {
+		t.Fatal("expected no warning while the default is loaded or unset")
+	}
+
+	// A newly discovered default that fails to load is skipped on reload
+	writeFile(t, parent, "globex/meta.yaml", "default: true\n")
+	writeFile(t, parent, "globex/routes.yaml", "routes: [")
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected reload to fail")
+	}
+	if !strings.Contains(logs.String(), "Default configuration is not loaded") {
+		t.Errorf("expected a warning about the missing default, got logs:\n%s", logs.String())
+	}
+}
+
+func TestDuplicateDynamicDefaults(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{policy: ""},
+		{policy: config.DuplicateDefaultsFirst},
+		{policy: config.DuplicateDefaultsStrict, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			parent := t.TempDir()
+			writeFile(t, parent, "globex/routes.yaml", testRoutes)
+			p := newTestProvider(t, &config.ProviderConfig{
+				Discovery:         &config.Discovery{Directory: parent},
+				DuplicateDefaults: tt.policy,
+			})
+
+			// Two discovered tenants claim the default after startup
+			for _, tenant := range []string{"globex", "acme"} {
+				writeFile(t, parent, tenant+"/meta.yaml", "default: true\nmetadata:\n  tenant: "+tenant+"\n")
+				writeFile(t, parent, tenant+"/routes.yaml", testRoutes)
+			}
+			err := p.Reload()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "tenant=acme, tenant=globex") {
+					t.Fatalf("expected a duplicate default error, got %v", err)
+				}
+				if _, cfg, err := p.GetConfig(t.Context(), map[string]string{"tenant": "unknown"}); err == nil {
+					t.Fatalf("expected the previous configurations without a default to be kept, got %s", cfg.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reload: %v", err)
+			}
+			_, cfg, err := p.GetConfig(t.Context(), map[string]string{"tenant": "unknown"})
+			if err != nil || cfg.ID != "tenant=acme" {
+				t.Fatalf("expected the first default by ID, got %v, %v", cfg, err)
+			}
+		})
+	}
+}
+
+// largeRoutes returns a config file with n text heavy routes
+func largeRoutes(n int) string {
+	var b strings.Builder
+	b.WriteString("routes:\n")
+	for i := range n {
+		fmt.Fprintf(&b, "  - name: route-%d\n    path: /service-%d/api\n    target: http://service-%d.default.svc.cluster.local:8080\n", i, i, i)
+		fmt.Fprintf(&b, "    hosts: [service-%d.example.com, www.service-%d.example.com]\n    methods: [GET, POST, PUT, DELETE]\n", i, i)
+		if i%10 == 0 {
+			fmt.Fprintf(&b, "    flag: beta\n")
+		}
+	}
+	b.WriteString("middlewares:\n  - name: auth\n    type: basic\n    rule:\n      realm: goma\n")
+	return b.String()
+}
+
+func TestCompressCache(t *testing.T) {
+	load := func(t *testing.T, compress bool) *HTTPProvider {
+		dir := t.TempDir()
+		writeFile(t, dir, "routes.yaml", largeRoutes(50))
+		return newTestProvider(t, &config.ProviderConfig{
+			Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+			Flags:          map[string]bool{"beta": true},
+			CompressCache:  compress,
+		})
+	}
+	plain, compressed := load(t, false), load(t, true)
+
+	for _, beta := range []bool{true, false} {
+		plain.SetFlag("beta", beta)
+		compressed.SetFlag("beta", beta)
+		want, _, err := plain.GetConfig(t.Context(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, gotCfg, err := compressed.GetConfig(t.Context(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantJSON, _ := json.Marshal(want.Routes)
+		gotJSON, _ := json.Marshal(got.Routes)
+		if string(gotJSON) != string(wantJSON) || len(got.Middlewares) != 1 {
+			t.Fatalf("beta=%v: decompressed bundle differs from the plain bundle", beta)
+		}
+		if got.Checksum != calculateChecksum(got) {
+			t.Errorf("beta=%v: checksum does not match the decompressed bundle", beta)
+		}
+		if previous, ok := compressed.PreviousBundle(gotCfg.ID, got.Checksum); !ok || len(previous.Routes) != len(got.Routes) {
+			t.Errorf("beta=%v: expected the served bundle to be retained for deltas", beta)
+		}
+	}
+
+	compressed.cacheMu.RLock()
+	defer compressed.cacheMu.RUnlock()
+	for _, cached := range compressed.cache {
+		if cached.compressed == nil || cached.Bundle.Routes != nil {
+			t.Error("expected cached routes to be held compressed only")
+		}
+	}
+}
+
+// BenchmarkCompressCache reports the heap retained by the cache of a large bundle
+func BenchmarkCompressCache(b *testing.B) {
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(largeRoutes(2000)), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	for _, compress := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compress=%v", compress), func(b *testing.B) {
+			var retained int64
+			for range b.N {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				p, err := NewHTTPProvider(&config.ProviderConfig{
+					Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+					CompressCache:  compress,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				runtime.KeepAlive(p)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
+
+func TestReloadNotifiesChangedConfigsOnly(t *testing.T) {
+	prod, staging := t.TempDir(), t.TempDir()
+	writeFile(t, prod, "routes.yaml", testRoutes)
+	writeFile(t, staging, "routes.yaml", testRoutes)
+	prodCfg := &config.Configuration{Directory: prod, Metadata: map[string]string{"env": "prod"}}
+	stagingCfg := &config.Configuration{Directory: staging, Metadata: map[string]string{"env": "staging"}}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{prodCfg, stagingCfg},
+	})
+	prodChanges, cancelProd := p.Subscribe(prodCfg.ID)
+	defer cancelProd()
+	stagingChanges, cancelStaging := p.Subscribe(stagingCfg.ID)
+	defer cancelStaging()
+
+	// Reloading unchanged files wakes nobody
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	select {
+	case <-prodChanges:
+		t.Fatal("unexpected prod notification for an unchanged reload")
+	case <-stagingChanges:
+		t.Fatal("unexpected staging notification for an unchanged reload")
+	default:
+	}
+
+	writeFile(t, staging, "extra.yaml", `
+routes:
+  - name: extra
+    path: /extra
+`)
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	select {
+	case <-stagingChanges:
+	default:
+		t.Fatal("expected staging subscribers to be notified")
+	}
+	select {
+	case <-prodChanges:
+		t.Fatal("unexpected prod notification, only staging changed")
+	default:
+	}
+}
+
+// memorySource is an in-memory source whose version is bumped by set
+type memorySource struct {
+	mu      sync.Mutex
+	routes  []string
+	version int
+}
+
+func (s *memorySource) set(routes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = routes
+	s.version++
+}
+
+func (s *memorySource) Load(_ context.Context) (*config.ConfigBundle, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bundle := newBundle()
+	for _, name := range s.routes {
+		bundle.Routes = append(bundle.Routes, models.Route{Name: name, Path: "/" + name})
+	}
+	return bundle, strconv.Itoa(s.version), nil
+}
+
+func (s *memorySource) Changed(_ context.Context, version string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strconv.Itoa(s.version) != version, nil
+}
+
+func TestPluggableSource(t *testing.T) {
+	memory := &memorySource{}
+	memory.set("api")
+	RegisterSource("memory-test", func(_ *HTTPProvider, _ *config.Configuration) (Source, error) {
+		return memory, nil
+	})
+	cfg := &config.Configuration{Source: "memory-test", Default: true}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+	})
+
+	bundle, _, err := p.GetConfig(t.Context(), nil)
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if len(bundle.Routes) != 1 || bundle.Routes[0].Name != "api" {
+		t.Fatalf("expected the in-memory route, got %+v", bundle.Routes)
+	}
+	if p.sourcesChanged(t.Context()) {
+		t.Fatal("expected an unchanged source after load")
+	}
+
+	memory.set("api", "admin")
+	if !p.sourcesChanged(t.Context()) {
+		t.Fatal("expected the source change to be detected")
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if p.sourcesChanged(t.Context()) {
+		t.Fatal("expected an unchanged source after reload")
+	}
+	bundle, _, _ = p.GetConfig(t.Context(), nil)
+	if len(bundle.Routes) != 2 {
+		t.Fatalf("expected the reloaded in-memory routes, got %+v", bundle.Routes)
+	}
+
+	cfg.Source = "unknown"
+	if err := p.Reload(); err == nil || !strings.Contains(err.Error(), `unknown source type "unknown"`) {
+		t.Fatalf("expected an unknown source type error, got %v", err)
+	}
+}
+
+// slowSource is a memory source taking delay to load, like a directory on slow storage
+type slowSource struct {
+	memorySource
+	delay time.Duration
+}
+
+func (s *slowSource) Load(ctx context.Context) (*config.ConfigBundle, string, error) {
+	time.Sleep(s.delay)
+	return s.memorySource.Load(ctx)
+}
+
+func TestSlowLoadThreshold(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	slow := &slowSource{delay: 20 * time.Millisecond}
+	slow.set("api")
+	RegisterSource("slow-test", func(_ *HTTPProvider, _ *config.Configuration) (Source, error) {
+		return slow, nil
+	})
+	cfg := &config.Configuration{Source: "slow-test", Default: true}
+	p := newTestProvider(t, &config.ProviderConfig{
+		SlowLoadThreshold: 5 * time.Millisecond,
+		Configurations:    []*config.Configuration{cfg},
+	})
+	if got := p.GetStats(cfg.ID).SlowLoads; got != 1 {
+		t.Fatalf("expected 1 slow load, got %d", got)
+	}
+	if !strings.Contains(logs.String(), "Slow configuration load") {
+		t.Fatalf("expected a slow load warning, got logs:\n%s", logs.String())
+	}
+
+	// Loads within the threshold are not counted
+	slow.delay = 0
+	p.config.SlowLoadThreshold = time.Second
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := p.GetStats(cfg.ID).SlowLoads; got != 1 {
+		t.Fatalf("expected the slow load count to stay 1, got %d", got)
+	}
+}
+
+func TestStaleAlarm(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{
+		Directory:  dir,
+		Default:    true,
+		StaleAlarm: &config.StaleAlarm{After: 10 * time.Minute, FailReadiness: true},
+	}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+	})
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	writeFile(t, dir, "broken.yaml", "routes: [")
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected reload to fail")
+	}
+	if p.GetStats(cfg.ID).StaleAlarm || !p.Status().Ready {
+		t.Fatal("expected no stale alarm within the threshold")
+	}
+
+	now = now.Add(11 * time.Minute)
+	if !p.GetStats(cfg.ID).StaleAlarm {
+		t.Fatal("expected the stale alarm past the threshold without a successful reload")
+	}
+	status := p.Status()
+	if status.Ready || !slices.Equal(status.StaleAlarms, []string{cfg.ID}) {
+		t.Fatalf("expected the stale alarm to fail readiness, got %+v", status)
+	}
+
+	// A successful reload clears the alarm
+	if err := os.Remove(filepath.Join(dir, "broken.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if p.GetStats(cfg.ID).StaleAlarm || !p.Status().Ready {
+		t.Fatal("expected the stale alarm to clear after a successful reload")
+	}
+}
+
+func TestLastGoodFileServedOnStartupFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	lastGood := filepath.Join(t.TempDir(), "last-good.json")
+	newConf := func() *config.ProviderConfig {
+		return &config.ProviderConfig{
+			Configurations: []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "safe"}}},
+			LastGoodFile:   lastGood,
+		}
+	}
+	p := newTestProvider(t, newConf())
+	want, _, err := p.GetConfig(t.Context(), map[string]string{"env": "safe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lastGood); err != nil {
+		t.Fatalf("expected the last good file to be written: %v", err)
+	}
+
+	// The source is unavailable on the next startup
+	writeFile(t, dir, "broken.yaml", "routes: [")
+	restarted := newTestProvider(t, newConf())
+	bundle, _, err := restarted.GetConfig(t.Context(), map[string]string{"env": "safe"})
+	if err != nil {
+		t.Fatalf("expected the persisted config to be served: %v", err)
+	}
+	if bundle.Checksum != want.Checksum || len(bundle.Routes) != 1 {
+		t.Fatalf("expected the persisted bundle %s, got %s with %d routes", want.Checksum, bundle.Checksum, len(bundle.Routes))
+	}
+	if restarted.StaleSince(p.BuildCacheKey(map[string]string{"env": "safe"})).IsZero() {
+		t.Fatal("expected the persisted config to be marked stale")
+	}
+
+	// Without a persisted copy, startup still fails
+	if err := os.Remove(lastGood); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHTTPProvider(newConf()); err == nil {
+		t.Fatal("expected startup to fail without a last good file")
+	}
+}
+
+func TestReloadUnchangedContentKeepsTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "touch"}}},
+	})
+	metadata := map[string]string{"env": "touch"}
+	before, _, err := p.GetConfig(t.Context(), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Touch the file without changing its content
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "routes.yaml"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	after, _, err := p.GetConfig(t.Context(), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.Timestamp.Equal(before.Timestamp) || after.Checksum != before.Checksum {
+		t.Fatalf("expected the unchanged bundle to keep its timestamp %v, got %v", before.Timestamp, after.Timestamp)
+	}
+
+	writeFile(t, dir, "routes.yaml", strings.Replace(testRoutes, "/api", "/v2", 1))
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	changed, _, err := p.GetConfig(t.Context(), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Checksum == before.Checksum || !changed.Timestamp.After(before.Timestamp) {
+		t.Fatal("expected a content change to rebuild the entry")
+	}
+}
+
+func TestReloadUnchangedCanaryKeepsTimestamp(t *testing.T) {
+	dir, canaryDir := t.TempDir(), t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	writeFile(t, canaryDir, "routes.yaml", "routes:\n  - name: api-canary\n    path: /api\n")
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{
+			Directory: dir,
+			Default:   true,
+			Canary:    &config.Canary{Directory: canaryDir, Percent: 100, Key: "client"},
+		}},
+		Fallback: []string{config.FallbackGlobal, config.FallbackEmpty},
+	})
+	timestamps := func() (canary, empty time.Time) {
+		t.Helper()
+		bundle, _, err := p.GetConfig(t.Context(), map[string]string{"client": "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bundle.Canary {
+			t.Fatal("expected the canary variant")
+		}
+		p.cacheMu.RLock()
+		defer p.cacheMu.RUnlock()
+		return bundle.Timestamp, p.cache[emptyConfig.ID].Bundle.Timestamp
+	}
+	canary, empty := timestamps()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	canaryAfter, emptyAfter := timestamps()
+	if !canaryAfter.Equal(canary) {
+		t.Fatalf("expected the unchanged canary to keep its timestamp %v, got %v", canary, canaryAfter)
+	}
+	if !emptyAfter.Equal(empty) {
+		t.Fatalf("expected the empty fallback to keep its timestamp %v, got %v", empty, emptyAfter)
+	}
+
+	writeFile(t, canaryDir, "routes.yaml", "routes:\n  - name: api-canary\n    path: /v2\n")
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if changed, _ := timestamps(); !changed.After(canary) {
+		t.Fatal("expected a canary content change to move its timestamp")
+	}
+}
+
+func TestRouteToggleUntilReload(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n  - name: harmful\n    path: /harmful\n")
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	if _, err := p.SetRouteEnabled("unknown-id", "harmful", false); !errors.Is(err, ErrRouteNotFound) {
+		t.Fatalf("expected the route not to be found in another configuration, got %v", err)
+	}
+	if _, err := p.SetRouteEnabled("", "harmful", false); err != nil {
+		t.Fatal(err)
+	}
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Routes) != 1 {
+		t.Fatalf("expected the disabled route to be removed, got %+v", bundle.Routes)
+	}
+
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	bundle, _, err = p.GetConfig(t.Context(), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Routes) != 2 {
+		t.Fatalf("expected a reload to serve the disabled route again, got %+v", bundle.Routes)
+	}
+}
+
+func TestHashCacheKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	metadata := map[string]string{"env": "prod", "region": "eu", "tenant": "acme"}
+	p := newTestProvider(t, &config.ProviderConfig{
+		HashCacheKeys:  true,
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: metadata}},
+	})
+
+	id := p.BuildCacheKey(metadata)
+	if len(id) != hashedKeyLength || strings.Contains(id, "acme") {
+		t.Fatalf("expected a fixed length ID without label values, got %q", id)
+	}
+	if again := p.BuildCacheKey(map[string]string{"tenant": "acme", "region": "eu", "env": "prod"}); again != id {
+		t.Fatalf("expected the hash to be stable, got %q and %q", id, again)
+	}
+	if other := p.BuildCacheKey(map[string]string{"env": "prod", "region": "eu", "tenant": "globex"}); other == id {
+		t.Fatal("expected different metadata to hash to a different ID")
+	}
+	if got := p.Configurations()[0].ID; got != id {
+		t.Fatalf("expected the configuration ID to be hashed, got %q", got)
+	}
+	if label := p.CacheKeyLabel(id); label != "env=prod&region=eu&tenant=acme" {
+		t.Fatalf("expected the human-readable key, got %q", label)
+	}
+	if _, _, err := p.GetConfig(t.Context(), metadata); err != nil {
+		t.Fatalf("expected the hashed ID to be served, got %v", err)
+	}
+
+	// Without hashing, existing configurations keep their IDs
+	plain := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: metadata}},
+	})
+	if got := plain.Configurations()[0].ID; got != "env=prod&region=eu&tenant=acme" {
+		t.Fatalf("expected the unhashed ID to be unchanged, got %q", got)
+	}
+
+	// Duplicate detection works on the hashed IDs, without leaking the label values
+	_, err := NewHTTPProvider(&config.ProviderConfig{
+		HashCacheKeys: true,
+		Configurations: []*config.Configuration{
+			{Directory: dir, Metadata: metadata},
+			{Directory: dir, Metadata: map[string]string{"tenant": "acme", "region": "eu", "env": "prod"}},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "duplicate configuration id: "+id) || strings.Contains(err.Error(), "acme") {
+		t.Fatalf("expected a duplicate hashed ID error, got %v", err)
+	}
+}
+
+func TestMiddlewarePaths(t *testing.T) {
+	for _, tt := range []struct {
+		path  string
+		valid bool
+	}{
+		{"/api", true},
+		{"/api/*", true},
+		{"/*", true},
+		{"api//users/{id}", true},
+		{"", false},
+		{"/api/*/users", false},
+		{"/api*", false},
+		{"/users/{id", false},
+		{"/users/{}", false},
+		{"/api?debug=true", false},
+		{"/my api", false},
+	} {
+		if err := checkPathPattern(tt.path); (err == nil) != tt.valid {
+			t.Errorf("checkPathPattern(%q) = %v, want valid %v", tt.path, err, tt.valid)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", `routes:
+  - name: api
+    path: /api
+  - name: users
+    path: /users/{id}
+middlewares:
+  - name: auth
+    type: basic
+    paths: ["/api/admin/*", "/users/42"]
+  - name: broken
+    type: basic
+    paths: ["/api/*/users"]
+  - name: stale
+    type: basic
+    paths: ["/legacy/*"]
+`)
+	warnings := func(orphans bool) []string {
+		t.Helper()
+		p := newTestProvider(t, &config.ProviderConfig{
+			Configurations:        []*config.Configuration{{Directory: dir, Default: true}},
+			WarnOrphanMiddlewares: orphans,
+		})
+		bundle, _, err := p.GetConfig(t.Context(), map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bundle.Warnings
+	}
+
+	got := warnings(false)
+	if len(got) != 1 || !strings.Contains(got[0], "middleware broken") {
+		t.Fatalf("expected only the malformed path warning, got %q", got)
+	}
+	got = warnings(true)
+	want := []string{
+		"middleware stale: path /legacy/* matches no route",
+		"middleware stale protects no route",
+	}
+	for _, w := range want {
+		if !slices.Contains(got, w) {
+			t.Errorf("expected warning %q, got %q", w, got)
+		}
+	}
+	for _, w := range got {
+		if strings.Contains(w, "middleware auth") {
+			t.Errorf("expected the auth middleware paths to match routes, got %q", w)
+		}
+	}
+
+	if problems := validateBundle(&config.ConfigBundle{Middlewares: []models.Middleware{{Name: "broken", Paths: []string{"/a/*/b"}}}}); len(problems) != 1 {
+		t.Errorf("expected a malformed middleware path problem, got %q", problems)
+	}
+}
+
+func TestMetadataPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", "metadata:\n  region: eu-west\n  owner: platform\n"+testRoutes)
+	for _, tt := range []struct {
+		precedence string
+		region     string
+	}{
+		{"", "eu-central"},
+		{config.MetadataPrecedenceConfig, "eu-central"},
+		{config.MetadataPrecedenceFile, "eu-west"},
+	} {
+		t.Run(tt.precedence, func(t *testing.T) {
+			p := newTestProvider(t, &config.ProviderConfig{
+				MetadataPrecedence: tt.precedence,
+				Configurations: []*config.Configuration{{
+					Directory: dir,
+					Metadata:  map[string]string{"region": "eu-central", "env": "prod"},
+				}},
+			})
+			bundle, _, err := p.GetConfig(t.Context(), map[string]string{"region": "eu-central", "env": "prod"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bundle.Metadata["region"] != tt.region {
+				t.Errorf("expected region %s to win, got %v", tt.region, bundle.Metadata)
+			}
+			// Keys set on one side only are always merged
+			if bundle.Metadata["owner"] != "platform" || bundle.Metadata["env"] != "prod" {
+				t.Errorf("expected the keys of both sides, got %v", bundle.Metadata)
+			}
+		})
+	}
+}
+
+func TestMinMatchScore(t *testing.T) {
+	acme, fallback := t.TempDir(), t.TempDir()
+	writeFile(t, acme, "routes.yaml", testRoutes)
+	writeFile(t, fallback, "routes.yaml", testRoutes)
+	configurations := []*config.Configuration{
+		{Directory: acme, Metadata: map[string]string{"env": "prod", "region": "eu", "tenant": "acme"}},
+		{Directory: fallback, Default: true},
+	}
+	low := map[string]string{"env": "prod", "region": "us", "tenant": "globex"}
+	high := map[string]string{"env": "prod", "region": "eu", "tenant": "globex"}
+
+	for _, tt := range []struct {
+		name      string
+		threshold float64
+		lowID     string
+	}{
+		{"unset", 0, "env=prod&region=eu&tenant=acme"},
+		{"absolute", 2, "default"},
+		{"fraction", 0.5, "default"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, &config.ProviderConfig{MinMatchScore: tt.threshold, Configurations: configurations})
+			cfg, fallback := p.matchConfiguration(low, "")
+			if cfg == nil || cfg.ID != tt.lowID || fallback != (tt.lowID == "default") {
+				t.Errorf("expected a score of 1 to select %s, got %v", tt.lowID, cfg)
+			}
+			if cfg, fallback := p.matchConfiguration(high, ""); cfg == nil || cfg.ID != "env=prod&region=eu&tenant=acme" || fallback {
+				t.Errorf("expected a score of 2 to select the acme configuration, got %v", cfg)
+			}
+		})
+	}
+}
+
+func TestDefaultFallbackCounter(t *testing.T) {
+	prod, fallback := t.TempDir(), t.TempDir()
+	writeFile(t, prod, "routes.yaml", testRoutes)
+	writeFile(t, fallback, "routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: prod, Metadata: map[string]string{"env": "prod"}},
+			{Directory: fallback, Default: true},
+		},
+	})
+	fallbacks := func() float64 { return metrics.DefaultFallbacks.Value(config.FallbackGlobal, "default") }
+	before := fallbacks()
+
+	if _, _, err := p.GetConfig(t.Context(), map[string]string{"env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fallbacks(); got != before {
+		t.Fatalf("expected a match not to count as a fallback, got %v fallbacks", got-before)
+	}
+	for range 3 {
+		if _, _, err := p.GetConfig(t.Context(), map[string]string{"env": "staging"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fallbacks(); got != before+3 {
+		t.Fatalf("expected 3 fallbacks, got %v", got-before)
+	}
+
+	// Warnings are sampled, one per interval
+	var sampler logSampler
+	now := time.Now()
+	if ok, _ := sampler.allow(now, time.Minute); !ok {
+		t.Fatal("expected the first warning to be logged")
+	}
+	if ok, _ := sampler.allow(now.Add(time.Second), time.Minute); ok {
+		t.Fatal("expected a warning within the interval to be suppressed")
+	}
+	if ok, suppressed := sampler.allow(now.Add(time.Minute), time.Minute); !ok || suppressed != 1 {
+		t.Fatalf("expected the next warning to report 1 suppressed, got %v %d", ok, suppressed)
+	}
+}
+
+func TestReloadTagged(t *testing.T) {
+	eu, euWest, us := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, dir := range []string{eu, euWest, us} {
+		writeFile(t, dir, "routes.yaml", testRoutes)
+	}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: eu, Metadata: map[string]string{"tenant": "eu"}, Tags: []string{"region:eu"}},
+			{Directory: euWest, Metadata: map[string]string{"tenant": "eu-west"}, Tags: []string{"region:eu", "tier:gold"}},
+			{Directory: us, Metadata: map[string]string{"tenant": "us"}, Tags: []string{"region:us"}},
+		},
+	})
+	loads := func() map[string]int64 {
+		counts := map[string]int64{}
+		for _, id := range p.ConfigurationIDs() {
+			counts[id] = p.GetStats(id).Loads
+		}
+		return counts
+	}
+	before := loads()
+
+	writeFile(t, euWest, "routes.yaml", strings.Replace(testRoutes, "/api", "/v2", 1))
+	writeFile(t, us, "routes.yaml", strings.Replace(testRoutes, "/api", "/v2", 1))
+	ids, err := p.ReloadTagged("region:eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{"tenant=eu", "tenant=eu-west"}) {
+		t.Fatalf("expected the eu configurations to be reloaded, got %v", ids)
+	}
+	after := loads()
+	for id, want := range map[string]int64{"tenant=eu": 1, "tenant=eu-west": 1, "tenant=us": 0} {
+		if got := after[id] - before[id]; got != want {
+			t.Errorf("expected %s to be loaded %d more times, got %d", id, want, got)
+		}
+	}
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{"tenant": "eu-west"})
+	if err != nil || bundle.Routes[0].Path != "/v2" {
+		t.Fatalf("expected the tagged configuration to serve its new routes, got %v", err)
+	}
+	if bundle, _, _ := p.GetConfig(t.Context(), map[string]string{"tenant": "us"}); bundle.Routes[0].Path != "/api" {
+		t.Fatal("expected the untagged configuration to keep its routes")
+	}
+
+	if _, err := p.ReloadTagged("region:apac"); !errors.Is(err, ErrTagNotFound) {
+		t.Fatalf("expected an unknown tag to be reported, got %v", err)
+	}
+}
+
+func TestExternalValidator(t *testing.T) {
+	dir, out := t.TempDir(), t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	received := filepath.Join(out, "bundle.json")
+	configurations := []*config.Configuration{{Directory: dir, Default: true}}
+
+	newTestProvider(t, &config.ProviderConfig{
+		Configurations: configurations,
+		Validator:      &config.Validator{Command: []string{"sh", "-c", "cat > " + received}},
+	})
+	data, err := os.ReadFile(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle config.ConfigBundle
+	if err := json.Unmarshal(data, &bundle); err != nil || len(bundle.Routes) != 1 || bundle.Routes[0].Name != "api" {
+		t.Fatalf("expected the validator to receive the bundle JSON, got %s", data)
+	}
+
+	_, err = NewHTTPProvider(&config.ProviderConfig{
+		Configurations: configurations,
+		Validator:      &config.Validator{Command: []string{"sh", "-c", "echo 'route api has no auth' >&2; exit 3"}},
+	})
+	if !errors.Is(err, ErrValidatorRejected) || !strings.Contains(err.Error(), "route api has no auth") {
+		t.Fatalf("expected the load to fail with the validator stderr, got %v", err)
+	}
+
+	_, err = NewHTTPProvider(&config.ProviderConfig{
+		Configurations: configurations,
+		Validator:      &config.Validator{Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond},
+	})
+	if !errors.Is(err, ErrValidatorRejected) || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected the load to fail on the validator timeout, got %v", err)
+	}
+}
+
+func TestQueryHeaderPrecedence(t *testing.T) {
+	acme, globex := t.TempDir(), t.TempDir()
+	writeFile(t, acme, "routes.yaml", testRoutes)
+	writeFile(t, globex, "routes.yaml", testRoutes)
+	configurations := []*config.Configuration{
+		{Directory: acme, Metadata: map[string]string{"tenant": "acme"}},
+		{Directory: globex, Metadata: map[string]string{"tenant": "globex"}},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/config?tenant=acme", nil)
+	r.Header.Set("X-Goma-Meta-Tenant", "globex")
+
+	for _, tt := range []struct {
+		name       string
+		extractors []string
+		want       string
+	}{
+		{"default", nil, "tenant=globex"},
+		{"header wins", []string{config.ExtractorQuery, config.ExtractorHeader}, "tenant=globex"},
+		{"query wins", []string{config.ExtractorHeader, config.ExtractorQuery}, "tenant=acme"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, &config.ProviderConfig{Configurations: configurations, MetadataExtractors: tt.extractors})
+			if cfg, _ := p.matchConfiguration(p.ExtractMetadata(r), ""); cfg == nil || cfg.ID != tt.want {
+				t.Errorf("expected %s to be matched, got %v", tt.want, cfg)
+			}
+		})
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	dir, secrets := t.TempDir(), t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	writeFile(t, secrets, "globex-key", "file-secret\n")
+	t.Setenv("ACME_API_KEY", "env-secret")
+	acme := &config.Configuration{
+		Directory: dir,
+		Metadata:  map[string]string{"tenant": "acme"},
+		Auth:      &config.HTTPAuth{APIKey: "${env:ACME_API_KEY}"},
+	}
+	globex := &config.Configuration{
+		Directory: dir,
+		Metadata:  map[string]string{"tenant": "globex"},
+		Auth:      &config.HTTPAuth{APIKey: "${file:" + filepath.Join(secrets, "globex-key") + "}"},
+	}
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{acme, globex}})
+
+	authenticate := func(cfg *config.Configuration, key string) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-API-Key", key)
+		return p.Authenticate(r, cfg, config.OperationRead)
+	}
+	for _, tt := range []struct {
+		cfg    *config.Configuration
+		secret string
+	}{{acme, "env-secret"}, {globex, "file-secret"}} {
+		if err := authenticate(tt.cfg, tt.secret); err != nil {
+			t.Errorf("%s: expected the resolved secret to authenticate, got %v", tt.cfg.ID, err)
+		}
+		if err := authenticate(tt.cfg, tt.cfg.Auth.APIKey); err == nil {
+			t.Errorf("%s: expected the reference itself not to authenticate", tt.cfg.ID)
+		}
+	}
+	if acme.Auth.APIKey != "${env:ACME_API_KEY}" {
+		t.Errorf("expected the configuration to keep its reference, got %q", acme.Auth.APIKey)
+	}
+
+	// A configuration whose secret can't be resolved fails to load
+	_, err := NewHTTPProvider(&config.ProviderConfig{Configurations: []*config.Configuration{{
+		Directory: dir,
+		Auth:      &config.HTTPAuth{APIKey: "${env:UNSET_API_KEY}"},
+	}}})
+	if !errors.Is(err, ErrSecretUnresolved) {
+		t.Fatalf("expected an unresolved secret to fail the load, got %v", err)
+	}
+
+	// Route TLS keys are resolved when route secrets are enabled, from outside
+	// of the configuration directory when path escapes are allowed
+	tlsDir := t.TempDir()
+	writeFile(t, secrets, "tls.key", "private-key")
+	writeFile(t, tlsDir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n    tls:\n      certificates:\n        - cert: /etc/certs/api.crt\n          key: ${file:"+filepath.Join(secrets, "tls.key")+"}\n")
+	p = newTestProvider(t, &config.ProviderConfig{
+		RouteSecrets:    true,
+		AllowPathEscape: true,
+		Configurations:  []*config.Configuration{{Directory: tlsDir, Default: true}},
+	})
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := bundle.Routes[0].TLS.Certificates[0].Key; key != "private-key" {
+		t.Fatalf("expected the route TLS key to be resolved, got %q", key)
+	}
+}
+
+func TestVaultSecrets(t *testing.T) {
+	var reads int
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/provider" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reads++
+		_, _ = w.Write([]byte(`{"data": {"data": {"apikey": "vault-secret", "password": "vault-password"}, "metadata": {"version": 3}}}`))
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{
+		Directory: dir,
+		Metadata:  map[string]string{"tenant": "acme"},
+		Auth:      &config.HTTPAuth{APIKey: "vault://secret/data/provider#apikey"},
+	}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+		Vault:          &config.Vault{Address: vault.URL},
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-API-Key", "vault-secret")
+	if err := p.Authenticate(r, cfg, config.OperationRead); err != nil {
+		t.Fatalf("expected the Vault secret to authenticate, got %v", err)
+	}
+
+	// Secrets are cached, then read again on reload
+	if _, err := p.resolveSecret(t.Context(), "vault://secret/data/provider#password"); err != nil || reads != 1 {
+		t.Fatalf("expected the cached secret to be served, got %d reads, %v", reads, err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if reads != 2 {
+		t.Fatalf("expected the reload to read the secret again, got %d reads", reads)
+	}
+
+	_, err := p.resolveSecret(t.Context(), "vault://secret/data/provider#missing")
+	if !errors.Is(err, ErrSecretUnresolved) || !strings.Contains(err.Error(), `key "missing" not found`) {
+		t.Fatalf("expected a missing key error, got %v", err)
+	}
+	_, err = NewHTTPProvider(&config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Auth: &config.HTTPAuth{APIKey: "vault://secret/data/other#apikey"}}},
+		Vault:          &config.Vault{Address: vault.URL},
+	})
+	if !errors.Is(err, ErrSecretUnresolved) {
+		t.Fatalf("expected an unknown Vault path to fail the load, got %v", err)
+	}
+}
+
+func TestPathConfinement(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	writeFile(t, outside, "leak.yaml", "routes:\n  - name: leak\n    path: /leak\n    target: http://leak:8080\n")
+	writeFile(t, outside, "tls.key", "outside-key")
+	if err := os.Symlink(filepath.Join(outside, "leak.yaml"), filepath.Join(dir, "leak.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files symlinked from outside of the configuration directory are rejected
+	_, err := NewHTTPProvider(&config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	if !errors.Is(err, ErrPathEscape) {
+		t.Fatalf("expected the symlinked file to be rejected, got %v", err)
+	}
+	p := newTestProvider(t, &config.ProviderConfig{
+		AllowPathEscape: true,
+		Configurations:  []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	if bundle, _, err := p.GetConfig(t.Context(), map[string]string{}); err != nil || len(bundle.Routes) != 2 {
+		t.Fatalf("expected the symlinked file to be loaded when path escapes are allowed, got %v", err)
+	}
+
+	// Route file secrets are relative to the configuration directory and can't escape it
+	tlsDir := t.TempDir()
+	writeFile(t, tlsDir, "tls.key", "private-key")
+	tlsRoutes := func(key string) string {
+		return "routes:\n  - name: api\n    path: /api\n    tls:\n      certificates:\n        - cert: /etc/certs/api.crt\n          key: ${file:" + key + "}\n"
+	}
+	writeFile(t, tlsDir, "routes.yaml", tlsRoutes("tls.key"))
+	p = newTestProvider(t, &config.ProviderConfig{
+		RouteSecrets:   true,
+		Configurations: []*config.Configuration{{Directory: tlsDir, Default: true}},
+	})
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := bundle.Routes[0].TLS.Certificates[0].Key; key != "private-key" {
+		t.Fatalf("expected the relative TLS key to be resolved, got %q", key)
+	}
+	rel, err := filepath.Rel(tlsDir, filepath.Join(outside, "tls.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{rel, filepath.Join(outside, "tls.key"), "/etc/passwd"} {
+		writeFile(t, tlsDir, "routes.yaml", tlsRoutes(key))
+		_, err := NewHTTPProvider(&config.ProviderConfig{
+			RouteSecrets:   true,
+			Configurations: []*config.Configuration{{Directory: tlsDir, Default: true}},
+		})
+		if !errors.Is(err, ErrPathEscape) {
+			t.Fatalf("expected the TLS key %s to be rejected, got %v", key, err)
+		}
+	}
+}
+
+func TestQuietPeriod(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		ReloadInterval: 10 * time.Millisecond,
+		QuietPeriod:    300 * time.Millisecond,
+	})
+	reloads := p.stats.reloads.Load()
+	p.StartPeriodicReload(t.Context())
+
+	// A sidecar still writing files during the quiet period doesn't trigger reloads
+	for i := range 3 {
+		writeFile(t, dir, fmt.Sprintf("extra-%d.yaml", i), fmt.Sprintf("routes:\n  - name: extra-%d\n    path: /extra-%d\n", i, i))
+		time.Sleep(30 * time.Millisecond)
+	}
+	if got := p.stats.reloads.Load(); got != reloads {
+		t.Fatalf("expected no reload during the quiet period, got %d", got-reloads)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for p.stats.reloads.Load() == reloads {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a reload once the quiet period elapsed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := p.stats.reloads.Load() - reloads; got != 1 {
+		t.Fatalf("expected the changes to coalesce into a single reload, got %d", got)
+	}
+	bundle, _, err := p.GetConfig(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Routes) != 4 {
+		t.Fatalf("expected the reload to pick up every file, got %d routes", len(bundle.Routes))
+	}
+}
+
+func TestMetadataTransforms(t *testing.T) {
+	prod, staging := t.TempDir(), t.TempDir()
+	writeFile(t, prod, "routes.yaml", testRoutes)
+	writeFile(t, staging, "routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: prod, Metadata: map[string]string{"env": "prod"}},
+			{Directory: staging, Metadata: map[string]string{"env": "staging"}},
+		},
+		MetadataTransforms: map[string][]config.MetadataTransform{
+			"env": {
+				{Trim: true},
+				{Lowercase: true},
+				{TrimPrefix: "env-"},
+				{Map: map[string]string{"production": "prod", "stage": "staging"}},
+			},
+		},
+	})
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "production", want: "env=prod"},
+		{header: " Env-Production ", want: "env=prod"},
+		{header: "stage", want: "env=staging"},
+		{header: "staging", want: "env=staging"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/config", nil)
+		r.Header.Set("X-Goma-Meta-Env", tt.header)
+		metadata := p.ExtractMetadata(r)
+		_, cfg, err := p.GetConfig(t.Context(), metadata)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.header, err)
+		}
+		if cfg.ID != tt.want {
+			t.Fatalf("expected %q to match %s, got %s (metadata %v)", tt.header, tt.want, cfg.ID, metadata)
+		}
+	}
+
+	// Keys without transforms are kept as sent
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/config?region=EU", nil)
+	if region := p.ExtractMetadata(r)["region"]; region != "EU" {
+		t.Fatalf("expected keys without transforms to be kept, got %q", region)
+	}
+}
+
+func TestLoadRetry(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{
+		Directory: dir,
+		Default:   true,
+		LoadRetry: &config.LoadRetry{Attempts: 3, Backoff: time.Millisecond},
+	}
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{cfg}})
+
+	// The storage fails the next read once, as an NFS hiccup would
+	var reads, failures atomic.Int32
+	failing := int32(1)
+	p.readFile = func(name string) ([]byte, error) {
+		if reads.Add(1) <= failing {
+			failures.Add(1)
+			return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EIO}
+		}
+		return os.ReadFile(name)
+	}
+	writeFile(t, dir, "extra.yaml", "routes:\n  - name: extra\n    path: /extra\n")
+	if err := p.Reload(); err != nil {
+		t.Fatalf("expected the load to succeed once retried, got %v", err)
+	}
+	bundle, _, err := p.GetConfig(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures.Load() != 1 || len(bundle.Routes) != 2 {
+		t.Fatalf("expected the retried load to serve both files after 1 failure, got %d routes after %d failures", len(bundle.Routes), failures.Load())
+	}
+
+	// Failures outlasting the retries fail the reload, keeping the last good bundle
+	reads.Store(0)
+	failing = 100
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected the reload to fail once the retries are exhausted")
+	}
+	if failures.Load() != 1+4 {
+		t.Fatalf("expected the first load and 3 retries, got %d failed reads", failures.Load()-1)
+	}
+
+	// Errors in the content of the files are not retried, broken.yaml being read first
+	reads.Store(0)
+	failing = 0
+	writeFile(t, dir, "broken.yaml", "routes: [")
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected the reload of a broken file to fail")
+	}
+	if reads.Load() != 1 {
+		t.Fatalf("expected a content error not to be retried, got %d reads", reads.Load())
+	}
+}
+
+func TestConfigHTTPClient(t *testing.T) {
+	kube := fakeKubeAPI(t)
+	var proxied atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Proxied requests carry the absolute API server URL
+		if r.URL.Host != "kube.internal" {
+			http.Error(w, "unexpected target "+r.URL.String(), http.StatusBadGateway)
+			return
+		}
+		proxied.Add(1)
+		kube.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		kube.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	// Acme reaches its API server through its proxy only, globex directly
+	acmeSource := kubeSource(t, "http://kube.internal")
+	acme := &config.Configuration{
+		Metadata:   map[string]string{"tenant": "acme"},
+		Kubernetes: acmeSource,
+		HTTPClient: &config.HTTPClient{Proxy: proxy.URL},
+	}
+	globex := &config.Configuration{
+		Metadata:   map[string]string{"tenant": "globex"},
+		Kubernetes: kubeSource(t, kube.URL),
+	}
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{acme, globex}})
+	for _, cfg := range []*config.Configuration{acme, globex} {
+		if bundle, _, err := p.GetConfig(t.Context(), cfg.Metadata); err != nil || len(bundle.Routes) != 3 {
+			t.Fatalf("expected %s to be loaded, got %v", cfg.ID, err)
+		}
+	}
+	if got := proxied.Load(); got != 2 {
+		t.Fatalf("expected the 2 acme list requests to be proxied, got %d", got)
+	}
+
+	// The timeout override bounds the lists of the tenant only
+	load := func(settings *config.HTTPClient) error {
+		source, err := newKubernetesSource(p, &config.Configuration{Kubernetes: kubeSource(t, slow.URL), HTTPClient: settings})
+		if err != nil {
+			return err
+		}
+		_, _, err = source.Load(t.Context())
+		return err
+	}
+	if err := load(&config.HTTPClient{Timeout: 50 * time.Millisecond}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the list to exceed the configuration timeout, got %v", err)
+	}
+	if err := load(nil); err != nil {
+		t.Fatalf("expected the default timeout to wait for the slow list, got %v", err)
+	}
+
+	// Loads and change checks reuse the configuration client until its settings change
+	client := func(cfg *config.Configuration) *http.Client {
+		t.Helper()
+		p.clients.mu.Lock()
+		defer p.clients.mu.Unlock()
+		return p.clients.clients[cfg.ID].client
+	}
+	first := client(acme)
+	source, err := newKubernetesSource(p, acme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Changed(t.Context(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || client(acme) != first || proxied.Load() != 4 {
+		t.Fatalf("expected the change check to reuse the proxied acme client, got %d proxied requests", proxied.Load())
+	}
+	if client(globex) == first {
+		t.Fatal("expected the configurations not to share a client")
+	}
+	updated := *acme
+	updated.HTTPClient = &config.HTTPClient{Proxy: proxy.URL, Timeout: time.Second}
+	if source, err = newKubernetesSource(p, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := source.Load(t.Context()); err != nil || client(acme) == first {
+		t.Fatalf("expected changed settings to rebuild the client, got %v", err)
+	}
+	umbrella := &config.Configuration{ID: "tenant=umbrella", Kubernetes: kubeSource(t, kube.URL), HTTPClient: &config.HTTPClient{CAFile: "missing.pem"}}
+	if source, err = newKubernetesSource(p, umbrella); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := source.Load(t.Context()); err == nil {
+		t.Fatal("expected a missing CA file to be rejected")
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	migrationsMu.Lock()
+	registered := maps.Clone(migrations)
+	migrationsMu.Unlock()
+	t.Cleanup(func() {
+		migrationsMu.Lock()
+		migrations = registered
+		migrationsMu.Unlock()
+	})
+
+	// v1 routes named their backend url, v2 renamed it to target
+	RegisterMigration(1, func(doc map[string]any) error {
+		routes, _ := doc["routes"].([]any)
+		for _, r := range routes {
+			if route, ok := r.(map[string]any); ok && route["url"] != nil {
+				route["target"] = route["url"]
+				delete(route, "url")
+			}
+		}
+		return nil
+	})
+	// v2 routes had a single host, v3 routes list their hosts
+	RegisterMigration(2, func(doc map[string]any) error {
+		routes, _ := doc["routes"].([]any)
+		for _, r := range routes {
+			if route, ok := r.(map[string]any); ok && route["host"] != nil {
+				route["hosts"] = []any{route["host"]}
+				delete(route, "host")
+			}
+		}
+		return nil
+	})
+	if got := SchemaVersion(); got != 3 {
+		t.Fatalf("expected schema version 3, got %d", got)
+	}
+
+	dir := t.TempDir()
+	writeFile(t, dir, "v1.yaml", `
+routes:
+  - name: legacy
+    path: /legacy
+    url: http://legacy:8080
+    host: legacy.example.com
+`)
+	writeFile(t, dir, "v2.json", `{"version": "2", "routes": [{"name": "json", "path": "/json", "host": "json.example.com"}]}`)
+	writeFile(t, dir, "v3.yaml", `
+version: "3.0"
+routes:
+  - name: current
+    path: /current
+    target: http://current:8080
+`)
+	p := newTestProvider(t, &config.ProviderConfig{
+		StrictFields:   true,
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "migrated"}}},
+	})
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{"env": "migrated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := map[string]models.Route{}
+	for _, route := range bundle.Routes {
+		routes[route.Name] = route
+	}
+	if got := routes["legacy"]; got.Target != "http://legacy:8080" || !slices.Equal(got.Hosts, []string{"legacy.example.com"}) {
+		t.Fatalf("expected the v1 route upgraded through v3, got %+v", got)
+	}
+	if got := routes["json"]; !slices.Equal(got.Hosts, []string{"json.example.com"}) {
+		t.Fatalf("expected the v2 route upgraded to v3, got %+v", got)
+	}
+	if got := routes["current"]; got.Target != "http://current:8080" {
+		t.Fatalf("expected the current route unchanged, got %+v", got)
+	}
+
+	writeFile(t, dir, "v4.yaml", "version: 4\nroutes: []\n")
+	if err := p.Reload(); err == nil || !strings.Contains(err.Error(), "unsupported schema version 4") {
+		t.Fatalf("expected a newer schema version to be rejected, got %v", err)
+	}
+}
+
+func TestStartupMode(t *testing.T) {
+	good, broken := t.TempDir(), t.TempDir()
+	writeFile(t, good, "routes.yaml", testRoutes)
+	writeFile(t, broken, "routes.yaml", "routes: [")
+	newConf := func(mode string) *config.ProviderConfig {
+		return &config.ProviderConfig{
+			StartupMode: mode,
+			Configurations: []*config.Configuration{
+				{Directory: good, Metadata: map[string]string{"tenant": "good"}},
+				{Directory: broken, Metadata: map[string]string{"tenant": "broken"}},
+			},
+		}
+	}
+
+	if _, err := NewHTTPProvider(newConf(config.StartupFailFast)); err == nil {
+		t.Fatal("expected a fail-fast start to fail on the broken config")
+	}
+
+	p := newTestProvider(t, newConf(config.StartupDegraded))
+	if _, _, err := p.GetConfig(t.Context(), map[string]string{"tenant": "good"}); err != nil {
+		t.Fatalf("expected the healthy config to be served: %v", err)
+	}
+	if _, _, err := p.GetConfig(t.Context(), map[string]string{"tenant": "broken"}); err == nil {
+		t.Fatal("expected the broken config not to be served")
+	}
+	status := p.Status()
+	brokenID := p.BuildCacheKey(map[string]string{"tenant": "broken"})
+	if !status.Ready || !slices.Equal(status.FailedConfigs, []string{brokenID}) {
+		t.Fatalf("expected a ready provider reporting the failed config, got %+v", status)
+	}
+
+	// The broken config loads once fixed
+	writeFile(t, broken, "routes.yaml", testRoutes)
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if failed := p.Status().FailedConfigs; len(failed) != 0 {
+		t.Fatalf("expected no failed configs after a successful reload, got %v", failed)
+	}
+}
+
+func TestMultiDocumentYAML(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "bundle.yaml", `
+routes:
+  - name: api
+    path: /api
+---
+routes:
+  - name: web
+    path: /web
+middlewares:
+  - name: auth
+    type: basic
+`)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "multidoc"}}},
+	})
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{"env": "multidoc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, route := range bundle.Routes {
+		names = append(names, route.Name)
+	}
+	if !slices.Equal(names, []string{"api", "web"}) || len(bundle.Middlewares) != 1 {
+		t.Fatalf("expected the routes and middlewares of both documents, got routes %v and %d middlewares", names, len(bundle.Middlewares))
+	}
+}
+
+func TestRequireNonEmpty(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr bool
+	}{
+		{name: "empty", wantErr: true},
+		{name: "no config files", files: map[string]string{"README.md": "routes"}, wantErr: true},
+		{name: "no routes", files: map[string]string{"middlewares.yaml": "middlewares:\n  - name: auth\n    type: basic\n"}, wantErr: true},
+		{name: "routes", files: map[string]string{"routes.yaml": testRoutes}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, dir, name, content)
+			}
+			_, err := NewHTTPProvider(&config.ProviderConfig{
+				Configurations: []*config.Configuration{{Directory: dir, Default: true, RequireNonEmpty: true}},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewHTTPProvider error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Without the setting, an empty directory loads an empty bundle
+	if _, err := NewHTTPProvider(&config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: t.TempDir(), Default: true}},
+	}); err != nil {
+		t.Fatalf("expected an empty directory to load, got %v", err)
+	}
+}
+
+// selfSignedPEM returns a PEM encoded self-signed certificate
+func selfSignedPEM(t *testing.T, commonName string, dnsNames ...string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestClientCertMetadata(t *testing.T) {
+	edge, fallback := t.TempDir(), t.TempDir()
+	writeFile(t, edge, "routes.yaml", testRoutes)
+	writeFile(t, fallback, "routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: edge, Metadata: map[string]string{MetadataClientCN: "edge-gateway"}},
+			{Directory: fallback, Default: true},
+		},
+	})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "edge-gateway"},
+		DNSNames:              []string{"edge.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata := p.ExtractMetadata(r)
+		_, cfg, err := p.GetConfig(r.Context(), metadata)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %s", cfg.ID, metadata[MetadataClientSAN])
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(client *http.Client, query string) string {
+		t.Helper()
+		resp, err := client.Get(server.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	edgeID := p.BuildCacheKey(map[string]string{MetadataClientCN: "edge-gateway"})
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}
+	withCert := &http.Client{Transport: transport}
+	if got := get(withCert, "/"); got != edgeID+" edge.example.com" {
+		t.Fatalf("expected the client certificate to select %s, got %q", edgeID, got)
+	}
+
+	// Without a certificate, the identity can't be claimed through the query
+	if got := get(server.Client(), "/?clientCN=edge-gateway"); strings.HasPrefix(got, edgeID) {
+		t.Fatalf("expected a spoofed clientCN to be ignored, got %q", got)
+	}
+}
+
+func TestCheckRouteTLS(t *testing.T) {
+	sans := selfSignedPEM(t, "api", "api.example.com", "*.apps.example.com")
+	cnOnly := selfSignedPEM(t, "legacy.example.com")
+	tests := []struct {
+		name    string
+		cert    string
+		hosts   []string
+		problem string
+	}{
+		{name: "san", cert: sans, hosts: []string{"api.example.com"}},
+		{name: "wildcard san", cert: sans, hosts: []string{"shop.apps.example.com"}},
+		{name: "base64", cert: base64.StdEncoding.EncodeToString([]byte(sans)), hosts: []string{"api.example.com"}},
+		{name: "common name", cert: cnOnly, hosts: []string{"legacy.example.com"}},
+		{name: "uncovered", cert: sans, hosts: []string{"api.example.com", "www.example.com"}, problem: `no TLS certificate covers host "www.example.com"`},
+		{name: "common name ignored with sans", cert: sans, hosts: []string{"api"}, problem: `no TLS certificate covers host "api"`},
+		{name: "file path", cert: "/etc/goma/certs/api.crt", hosts: []string{"www.example.com"}},
+		{name: "invalid pem", cert: "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n", hosts: []string{"api.example.com"}, problem: "invalid certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := newBundle()
+			bundle.Routes = []models.Route{{
+				Name:  "api",
+				Hosts: tt.hosts,
+				TLS:   models.TlsCertificates{Certificates: []models.TLS{{Cert: tt.cert}}},
+			}}
+			problems := checkRouteTLS(bundle)
+			if tt.problem == "" {
+				if len(problems) != 0 {
+					t.Fatalf("expected no problem, got %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+				t.Fatalf("expected problem %q, got %v", tt.problem, problems)
+			}
+		})
+	}
+}
+
+func TestRouteTLSMismatch(t *testing.T) {
+	cert := selfSignedPEM(t, "api", "api.example.com")
+	dir := t.TempDir()
+	routes, err := json.Marshal(map[string]any{"routes": []models.Route{{
+		Name:  "api",
+		Path:  "/",
+		Hosts: []string{"www.example.com"},
+		TLS:   models.TlsCertificates{Certificates: []models.TLS{{Cert: cert}}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "routes.json", string(routes))
+
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	bundle, _, err := p.GetConfig(t.Context(), nil)
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if len(bundle.Warnings) != 1 || !strings.Contains(bundle.Warnings[0], "www.example.com") {
+		t.Fatalf("expected a TLS mismatch warning, got %v", bundle.Warnings)
+	}
+
+	_, err = NewHTTPProvider(&config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		StrictTLS:      true,
+	})
+	if err == nil || !strings.Contains(err.Error(), `no TLS certificate covers host "www.example.com"`) {
+		t.Fatalf("expected strict TLS to reject the bundle, got %v", err)
+	}
+}
+
+func TestConfigLastChangeGauge(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	cfg := &config.Configuration{Directory: dir, Metadata: map[string]string{"tenant": "last-change"}}
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{cfg},
+	})
+	initial, ok := metrics.ConfigLastChange.Value(cfg.ID)
+	if !ok {
+		t.Fatal("expected the initial load to set the last change time")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got, _ := metrics.ConfigLastChange.Value(cfg.ID); got != initial {
+		t.Fatalf("expected a no-op reload to keep the last change time %v, got %v", initial, got)
+	}
+
+	writeFile(t, dir, "extra.yaml", "routes:\n  - name: extra\n    path: /extra\n")
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got, _ := metrics.ConfigLastChange.Value(cfg.ID); got <= initial {
+		t.Fatalf("expected the checksum change to move the last change time past %v, got %v", initial, got)
+	}
+}
+
+func TestMetadataDefaults(t *testing.T) {
+	prod, staging, fallback := t.TempDir(), t.TempDir(), t.TempDir()
+	writeFile(t, prod, "routes.yaml", testRoutes)
+	writeFile(t, staging, "routes.yaml", testRoutes)
+	writeFile(t, fallback, "routes.yaml", testRoutes)
+	prodCfg := &config.Configuration{Directory: prod, Metadata: map[string]string{"env": "prod", "region": "eu"}}
+	stagingCfg := &config.Configuration{Directory: staging, Metadata: map[string]string{"env": "staging", "tier": "free"}}
+	defaults := map[string]string{"env": "prod", "region": "eu"}
+	tests := []struct {
+		name     string
+		defaults map[string]string
+		header   map[string]string
+		want     *config.Configuration
+	}{
+		{name: "no defaults", header: map[string]string{"Tier": "free"}, want: stagingCfg},
+		{name: "defaulted keys change the match", defaults: defaults, header: map[string]string{"Tier": "free"}, want: prodCfg},
+		{name: "defaulted keys without metadata", defaults: defaults, want: prodCfg},
+		{name: "client value wins", defaults: defaults, header: map[string]string{"Env": "staging", "Tier": "free"}, want: stagingCfg},
+		{name: "no defaults without metadata", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, &config.ProviderConfig{
+				Configurations: []*config.Configuration{
+					prodCfg,
+					stagingCfg,
+					{Directory: fallback, Default: true},
+				},
+				MetadataDefaults: tt.defaults,
+			})
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/config", nil)
+			for k, v := range tt.header {
+				r.Header.Set("X-Goma-Meta-"+k, v)
+			}
+			_, cfg, err := p.GetConfig(t.Context(), p.ExtractMetadata(r))
+			if err != nil {
+				t.Fatalf("GetConfig: %v", err)
+			}
+			if tt.want == nil {
+				if !cfg.Default {
+					t.Fatalf("expected the default configuration, got %s", cfg.ID)
+				}
+				return
+			}
+			if cfg.ID != tt.want.ID {
+				t.Fatalf("expected %s, got %s", tt.want.ID, cfg.ID)
+			}
+		})
+	}
+}
+
+func TestAllowedMetadataKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	tests := []struct {
+		name      string
+		allowed   []string
+		wantMatch bool
+	}{
+		{name: "every key allowed", wantMatch: false},
+		{name: "unlisted param ignored", allowed: []string{"env", "tenant-id"}, wantMatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, &config.ProviderConfig{
+				Configurations: []*config.Configuration{{
+					Directory:     dir,
+					Metadata:      map[string]string{"env": "prod", "tenant_id": "acme"},
+					MatchStrategy: config.MatchExact,
+				}},
+				MetadataKeys:        config.MetadataKeysSnake,
+				AllowedMetadataKeys: tt.allowed,
+			})
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/config?env=prod&cachebuster=123", nil)
+			r.Header.Set("X-Goma-Meta-Tenant-Id", "acme")
+			metadata := p.ExtractMetadata(r)
+			if _, ok := metadata["cachebuster"]; ok == tt.wantMatch {
+				t.Fatalf("unexpected cachebuster key in %v", metadata)
+			}
+			_, _, err := p.GetConfigMatching(t.Context(), metadata, "")
+			if matched := err == nil; matched != tt.wantMatch {
+				t.Fatalf("expected match %v, got error %v", tt.wantMatch, err)
+			}
+			if tt.allowed != nil && p.BuildCacheKey(p.normalizeMetadata(metadata)) != "env=prod&tenant_id=acme" {
+				t.Fatalf("expected the unlisted param to stay out of the cache key, got %v", metadata)
+			}
+		})
+	}
+
+	p := newTestProvider(t, &config.ProviderConfig{AllowedMetadataKeys: []string{"env"}})
+	resolved := p.ResolveMetadata(httptest.NewRequest(http.MethodPost, "/api/v1/config/batch", nil), map[string]string{"env": "prod", "debug": "1"})
+	if len(resolved) != 1 || resolved["env"] != "prod" {
+		t.Fatalf("expected body metadata to be filtered, got %v", resolved)
+	}
+}
+
+func TestRouteConflicts(t *testing.T) {
+	base := `routes:
+  - name: cart
+    path: /cart
+    target: http://cart:8080
+    hosts: [shop.example.com]
+    methods: [GET]
+    backends:
+      - endpoint: http://cart-1:8080
+        weight: 1
+    healthCheck:
+      path: /health
+`
+	partial := `routes:
+  - name: cart
+    methods: [POST]
+    hosts: [shop.example.com, m.example.com]
+    backends:
+      - endpoint: http://cart-1:8080
+        weight: 3
+      - endpoint: http://cart-2:8080
+`
+	full := `routes:
+  - name: cart
+    path: /basket
+    target: http://basket:8080
+    hosts: [basket.example.com]
+    methods: [PUT]
+    backends:
+      - endpoint: http://basket-1:8080
+    healthCheck:
+      path: /ready
+`
+	tests := []struct {
+		name    string
+		policy  string
+		overlay string
+		want    models.Route
+		wantErr bool
+	}{
+		{
+			name: "replace partially", policy: config.RouteConflictsReplace, overlay: partial,
+			want: models.Route{
+				Name: "cart", Hosts: []string{"shop.example.com", "m.example.com"}, Methods: []string{"POST"},
+				Backends: []models.Backend{{Endpoint: "http://cart-1:8080", Weight: 3}, {Endpoint: "http://cart-2:8080"}},
+			},
+		},
+		{
+			name: "replace fully", policy: config.RouteConflictsReplace, overlay: full,
+			want: models.Route{
+				Name: "cart", Path: "/basket", Target: "http://basket:8080", Hosts: []string{"basket.example.com"}, Methods: []string{"PUT"},
+				Backends: []models.Backend{{Endpoint: "http://basket-1:8080"}}, HealthCheck: models.RouteHealthCheck{Path: "/ready"},
+			},
+		},
+		{
+			name: "deep-merge partially", policy: config.RouteConflictsDeepMerge, overlay: partial,
+			want: models.Route{
+				Name: "cart", Path: "/cart", Target: "http://cart:8080",
+				Hosts: []string{"shop.example.com", "m.example.com"}, Methods: []string{"GET", "POST"},
+				Backends:    []models.Backend{{Endpoint: "http://cart-1:8080", Weight: 3}, {Endpoint: "http://cart-2:8080"}},
+				HealthCheck: models.RouteHealthCheck{Path: "/health"},
+			},
+		},
+		{
+			name: "deep-merge fully", policy: config.RouteConflictsDeepMerge, overlay: full,
+			want: models.Route{
+				Name: "cart", Path: "/basket", Target: "http://basket:8080",
+				Hosts: []string{"shop.example.com", "basket.example.com"}, Methods: []string{"GET", "PUT"},
+				Backends:    []models.Backend{{Endpoint: "http://cart-1:8080", Weight: 1}, {Endpoint: "http://basket-1:8080"}},
+				HealthCheck: models.RouteHealthCheck{Path: "/ready"},
+			},
+		},
+		{name: "error partially", policy: config.RouteConflictsError, overlay: partial, wantErr: true},
+		{name: "error fully", policy: config.RouteConflictsError, overlay: full, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "a-base.yaml", base)
+			writeFile(t, dir, "b-tenant.yaml", tt.overlay)
+			p, err := NewHTTPProvider(&config.ProviderConfig{
+				Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+				RouteConflicts: tt.policy,
+			})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "route cart") {
+					t.Fatalf("expected a route conflict error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewHTTPProvider: %v", err)
+			}
+			bundle, _, err := p.GetConfig(t.Context(), nil)
+			if err != nil {
+				t.Fatalf("GetConfig: %v", err)
+			}
+			if len(bundle.Routes) != 1 {
+				t.Fatalf("expected the conflict to resolve to one route, got %d", len(bundle.Routes))
+			}
+			if got := bundle.Routes[0]; !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected route\n got: %+v\nwant: %+v", got, tt.want)
+			}
+			if len(bundle.Sources) != 1 || filepath.Base(bundle.Sources[0].File) != "b-tenant.yaml" {
+				t.Fatalf("expected the route source to be the later file, got %+v", bundle.Sources)
+			}
+		})
+	}
+
+	// Without a policy both routes are kept
+	dir := t.TempDir()
+	writeFile(t, dir, "a-base.yaml", base)
+	writeFile(t, dir, "b-tenant.yaml", partial)
+	p := newTestProvider(t, &config.ProviderConfig{Configurations: []*config.Configuration{{Directory: dir, Default: true}}})
+	if bundle, _, err := p.GetConfig(t.Context(), nil); err != nil || len(bundle.Routes) != 2 {
+		t.Fatalf("expected both routes without a policy, got %v", err)
+	}
+}
+
+func TestMetadataExtractors(t *testing.T) {
+	RegisterExtractor("test-host", ExtractorFunc(func(r *http.Request, metadata map[string]string) {
+		if tenant, _, ok := strings.Cut(r.Host, "."); ok {
+			metadata["tenant"] = tenant
+		}
+	}))
+	acme, globex := t.TempDir(), t.TempDir()
+	writeFile(t, acme, "routes.yaml", testRoutes)
+	writeFile(t, globex, "routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: acme, Metadata: map[string]string{"tenant": "acme"}},
+			{Directory: globex, Metadata: map[string]string{"tenant": "globex"}},
+		},
+		MetadataExtractors: []string{config.ExtractorQuery, config.ExtractorHeader, "test-host"},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://acme.example.com/api/v1/config", nil)
+	if cfg, _ := p.matchConfiguration(p.ExtractMetadata(r), ""); cfg == nil || cfg.ID != "tenant=acme" {
+		t.Fatalf("expected the host to select tenant=acme, got %v", cfg)
+	}
+	// Later extractors take precedence over the built-in ones
+	r.Header.Set("X-Goma-Meta-Tenant", "globex")
+	if cfg, _ := p.matchConfiguration(p.ExtractMetadata(r), ""); cfg == nil || cfg.ID != "tenant=acme" {
+		t.Fatalf("expected the host to override the header, got %v", cfg)
+	}
+
+	// The built-in extractors alone ignore the host
+	builtin := newTestProvider(t, &config.ProviderConfig{Configurations: p.config.Configurations})
+	if cfg, _ := builtin.matchConfiguration(builtin.ExtractMetadata(r), ""); cfg == nil || cfg.ID != "tenant=globex" {
+		t.Fatalf("expected the header to select tenant=globex, got %v", cfg)
+	}
+
+	if _, err := NewHTTPProvider(&config.ProviderConfig{MetadataExtractors: []string{"unknown"}}); err == nil {
+		t.Fatal("expected an unknown extractor to be rejected")
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	conf := &config.ProviderConfig{
+		NegativeCacheTTL: time.Minute,
+		Configurations:   []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "prod"}}},
+	}
+	p := newTestProvider(t, conf)
+	staging := map[string]string{"env": "staging"}
+
+	for range 3 {
+		if _, _, err := p.GetConfigMatching(t.Context(), staging, ""); err == nil {
+			t.Fatal("expected no configuration to match")
+		}
+	}
+	if got := p.NegativeCacheHits(); got != 2 {
+		t.Fatalf("expected 2 negative cache hits, got %d", got)
+	}
+	// The strategy is part of the key
+	if _, _, err := p.GetConfigMatching(t.Context(), staging, config.MatchStrict); err == nil {
+		t.Fatal("expected no configuration to match")
+	}
+	if got := p.NegativeCacheHits(); got != 2 {
+		t.Fatalf("expected a miss for another strategy, got %d hits", got)
+	}
+
+	// A reload may add a matching configuration
+	conf.Configurations = append(conf.Configurations, &config.Configuration{Directory: dir, Metadata: staging})
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	_, cfg, err := p.GetConfigMatching(t.Context(), staging, "")
+	if err != nil || cfg.Metadata["env"] != "staging" {
+		t.Fatalf("expected the new configuration after reload, got %+v, %v", cfg, err)
+	}
+	if got := p.NegativeCacheHits(); got != 2 {
+		t.Fatalf("expected the negative cache to be cleared on reload, got %d hits", got)
+	}
+}
+
+func TestNegativeCacheExpiry(t *testing.T) {
+	p := newTestProvider(t, &config.ProviderConfig{NegativeCacheTTL: 20 * time.Millisecond})
+	metadata := map[string]string{"env": "staging"}
+	for range 2 {
+		_, _, _ = p.GetConfigMatching(t.Context(), metadata, "")
+	}
+	time.Sleep(40 * time.Millisecond)
+	_, _, _ = p.GetConfigMatching(t.Context(), metadata, "")
+	if got := p.NegativeCacheHits(); got != 1 {
+		t.Fatalf("expected expired entries to be rematched, got %d hits", got)
+	}
+}
+
+// TestStatsConcurrent hammers GetConfigMatching, GetStats and Reload together,
+// run it with -race to check the stats counters
+func TestStatsConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", testRoutes)
+	p := newTestProvider(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "prod"}}},
+	})
+	metadata := map[string]string{"env": "prod"}
+
+	const workers, requests = 8, 200
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for range requests {
+				if _, _, err := p.GetConfigMatching(t.Context(), metadata, ""); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+		wg.Go(func() {
+			for range requests {
+				stats := p.GetStats("env=prod")
+				if stats.ConfigsLoaded != 1 {
+					t.Errorf("expected 1 config loaded, got %d", stats.ConfigsLoaded)
+					return
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 5 {
+			if err := p.Reload(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	wg.Wait()
+
+	stats := p.GetStats("env=prod")
+	if got := stats.CacheHits + stats.CacheMisses; got != workers*requests {
+		t.Errorf("expected %d cache lookups, got %d", workers*requests, got)
+	}
+	if stats.Reloads != 6 {
+		t.Errorf("expected the initial load and 5 reloads, got %d", stats.Reloads)
+	}
+	if stats.LastReload.IsZero() {
+		t.Error("expected the last reload time to be set")
+	}
+}
+
+// indexedTestProvider returns a provider matching n generated configurations,
+// indexed without loading them
+func indexedTestProvider(n int, conf *config.ProviderConfig) *HTTPProvider {
+	strategies := []string{"", config.MatchBest, config.MatchStrict, config.MatchExact}
+	configurations := make([]*config.Configuration, n)
+	for i := range configurations {
+		metadata := map[string]string{
+			"tenant_id": fmt.Sprintf("t%d", i%(n/4+1)),
+			"env":       []string{"prod", "staging", "dev"}[i%3],
+		}
+		if i%5 == 0 {
+			metadata["region"] = fmt.Sprintf("r%d", i%4)
+		}
+		if i%11 == 0 {
+			metadata["zone"] = ""
+		}
+		configurations[i] = &config.Configuration{ID: strconv.Itoa(i), Metadata: metadata, MatchStrategy: strategies[i%len(strategies)]}
+	}
+	p := &HTTPProvider{config: conf}
+	p.configurations = configurations
+	p.index = p.newMatchIndex(configurations)
+	return p
+}
+
+// naiveMatch is the reference linear scan the match index must agree with
+func naiveMatch(p *HTTPProvider, metadata map[string]string, requested string) *config.Configuration {
+	var best *config.Configuration
+	bestScore := 0
+	metadata = p.normalizeMetadata(metadata)
+	for _, cfg := range p.Configurations() {
+		cfgMetadata := p.normalizeMetadata(cfg.Metadata)
+		score := matchScore(metadata, cfgMetadata)
+		switch p.matchStrategy(cfg, requested) {
+		case config.MatchStrict:
+			if score < len(cfgMetadata) {
+				continue
+			}
+		case config.MatchExact:
+			if score < len(cfgMetadata) || len(metadata) != len(cfgMetadata) {
+				continue
+			}
+		}
+		if score > bestScore {
+			bestScore = score
+			best = cfg
+		}
+	}
+	return best
+}
+
+func TestMatchIndexAgreesWithLinearScan(t *testing.T) {
+	for _, keys := range []string{"", config.MetadataKeysKebab} {
+		p := indexedTestProvider(200, &config.ProviderConfig{MetadataKeys: keys})
+		for i := range 400 {
+			metadata := map[string]string{}
+			if i%2 == 0 {
+				metadata["tenant-id"] = fmt.Sprintf("t%d", i%60)
+			}
+			if i%3 != 0 {
+				metadata["env"] = []string{"prod", "staging", "dev", "qa"}[i%4]
+			}
+			if i%7 == 0 {
+				metadata["region"] = fmt.Sprintf("r%d", i%5)
+			}
+			if i%13 == 0 {
+				metadata["zone"] = ""
+			}
+			for _, requested := range []string{"", config.MatchBest, config.MatchStrict, config.MatchExact} {
+				want := naiveMatch(p, metadata, requested)
+				got, _ := p.matchConfiguration(metadata, requested)
+				if got != want {
+					t.Fatalf("metadataKeys=%q metadata=%v match=%q: indexed %v, linear %v", keys, metadata, requested, got, want)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkMatchConfiguration compares indexed matching with the linear scan over thousands of configurations
+func BenchmarkMatchConfiguration(b *testing.B) {
+	p := indexedTestProvider(5000, &config.ProviderConfig{})
+	metadata := map[string]string{"tenant_id": "t42", "env": "prod"}
+	b.Run("indexed", func(b *testing.B) {
+		for range b.N {
+			p.matchConfiguration(metadata, "")
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for range b.N {
+			naiveMatch(p, metadata, "")
+		}
+	})
+}
+
+func TestWatchProviderConfig(t *testing.T) {
+	prod, staging, dev := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, dir := range []string{prod, staging, dev} {
+		writeFile(t, dir, "routes.yaml", testRoutes)
+	}
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeProviderConfig := func(dirs map[string]string) {
+		var b strings.Builder
+		b.WriteString("configurations:\n")
+		for _, env := range slices.Sorted(maps.Keys(dirs)) {
+			fmt.Fprintf(&b, "  - directory: %s\n    metadata:\n      env: %s\n", dirs[env], env)
+		}
+		writeFile(t, filepath.Dir(configFile), filepath.Base(configFile), b.String())
+	}
+	writeProviderConfig(map[string]string{"prod": prod, "staging": staging})
+
+	conf, err := config.ReadProviderConfig(configFile)
+	if err != nil {
+		t.Fatalf("ReadProviderConfig: %v", err)
+	}
+	conf.ConfigFile = configFile
+	conf.ConfigWatchInterval = 10 * time.Millisecond
+	p := newTestProvider(t, conf)
+	stagingChanges, cancel := p.Subscribe("env=staging")
+	defer cancel()
+	p.WatchProviderConfig(t.Context())
+
+	// Replace staging with dev
+	writeProviderConfig(map[string]string{"prod": prod, "dev": dev})
+	deadline := time.Now().Add(2 * time.Second)
+	for p.loaded("env=staging") || !p.loaded("env=dev") {
+		if time.Now().After(deadline) {
+			t.Fatalf("configurations not reconciled, got %v", p.ConfigurationIDs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-stagingChanges:
+	default:
+		t.Fatal("expected the subscribers of the removed configuration to be notified")
+	}
+	if _, _, err := p.GetConfigMatching(t.Context(), map[string]string{"env": "dev"}, config.MatchStrict); err != nil {
+		t.Fatalf("expected the added configuration to be served: %v", err)
+	}
+	if _, _, err := p.GetConfigMatching(t.Context(), map[string]string{"env": "staging"}, config.MatchStrict); err == nil {
+		t.Fatal("expected the removed configuration not to be served")
+	}
+
+	// An invalid file keeps the running configurations
+	writeFile(t, filepath.Dir(configFile), filepath.Base(configFile), "configurations: []\n")
+	if err := p.ReloadProviderConfig(); err == nil {
+		t.Fatal("expected a config file without configurations to be rejected")
+	}
+	if !p.loaded("env=prod") || !p.loaded("env=dev") {
+		t.Fatalf("expected the running configurations to be kept, got %v", p.ConfigurationIDs())
+	}
+}
+
+func TestWatchKubernetesReconcile(t *testing.T) {
+	kube := fakeKubeAPI(t)
+	var watching atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			watching.Add(1)
+			defer watching.Add(-1)
+		}
+		kube.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	source := kubeSource(t, server.URL)
+
+	prod := t.TempDir()
+	writeFile(t, prod, "routes.yaml", testRoutes)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeProviderConfig := func(withKube bool) {
+		content := "configurations:\n  - directory: " + prod + "\n    metadata:\n      env: prod\n"
+		if withKube {
+			content += fmt.Sprintf("  - metadata:\n      env: kube\n    kubernetes:\n      namespace: %s\n      labelSelector: %s\n      apiServer: %s\n      tokenFile: %s\n",
+				source.Namespace, source.LabelSelector, source.APIServer, source.TokenFile)
+		}
+		writeFile(t, filepath.Dir(configFile), filepath.Base(configFile), content)
+	}
+	waitWatching := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for watching.Load() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d open watches, got %d", want, watching.Load())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	writeProviderConfig(false)
+	conf, err := config.ReadProviderConfig(configFile)
+	if err != nil {
+		t.Fatalf("ReadProviderConfig: %v", err)
+	}
+	conf.ConfigFile = configFile
+	p := newTestProvider(t, conf)
+	p.WatchKubernetes(t.Context())
+
+	// An added Kubernetes configuration is loaded and watched
+	writeProviderConfig(true)
+	if err := p.ReloadProviderConfig(); err != nil {
+		t.Fatalf("ReloadProviderConfig: %v", err)
+	}
+	if !p.loaded("env=kube") {
+		t.Fatalf("expected the kubernetes configuration to be loaded, got %v", p.ConfigurationIDs())
+	}
+	waitWatching(1)
+
+	// A removed one stops being watched
+	writeProviderConfig(false)
+	if err := p.ReloadProviderConfig(); err != nil {
+		t.Fatalf("ReloadProviderConfig: %v", err)
+	}
+	if p.loaded("env=kube") {
+		t.Fatal("expected the kubernetes configuration to be dropped")
+	}
+	waitWatching(0)
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path, strip, keep string
+	}{
+		{path: "/cart", strip: "/cart", keep: "/cart"},
+		{path: "/cart/", strip: "/cart", keep: "/cart/"},
+		{path: "//cart//items///", strip: "/cart/items", keep: "/cart/items/"},
+		{path: "cart/", strip: "/cart", keep: "/cart/"},
+		{path: "/", strip: "/", keep: "/"},
+		{path: "///", strip: "/", keep: "/"},
+		{path: "/api/*", strip: "/api/*", keep: "/api/*"},
+		{path: "", strip: "", keep: ""},
+	}
+	for _, tt := range tests {
+		if got := normalizePath(tt.path, config.PathNormalizationStrip); got != tt.strip {
+			t.Errorf("strip %q: expected %q, got %q", tt.path, tt.strip, got)
+		}
+		if got := normalizePath(tt.path, config.PathNormalizationKeep); got != tt.keep {
+			t.Errorf("keep %q: expected %q, got %q", tt.path, tt.keep, got)
+		}
+	}
+}
+
+func TestPathNormalization(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes.yaml", `
+routes:
+  - name: cart
+    path: /cart//items/
+    target: http://cart:8080
+  - name: root
+    path: /
+    target: http://web:8080
+`)
+	for _, tt := range []struct {
+		policy string
+		want   []string
+	}{
+		{policy: "", want: []string{"/cart//items/", "/"}},
+		{policy: config.PathNormalizationStrip, want: []string{"/cart/items", "/"}},
+		{policy: config.PathNormalizationKeep, want: []string{"/cart/items/", "/"}},
+	} {
+		p := newTestProvider(t, &config.ProviderConfig{
+			Configurations:    []*config.Configuration{{Directory: dir, Default: true}},
+			PathNormalization: tt.policy,
+		})
+		bundle, _, err := p.GetConfig(t.Context(), nil)
+		if err != nil {
+			t.Fatalf("GetConfig: %v", err)
+		}
+		var paths []string
+		for _, route := range bundle.Routes {
+			paths = append(paths, route.Path)
+		}
+		if !slices.Equal(paths, tt.want) {
+			t.Errorf("policy %q: expected paths %v, got %v", tt.policy, tt.want, paths)
+		}
+	}
+}
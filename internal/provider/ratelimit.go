@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/models"
+)
+
+// RateLimitMiddleware is the type of rate limiting middlewares
+const RateLimitMiddleware = "rateLimit"
+
+// rateLimitUnits maps the rate limit units to their length in seconds
+var rateLimitUnits = map[string]float64{"second": 1, "minute": 60, "hour": 3600}
+
+// RateLimit is the effective rate limit of a path
+type RateLimit struct {
+	Path            string `json:"path"`
+	Route           string `json:"route"`
+	Middleware      string `json:"middleware"`
+	Unit            string `json:"unit"`
+	RequestsPerUnit int    `json:"requestsPerUnit"`
+}
+
+// BundleRateLimits summarizes the rate limits of a bundle
+type BundleRateLimits struct {
+	ConfigID   string      `json:"configId"`
+	RateLimits []RateLimit `json:"rateLimits"`
+}
+
+// perSecond returns the limit in requests per second, for comparing limits of different units
+func (r RateLimit) perSecond() float64 {
+	return float64(r.RequestsPerUnit) / rateLimitUnits[r.Unit]
+}
+
+// parseRateLimitRule decodes the Rule of a rateLimit middleware
+func parseRateLimitRule(middleware models.Middleware) (models.RateLimitRule, error) {
+	var rule models.RateLimitRule
+	data, err := json.Marshal(middleware.Rule)
+	if err != nil {
+		return rule, fmt.Errorf("middleware %s: invalid rule: %w", middleware.Name, err)
+	}
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return rule, fmt.Errorf("middleware %s: invalid rule: %w", middleware.Name, err)
+	}
+	if rule.Unit == "" {
+		rule.Unit = "second"
+	}
+	if _, ok := rateLimitUnits[rule.Unit]; !ok {
+		return rule, fmt.Errorf("middleware %s: unknown rate limit unit %q", middleware.Name, rule.Unit)
+	}
+	if rule.RequestsPerUnit <= 0 {
+		return rule, fmt.Errorf("middleware %s: requestsPerUnit must be positive", middleware.Name)
+	}
+	return rule, nil
+}
+
+// RateLimits returns the effective rate limit of each path protected by a rateLimit
+// middleware. A path protected by several of them gets the most restrictive one.
+func RateLimits(bundle *config.ConfigBundle) ([]RateLimit, error) {
+	rules := map[string]models.RateLimitRule{}
+	paths := map[string][]string{}
+	for _, middleware := range bundle.Middlewares {
+		if !strings.EqualFold(middleware.Type, RateLimitMiddleware) {
+			continue
+		}
+		rule, err := parseRateLimitRule(middleware)
+		if err != nil {
+			return nil, err
+		}
+		rules[middleware.Name] = rule
+		paths[middleware.Name] = middleware.Paths
+	}
+
+	effective := map[string]RateLimit{}
+	for _, route := range bundle.Routes {
+		for _, name := range route.Middlewares {
+			rule, ok := rules[name]
+			if !ok {
+				continue
+			}
+			limit := RateLimit{Route: route.Name, Middleware: name, Unit: rule.Unit, RequestsPerUnit: rule.RequestsPerUnit}
+			for _, path := range routePaths(route.Path, paths[name]) {
+				limit.Path = path
+				if current, ok := effective[path]; !ok || limit.perSecond() < current.perSecond() {
+					effective[path] = limit
+				}
+			}
+		}
+	}
+
+	limits := make([]RateLimit, 0, len(effective))
+	for _, limit := range effective {
+		limits = append(limits, limit)
+	}
+	sort.Slice(limits, func(i, j int) bool { return limits[i].Path < limits[j].Path })
+	return limits, nil
+}
+
+// routePaths returns the middleware paths under the route path, or the route path itself
+func routePaths(routePath string, middlewarePaths []string) []string {
+	if len(middlewarePaths) == 0 {
+		return []string{routePath}
+	}
+	paths := make([]string, 0, len(middlewarePaths))
+	for _, path := range middlewarePaths {
+		paths = append(paths, strings.TrimSuffix(routePath, "/")+"/"+strings.TrimPrefix(path, "/"))
+	}
+	return paths
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/metrics"
+	"github.com/jkaninda/logger"
+)
+
+// flightGroup coalesces concurrent calls sharing a key into a single execution
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val *CachedConfig
+}
+
+// do runs fn once for concurrent callers of the same key, which all get its result
+func (g *flightGroup) do(key string, fn func() *CachedConfig) *CachedConfig {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.val
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.val = fn()
+	return call.val
+}
+
+// expired reports whether the cache entry outlived its TTL
+func (c *CachedConfig) expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt)
+}
+
+// refresh lazily reloads an expired configuration. Concurrent refreshes of the same
+// configuration share a single load. A failed load keeps the expired entry as stale
+// until the next TTL, so a failing source is not retried on every request.
+func (p *HTTPProvider) refresh(cfg *config.Configuration, expired *CachedConfig) *CachedConfig {
+	cached, _ := p.reloadConfiguration(cfg, expired)
+	return cached
+}
+
+// reloadConfiguration reloads a single configuration over its current entry, leaving the
+// others untouched, and returns the entry now cached along with the load error.
+// A caller joining a load already in flight gets its entry without the error.
+func (p *HTTPProvider) reloadConfiguration(cfg *config.Configuration, expired *CachedConfig) (*CachedConfig, error) {
+	var loadErr error
+	cached := p.refreshes.do(cfg.ID, func() *CachedConfig {
+		p.cacheMu.RLock()
+		current := p.cache[cfg.ID]
+		p.cacheMu.RUnlock()
+		if current != expired {
+			// Refreshed or reloaded while waiting
+			return current
+		}
+
+		loadStart := time.Now()
+		bundle, version, err := p.loadConfiguration(context.Background(), cfg)
+		metrics.LoadDuration.ObserveSince(loadStart, cfg.ID, outcome(err))
+		var cached *CachedConfig
+		if err == nil {
+			var canaryErr error
+			cached, canaryErr, err = p.cacheBundle(cfg, bundle, version, expired)
+			if canaryErr != nil {
+				logger.Error("Failed to refresh canary config", "id", cfg.ID, "error", canaryErr)
+			}
+			if err == nil {
+				observeChecksumChange(cfg.ID, expired, cached)
+			}
+		}
+		if err != nil {
+			loadErr = err
+			logger.Error("Failed to refresh expired config, keeping last good", "id", cfg.ID, "error", err)
+			stale := *expired
+			if stale.StaleSince.IsZero() {
+				stale.StaleSince = time.Now()
+			}
+			stale.ExpiresAt = p.expiresAt(time.Now())
+			cached = &stale
+		}
+
+		// Copy on write, readers may hold the current map without the lock
+		p.cacheMu.Lock()
+		defer p.cacheMu.Unlock()
+		if p.cache[cfg.ID] != expired {
+			return p.cache[cfg.ID]
+		}
+		cache := maps.Clone(p.cache)
+		cache[cfg.ID] = cached
+		p.cache = cache
+		if bundleChanged(expired, cached) {
+			p.changes.notify(cfg.ID)
+		}
+		return cached
+	})
+	return cached, loadErr
+}
@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/logger"
+)
+
+// Reload job states
+const (
+	ReloadRunning   = "running"
+	ReloadSucceeded = "succeeded"
+	ReloadFailed    = "failed"
+)
+
+// maxReloadJobs is the number of reload jobs kept for status polling
+const maxReloadJobs = 16
+
+// ReloadJob is a reload running in the background
+type ReloadJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// reloadJobs tracks the running reload job and the most recent ones
+type reloadJobs struct {
+	mu      sync.Mutex
+	current *ReloadJob
+	jobs    map[string]*ReloadJob
+	order   []string
+}
+
+// ReloadAsync starts a reload in the background and returns its job.
+// While a reload job is running, the running job is returned instead and started is false.
+func (p *HTTPProvider) ReloadAsync() (job ReloadJob, started bool) {
+	r := &p.reloadJobs
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		return *r.current, false
+	}
+
+	current := &ReloadJob{ID: newJobID(), Status: ReloadRunning, StartedAt: time.Now()}
+	if r.jobs == nil {
+		r.jobs = map[string]*ReloadJob{}
+	}
+	r.jobs[current.ID] = current
+	r.order = append(r.order, current.ID)
+	if len(r.order) > maxReloadJobs {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.current = current
+
+	go func() {
+		err := p.Reload()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		finishedAt := time.Now()
+		current.FinishedAt = &finishedAt
+		current.Status = ReloadSucceeded
+		if err != nil {
+			logger.Error("Background reload failed", "job", current.ID, "error", err)
+			current.Status = ReloadFailed
+			current.Error = err.Error()
+		}
+		r.current = nil
+	}()
+	return *current, true
+}
+
+// ReloadJobStatus returns a recent reload job by ID
+func (p *HTTPProvider) ReloadJobStatus(id string) (ReloadJob, bool) {
+	r := &p.reloadJobs
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return ReloadJob{}, false
+	}
+	return *job, true
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
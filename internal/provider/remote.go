@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// maxRemoteBundleBytes limits the size of a fetched remote bundle
+const maxRemoteBundleBytes = 10 << 20
+
+// RemoteValidation is the result of validating a bundle hosted at a URL
+type RemoteValidation struct {
+	URL         string   `json:"url"`
+	Valid       bool     `json:"valid"`
+	Errors      []string `json:"errors,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+	Checksum    string   `json:"checksum,omitempty"`
+	Routes      int      `json:"routes"`
+	Middlewares int      `json:"middlewares"`
+}
+
+// fetchRemote fetches a config file from a URL with the given auth.
+// It returns the file name used to select the decoder, from the URL path
+// extension or the response content type.
+func (p *HTTPProvider) fetchRemote(ctx context.Context, rawURL string, auth *config.HTTPAuth) (string, []byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", nil, fmt.Errorf("invalid url %q, an absolute http or https url is required", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if auth != nil {
+		if auth.APIKey != "" {
+			req.Header.Set("X-API-Key", auth.APIKey)
+		} else if auth.BasicAuth != nil {
+			req.SetBasicAuth(auth.BasicAuth.Username, auth.BasicAuth.Password)
+		}
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch %s: %w", u.Redacted(), err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to fetch %s: %s", u.Redacted(), resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteBundleBytes+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", u.Redacted(), err)
+	}
+	if len(data) > maxRemoteBundleBytes {
+		return "", nil, fmt.Errorf("%s exceeds %d bytes", u.Redacted(), maxRemoteBundleBytes)
+	}
+
+	name := path.Base(u.Path)
+	if !isConfigFile(name) {
+		name = "remote.yaml"
+		if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+			name = "remote.json"
+		}
+	}
+	return name, data, nil
+}
+
+// ValidateRemote fetches a candidate bundle and runs it through the load pipeline of the
+// configuration, or of a configuration without settings when id is empty, returning validation
+// results and the checksum it would be served with, without registering it
+func (p *HTTPProvider) ValidateRemote(ctx context.Context, rawURL string, auth *config.HTTPAuth, id string) (*RemoteValidation, error) {
+	cfg := &config.Configuration{}
+	if id != "" {
+		if cfg = p.configurationByID(id); cfg == nil {
+			return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, id)
+		}
+	}
+	result := &RemoteValidation{URL: rawURL}
+	name, data, err := p.fetchRemote(ctx, rawURL, auth)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, nil
+	}
+
+	bundle := newBundle()
+	if err := p.mergeConfigFile(bundle, name, data); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, nil
+	}
+	result.Errors = validateBundle(bundle)
+	if err := p.prepareBundle(ctx, cfg, bundle); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	result.Warnings = bundle.Warnings
+	result.Routes = len(bundle.Routes)
+	result.Middlewares = len(bundle.Middlewares)
+	result.Valid = len(result.Errors) == 0
+	if result.Valid {
+		result.Checksum = bundle.Checksum
+	}
+	return result, nil
+}
+
+// validateBundle returns the problems found in a decoded bundle
+func validateBundle(bundle *config.ConfigBundle) []string {
+	var problems []string
+	seen := map[string]struct{}{}
+	for i, route := range bundle.Routes {
+		if route.Name == "" {
+			problems = append(problems, fmt.Sprintf("routes[%d]: name is required", i))
+		} else if _, ok := seen[route.Name]; ok {
+			problems = append(problems, fmt.Sprintf("routes[%d]: duplicate route name %q", i, route.Name))
+		}
+		seen[route.Name] = struct{}{}
+		if err := checkPathPattern(route.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("routes[%d]: %v", i, err))
+		}
+	}
+	for i, middleware := range bundle.Middlewares {
+		if middleware.Name == "" {
+			problems = append(problems, fmt.Sprintf("middlewares[%d]: name is required", i))
+		}
+		for _, path := range middleware.Paths {
+			if err := checkPathPattern(path); err != nil {
+				problems = append(problems, fmt.Sprintf("middlewares[%d]: %v", i, err))
+			}
+		}
+	}
+	return problems
+}
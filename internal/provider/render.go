@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"slices"
+	"time"
+)
+
+// Timestamp renderings of served bundles
+const (
+	// TimeFormatRFC3339 renders timestamps as RFC 3339 strings, the default
+	TimeFormatRFC3339 = "rfc3339"
+	// TimeFormatUnix renders timestamps as seconds since the unix epoch
+	TimeFormatUnix = "unix"
+)
+
+// Duration renderings of served bundles
+const (
+	// DurationFormatString renders durations as Go duration strings such as "10s", the default
+	DurationFormatString = "string"
+	// DurationFormatMillis renders durations as a number of milliseconds
+	DurationFormatMillis = "ms"
+)
+
+// timeFields and durationFields are the JSON fields holding timestamps and durations
+var (
+	timeFields     = []string{"timestamp", "staleSince", "serverTime"}
+	durationFields = []string{"interval", "timeout"}
+)
+
+// RenderOptions selects how timestamps and durations are rendered, for gateways
+// that don't parse the Go renderings. The zero value keeps the default renderings.
+type RenderOptions struct {
+	TimeFormat     string
+	DurationFormat string
+}
+
+// IsDefault reports whether the options keep the default renderings
+func (o RenderOptions) IsDefault() bool {
+	return (o.TimeFormat == "" || o.TimeFormat == TimeFormatRFC3339) &&
+		(o.DurationFormat == "" || o.DurationFormat == DurationFormatString)
+}
+
+// Render returns the JSON encoding of v with its timestamps and durations in the requested renderings.
+// The checksum is computed on the bundle itself, so it doesn't depend on the rendering.
+func Render(v any, opts RenderOptions) (any, error) {
+	if opts.IsDefault() {
+		return v, nil
+	}
+	value, err := toJSONValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return opts.render(value), nil
+}
+
+func (o RenderOptions) render(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			s, isString := child.(string)
+			switch {
+			case isString && o.TimeFormat == TimeFormatUnix && slices.Contains(timeFields, key):
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					v[key] = t.Unix()
+				}
+			case isString && o.DurationFormat == DurationFormatMillis && slices.Contains(durationFields, key):
+				if d, err := time.ParseDuration(s); err == nil {
+					v[key] = d.Milliseconds()
+				}
+			default:
+				v[key] = o.render(child)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = o.render(v[i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+const (
+	// defaultLoadRetryBackoff is the wait before the first load retry when no backoff is configured
+	defaultLoadRetryBackoff = 100 * time.Millisecond
+	// defaultLoadRetryMaxBackoff caps the wait between load retries when no max backoff is configured
+	defaultLoadRetryMaxBackoff = 5 * time.Second
+)
+
+// retryLoad runs the load, retrying it with exponential backoff on filesystem errors
+// per the load retry policy of the configuration. Errors in the content of the files are not retried.
+func (p *HTTPProvider) retryLoad(ctx context.Context, cfg *config.Configuration, load func() error) error {
+	err := load()
+	policy := cfg.LoadRetry
+	if err == nil || policy == nil {
+		return err
+	}
+	maxBackoff := cmp.Or(policy.MaxBackoff, defaultLoadRetryMaxBackoff)
+	backoff := min(cmp.Or(policy.Backoff, defaultLoadRetryBackoff), maxBackoff)
+	for attempt := 1; err != nil && attempt <= policy.Attempts && transientLoadError(err); attempt++ {
+		logger.Warn("Failed to load configuration, retrying", "id", cfg.ID, "attempt", attempt, "backoff", backoff.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		err = load()
+		backoff = min(backoff*2, maxBackoff)
+	}
+	return err
+}
+
+// transientLoadError reports whether a load failed on the filesystem, rather than on the content of the files
+func transientLoadError(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr)
+}
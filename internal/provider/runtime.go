@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"os"
+	"runtime"
+)
+
+// RuntimeStats holds Go runtime and process resource statistics
+type RuntimeStats struct {
+	Goroutines     int    `json:"goroutines"`
+	HeapAllocBytes uint64 `json:"heapAllocBytes"`
+	NumGC          uint32 `json:"numGC"`
+	LastGCPauseNs  uint64 `json:"lastGCPauseNs"`
+	TotalGCPauseNs uint64 `json:"totalGCPauseNs"`
+	// OpenFDs is -1 when the platform does not expose open file descriptors
+	OpenFDs int `json:"openFDs"`
+}
+
+// collectRuntimeStats reads the current runtime and process statistics
+func collectRuntimeStats() *RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := &RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		NumGC:          mem.NumGC,
+		TotalGCPauseNs: mem.PauseTotalNs,
+		OpenFDs:        openFDs(),
+	}
+	if mem.NumGC > 0 {
+		stats.LastGCPauseNs = mem.PauseNs[(mem.NumGC+255)%256]
+	}
+	return stats
+}
+
+// openFDs returns the number of open file descriptors of the process, or -1 if unknown
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
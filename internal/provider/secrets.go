@@ -0,0 +1,204 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// SecretResolver resolves the secret references of a scheme, such as ${aws:prod/acme#apiKey}
+type SecretResolver interface {
+	// Resolve returns the secret value of the reference, without the scheme
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver
+type SecretResolverFunc func(ctx context.Context, ref string) (string, error)
+
+// Resolve calls f(ctx, ref)
+func (f SecretResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// ErrSecretUnresolved is returned when a secret reference can't be resolved
+var ErrSecretUnresolved = errors.New("secret reference not resolved")
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]SecretResolver{
+		"env":  SecretResolverFunc(envSecret),
+		"file": SecretResolverFunc(fileSecret),
+	}
+)
+
+// RegisterSecretResolver makes a secret source, such as a cloud secret manager, available to secret
+// references, replacing any resolver registered with the same scheme
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	secretResolvers[scheme] = resolver
+}
+
+// envSecret resolves ${env:NAME} to the value of the environment variable
+func envSecret(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// fileSecret resolves ${file:/path} to the content of the file, without trailing newlines
+func fileSecret(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// secretReference splits a ${scheme:ref} or vault://path#key value into its scheme and reference
+func secretReference(value string) (scheme, ref string, ok bool) {
+	if ref, found := strings.CutPrefix(value, vaultURLPrefix); found && ref != "" {
+		return vaultScheme, ref, true
+	}
+	inner, found := strings.CutPrefix(value, "${")
+	if !found {
+		return "", "", false
+	}
+	inner, found = strings.CutSuffix(inner, "}")
+	if !found {
+		return "", "", false
+	}
+	scheme, ref, found = strings.Cut(inner, ":")
+	if !found || scheme == "" || ref == "" {
+		return "", "", false
+	}
+	return scheme, ref, true
+}
+
+// isSecretReference reports whether the value is a secret reference
+func isSecretReference(value string) bool {
+	_, _, ok := secretReference(value)
+	return ok
+}
+
+// resolveSecret returns the secret a value references, or the value itself when it is not a reference.
+// Vault references are read from the configured Vault, when set.
+func (p *HTTPProvider) resolveSecret(ctx context.Context, value string) (string, error) {
+	scheme, ref, ok := secretReference(value)
+	if !ok {
+		return value, nil
+	}
+	var resolver SecretResolver
+	if scheme == vaultScheme && p.vault != nil {
+		resolver, ok = p.vault, true
+	} else {
+		secretResolversMu.RLock()
+		resolver, ok = secretResolvers[scheme]
+		secretResolversMu.RUnlock()
+	}
+	if !ok {
+		return "", fmt.Errorf("%w: unknown secret source %q", ErrSecretUnresolved, scheme)
+	}
+	secret, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		// The error names the reference, never the secret
+		return "", fmt.Errorf("%w: %s: %v", ErrSecretUnresolved, value, err)
+	}
+	return secret, nil
+}
+
+// resolveAuth returns a copy of the auth with its secret references resolved
+func (p *HTTPProvider) resolveAuth(ctx context.Context, auth *config.HTTPAuth) (*config.HTTPAuth, error) {
+	if auth == nil {
+		return nil, nil
+	}
+	resolved := *auth
+	var err error
+	if resolved.APIKey, err = p.resolveSecret(ctx, auth.APIKey); err != nil {
+		return nil, err
+	}
+	if resolved.BasicAuth, err = p.resolveBasicAuth(ctx, auth.BasicAuth); err != nil {
+		return nil, err
+	}
+	if len(auth.Operations) > 0 {
+		resolved.Operations = make(map[string]*config.OperationAuth, len(auth.Operations))
+		for operation, override := range auth.Operations {
+			if override == nil {
+				resolved.Operations[operation] = nil
+				continue
+			}
+			op := *override
+			if op.APIKey, err = p.resolveSecret(ctx, override.APIKey); err != nil {
+				return nil, fmt.Errorf("operation %s: %w", operation, err)
+			}
+			if op.BasicAuth, err = p.resolveBasicAuth(ctx, override.BasicAuth); err != nil {
+				return nil, fmt.Errorf("operation %s: %w", operation, err)
+			}
+			resolved.Operations[operation] = &op
+		}
+	}
+	return &resolved, nil
+}
+
+func (p *HTTPProvider) resolveBasicAuth(ctx context.Context, basicAuth *config.BasicAuth) (*config.BasicAuth, error) {
+	if basicAuth == nil {
+		return nil, nil
+	}
+	resolved := *basicAuth
+	var err error
+	if resolved.Username, err = p.resolveSecret(ctx, basicAuth.Username); err != nil {
+		return nil, err
+	}
+	if resolved.Password, err = p.resolveSecret(ctx, basicAuth.Password); err != nil {
+		return nil, err
+	}
+	return &resolved, nil
+}
+
+// resolveRouteSecrets resolves the secret references of the route TLS certificates and keys,
+// when enabled. Config files are then trusted to read the provider environment, and the files
+// of the configuration directory unless path escapes are allowed.
+func (p *HTTPProvider) resolveRouteSecrets(ctx context.Context, cfg *config.Configuration, bundle *config.ConfigBundle) error {
+	if !p.config.RouteSecrets {
+		return nil
+	}
+	resolve := func(value string) (string, error) {
+		value, err := p.confineFileSecret(cfg, value)
+		if err != nil {
+			return "", err
+		}
+		return p.resolveSecret(ctx, value)
+	}
+	for i := range bundle.Routes {
+		route := &bundle.Routes[i]
+		for j := range route.TLS.Certificates {
+			tls := &route.TLS.Certificates[j]
+			var err error
+			if tls.Cert, err = resolve(tls.Cert); err != nil {
+				return fmt.Errorf("route %q: tls.certificates[%d].cert: %w", route.Name, j, err)
+			}
+			if tls.Key, err = resolve(tls.Key); err != nil {
+				return fmt.Errorf("route %q: tls.certificates[%d].key: %w", route.Name, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configAuth returns the auth of a configuration with its secrets resolved at the last reload
+func (p *HTTPProvider) configAuth(cfg *config.Configuration) *config.HTTPAuth {
+	p.cacheMu.RLock()
+	auth, ok := p.auths[cfg.ID]
+	p.cacheMu.RUnlock()
+	if ok {
+		return auth
+	}
+	return cfg.Auth
+}
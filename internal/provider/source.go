@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// Source loads the bundle of a configuration from a storage backend
+type Source interface {
+	// Load returns the bundle along with a version token identifying its content
+	Load(ctx context.Context) (*config.ConfigBundle, string, error)
+	// Changed reports whether the content moved since the version returned by Load
+	Changed(ctx context.Context, version string) (bool, error)
+}
+
+// SourceFactory builds the source of a configuration
+type SourceFactory func(p *HTTPProvider, cfg *config.Configuration) (Source, error)
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = map[string]SourceFactory{
+		config.SourceDirectory:  newDirectorySource,
+		config.SourceKubernetes: newKubernetesSource,
+	}
+)
+
+// RegisterSource makes a source type available to configurations,
+// replacing any source registered with the same type
+func RegisterSource(sourceType string, factory SourceFactory) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources[sourceType] = factory
+}
+
+// source returns the source of a configuration
+func (p *HTTPProvider) source(cfg *config.Configuration) (Source, error) {
+	sourcesMu.RLock()
+	factory, ok := sources[cfg.SourceType()]
+	sourcesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown source type %q", cfg.SourceType())
+	}
+	return factory(p, cfg)
+}
+
+// directorySource loads a configuration from the config files of a directory.
+// Its version is a fingerprint of the files of the directory.
+type directorySource struct {
+	p   *HTTPProvider
+	cfg *config.Configuration
+}
+
+func newDirectorySource(p *HTTPProvider, cfg *config.Configuration) (Source, error) {
+	return &directorySource{p: p, cfg: cfg}, nil
+}
+
+// Load loads the directory, fingerprinted first so a change made while
+// loading is detected by the next Changed. Filesystem errors are retried
+// per the load retry policy of the configuration.
+func (s *directorySource) Load(ctx context.Context) (*config.ConfigBundle, string, error) {
+	var bundle *config.ConfigBundle
+	var version string
+	err := s.p.retryLoad(ctx, s.cfg, func() error {
+		var err error
+		if version, err = fingerprint(s.cfg.Directory); err != nil {
+			return err
+		}
+		bundle, err = s.p.loadConfigFromDirectory(s.cfg)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return bundle, version, nil
+}
+
+// Changed reports whether a file of the directory was added, modified or removed since version
+func (s *directorySource) Changed(_ context.Context, version string) (bool, error) {
+	current, err := fingerprint(s.cfg.Directory)
+	if err != nil {
+		return false, err
+	}
+	return current != version, nil
+}
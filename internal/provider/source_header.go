@@ -0,0 +1,40 @@
+package provider
+
+import "context"
+
+// Config sources, telling how a served bundle was produced
+const (
+	// SourceCache is a bundle served from the cache
+	SourceCache = "cache"
+	// SourceLazyReload is a bundle reloaded on request because its cache entry expired
+	SourceLazyReload = "lazy-reload"
+	// SourceDefaultFallback is a bundle of the fallback chain, served when no configuration matched
+	SourceDefaultFallback = "default-fallback"
+)
+
+// ConfigSourceHeader is the response header exposing the config source
+const ConfigSourceHeader = "X-Goma-Config-Source"
+
+type configSourceKey struct{}
+
+// WithConfigSource returns a context recording the source of the bundle resolved with it,
+// read back with ConfigSource
+func WithConfigSource(ctx context.Context) context.Context {
+	return context.WithValue(ctx, configSourceKey{}, new(string))
+}
+
+// ConfigSource returns the source recorded in a context returned by WithConfigSource,
+// or an empty string when no bundle was resolved with it
+func ConfigSource(ctx context.Context) string {
+	if source, ok := ctx.Value(configSourceKey{}).(*string); ok {
+		return *source
+	}
+	return ""
+}
+
+// recordConfigSource records the source in a context returned by WithConfigSource
+func recordConfigSource(ctx context.Context, source string) {
+	if recorded, ok := ctx.Value(configSourceKey{}).(*string); ok {
+		*recorded = source
+	}
+}
@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// providerStats holds the counters reported by GetStats. Each one is updated
+// atomically, so requests and stats reads don't take the cache lock to report them.
+type providerStats struct {
+	configsLoaded atomic.Int64
+	cacheHits     atomic.Int64
+	cacheMisses   atomic.Int64
+	reloads       atomic.Int64
+	negativeHits  atomic.Int64
+	// lastReload is the unix nano time of the last reload, 0 before the first one
+	lastReload atomic.Int64
+	// slowLoads maps config IDs to their *atomic.Int64 count of slow loads
+	slowLoads sync.Map
+	// loads maps config IDs to their *atomic.Int64 count of successful loads
+	loads sync.Map
+	// history holds the most recent reloads, oldest first
+	historyMu sync.Mutex
+	history   []ReloadRecord
+}
+
+// maxReloadRecords bounds the reload history kept for state dumps
+const maxReloadRecords = 20
+
+// ReloadRecord is a completed reload of the reload history
+type ReloadRecord struct {
+	// Scope is startup, full, or tag:<tag> for the reload of the configurations carrying a tag
+	Scope    string    `json:"scope"`
+	At       time.Time `json:"at"`
+	Duration string    `json:"duration"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// recordReload adds a reload started at start to the reload history
+func (s *providerStats) recordReload(scope string, start time.Time, err error) {
+	record := ReloadRecord{Scope: scope, At: start, Duration: time.Since(start).String(), Outcome: outcome(err)}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	if len(s.history) == maxReloadRecords {
+		s.history = append(s.history[:0], s.history[1:]...)
+	}
+	s.history = append(s.history, record)
+}
+
+// reloadHistory returns the most recent reloads, newest first
+func (s *providerStats) reloadHistory() []ReloadRecord {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	history := make([]ReloadRecord, len(s.history))
+	for i, record := range s.history {
+		history[len(s.history)-1-i] = record
+	}
+	return history
+}
+
+// reloaded records a reload completed at the given time with the number of loaded configs
+func (s *providerStats) reloaded(at time.Time, configs int) {
+	s.configsLoaded.Store(int64(configs))
+	s.reloads.Add(1)
+	s.lastReload.Store(at.UnixNano())
+}
+
+// lastReloadTime returns the time of the last reload, zero before the first one
+func (s *providerStats) lastReloadTime() time.Time {
+	nanos := s.lastReload.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// addSlowLoad counts a slow load of the config
+func (s *providerStats) addSlowLoad(id string) {
+	count, _ := s.slowLoads.LoadOrStore(id, new(atomic.Int64))
+	count.(*atomic.Int64).Add(1)
+}
+
+// slowLoadCount returns the number of slow loads of the config
+func (s *providerStats) slowLoadCount(id string) int64 {
+	if count, ok := s.slowLoads.Load(id); ok {
+		return count.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+// addLoad counts a successful load of the config
+func (s *providerStats) addLoad(id string) {
+	count, _ := s.loads.LoadOrStore(id, new(atomic.Int64))
+	count.(*atomic.Int64).Add(1)
+}
+
+// loadCount returns the number of successful loads of the config
+func (s *providerStats) loadCount(id string) int64 {
+	if count, ok := s.loads.Load(id); ok {
+		return count.(*atomic.Int64).Load()
+	}
+	return 0
+}
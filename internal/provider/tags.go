@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+// ErrTagNotFound is returned when no configuration carries the requested tag
+var ErrTagNotFound = errors.New("no configuration with tag")
+
+// ConfigurationsByTag returns the configurations carrying the tag, in configuration order
+func (p *HTTPProvider) ConfigurationsByTag(tag string) []*config.Configuration {
+	var tagged []*config.Configuration
+	for _, cfg := range p.Configurations() {
+		if slices.Contains(cfg.Tags, tag) {
+			tagged = append(tagged, cfg)
+		}
+	}
+	return tagged
+}
+
+// ReloadTagged reloads the configurations carrying the tag, leaving the others untouched,
+// and returns their IDs. A configuration failing to load keeps its last good bundle.
+func (p *HTTPProvider) ReloadTagged(tag string) ([]string, error) {
+	tagged := p.ConfigurationsByTag(tag)
+	if len(tagged) == 0 {
+		return nil, fmt.Errorf("%w %s", ErrTagNotFound, tag)
+	}
+	start := time.Now()
+	ids := make([]string, 0, len(tagged))
+	var errs []error
+	for _, cfg := range tagged {
+		ids = append(ids, cfg.ID)
+		p.cacheMu.RLock()
+		current := p.cache[cfg.ID]
+		p.cacheMu.RUnlock()
+		if current == nil {
+			// The full reload also discovers and indexes configurations that never loaded
+			errs = append(errs, fmt.Errorf("config %s is not loaded, a full reload is required", cfg.ID))
+			continue
+		}
+		if _, err := p.reloadConfiguration(cfg, current); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load config %s: %w", cfg.ID, err))
+		}
+	}
+	logger.Info("Reloaded tagged configurations", "tag", tag, "ids", ids)
+	err := errors.Join(errs...)
+	p.stats.recordReload("tag:"+tag, start, err)
+	return ids, err
+}
@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+// parseCertificate parses the leaf certificate of a route TLS cert, given as raw
+// or base64 encoded PEM. It returns nil without error for other values, such as a
+// file path resolved by the gateway.
+func parseCertificate(value string) (*x509.Certificate, error) {
+	data := []byte(strings.TrimSpace(value))
+	if !strings.HasPrefix(string(data), "-----BEGIN") {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(decoded)), "-----BEGIN") {
+			return nil, nil
+		}
+		data = decoded
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found in PEM")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// certificateCovers reports whether the certificate is valid for the host, by its
+// SANs or, for certificates without DNS SANs, by its common name
+func certificateCovers(cert *x509.Certificate, host string) bool {
+	if cert.VerifyHostname(host) == nil {
+		return true
+	}
+	return len(cert.DNSNames) == 0 && strings.EqualFold(cert.Subject.CommonName, host)
+}
+
+// checkRouteTLS returns the route hosts not covered by any certificate of their route
+func checkRouteTLS(bundle *config.ConfigBundle) []string {
+	var problems []string
+	for _, route := range bundle.Routes {
+		if len(route.TLS.Certificates) == 0 || len(route.Hosts) == 0 {
+			continue
+		}
+		var certs []*x509.Certificate
+		for i, tls := range route.TLS.Certificates {
+			cert, err := parseCertificate(tls.Cert)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("route %q: tls.certificates[%d]: invalid certificate: %v", route.Name, i, err))
+				continue
+			}
+			if cert != nil {
+				certs = append(certs, cert)
+			}
+		}
+		if len(certs) == 0 {
+			continue
+		}
+		for _, host := range route.Hosts {
+			covered := false
+			for _, cert := range certs {
+				if certificateCovers(cert, host) {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				problems = append(problems, fmt.Sprintf("route %q: no TLS certificate covers host %q", route.Name, host))
+			}
+		}
+	}
+	return problems
+}
+
+// validateRouteTLS checks the route certificates of a bundle against their hosts.
+// Problems are bundle warnings, or an error in strict TLS mode.
+func (p *HTTPProvider) validateRouteTLS(bundle *config.ConfigBundle) error {
+	problems := checkRouteTLS(bundle)
+	if len(problems) == 0 {
+		return nil
+	}
+	if p.config.StrictTLS {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		logger.Warn("Route TLS mismatch", "problem", problem)
+	}
+	bundle.Warnings = appendUnique(bundle.Warnings, problems...)
+	return nil
+}
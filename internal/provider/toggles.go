@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/jkaninda/goma-http-provider/internal/models"
+	"github.com/jkaninda/logger"
+)
+
+// ErrRouteNotFound is returned when no configuration has a route with the requested name
+var ErrRouteNotFound = errors.New("route not found")
+
+// routeToggles holds the routes disabled at runtime, per configuration ID.
+// They are removed from the served bundles until the next reload.
+type routeToggles struct {
+	mu       sync.RWMutex
+	disabled map[string][]string
+}
+
+// set disables or re-enables a route of a configuration
+func (t *routeToggles) set(id, route string, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	routes := slices.DeleteFunc(slices.Clone(t.disabled[id]), func(name string) bool { return name == route })
+	if !enabled {
+		routes = append(routes, route)
+		slices.Sort(routes)
+	}
+	if t.disabled == nil {
+		t.disabled = map[string][]string{}
+	}
+	if len(routes) == 0 {
+		delete(t.disabled, id)
+		return
+	}
+	t.disabled[id] = routes
+}
+
+// routes returns the sorted names of the disabled routes of a configuration
+func (t *routeToggles) routes(id string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.disabled[id]
+}
+
+func (t *routeToggles) clear() {
+	t.mu.Lock()
+	t.disabled = nil
+	t.mu.Unlock()
+}
+
+// RouteToggle is the outcome of enabling or disabling a route in one configuration
+type RouteToggle struct {
+	ConfigID string `json:"configId"`
+	// Checksum is the checksum of the bundle now served
+	Checksum string `json:"checksum"`
+	// Routes are the routes now served
+	Routes []models.Route `json:"routes"`
+}
+
+// SetRouteEnabled disables or re-enables the named route until the next reload, in the
+// configuration with the given ID or, when id is empty, in every configuration having it.
+// A disabled route is removed from the served bundle, whose checksum is recomputed.
+func (p *HTTPProvider) SetRouteEnabled(id, route string, enabled bool) ([]RouteToggle, error) {
+	p.cacheMu.RLock()
+	configurations := p.configurations
+	cache := p.cache
+	p.cacheMu.RUnlock()
+
+	var affected []string
+	for _, cfg := range configurations {
+		cached := cache[cfg.ID]
+		if cached == nil || (id != "" && cfg.ID != id) {
+			continue
+		}
+		bundle, err := cached.bundle()
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(bundle.Routes, func(r models.Route) bool { return r.Name == route }) {
+			affected = append(affected, cfg.ID)
+		}
+	}
+	if len(affected) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrRouteNotFound, route)
+	}
+
+	toggles := make([]RouteToggle, 0, len(affected))
+	for _, configID := range affected {
+		p.routeToggles.set(configID, route, enabled)
+		served, err := p.applyFlags(configID, cache[configID])
+		if err != nil {
+			return nil, err
+		}
+		toggles = append(toggles, RouteToggle{ConfigID: configID, Checksum: served.Checksum, Routes: served.Routes})
+		p.changes.notify(configID)
+	}
+	logger.Info("Route toggled", "route", route, "enabled", enabled, "ids", affected)
+	return toggles, nil
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+// defaultValidatorTimeout bounds a validator run when no timeout is configured
+const defaultValidatorTimeout = 10 * time.Second
+
+// maxValidatorOutput bounds the validator stderr kept in the load error
+const maxValidatorOutput = 4 << 10
+
+// ErrValidatorRejected is returned when the external validator exits non-zero
+var ErrValidatorRejected = errors.New("bundle rejected by validator")
+
+// runValidator pipes the bundle JSON to the external validator command, when configured.
+// The bundle is rejected when the command exits non-zero or doesn't complete in time.
+func (p *HTTPProvider) runValidator(ctx context.Context, bundle *config.ConfigBundle) error {
+	validator := p.config.Validator
+	if validator == nil || len(validator.Command) == 0 {
+		return nil
+	}
+	timeout := validator.Timeout
+	if timeout <= 0 {
+		timeout = defaultValidatorTimeout
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle for validator: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, validator.Command[0], validator.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %s timed out after %s", ErrValidatorRejected, validator.Command[0], timeout)
+		}
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > maxValidatorOutput {
+			output = output[:maxValidatorOutput] + "..."
+		}
+		if output == "" {
+			return fmt.Errorf("%w: %s: %v", ErrValidatorRejected, validator.Command[0], err)
+		}
+		return fmt.Errorf("%w: %s: %v: %s", ErrValidatorRejected, validator.Command[0], err, output)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+)
+
+const (
+	// vaultScheme is the scheme of Vault secret references
+	vaultScheme = "vault"
+	// vaultURLPrefix is the URL form of Vault secret references, vault://secret/data/provider#apikey
+	vaultURLPrefix = "vault://"
+	// defaultVaultCacheTTL is how long a Vault secret is reused when no cache TTL is configured
+	defaultVaultCacheTTL = 5 * time.Minute
+	// defaultVaultAuthPath is the mount of the Kubernetes auth method
+	defaultVaultAuthPath = "kubernetes"
+)
+
+// vaultClient is a minimal Vault client reading KV secrets, version 1 or 2.
+// Read secrets are cached until their TTL or the next reload.
+type vaultClient struct {
+	address   string
+	role      string
+	authPath  string
+	tokenFile string
+	ttl       time.Duration
+	http      *http.Client
+	now       func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	secrets map[string]vaultSecret
+}
+
+type vaultSecret struct {
+	data      map[string]any
+	expiresAt time.Time
+}
+
+// vaultRead is a KV read response, its data holding the secret data directly in
+// KV version 1 and along with the secret metadata in KV version 2
+type vaultRead struct {
+	Data json.RawMessage `json:"data"`
+}
+
+type vaultKVv2 struct {
+	Data     map[string]any `json:"data"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type vaultLogin struct {
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+// newVaultClient returns a client for the configured Vault, defaulting to the VAULT_ADDR and
+// VAULT_TOKEN environment variables. The token may itself be an env or file secret reference.
+func (p *HTTPProvider) newVaultClient(vault *config.Vault) (*vaultClient, error) {
+	client := &vaultClient{
+		address:   strings.TrimSuffix(vault.Address, "/"),
+		role:      vault.Role,
+		authPath:  vault.AuthPath,
+		tokenFile: kubeTokenFile,
+		ttl:       vault.CacheTTL,
+		http:      &http.Client{Timeout: 30 * time.Second},
+		now:       time.Now,
+	}
+	if client.address == "" {
+		client.address = strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	}
+	if client.address == "" {
+		return nil, fmt.Errorf("vault: address is required when VAULT_ADDR is not set")
+	}
+	if client.authPath == "" {
+		client.authPath = defaultVaultAuthPath
+	}
+	if client.ttl == 0 {
+		client.ttl = defaultVaultCacheTTL
+	}
+	token := vault.Token
+	if token == "" && vault.Role == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if isSecretReference(token) && !strings.HasPrefix(token, vaultURLPrefix) {
+		resolved, err := p.resolveSecret(context.Background(), token)
+		if err != nil {
+			return nil, fmt.Errorf("vault: token: %w", err)
+		}
+		token = resolved
+	}
+	if token == "" && vault.Role == "" {
+		return nil, fmt.Errorf("vault: token or role is required")
+	}
+	client.token = token
+	return client, nil
+}
+
+// Resolve returns the key of the secret at path, from a path#key reference
+func (v *vaultClient) Resolve(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("vault: reference %s must be path#key", ref)
+	}
+	data, err := v.read(ctx, strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault: key %q not found in %s", key, path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// read returns the data of the secret at path, from the cache while fresh
+func (v *vaultClient) read(ctx context.Context, path string) (map[string]any, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	if secret, ok := v.secrets[path]; ok && now.Before(secret.expiresAt) {
+		return secret.data, nil
+	}
+
+	if v.token == "" {
+		if err := v.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := v.get(ctx, path)
+	if err == nil && resp.StatusCode == http.StatusForbidden && v.role != "" {
+		// The login token expired, log in again once
+		_ = resp.Body.Close()
+		if err = v.login(ctx); err == nil {
+			resp, err = v.get(ctx, path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: failed to read %s: %s", path, resp.Status)
+	}
+	var body vaultRead
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("vault: failed to decode %s: %w", path, err)
+	}
+	var data map[string]any
+	var kv2 vaultKVv2
+	if err := json.Unmarshal(body.Data, &kv2); err == nil && kv2.Metadata != nil {
+		data = kv2.Data
+	} else if err := json.Unmarshal(body.Data, &data); err != nil {
+		return nil, fmt.Errorf("vault: failed to decode %s: %w", path, err)
+	}
+	if v.secrets == nil {
+		v.secrets = map[string]vaultSecret{}
+	}
+	v.secrets[path] = vaultSecret{data: data, expiresAt: now.Add(v.ttl)}
+	return data, nil
+}
+
+func (v *vaultClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: failed to read %s: %w", path, err)
+	}
+	return resp, nil
+}
+
+// login exchanges the service account token for a Vault token with the Kubernetes auth method
+func (v *vaultClient) login(ctx context.Context) error {
+	if v.role == "" {
+		return fmt.Errorf("vault: no token to authenticate with")
+	}
+	jwt, err := os.ReadFile(v.tokenFile)
+	if err != nil {
+		return fmt.Errorf("vault: failed to read service account token: %w", err)
+	}
+	payload, err := json.Marshal(map[string]string{"role": v.role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/v1/auth/%s/login", v.address, strings.Trim(v.authPath, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: failed to log in: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault: failed to log in with role %s: %s", v.role, resp.Status)
+	}
+	var body vaultLogin
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Auth.ClientToken == "" {
+		return fmt.Errorf("vault: invalid login response")
+	}
+	v.token = body.Auth.ClientToken
+	return nil
+}
+
+// clear drops the cached secrets, so the next reload reads them again
+func (v *vaultClient) clear() {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	v.secrets = nil
+	v.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/logger"
+)
+
+// ReloadProviderConfig re-reads the provider config file and reconciles its configurations:
+// added ones are loaded and watched, and removed ones are dropped once their subscribers
+// are notified. Settings other than the configurations only apply on restart.
+func (p *HTTPProvider) ReloadProviderConfig() error {
+	next, err := config.ReadProviderConfig(p.config.ConfigFile)
+	if err != nil {
+		return err
+	}
+	p.reloadMu.Lock()
+	p.config.Configurations = next.Configurations
+	p.reloadMu.Unlock()
+	err = p.Reload()
+	// Watches follow the configurations even when some failed to load, the reload sets their IDs
+	p.reconcileKubeWatches(next.Configurations)
+	return err
+}
+
+// WatchProviderConfig polls the provider config file every config watch interval and
+// reconciles the configurations when it changes. It is a no-op when the interval is 0.
+func (p *HTTPProvider) WatchProviderConfig(ctx context.Context) {
+	interval := p.config.ConfigWatchInterval
+	if interval <= 0 || p.config.ConfigFile == "" {
+		return
+	}
+	last, err := fingerprint(p.config.ConfigFile)
+	if err != nil {
+		logger.Warn("Failed to fingerprint provider config file", "file", p.config.ConfigFile, "error", err)
+	}
+	go func() {
+		if !p.awaitQuietPeriod(ctx) {
+			return
+		}
+		// Changes made during the quiet period are checked for right after it
+		delay := time.Duration(0)
+		if p.config.QuietPeriod <= 0 {
+			delay = interval
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = interval
+
+			current, err := fingerprint(p.config.ConfigFile)
+			if err != nil || current == last {
+				continue
+			}
+			logger.Info("Provider config file changed, reconciling configurations", "file", p.config.ConfigFile)
+			if err := p.ReloadProviderConfig(); err != nil {
+				// The running configurations are kept until the file is fixed
+				logger.Error("Failed to reload provider config", "file", p.config.ConfigFile, "error", err)
+			}
+			last = current
+		}
+	}()
+}
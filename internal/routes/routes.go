@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/middlewares"
 	"github.com/jkaninda/goma-http-provider/internal/provider"
 	"github.com/jkaninda/goma-http-provider/internal/services"
 	"github.com/jkaninda/goma-http-provider/utils"
@@ -16,8 +17,11 @@ var providerService = &services.ProviderService{}
 type Route struct {
 	app      *okapi.Okapi
 	group    *okapi.Group
+	groupV2  *okapi.Group
 	metadata map[string]string
 	secutity []map[string][]string
+	// concurrency bounds in-flight config requests per client across API versions
+	concurrency okapi.Middleware
 }
 
 // NewRoute creates a new Route instance with the provided Okapi app
@@ -25,34 +29,26 @@ func New(app *okapi.Okapi, provider *provider.HTTPProvider, secutity []map[strin
 	providerService.Provider = provider
 
 	return &Route{
-		app:      app,
-		group:    &okapi.Group{Prefix: "api/v1"},
-		metadata: provider.GetMetadata(),
-		secutity: secutity,
+		app:         app,
+		group:       &okapi.Group{Prefix: "api/v1"},
+		groupV2:     &okapi.Group{Prefix: "api/v2"},
+		metadata:    provider.GetMetadata(),
+		secutity:    secutity,
+		concurrency: middlewares.ConcurrencyLimit(provider.MaxConcurrentFetches()),
 	}
 }
 func (r *Route) RegisterRoutes() {
-	r.app.Get("/", func(ctx *okapi.Context) error {
-		return ctx.OK(okapi.M{
-			"service": "http-provider",
-		})
-	})
+	r.app.Get("/", providerService.Status)
 	r.app.Register(r.providerRoutes()...)
+	r.app.Register(r.configRoutes(r.group, providerService.GetConfig, &config.ConfigBundle{})...)
+	r.app.Register(r.configRoutes(r.groupV2, providerService.GetConfigV2, &config.ConfigBundleV2{})...)
+	r.app.Register(r.gomaRoutes()...)
+	r.app.Register(r.adminRoutes()...)
 
 }
 
 // providerRoutes returns the route definitions for the ProviderService
 func (r *Route) providerRoutes() []okapi.RouteDefinition {
-	cfgGroup := r.group.Group("/config").WithTags([]string{"provider-config"})
-
-	options := []okapi.RouteOption{}
-	if len(r.metadata) > 0 {
-		for k := range r.metadata {
-			meta := fmt.Sprintf("X-Goma-Meta-%s", utils.Capitalize(k))
-			options = append(options, okapi.DocHeader(meta, "string", "", true))
-
-		}
-	}
 	return []okapi.RouteDefinition{
 		{
 			Method:      http.MethodGet,
@@ -62,38 +58,336 @@ func (r *Route) providerRoutes() []okapi.RouteDefinition {
 			Summary:     "Service health check",
 			Description: "Goma HTTP provider service health check",
 		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/metrics",
+			Handler:     providerService.Metrics,
+			Middlewares: []okapi.Middleware{},
+			Summary:     "Prometheus metrics",
+			Description: "Goma HTTP provider metrics in the Prometheus text format",
+		},
+	}
+}
+
+// configRoutes returns the config route definitions for an API version group.
+// Versions share auth and metadata extraction and differ in the served bundle shape.
+func (r *Route) configRoutes(group *okapi.Group, getConfig okapi.HandlerFunc, response any) []okapi.RouteDefinition {
+	cfgGroup := group.Group("/config").WithTags([]string{"provider-config"})
+
+	options := r.metadataOptions()
+	return []okapi.RouteDefinition{
 		{
 			Method:      http.MethodGet,
 			Path:        "/stats",
 			Handler:     providerService.GetStats,
 			Group:       cfgGroup,
-			Middlewares: []okapi.Middleware{},
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
 			Response:    &provider.ProviderStats{},
 			Summary:     "Get provider statistics",
 			Description: "Goma provider statistics",
 			Security:    r.secutity,
 			Options:     options,
 		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/sources",
+			Handler:     providerService.GetSources,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Response:    &provider.BundleSources{},
+			Summary:     "Get config sources",
+			Description: "Files each route and middleware of the bundle was loaded from",
+			Security:    r.secutity,
+			Options:     options,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/routes",
+			Handler:     providerService.GetRoutes,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Response:    &provider.RoutesSection{},
+			Summary:     "Get config routes",
+			Description: "Routes of the matched bundle only, with an ETag over the routes",
+			Security:    r.secutity,
+			Options:     options,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/middlewares",
+			Handler:     providerService.GetMiddlewares,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Response:    &provider.MiddlewaresSection{},
+			Summary:     "Get config middlewares",
+			Description: "Middlewares of the matched bundle only, with an ETag over the middlewares",
+			Security:    r.secutity,
+			Options:     options,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/watch",
+			Handler:     providerService.WatchConfig,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Response:    &services.ConfigChange{},
+			Summary:     "Watch config changes",
+			Description: "Waits, for up to the Prefer wait seconds, for the checksum of the matched bundle to differ from If-None-Match, answering 304 on timeout",
+			Security:    r.secutity,
+			Options:     options,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/ratelimits",
+			Handler:     providerService.GetRateLimits,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Response:    &provider.BundleRateLimits{},
+			Summary:     "Get rate limits",
+			Description: "Effective rate limit of each path protected by a rateLimit middleware",
+			Security:    r.secutity,
+			Options:     options,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/batch",
+			Handler:     providerService.GetConfigBatch,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Request:     &services.BatchRequest{},
+			Response:    &services.BatchResponse{},
+			Summary:     "Get configs in batch",
+			Description: "Resolve several metadata sets in one call, each item matched and authenticated on its own",
+			Security:    r.secutity,
+			Options:     options,
+		},
 		{
 			Method:      http.MethodGet,
 			Path:        "/reload",
 			Handler:     providerService.ReloadConfig,
 			Group:       cfgGroup,
-			Middlewares: []okapi.Middleware{},
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
 			Summary:     "Reload configuration",
-			Description: "Goma HTTP provider service reload config",
+			Description: "Goma HTTP provider service reload config, in the background with 202 when async",
+			Security:    r.secutity,
+			Options: append([]okapi.RouteOption{
+				okapi.DocQueryParam("async", "boolean", "Reload in the background and return the reload job to poll", false),
+			}, options...),
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/reload/{id}",
+			Handler:     providerService.GetReloadJob,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Response:    &provider.ReloadJob{},
+			Summary:     "Get reload job",
+			Description: "Status of a background reload",
 			Security:    r.secutity,
 			Options:     options,
 		},
 		{
 			Method:      http.MethodGet,
 			Path:        "/",
-			Handler:     providerService.GetConfig,
+			Handler:     getConfig,
 			Group:       cfgGroup,
-			Middlewares: []okapi.Middleware{},
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
 			Summary:     "Get provider config",
 			Description: "Retrieve Goma gateway config",
-			Response:    &config.ConfigBundle{},
+			Response:    response,
+			Security:    r.secutity,
+			Options: append([]okapi.RouteOption{
+				okapi.DocHeader("If-Match", "string", "Checksum of the bundle held by the client", false),
+				okapi.DocHeader("Prefer", "string", "return=delta to get a JSON Patch from the If-Match bundle", false),
+				okapi.DocQueryParam("fields", "string", "Comma separated dot paths to project the bundle to, e.g. routes.path,routes.target", false),
+				okapi.DocQueryParam("match", "string", "Metadata match strategy: best, strict or exact", false),
+				okapi.DocQueryParam("envelope", "boolean", "Wrap the bundle in an envelope with configId, checksum, serverTime and providerVersion", false),
+				okapi.DocHeader(provider.ConfigIDHeader, "string", "Configuration ID to serve, bypassing metadata matching", false),
+				okapi.DocQueryParam(provider.ConfigIDQueryParam, "string", "Configuration ID to serve, bypassing metadata matching", false),
+			}, options...),
+		},
+		{
+			Method:      http.MethodHead,
+			Path:        "/",
+			Handler:     getConfig,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Summary:     "Get provider config headers",
+			Description: "Headers of the provider config, ETag and Content-Length included, without the body",
+			Security:    r.secutity,
+			Options:     options,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/",
+			Handler:     providerService.ConfigOptions,
+			Group:       cfgGroup,
+			Response:    &services.ConfigCapabilities{},
+			Summary:     "Get provider config capabilities",
+			Description: "Allowed methods, content encodings, Accept profiles and query parameters of the provider config",
+		},
+	}
+}
+
+// gomaRoutes returns the route definitions serving the Goma Gateway HTTP provider format
+func (r *Route) gomaRoutes() []okapi.RouteDefinition {
+	cfgGroup := r.group.Group("/config").WithTags([]string{"provider-config"})
+	return []okapi.RouteDefinition{
+		{
+			Method:      http.MethodGet,
+			Path:        "/goma",
+			Handler:     providerService.GetConfigGoma,
+			Group:       cfgGroup,
+			Middlewares: []okapi.Middleware{providerService.DrainGuard, r.concurrency},
+			Summary:     "Get Goma provider config",
+			Description: "Retrieve the config in the exact format of the Goma Gateway HTTP provider, 500 when it doesn't match the Goma schema",
+			Response:    &config.GomaConfigBundle{},
+			Security:    r.secutity,
+			Options:     r.metadataOptions(),
+		},
+	}
+}
+
+// metadataOptions documents the metadata headers of the config routes
+func (r *Route) metadataOptions() []okapi.RouteOption {
+	options := []okapi.RouteOption{}
+	for k := range r.metadata {
+		meta := fmt.Sprintf("X-Goma-Meta-%s", utils.Capitalize(k))
+		options = append(options, okapi.DocHeader(meta, "string", "", true))
+	}
+	return options
+}
+
+// adminRoutes returns the provider wide admin route definitions
+func (r *Route) adminRoutes() []okapi.RouteDefinition {
+	adminGroup := r.group.Group("/admin").WithTags([]string{"provider-admin"})
+
+	options := r.metadataOptions()
+	return []okapi.RouteDefinition{
+		{
+			Method:      http.MethodPost,
+			Path:        "/drain",
+			Handler:     providerService.EnterDrain,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Summary:     "Enter drain mode",
+			Description: "Config endpoints return 503 with Retry-After until drain mode is exited",
+			Security:    r.secutity,
+			Options:     []okapi.RouteOption{okapi.DocQueryParam("retryAfter", "integer", "Retry-After in seconds", false)},
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/drain",
+			Handler:     providerService.ExitDrain,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Summary:     "Exit drain mode",
+			Description: "Resume serving config endpoints",
+			Security:    r.secutity,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/flags/{name}",
+			Handler:     providerService.SetFlag,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Summary:     "Set a feature flag",
+			Description: "Enable or disable a feature flag, routes with a disabled flag are not served",
+			Security:    r.secutity,
+			Options:     []okapi.RouteOption{okapi.DocQueryParam("enabled", "boolean", "Flag state", true)},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/configurations",
+			Handler:     providerService.ListConfigurations,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Summary:     "List configurations",
+			Description: "Configurations with their metadata, checksum and route and middleware counts, as an HTML page for browsers",
+			Security:    r.secutity,
+			Options:     []okapi.RouteOption{okapi.DocQueryParam("tag", "string", "Only the configurations carrying the tag", false)},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/reload",
+			Handler:     providerService.ReloadTagged,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Summary:     "Reload configurations by tag",
+			Description: "Reload the configurations carrying the tag, leaving the others untouched",
+			Security:    r.secutity,
+			Options:     []okapi.RouteOption{okapi.DocQueryParam("tag", "string", "Configuration tag, such as region:eu", true)},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/routes/{name}",
+			Handler:     providerService.SetRouteEnabled,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Summary:     "Enable or disable a route",
+			Description: "Disabled routes are removed from the served bundles until the next reload",
+			Security:    r.secutity,
+			Options: []okapi.RouteOption{
+				okapi.DocQueryParam("enabled", "boolean", "Route state", true),
+				okapi.DocQueryParam("id", "string", "Configuration ID, every configuration having the route when unset", false),
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/preview-metadata",
+			Handler:     providerService.PreviewMetadata,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Request:     &services.PreviewMetadataRequest{},
+			Response:    &provider.MetadataPreview{},
+			Summary:     "Preview a proposed configuration metadata",
+			Description: "Simulate adding a configuration with the proposed metadata and report the configurations it would collide with or shadow",
+			Security:    r.secutity,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/state",
+			Handler:     providerService.DumpState,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Response:    &provider.StateDump{},
+			Summary:     "Dump the provider state",
+			Description: "Configurations with their checksum, load and expiry times and counts, and the recent reloads, with secrets redacted",
+			Security:    r.secutity,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/validate-remote",
+			Handler:     providerService.ValidateRemote,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Request:     &services.ValidateRemoteRequest{},
+			Response:    &provider.RemoteValidation{},
+			Summary:     "Validate a remote bundle",
+			Description: "Fetch a candidate bundle from a URL and validate it without registering it",
+			Security:    r.secutity,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/effective",
+			Handler:     providerService.GetEffectiveConfig,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Response:    &provider.EffectiveConfig{},
+			Summary:     "Get effective configuration",
+			Description: "Get the fully resolved configuration of the request and the transformations applied to it",
+			Security:    r.secutity,
+			Options:     options,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/graph",
+			Handler:     providerService.GetGraph,
+			Group:       adminGroup,
+			Middlewares: []okapi.Middleware{},
+			Response:    &provider.BundleGraph{},
+			Summary:     "Get route and middleware graph",
+			Description: "Routes and the middlewares they reference as a graph, in Graphviz DOT with Accept: text/vnd.graphviz",
 			Security:    r.secutity,
 			Options:     options,
 		},
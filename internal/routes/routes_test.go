@@ -0,0 +1,809 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/provider"
+	"github.com/jkaninda/goma-http-provider/internal/services"
+	"github.com/jkaninda/okapi"
+	"github.com/jkaninda/okapi/okapitest"
+)
+
+func newTestApp(t *testing.T, conf *config.ProviderConfig) *okapi.TestServer {
+	t.Helper()
+	p, err := provider.NewHTTPProvider(conf)
+	if err != nil {
+		t.Fatalf("NewHTTPProvider: %v", err)
+	}
+	app := okapi.NewTestServer(t)
+	New(app.Okapi, p, nil).RegisterRoutes()
+	return app
+}
+
+func writeRoutes(t *testing.T, dir string) {
+	t.Helper()
+	content := "routes:\n  - name: api\n    path: /api\n    target: http://api:8080\n"
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigVersions(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	app := newTestApp(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{
+			Directory: dir,
+			Default:   true,
+			Metadata:  map[string]string{"env": "prod"},
+		}},
+	})
+
+	var v1 map[string]any
+	okapitest.GET(t, app.BaseURL+"/api/v1/config").
+		Header("X-Goma-Meta-Env", "prod").
+		ExpectStatusOK().
+		ParseJSON(&v1)
+	if _, ok := v1["routes"]; !ok {
+		t.Fatalf("expected v1 bundle to contain routes, got %v", v1)
+	}
+	if _, ok := v1["configId"]; ok {
+		t.Fatalf("expected v1 bundle to keep the legacy shape, got %v", v1)
+	}
+
+	var v2 map[string]any
+	resp, _ := okapitest.GET(t, app.BaseURL+"/api/v2/config").
+		Header("X-Goma-Meta-Env", "prod").
+		ExpectStatusOK().
+		ParseJSON(&v2).
+		Execute()
+	if _, ok := v2["routes"]; !ok {
+		t.Fatalf("expected v2 bundle to contain routes, got %v", v2)
+	}
+	if v2["configId"] != "env=prod" {
+		t.Fatalf("expected v2 bundle to report the config id, got %v", v2["configId"])
+	}
+	if resp.Header.Get("ETag") != provider.WeakETag(v1["checksum"].(string)) {
+		t.Fatalf("expected both versions to share the bundle checksum")
+	}
+
+	okapitest.GET(t, app.BaseURL+"/api/v2/config/stats").ExpectStatusOK()
+}
+
+func TestDrainMode(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin:          &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/drain").ExpectStatusUnauthorized()
+	okapitest.GET(t, app.BaseURL+"/api/v1/config").ExpectStatusOK()
+
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/drain?retryAfter=60").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		ExpectBodyContains(`"draining":true`)
+
+	for _, path := range []string{"/api/v1/config", "/api/v2/config", "/api/v1/config/stats"} {
+		okapitest.GET(t, app.BaseURL+path).
+			ExpectStatus(http.StatusServiceUnavailable).
+			ExpectHeader("Retry-After", "60")
+	}
+	okapitest.GET(t, app.BaseURL+"/healthz").ExpectStatusOK()
+	var status provider.ProviderStatus
+	okapitest.GET(t, app.BaseURL+"/").ExpectStatusOK().ParseJSON(&status)
+	if status.Ready {
+		t.Fatalf("expected the status to report not ready while draining, got %+v", status)
+	}
+	okapitest.GET(t, app.BaseURL+"/").
+		Header("Accept", "text/html").
+		ExpectStatusOK().
+		ExpectBodyContains("Draining")
+
+	okapitest.DELETE(t, app.BaseURL+"/api/v1/admin/drain").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK()
+	okapitest.GET(t, app.BaseURL+"/api/v1/config").ExpectStatusOK()
+}
+
+func TestAdminDisabled(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	app := newTestApp(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/drain").ExpectStatusForbidden()
+}
+
+func TestAuditAdminActions(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin:          &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		AuditFile:      auditFile,
+	})
+
+	okapitest.GET(t, app.BaseURL+"/api/v1/config/reload").ExpectStatusOK()
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/drain").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK()
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d: %s", len(lines), data)
+	}
+
+	var reload, drain provider.AuditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &reload); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &drain); err != nil {
+		t.Fatal(err)
+	}
+	if reload.Action != provider.AuditReload || len(reload.ConfigIDs) != 1 || reload.SourceIP == "" || reload.Time.IsZero() {
+		t.Errorf("unexpected reload entry: %+v", reload)
+	}
+	if drain.Action != provider.AuditDrainEnter || !strings.HasPrefix(drain.Subject, "apikey:") {
+		t.Errorf("unexpected drain entry: %+v", drain)
+	}
+	if strings.Contains(string(data), "admin-key") {
+		t.Error("audit entries must not contain the api key")
+	}
+}
+
+func TestValidateRemote(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin:             &config.HTTPAuth{APIKey: "admin-key"},
+		PathNormalization: config.PathNormalizationStrip,
+		Configurations: []*config.Configuration{{
+			Directory:       dir,
+			Default:         true,
+			Metadata:        map[string]string{"env": "prod"},
+			RequireNonEmpty: true,
+		}},
+	})
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "remote-key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/valid.yaml":
+			_, _ = w.Write([]byte("routes:\n  - name: api\n    path: /api\n"))
+		case "/served.yaml":
+			_, _ = w.Write([]byte("routes:\n  - name: api\n    path: //api/\n    target: http://api:8080\n"))
+		case "/empty.yaml":
+			_, _ = w.Write([]byte("middlewares: []\n"))
+		case "/bundle":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"routes":[{"name":"api"},{"name":"api","path":"/api"}]}`))
+		case "/broken.yaml":
+			_, _ = w.Write([]byte("routes: ["))
+		}
+	}))
+	defer remote.Close()
+
+	validate := func(path string) *okapitest.RequestBuilder {
+		body := `{"url":"` + remote.URL + path + `","auth":{"apiKey":"remote-key"}}`
+		return okapitest.POST(t, app.BaseURL+"/api/v1/admin/validate-remote").
+			Header("X-API-Key", "admin-key").
+			JSONBody(body)
+	}
+
+	var valid provider.RemoteValidation
+	validate("/valid.yaml").ExpectStatusOK().ParseJSON(&valid)
+	if !valid.Valid || valid.Checksum == "" || valid.Routes != 1 {
+		t.Errorf("expected a valid bundle with a checksum, got %+v", valid)
+	}
+
+	var invalid provider.RemoteValidation
+	validate("/bundle").ExpectStatus(http.StatusUnprocessableEntity).ParseJSON(&invalid)
+	if invalid.Valid || len(invalid.Errors) != 2 {
+		t.Errorf("expected a missing path and a duplicate name, got %+v", invalid)
+	}
+
+	validate("/broken.yaml").
+		ExpectStatus(http.StatusUnprocessableEntity).
+		ExpectBodyContains("broken.yaml")
+
+	// Loaded as a configuration, the candidate gets its settings and metadata, and the
+	// checksum of the bundle it would serve, paths normalized
+	validateAs := func(path, id string) *okapitest.RequestBuilder {
+		body := `{"url":"` + remote.URL + path + `","auth":{"apiKey":"remote-key"},"configId":"` + id + `"}`
+		return okapitest.POST(t, app.BaseURL+"/api/v1/admin/validate-remote").
+			Header("X-API-Key", "admin-key").
+			JSONBody(body)
+	}
+	var served config.ConfigBundle
+	okapitest.GET(t, app.BaseURL+"/api/v1/config").ExpectStatusOK().ParseJSON(&served)
+	var candidate provider.RemoteValidation
+	validateAs("/served.yaml", "env=prod").ExpectStatusOK().ParseJSON(&candidate)
+	if candidate.Checksum != served.Checksum {
+		t.Errorf("expected the checksum of the served bundle %s, got %+v", served.Checksum, candidate)
+	}
+	validateAs("/empty.yaml", "env=prod").
+		ExpectStatus(http.StatusUnprocessableEntity).
+		ExpectBodyContains("has no routes")
+	validate("/empty.yaml").ExpectStatusOK()
+	validateAs("/valid.yaml", "env=staging").ExpectStatusNotFound()
+
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/validate-remote").
+		JSONBody(`{"url":"` + remote.URL + `/valid.yaml"}`).
+		ExpectStatusUnauthorized()
+
+	// Validation does not register the candidate
+	okapitest.GET(t, app.BaseURL+"/api/v1/config/sources").
+		ExpectStatusOK().
+		ExpectBodyContains(filepath.Join(dir, "routes.yaml"))
+}
+
+func TestPassthroughFields(t *testing.T) {
+	dir := t.TempDir()
+	content := "routes:\n  - name: api\n    path: /api\ncertificates:\n  - name: default\n    sni: [\"*.example.com\"]\n"
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, &config.ProviderConfig{
+		Configurations:    []*config.Configuration{{Directory: dir, Default: true}},
+		PassthroughFields: true,
+	})
+
+	want := `[{"name":"default","sni":["*.example.com"]}]`
+	for _, path := range []string{"/api/v1/config", "/api/v2/config"} {
+		var bundle map[string]json.RawMessage
+		okapitest.GET(t, app.BaseURL+path).ExpectStatusOK().ParseJSON(&bundle)
+		if string(bundle["certificates"]) != want {
+			t.Errorf("%s: certificates = %s, want %s", path, bundle["certificates"], want)
+		}
+		if _, ok := bundle["routes"]; !ok {
+			t.Errorf("%s: expected routes to be served, got %v", path, bundle)
+		}
+	}
+	var v2 map[string]any
+	okapitest.GET(t, app.BaseURL+"/api/v2/config").ExpectStatusOK().ParseJSON(&v2)
+	if v2["configId"] != "default" {
+		t.Errorf("expected the v2 fields to be kept, got %v", v2)
+	}
+}
+
+func TestRootStatus(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	app := newTestApp(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+
+	var status provider.ProviderStatus
+	okapitest.GET(t, app.BaseURL+"/").
+		ExpectStatusOK().
+		ParseJSON(&status)
+	if !status.Ready || status.ConfigsLoaded != 1 || status.Version == "" || status.LastReload.IsZero() {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	okapitest.GET(t, app.BaseURL+"/").
+		Header("Accept", "text/html,application/xhtml+xml").
+		ExpectStatusOK().
+		ExpectHeaderContains("Content-Type", "text/html").
+		ExpectBodyContains("Ready").
+		ExpectBodyContains("<td>1</td>")
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	dir := t.TempDir()
+	content := "routes:\n  - name: api\n    path: api/\n    target: http://api:8080\n  - name: beta\n    path: /beta\n    target: http://beta:8080\n    flag: beta\n"
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin: &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{{
+			Directory: dir,
+			Default:   true,
+			Metadata:  map[string]string{"env": "prod"},
+		}},
+		MetadataDefaults:  map[string]string{"env": "prod"},
+		PathNormalization: config.PathNormalizationStrip,
+	})
+
+	var effective provider.EffectiveConfig
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/effective").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		ParseJSON(&effective)
+
+	if effective.ConfigID != "env=prod" || effective.Metadata["env"] != "prod" {
+		t.Errorf("expected the defaulted metadata to select env=prod, got %+v", effective)
+	}
+	if len(effective.Bundle.Routes) != 1 || effective.Bundle.Routes[0].Path != "/api" {
+		t.Errorf("expected the normalized api route without the flagged one, got %+v", effective.Bundle.Routes)
+	}
+	kinds := map[string]bool{}
+	for _, tr := range effective.Transformations {
+		kinds[tr.Kind] = true
+	}
+	for _, kind := range []string{
+		provider.TransformPathNormalization,
+		provider.TransformMetadataMerge,
+		provider.TransformFeatureFlag,
+		provider.TransformMetadataDefault,
+	} {
+		if !kinds[kind] {
+			t.Errorf("expected a %s transformation, got %+v", kind, effective.Transformations)
+		}
+	}
+
+	// Metadata sent by the request is not reported as defaulted
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/effective").
+		Header("X-API-Key", "admin-key").
+		Header("X-Goma-Meta-Env", "prod").
+		ExpectStatusOK().
+		ParseJSON(&effective)
+	for _, tr := range effective.Transformations {
+		if tr.Kind == provider.TransformMetadataDefault {
+			t.Errorf("expected no metadata default, got %+v", tr)
+		}
+	}
+
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/effective").ExpectStatusUnauthorized()
+}
+
+func TestRouteToggle(t *testing.T) {
+	dir := t.TempDir()
+	content := "routes:\n  - name: api\n    path: /api\n    target: http://api:8080\n  - name: harmful\n    path: /harmful\n    target: http://harmful:8080\n"
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin:          &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	served := func() config.ConfigBundle {
+		t.Helper()
+		var bundle config.ConfigBundle
+		okapitest.GET(t, app.BaseURL+"/api/v1/config").ExpectStatusOK().ParseJSON(&bundle)
+		return bundle
+	}
+	before := served()
+
+	var toggled struct {
+		Enabled bool                   `json:"enabled"`
+		Configs []provider.RouteToggle `json:"configs"`
+	}
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/routes/harmful?enabled=false").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		ParseJSON(&toggled)
+	if len(toggled.Configs) != 1 || len(toggled.Configs[0].Routes) != 1 || toggled.Configs[0].Routes[0].Name != "api" {
+		t.Fatalf("expected only the api route to remain, got %+v", toggled)
+	}
+	disabled := served()
+	if len(disabled.Routes) != 1 || disabled.Routes[0].Name != "api" {
+		t.Fatalf("expected the disabled route to be removed from the served bundle, got %+v", disabled.Routes)
+	}
+	if disabled.Checksum == before.Checksum || disabled.Checksum != toggled.Configs[0].Checksum {
+		t.Fatalf("expected the checksum to follow the served routes, got %s", disabled.Checksum)
+	}
+
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/routes/harmful?enabled=true").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK()
+	if enabled := served(); len(enabled.Routes) != 2 || enabled.Checksum != before.Checksum {
+		t.Fatalf("expected the re-enabled route to be served again, got %+v", enabled.Routes)
+	}
+
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/routes/unknown?enabled=false").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusNotFound()
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/routes/harmful?enabled=false").ExpectStatusUnauthorized()
+}
+
+func TestConfigurationsPage(t *testing.T) {
+	prod, staging := t.TempDir(), t.TempDir()
+	writeRoutes(t, prod)
+	writeRoutes(t, staging)
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin: &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{
+			{Directory: prod, Metadata: map[string]string{"env": "prod"}},
+			{Directory: staging, Metadata: map[string]string{"env": "staging"}},
+		},
+	})
+
+	page := okapitest.GET(t, app.BaseURL+"/api/v1/admin/configurations").
+		Header("X-API-Key", "admin-key").
+		Header("Accept", "text/html").
+		ExpectStatusOK().
+		ExpectHeaderContains("Content-Type", "text/html")
+	for _, id := range []string{"env=prod", "env=staging"} {
+		page.ExpectBodyContains(id).ExpectBodyContains("configId=" + strings.ReplaceAll(id, "=", "%3d"))
+	}
+
+	var summaries []provider.ConfigSummary
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/configurations").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		ParseJSON(&summaries)
+	if len(summaries) != 2 || !summaries[0].Loaded || summaries[0].Routes != 1 || summaries[0].Checksum == "" {
+		t.Fatalf("expected a summary of each loaded configuration, got %+v", summaries)
+	}
+
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/configurations").
+		Header("Accept", "text/html").
+		ExpectStatusUnauthorized()
+}
+
+func TestTaggedConfigurations(t *testing.T) {
+	eu, us := t.TempDir(), t.TempDir()
+	writeRoutes(t, eu)
+	writeRoutes(t, us)
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin: &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{
+			{Directory: eu, Metadata: map[string]string{"tenant": "eu"}, Tags: []string{"region:eu"}},
+			{Directory: us, Metadata: map[string]string{"tenant": "us"}, Tags: []string{"region:us"}},
+		},
+	})
+
+	var summaries []provider.ConfigSummary
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/configurations?tag=region:eu").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		ParseJSON(&summaries)
+	if len(summaries) != 1 || summaries[0].ID != "tenant=eu" {
+		t.Fatalf("expected only the eu configuration, got %+v", summaries)
+	}
+
+	var reloaded struct {
+		IDs []string `json:"ids"`
+	}
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/reload?tag=region:eu").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		ParseJSON(&reloaded)
+	if len(reloaded.IDs) != 1 || reloaded.IDs[0] != "tenant=eu" {
+		t.Fatalf("expected only the eu configuration to be reloaded, got %v", reloaded.IDs)
+	}
+
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/reload?tag=region:apac").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusNotFound()
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/reload").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusBadRequest()
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/reload?tag=region:eu").ExpectStatusUnauthorized()
+}
+
+func TestPreviewMetadata(t *testing.T) {
+	acme, globex := t.TempDir(), t.TempDir()
+	writeRoutes(t, acme)
+	writeRoutes(t, globex)
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin: &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{
+			{Directory: acme, Metadata: map[string]string{"tenant": "acme"}},
+			{Directory: globex, Metadata: map[string]string{"tenant": "globex"}},
+		},
+	})
+	preview := func(body string) provider.MetadataPreview {
+		t.Helper()
+		var result provider.MetadataPreview
+		okapitest.POST(t, app.BaseURL+"/api/v1/admin/preview-metadata").
+			Header("X-API-Key", "admin-key").
+			Header("Content-Type", "application/json").
+			Body(strings.NewReader(body)).
+			ExpectStatusOK().
+			ParseJSON(&result)
+		return result
+	}
+
+	// Acme gateways also sending env=prod would be served the proposed configuration
+	shadowing := preview(`{"metadata": {"tenant": "acme", "env": "prod"}}`)
+	if shadowing.Safe || shadowing.CurrentMatch != "tenant=acme" || len(shadowing.Shadowed) != 1 || shadowing.Shadowed[0].ConfigID != "tenant=acme" {
+		t.Fatalf("expected the proposed configuration to shadow tenant=acme, got %+v", shadowing)
+	}
+
+	disjoint := preview(`{"metadata": {"tenant": "initech"}}`)
+	if !disjoint.Safe || disjoint.ConfigID != "tenant=initech" || disjoint.CurrentMatch != "" || len(disjoint.Shadowed) != 0 {
+		t.Fatalf("expected a disjoint configuration to be safe, got %+v", disjoint)
+	}
+
+	if colliding := preview(`{"metadata": {"tenant": "globex"}}`); colliding.Safe || colliding.Collides != "tenant=globex" {
+		t.Fatalf("expected the proposed configuration to collide with tenant=globex, got %+v", colliding)
+	}
+
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/preview-metadata").
+		Header("X-API-Key", "admin-key").
+		Header("Content-Type", "application/json").
+		Body(strings.NewReader(`{"metadata": {}}`)).
+		ExpectStatusBadRequest()
+	okapitest.POST(t, app.BaseURL+"/api/v1/admin/preview-metadata").
+		Body(strings.NewReader(`{"metadata": {"tenant": "initech"}}`)).
+		ExpectStatusUnauthorized()
+}
+
+func TestConfigHeadAndOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	app := newTestApp(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+
+	get, body := okapitest.GET(t, app.BaseURL+"/api/v1/config").
+		Header("Accept-Encoding", "identity").
+		ExpectStatusOK().
+		Execute()
+	head, headBody := okapitest.HEAD(t, app.BaseURL+"/api/v1/config").
+		Header("Accept-Encoding", "identity").
+		ExpectStatusOK().
+		Execute()
+	if len(headBody) != 0 {
+		t.Fatalf("expected HEAD to return no body, got %q", headBody)
+	}
+	if etag := head.Header.Get("ETag"); etag == "" || etag != get.Header.Get("ETag") {
+		t.Fatalf("expected HEAD to return the GET ETag %q, got %q", get.Header.Get("ETag"), etag)
+	}
+	if head.ContentLength != int64(len(body)) {
+		t.Fatalf("expected HEAD Content-Length %d, got %d", len(body), head.ContentLength)
+	}
+
+	var capabilities map[string]any
+	okapitest.OPTIONS(t, app.BaseURL+"/api/v2/config").
+		ExpectStatusOK().
+		ExpectHeader("Allow", "GET, HEAD, OPTIONS").
+		ParseJSON(&capabilities)
+	if _, ok := capabilities["encodings"]; !ok {
+		t.Fatalf("expected OPTIONS to summarize the config capabilities, got %v", capabilities)
+	}
+}
+
+func TestHealthSummary(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	type health struct {
+		Status  string                  `json:"status"`
+		Summary *provider.HealthSummary `json:"summary"`
+	}
+
+	t.Run("toggle on", func(t *testing.T) {
+		app := newTestApp(t, &config.ProviderConfig{
+			HealthSummary:  true,
+			Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		})
+		var got health
+		okapitest.GET(t, app.BaseURL+"/healthz").ExpectStatusOK().ParseJSON(&got)
+		if got.Status != "healthy" || got.Summary == nil {
+			t.Fatalf("expected the health check to include the summary, got %+v", got)
+		}
+		if !got.Summary.Ready || got.Summary.Configs != 1 || got.Summary.Routes != 1 || got.Summary.LastReloadAge == "" {
+			t.Fatalf("unexpected health summary %+v", *got.Summary)
+		}
+	})
+
+	t.Run("compressed cache", func(t *testing.T) {
+		app := newTestApp(t, &config.ProviderConfig{
+			HealthSummary:  true,
+			CompressCache:  true,
+			Admin:          &config.HTTPAuth{APIKey: "admin-key"},
+			Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		})
+		var got health
+		okapitest.GET(t, app.BaseURL+"/healthz").ExpectStatusOK().ParseJSON(&got)
+		if got.Summary == nil || got.Summary.Routes != 1 {
+			t.Fatalf("expected the compressed routes to be counted, got %+v", got)
+		}
+
+		// Routes disabled at runtime are not served, so not counted
+		okapitest.POST(t, app.BaseURL+"/api/v1/admin/routes/api?enabled=false").
+			Header("X-API-Key", "admin-key").
+			ExpectStatusOK()
+		okapitest.GET(t, app.BaseURL+"/healthz").ExpectStatusOK().ParseJSON(&got)
+		if got.Summary == nil || got.Summary.Routes != 0 {
+			t.Fatalf("expected the disabled route not to be counted, got %+v", got)
+		}
+	})
+
+	t.Run("toggle off", func(t *testing.T) {
+		app := newTestApp(t, &config.ProviderConfig{
+			Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		})
+		var got health
+		okapitest.GET(t, app.BaseURL+"/healthz").ExpectStatusOK().ParseJSON(&got)
+		if got.Summary != nil {
+			t.Fatalf("expected the minimal health response by default, got %+v", *got.Summary)
+		}
+		okapitest.GET(t, app.BaseURL+"/healthz?summary=true").ExpectStatusOK().ParseJSON(&got)
+		if got.Summary == nil || got.Summary.Routes != 1 {
+			t.Fatalf("expected ?summary=true to include the summary, got %+v", got)
+		}
+	})
+}
+
+func TestRouteGraph(t *testing.T) {
+	dir := t.TempDir()
+	content := "routes:\n  - name: api\n    path: /api\n    target: http://api:8080\n    middlewares: [auth, cors]\n" +
+		"middlewares:\n  - name: auth\n    type: basicAuth\n  - name: unused\n    type: rateLimit\n"
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin:          &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+
+	var graph provider.BundleGraph
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/graph").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		ParseJSON(&graph)
+	if !slices.Contains(graph.Edges, provider.GraphEdge{From: "route:api", To: "middleware:auth"}) {
+		t.Fatalf("expected an edge from the api route to the auth middleware, got %+v", graph.Edges)
+	}
+	if !slices.Equal(graph.Orphans, []string{"unused"}) || !slices.Equal(graph.Missing, []string{"cors"}) {
+		t.Fatalf("expected unused to be orphan and cors missing, got orphans %v and missing %v", graph.Orphans, graph.Missing)
+	}
+
+	_, body := okapitest.GET(t, app.BaseURL+"/api/v1/admin/graph").
+		Header("X-API-Key", "admin-key").
+		Header("Accept", "text/vnd.graphviz").
+		ExpectStatusOK().
+		ExpectHeader("Content-Type", "text/vnd.graphviz").
+		Execute()
+	if !strings.Contains(string(body), `"route:api" -> "middleware:auth";`) {
+		t.Fatalf("expected the DOT graph to contain the api to auth edge, got %s", body)
+	}
+
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/graph").ExpectStatusUnauthorized()
+}
+
+func TestDumpState(t *testing.T) {
+	acme, globex := t.TempDir(), t.TempDir()
+	writeRoutes(t, acme)
+	writeRoutes(t, globex)
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin: &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{
+			{
+				Directory: acme,
+				Metadata:  map[string]string{"tenant": "acme"},
+				Auth:      &config.HTTPAuth{APIKey: "acme-secret-key"},
+			},
+			{
+				Directory: globex,
+				Metadata:  map[string]string{"tenant": "globex"},
+				Auth:      &config.HTTPAuth{BasicAuth: &config.BasicAuth{Username: "globex", Password: "globex-secret-password"}},
+			},
+		},
+	})
+
+	_, body := okapitest.GET(t, app.BaseURL+"/api/v1/admin/state").
+		Header("X-API-Key", "admin-key").
+		ExpectStatusOK().
+		Execute()
+	for _, secret := range []string{"admin-key", "acme-secret-key", "globex-secret-password"} {
+		if strings.Contains(string(body), secret) {
+			t.Fatalf("expected the state dump to redact %q, got %s", secret, body)
+		}
+	}
+
+	var dump provider.StateDump
+	if err := json.Unmarshal(body, &dump); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]provider.ConfigState{}
+	for _, state := range dump.Configurations {
+		ids[state.ID] = state
+	}
+	for id, auth := range map[string]string{"tenant=acme": "apiKey", "tenant=globex": "basicAuth"} {
+		state, ok := ids[id]
+		if !ok || !state.Loaded || state.Checksum == "" || state.Routes != 1 || state.LoadedAt == nil {
+			t.Fatalf("expected %s to be dumped with its loaded bundle, got %+v", id, dump.Configurations)
+		}
+		if !slices.Equal(state.Auth, []string{auth}) {
+			t.Fatalf("expected %s to enable %s, got %v", id, auth, state.Auth)
+		}
+	}
+	if len(dump.Reloads) == 0 || dump.Reloads[0].Scope != "startup" || dump.Reloads[0].Outcome != "success" {
+		t.Fatalf("expected the startup load in the reload history, got %+v", dump.Reloads)
+	}
+
+	okapitest.GET(t, app.BaseURL+"/api/v1/admin/state").ExpectStatusUnauthorized()
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	content := "routes:\n  - name: api\n    path: /api\n    target: http://api:8080\n  - name: harmful\n    path: /harmful\n    target: http://harmful:8080\n"
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, &config.ProviderConfig{
+		Admin:          &config.HTTPAuth{APIKey: "admin-key"},
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	var bundle config.ConfigBundle
+	okapitest.GET(t, app.BaseURL+"/api/v1/config").ExpectStatusOK().ParseJSON(&bundle)
+	etag := provider.WeakETag(bundle.Checksum)
+
+	var change services.ConfigChange
+	okapitest.GET(t, app.BaseURL+"/api/v2/config/watch").
+		Header("If-None-Match", `W/"stale"`).
+		ExpectStatusOK().
+		ParseJSON(&change)
+	if change.Checksum != bundle.Checksum {
+		t.Fatalf("expected a stale checksum to be answered right away, got %+v", change)
+	}
+
+	okapitest.GET(t, app.BaseURL+"/api/v1/config/watch").
+		Header("If-None-Match", etag).
+		Header("Prefer", "wait=1").
+		ExpectStatus(http.StatusNotModified)
+
+	toggled := time.AfterFunc(200*time.Millisecond, func() {
+		req, _ := http.NewRequest(http.MethodPost, app.BaseURL+"/api/v1/admin/routes/harmful?enabled=false", nil)
+		req.Header.Set("X-API-Key", "admin-key")
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	})
+	defer toggled.Stop()
+	start := time.Now()
+	okapitest.GET(t, app.BaseURL+"/api/v1/config/watch").
+		Header("If-None-Match", etag).
+		Header("Prefer", "wait=10").
+		ExpectStatusOK().
+		ParseJSON(&change)
+	if change.Checksum == bundle.Checksum || change.Checksum == "" {
+		t.Fatalf("expected the watch to answer the checksum without the disabled route, got %+v", change)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the watch to return on the change, took %s", elapsed)
+	}
+
+}
+
+func TestWatchConfigConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	writeRoutes(t, dir)
+	app := newTestApp(t, &config.ProviderConfig{
+		Configurations:       []*config.Configuration{{Directory: dir, Default: true}},
+		MaxConcurrentFetches: 1,
+	})
+	var bundle config.ConfigBundle
+	okapitest.GET(t, app.BaseURL+"/api/v1/config").ExpectStatusOK().ParseJSON(&bundle)
+	etag := provider.WeakETag(bundle.Checksum)
+
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		req, _ := http.NewRequest(http.MethodGet, app.BaseURL+"/api/v1/config/watch", nil)
+		req.Header.Set("If-None-Match", etag)
+		req.Header.Set("Prefer", "wait=1")
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	okapitest.GET(t, app.BaseURL+"/api/v2/config/watch").
+		Header("If-None-Match", etag).
+		ExpectStatus(http.StatusTooManyRequests)
+	<-held
+}
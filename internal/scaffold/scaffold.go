@@ -0,0 +1,60 @@
+// Package scaffold generates an example provider configuration
+package scaffold
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+//go:embed all:templates
+var templates embed.FS
+
+// Write writes the example provider config, a sample tenant directory and a .env
+// template into dir, returning the written paths. Unless force is set, nothing is
+// written when one of the files already exists.
+func Write(dir string, force bool) ([]string, error) {
+	root, err := fs.Sub(templates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = fs.WalkDir(root, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !force {
+		for _, file := range files {
+			target := filepath.Join(dir, filepath.FromSlash(file))
+			if _, err := os.Stat(target); err == nil {
+				return nil, fmt.Errorf("%s already exists, use --force to overwrite", target)
+			}
+		}
+	}
+
+	written := make([]string, 0, len(files))
+	for _, file := range files {
+		data, err := fs.ReadFile(root, file)
+		if err != nil {
+			return written, err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(target, data, 0o644); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", target, err)
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
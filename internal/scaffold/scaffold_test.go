@@ -0,0 +1,64 @@
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/provider"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	written, err := Write(dir, false)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, want := range []string{"config.yaml", ".env", "configs/production/routes.yaml", "configs/production/middlewares.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, want)); err != nil {
+			t.Errorf("expected %s to be written: %v", want, err)
+		}
+	}
+
+	// The generated files load through the provider from the scaffold directory
+	data, err := os.ReadFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conf config.ProviderConfig
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		t.Fatalf("generated config.yaml: %v", err)
+	}
+	t.Chdir(dir)
+	p, err := provider.NewHTTPProvider(&conf)
+	if err != nil {
+		t.Fatalf("NewHTTPProvider: %v", err)
+	}
+	bundle, _, err := p.GetConfig(t.Context(), map[string]string{"environment": "production"})
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if len(bundle.Routes) != 1 || len(bundle.Middlewares) != 1 {
+		t.Fatalf("expected 1 route and 1 middleware, got %d and %d", len(bundle.Routes), len(bundle.Middlewares))
+	}
+
+	// Existing files are kept unless forced
+	if err := os.WriteFile(written[0], []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Write(dir, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected Write to refuse overwriting, got %v", err)
+	}
+	if data, _ := os.ReadFile(written[0]); string(data) != "edited" {
+		t.Fatal("expected the existing file to be kept")
+	}
+	if _, err := Write(dir, true); err != nil {
+		t.Fatalf("Write with force: %v", err)
+	}
+	if data, _ := os.ReadFile(written[0]); string(data) == "edited" {
+		t.Fatal("expected force to overwrite the existing file")
+	}
+}
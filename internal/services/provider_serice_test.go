@@ -1,8 +1,31 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/provider"
 	"github.com/jkaninda/okapi"
 	"github.com/jkaninda/okapi/okapitest"
 )
@@ -15,3 +38,1184 @@ func TestHealthCheck(t *testing.T) {
 
 	okapitest.GET(t, "http://localhost:8080/helth").ExpectStatusOK()
 }
+
+func newTestService(t *testing.T, conf *config.ProviderConfig) *ProviderService {
+	t.Helper()
+	p, err := provider.NewHTTPProvider(conf)
+	if err != nil {
+		t.Fatalf("NewHTTPProvider: %v", err)
+	}
+	return &ProviderService{Provider: p}
+}
+
+func writeConfigFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetConfigStaleHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		ReportStale:    true,
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+	app.Get("/stats", svc.GetStats)
+
+	resp, _ := okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().Execute()
+	if resp.Header.Get("X-Goma-Config-Stale") != "" {
+		t.Fatal("expected no stale header before a failed reload")
+	}
+
+	writeConfigFile(t, dir, "broken.yaml", "routes: [")
+	if err := svc.Provider.Reload(); err == nil {
+		t.Fatal("expected reload to fail")
+	}
+
+	okapitest.GET(t, app.BaseURL+"/config").
+		ExpectStatusOK().
+		ExpectHeader("X-Goma-Config-Stale", "true").
+		ExpectBodyContains("/api")
+	okapitest.GET(t, app.BaseURL+"/stats").
+		ExpectStatusOK().
+		ExpectBodyContains("staleSince")
+}
+
+func TestGetConfigDeprecationWarning(t *testing.T) {
+	provider.DeprecateField("routes.rewrite", "use routes.path with a rewrite middleware")
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n    rewrite: /\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	okapitest.GET(t, app.BaseURL+"/config").
+		ExpectStatusOK().
+		ExpectHeaderContains("Warning", "299 goma-http-provider").
+		ExpectHeaderContains("Warning", "routes.rewrite is deprecated: use routes.path with a rewrite middleware")
+}
+
+func TestGetConfigCanaryHeader(t *testing.T) {
+	tests := []struct {
+		percent int
+		want    string
+	}{
+		{percent: 100, want: "true"},
+		{percent: 0, want: "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			dir, canaryDir := t.TempDir(), t.TempDir()
+			writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+			writeConfigFile(t, canaryDir, "routes.yaml", "routes:\n  - name: api-canary\n    path: /api\n")
+			svc := newTestService(t, &config.ProviderConfig{
+				Configurations: []*config.Configuration{{
+					Directory: dir,
+					Default:   true,
+					Canary:    &config.Canary{Directory: canaryDir, Percent: tt.percent},
+				}},
+			})
+			app := okapi.NewTestServer(t)
+			app.Get("/config", svc.GetConfig)
+
+			resp := okapitest.GET(t, app.BaseURL+"/config").
+				ExpectStatusOK().
+				ExpectHeader("X-Goma-Canary", tt.want)
+			if tt.want == "true" {
+				resp.ExpectBodyContains("api-canary")
+			}
+		})
+	}
+}
+
+func TestGetConfigDelta(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	resp, _ := okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().Execute()
+	previous := resp.Header.Get("ETag")
+
+	writeConfigFile(t, dir, "extra.yaml", "routes:\n  - name: extra\n    path: /extra\n")
+	if err := svc.Provider.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ops []provider.PatchOperation
+	okapitest.GET(t, app.BaseURL+"/config").
+		Header("If-Match", previous).
+		Header("Prefer", "return=delta").
+		ExpectStatusOK().
+		ExpectHeader("Preference-Applied", "return=delta").
+		ExpectHeaderContains("Content-Type", "application/json-patch+json").
+		ParseJSON(&ops)
+	added := false
+	for _, op := range ops {
+		if op.Op == "add" && op.Path == "/routes/1" {
+			added = true
+		}
+	}
+	if !added {
+		t.Errorf("expected the new route to be added, got %+v", ops)
+	}
+
+	okapitest.GET(t, app.BaseURL+"/config").
+		Header("If-Match", "unknown").
+		Header("Prefer", "return=delta").
+		ExpectStatusOK().
+		ExpectBodyContains(`"routes"`).
+		ExpectBodyContains("/extra")
+}
+
+func TestGetConfigDeltaIsolation(t *testing.T) {
+	acme, globex := t.TempDir(), t.TempDir()
+	writeConfigFile(t, acme, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	writeConfigFile(t, globex, "routes.yaml", "routes:\n  - name: internal\n    path: /globex-internal\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: acme, Metadata: map[string]string{"tenant": "acme"}},
+			{Directory: globex, Metadata: map[string]string{"tenant": "globex"}},
+		},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	resp, _ := okapitest.GET(t, app.BaseURL+"/config").
+		Header("X-Goma-Meta-Tenant", "globex").
+		ExpectStatusOK().
+		Execute()
+	okapitest.GET(t, app.BaseURL+"/config").
+		Header("X-Goma-Meta-Tenant", "acme").
+		Header("If-Match", resp.Header.Get("ETag")).
+		Header("Prefer", "return=delta").
+		ExpectStatusOK().
+		ExpectHeaderContains("Content-Type", "application/json").
+		ExpectBodyContains(`"routes"`).
+		ExpectBodyNotContains("globex-internal")
+}
+
+func TestGetConfigChecksumGrace(t *testing.T) {
+	tests := []struct {
+		name   string
+		grace  time.Duration
+		status int
+	}{
+		{name: "within grace", grace: time.Minute, status: http.StatusNotModified},
+		{name: "disabled", grace: 0, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+			svc := newTestService(t, &config.ProviderConfig{
+				Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+				ChecksumGrace:  tt.grace,
+			})
+			app := okapi.NewTestServer(t)
+			app.Get("/config", svc.GetConfig)
+
+			resp, _ := okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().Execute()
+			previous := resp.Header.Get("ETag")
+
+			writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /v2/api\n")
+			if err := svc.Provider.Reload(); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, _ = okapitest.GET(t, app.BaseURL+"/config").
+				Header("If-None-Match", previous).
+				ExpectStatus(tt.status).
+				Execute()
+			if resp.Header.Get("ETag") == previous {
+				t.Error("expected the current checksum in the ETag header")
+			}
+			okapitest.GET(t, app.BaseURL+"/config").
+				Header("If-None-Match", "unknown").
+				ExpectStatusOK()
+		})
+	}
+}
+
+func TestGetSources(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	writeConfigFile(t, dir, "nested/more.yaml", "routes:\n  - name: web\n    path: /web\nmiddlewares:\n  - name: auth\n    type: basic\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+	app.Get("/sources", svc.GetSources)
+
+	var resp provider.BundleSources
+	okapitest.GET(t, app.BaseURL+"/sources").ExpectStatusOK().ParseJSON(&resp)
+	sources := resp.Sources
+	want := map[string]string{
+		"route/api":       filepath.Join(dir, "routes.yaml"),
+		"route/web":       filepath.Join(dir, "nested", "more.yaml"),
+		"middleware/auth": filepath.Join(dir, "nested", "more.yaml"),
+	}
+	if len(sources) != len(want) {
+		t.Fatalf("expected %d sources, got %+v", len(want), sources)
+	}
+	for _, source := range sources {
+		if file := want[source.Kind+"/"+source.Name]; file != source.File {
+			t.Errorf("%s %s: file = %s, want %s", source.Kind, source.Name, source.File, file)
+		}
+	}
+
+	// Provenance is not part of the served bundle
+	okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().ExpectBodyNotContains("more.yaml")
+}
+
+func TestGetConfigFields(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", `
+routes:
+  - name: api
+    path: /api
+    target: http://api:8080
+    hosts: [api.example.com]
+    healthCheck:
+      path: /healthz
+middlewares:
+  - name: auth
+    type: basic
+`)
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	resp, _ := okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().Execute()
+	etag := resp.Header.Get("ETag")
+
+	tests := []struct {
+		fields string
+		want   string
+	}{
+		{fields: "routes.path,routes.target", want: `{"routes":[{"path":"/api","target":"http://api:8080"}]}`},
+		{fields: "version,middlewares.name,routes.healthCheck.path", want: `{"middlewares":[{"name":"auth"}],"routes":[{"healthCheck":{"path":"/healthz"}}],"version":"1.0"}`},
+		{fields: "routes,routes.path", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			var body map[string]any
+			resp, _ := okapitest.GET(t, app.BaseURL+"/config?fields="+tt.fields).
+				ExpectStatusOK().
+				ParseJSON(&body).
+				Execute()
+			if resp.Header.Get("ETag") != etag {
+				t.Error("expected the ETag of the full bundle")
+			}
+			if tt.want == "" {
+				routes, _ := body["routes"].([]any)
+				if len(body) != 1 || len(routes) != 1 || len(routes[0].(map[string]any)) < 3 {
+					t.Errorf("expected a parent field to select whole routes, got %v", body)
+				}
+				return
+			}
+			got, _ := json.Marshal(body)
+			if string(got) != tt.want {
+				t.Errorf("fields=%s: got %s, want %s", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetConfigByID(t *testing.T) {
+	prod, staging := t.TempDir(), t.TempDir()
+	writeConfigFile(t, prod, "routes.yaml", "routes:\n  - name: prod\n    path: /prod\n")
+	writeConfigFile(t, staging, "routes.yaml", "routes:\n  - name: staging\n    path: /staging\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: prod, Metadata: map[string]string{"env": "prod"}},
+			{Directory: staging, Metadata: map[string]string{"env": "staging"}},
+		},
+	})
+	stagingID := svc.Provider.BuildCacheKey(map[string]string{"env": "staging"})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	// Metadata matching stays the default
+	okapitest.GET(t, app.BaseURL+"/config").
+		Header("X-Goma-Meta-Env", "prod").
+		ExpectStatusOK().
+		ExpectBodyContains("/prod")
+
+	// The ID wins over metadata
+	okapitest.GET(t, app.BaseURL+"/config").
+		Header(provider.ConfigIDHeader, stagingID).
+		Header("X-Goma-Meta-Env", "prod").
+		ExpectStatusOK().
+		ExpectBodyContains("/staging")
+	okapitest.GET(t, app.BaseURL+"/config?env=prod&configId="+url.QueryEscape(stagingID)).
+		ExpectStatusOK().
+		ExpectBodyContains("/staging")
+
+	okapitest.GET(t, app.BaseURL+"/config").
+		Header(provider.ConfigIDHeader, "unknown").
+		ExpectStatusNotFound()
+}
+
+func TestGetConfigEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	var raw config.ConfigBundle
+	resp, _ := okapitest.GET(t, app.BaseURL+"/config").
+		ExpectStatusOK().
+		ExpectBodyNotContains("providerVersion").
+		ParseJSON(&raw).
+		Execute()
+	etag := resp.Header.Get("ETag")
+	if provider.WeakETag(raw.Checksum) != etag || len(raw.Routes) != 1 {
+		t.Fatalf("expected the raw bundle with checksum %s, got %+v", etag, raw)
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+	}{
+		{name: "query", url: "/config?envelope=true"},
+		{name: "accept profile", url: "/config", accept: `application/json; profile="envelope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var envelope struct {
+				ConfigID        string              `json:"configId"`
+				Checksum        string              `json:"checksum"`
+				ServerTime      time.Time           `json:"serverTime"`
+				ProviderVersion string              `json:"providerVersion"`
+				Bundle          config.ConfigBundle `json:"bundle"`
+			}
+			req := okapitest.GET(t, app.BaseURL+tt.url)
+			if tt.accept != "" {
+				req = req.Header("Accept", tt.accept)
+			}
+			resp, _ := req.ExpectStatusOK().ParseJSON(&envelope).Execute()
+			if resp.Header.Get("ETag") != etag {
+				t.Errorf("expected the ETag of the bundle, got %s", resp.Header.Get("ETag"))
+			}
+			if envelope.ConfigID != "default" || envelope.Checksum != raw.Checksum || envelope.ProviderVersion == "" || envelope.ServerTime.IsZero() {
+				t.Errorf("unexpected envelope %+v", envelope)
+			}
+			if envelope.Bundle.Checksum != raw.Checksum || len(envelope.Bundle.Routes) != 1 {
+				t.Errorf("expected the bundle in the envelope, got %+v", envelope.Bundle)
+			}
+		})
+	}
+
+	// The envelope does not change revalidation
+	okapitest.GET(t, app.BaseURL+"/config?envelope=true").
+		Header("If-None-Match", etag).
+		ExpectStatus(http.StatusNotModified)
+}
+
+// gatedSource loads an empty bundle, blocking while its gate is closed
+type gatedSource struct {
+	gate chan struct{}
+}
+
+func (s *gatedSource) Load(_ context.Context) (*config.ConfigBundle, string, error) {
+	<-s.gate
+	return &config.ConfigBundle{Version: "1.0", Metadata: map[string]string{}}, "", nil
+}
+
+func (s *gatedSource) Changed(_ context.Context, _ string) (bool, error) {
+	return true, nil
+}
+
+func TestReloadConfigAsync(t *testing.T) {
+	source := &gatedSource{gate: make(chan struct{})}
+	close(source.gate)
+	provider.RegisterSource("gated-test", func(_ *provider.HTTPProvider, _ *config.Configuration) (provider.Source, error) {
+		return source, nil
+	})
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Source: "gated-test", Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config/reload", svc.ReloadConfig)
+	app.Get("/config/reload/{id}", svc.GetReloadJob)
+
+	// Hold the background reload until released
+	source.gate = make(chan struct{})
+	var job provider.ReloadJob
+	resp, _ := okapitest.GET(t, app.BaseURL+"/config/reload?async=true").
+		ExpectStatus(http.StatusAccepted).
+		ParseJSON(&job).
+		Execute()
+	if job.ID == "" || job.Status != provider.ReloadRunning {
+		t.Fatalf("expected a running reload job, got %+v", job)
+	}
+	if got := resp.Header.Get("Location"); got != "/config/reload/"+job.ID {
+		t.Fatalf("expected the job status URL in Location, got %q", got)
+	}
+
+	// Reloads requested while one is running join it
+	var joined provider.ReloadJob
+	okapitest.GET(t, app.BaseURL+"/config/reload?async=true").
+		ExpectStatus(http.StatusAccepted).
+		ParseJSON(&joined)
+	if joined.ID != job.ID {
+		t.Fatalf("expected the in-progress job %s, got %s", job.ID, joined.ID)
+	}
+	okapitest.GET(t, app.BaseURL+"/config/reload/"+job.ID).
+		ExpectStatusOK().
+		ExpectBodyContains(provider.ReloadRunning)
+
+	close(source.gate)
+	deadline := time.Now().Add(time.Second)
+	for {
+		var status provider.ReloadJob
+		okapitest.GET(t, app.BaseURL+"/config/reload/"+job.ID).ExpectStatusOK().ParseJSON(&status)
+		if status.Status == provider.ReloadSucceeded && status.FinishedAt != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the reload job to succeed, got %+v", status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A new reload starts a new job once the previous one finished
+	var next provider.ReloadJob
+	okapitest.GET(t, app.BaseURL+"/config/reload?async=true").
+		ExpectStatus(http.StatusAccepted).
+		ParseJSON(&next)
+	if next.ID == job.ID {
+		t.Fatal("expected a new reload job")
+	}
+	okapitest.GET(t, app.BaseURL+"/config/reload/unknown").ExpectStatusNotFound()
+}
+
+func TestGetConfigMatchStrategy(t *testing.T) {
+	prod, fallback := t.TempDir(), t.TempDir()
+	writeConfigFile(t, prod, "routes.yaml", "routes:\n  - name: prod\n    path: /prod\n")
+	writeConfigFile(t, fallback, "routes.yaml", "routes:\n  - name: fallback\n    path: /fallback\n")
+	newService := func(conf *config.ProviderConfig, prodStrategy string) *ProviderService {
+		conf.Configurations = []*config.Configuration{
+			{Directory: prod, Metadata: map[string]string{"env": "prod", "region": "eu"}, MatchStrategy: prodStrategy},
+			{Directory: fallback, Default: true},
+		}
+		return newTestService(t, conf)
+	}
+	app := okapi.NewTestServer(t)
+	app.Get("/config", newService(&config.ProviderConfig{}, "").GetConfig)
+
+	tests := []struct {
+		query  string
+		status int
+		want   string
+	}{
+		{query: "env=prod", status: http.StatusOK, want: "/prod"},
+		{query: "env=prod&match=best", status: http.StatusOK, want: "/prod"},
+		{query: "env=prod&match=strict", status: http.StatusNotFound},
+		{query: "env=prod&region=eu&zone=a&match=strict", status: http.StatusOK, want: "/prod"},
+		{query: "env=prod&region=eu&match=exact", status: http.StatusOK, want: "/prod"},
+		{query: "env=prod&region=eu&zone=a&match=exact", status: http.StatusNotFound},
+		{query: "env=staging", status: http.StatusOK, want: "/fallback"},
+		{query: "env=staging&match=strict", status: http.StatusNotFound},
+		{query: "env=prod&match=fuzzy", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := okapitest.GET(t, app.BaseURL+"/config?"+tt.query).ExpectStatus(tt.status)
+			if tt.want != "" {
+				req.ExpectBodyContains(tt.want)
+			}
+		})
+	}
+
+	// Deployments bound the strategies requests may select
+	svc := newService(&config.ProviderConfig{AllowedMatchStrategies: []string{config.MatchBest}}, "")
+	prodMetadata := map[string]string{"env": "prod", "region": "eu"}
+	if _, _, err := svc.Provider.GetConfigMatching(t.Context(), prodMetadata, config.MatchStrict); !errors.Is(err, provider.ErrMatchStrategy) {
+		t.Fatalf("expected a disallowed strategy error, got %v", err)
+	}
+
+	// A configuration strategy applies when the request selects none
+	svc = newService(&config.ProviderConfig{}, config.MatchStrict)
+	if _, cfg, _ := svc.Provider.GetConfigMatching(t.Context(), map[string]string{"env": "prod"}, ""); cfg == nil || !cfg.Default {
+		t.Fatalf("expected the strict configuration to be skipped, got %+v", cfg)
+	}
+	if _, cfg, _ := svc.Provider.GetConfigMatching(t.Context(), map[string]string{"env": "prod"}, config.MatchBest); cfg == nil || cfg.Default {
+		t.Fatalf("expected the request strategy to win, got %+v", cfg)
+	}
+}
+
+func TestConfigResolvedOncePerRequest(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: first\n    path: /first\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	// The middleware resolves the config, then the config changes before the handler runs
+	app.Use(func(next okapi.HandlerFunc) okapi.HandlerFunc {
+		return func(c *okapi.Context) error {
+			if _, _, err := svc.configBundle(c); err != nil {
+				return err
+			}
+			writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: second\n    path: /second\n")
+			if err := svc.Provider.Reload(); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	})
+	app.Get("/config", svc.GetConfig)
+
+	okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().ExpectBodyContains("/first")
+	okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().ExpectBodyContains("/second")
+}
+
+func TestGetRateLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", `
+routes:
+  - name: api
+    path: /api
+    middlewares: [api-limit, global-limit]
+  - name: web
+    path: /web
+    middlewares: [global-limit]
+middlewares:
+  - name: api-limit
+    type: rateLimit
+    paths: [/login, /users]
+    rule:
+      unit: second
+      requestsPerUnit: 5
+  - name: global-limit
+    type: rateLimit
+    paths: [/login]
+    rule:
+      unit: minute
+      requestsPerUnit: 60
+`)
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/ratelimits", svc.GetRateLimits)
+
+	var resp provider.BundleRateLimits
+	okapitest.GET(t, app.BaseURL+"/ratelimits").ExpectStatusOK().ParseJSON(&resp)
+	want := []provider.RateLimit{
+		// 60 per minute is more restrictive than 5 per second
+		{Path: "/api/login", Route: "api", Middleware: "global-limit", Unit: "minute", RequestsPerUnit: 60},
+		{Path: "/api/users", Route: "api", Middleware: "api-limit", Unit: "second", RequestsPerUnit: 5},
+		{Path: "/web/login", Route: "web", Middleware: "global-limit", Unit: "minute", RequestsPerUnit: 60},
+	}
+	if !slices.Equal(resp.RateLimits, want) {
+		t.Fatalf("expected rate limits %+v, got %+v", want, resp.RateLimits)
+	}
+
+	writeConfigFile(t, dir, "broken.yaml", "middlewares:\n  - name: broken\n    type: rateLimit\n    rule:\n      unit: day\n      requestsPerUnit: 1\n")
+	if err := svc.Provider.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	okapitest.GET(t, app.BaseURL+"/ratelimits").ExpectStatus(http.StatusInternalServerError)
+}
+
+func TestGetBundleSections(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	writeConfigFile(t, dir, "middlewares.yaml", "middlewares:\n  - name: auth\n    type: basic\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/routes", svc.GetRoutes)
+	app.Get("/middlewares", svc.GetMiddlewares)
+
+	var routes provider.RoutesSection
+	resp := okapitest.GET(t, app.BaseURL+"/routes").ExpectStatusOK().ExpectBodyNotContains("auth")
+	resp.ParseJSON(&routes)
+	if len(routes.Routes) != 1 || routes.Routes[0].Name != "api" {
+		t.Fatalf("expected only the api route, got %+v", routes)
+	}
+	var middlewares provider.MiddlewaresSection
+	okapitest.GET(t, app.BaseURL+"/middlewares").ExpectStatusOK().ExpectBodyNotContains("/api").ParseJSON(&middlewares)
+	if len(middlewares.Middlewares) != 1 || middlewares.Middlewares[0].Name != "auth" {
+		t.Fatalf("expected only the auth middleware, got %+v", middlewares)
+	}
+
+	routesETag := provider.SectionChecksum(routes)
+	middlewaresETag := provider.SectionChecksum(middlewares)
+	okapitest.GET(t, app.BaseURL+"/routes").ExpectStatusOK().ExpectHeader("ETag", provider.WeakETag(routesETag))
+	okapitest.GET(t, app.BaseURL+"/routes").Header("If-None-Match", routesETag).ExpectStatus(http.StatusNotModified)
+
+	// A middleware change leaves the routes ETag unchanged
+	writeConfigFile(t, dir, "middlewares.yaml", "middlewares:\n  - name: auth\n    type: jwt\n")
+	if err := svc.Provider.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	okapitest.GET(t, app.BaseURL+"/routes").Header("If-None-Match", routesETag).ExpectStatus(http.StatusNotModified)
+	okapitest.GET(t, app.BaseURL+"/middlewares").Header("If-None-Match", middlewaresETag).ExpectStatusOK()
+}
+
+func TestGetConfigBatch(t *testing.T) {
+	prod, private := t.TempDir(), t.TempDir()
+	writeConfigFile(t, prod, "routes.yaml", "routes:\n  - name: prod\n    path: /prod\n")
+	writeConfigFile(t, private, "routes.yaml", "routes:\n  - name: private\n    path: /private\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: prod, Metadata: map[string]string{"env": "prod"}},
+			{Directory: private, Metadata: map[string]string{"env": "private"}, Auth: &config.HTTPAuth{
+				BasicAuth: &config.BasicAuth{Username: "admin", Password: "secret"},
+			}},
+		},
+	})
+	app := okapi.NewTestServer(t)
+	app.Post("/batch", svc.GetConfigBatch)
+
+	var resp BatchResponse
+	okapitest.POST(t, app.BaseURL+"/batch").
+		JSONBody(BatchRequest{Items: []map[string]string{{"env": "prod"}, {"env": "missing"}, {"env": "private"}}}).
+		ExpectStatusOK().ParseJSON(&resp)
+	if len(resp.Items) != 3 {
+		t.Fatalf("expected 3 items, got %+v", resp.Items)
+	}
+	if item := resp.Items[0]; item.Status != http.StatusOK || item.Bundle == nil || item.Bundle.Routes[0].Name != "prod" || item.Checksum == "" {
+		t.Errorf("expected the prod bundle, got %+v", item)
+	}
+	if item := resp.Items[1]; item.Index != 1 || item.Status != http.StatusNotFound || item.Bundle != nil {
+		t.Errorf("expected the unmatched item not found, got %+v", item)
+	}
+	if item := resp.Items[2]; item.Status != http.StatusUnauthorized || item.Bundle != nil {
+		t.Errorf("expected the private item unauthorized, got %+v", item)
+	}
+
+	// Credentials apply to every item, checksums can be requested alone
+	resp = BatchResponse{}
+	okapitest.POST(t, app.BaseURL+"/batch").
+		SetBasicAuth("admin", "secret").
+		JSONBody(BatchRequest{Items: []map[string]string{{"env": "private"}}, ChecksumOnly: true}).
+		ExpectStatusOK().ParseJSON(&resp)
+	if item := resp.Items[0]; item.Status != http.StatusOK || item.Bundle != nil || item.Checksum == "" {
+		t.Errorf("expected the private checksum only, got %+v", item)
+	}
+}
+
+func TestGetConfigWeakETag(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/v1", svc.GetConfig)
+	app.Get("/v2", svc.GetConfigV2)
+
+	var bundle config.ConfigBundle
+	okapitest.GET(t, app.BaseURL+"/v1").ExpectStatusOK().ParseJSON(&bundle)
+	etag := provider.WeakETag(bundle.Checksum)
+	if etag != `W/"`+bundle.Checksum+`"` {
+		t.Fatalf("unexpected weak ETag %s", etag)
+	}
+
+	// Every representation of the bundle carries the same weak ETag
+	for _, path := range []string{"/v1", "/v2", "/v1?envelope=true", "/v1?fields=routes"} {
+		okapitest.GET(t, app.BaseURL+path).
+			ExpectStatusOK().
+			ExpectHeader("ETag", etag).
+			ExpectHeader("Vary", "Accept, Accept-Encoding")
+	}
+
+	for _, ifNoneMatch := range []string{etag, `"` + bundle.Checksum + `"`, bundle.Checksum, `W/"other", ` + etag, "*"} {
+		okapitest.GET(t, app.BaseURL+"/v2").Header("If-None-Match", ifNoneMatch).ExpectStatus(http.StatusNotModified)
+	}
+	okapitest.GET(t, app.BaseURL+"/v1").Header("If-None-Match", `W/"other"`).ExpectStatusOK()
+}
+
+func TestOperationAuth(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "prod"}, Auth: &config.HTTPAuth{
+			APIKey: "secret",
+			Operations: map[string]*config.OperationAuth{
+				config.OperationRead:   {Anonymous: true},
+				config.OperationReload: {APIKey: "reload-secret"},
+			},
+		}}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+	app.Get("/stats", svc.GetStats)
+	app.Post("/reload", svc.ReloadConfig)
+
+	// Reads are anonymous
+	okapitest.GET(t, app.BaseURL+"/config?env=prod").ExpectStatusOK()
+	// Stats keep the configuration auth
+	okapitest.GET(t, app.BaseURL+"/stats?env=prod").ExpectStatus(http.StatusUnauthorized)
+	okapitest.GET(t, app.BaseURL+"/stats?env=prod").Header("X-API-Key", "secret").ExpectStatusOK()
+	// Reloads require their own key
+	okapitest.POST(t, app.BaseURL+"/reload?env=prod").ExpectStatus(http.StatusUnauthorized)
+	okapitest.POST(t, app.BaseURL+"/reload?env=prod").Header("X-API-Key", "secret").ExpectStatus(http.StatusUnauthorized)
+	okapitest.POST(t, app.BaseURL+"/reload?env=prod").Header("X-API-Key", "reload-secret").ExpectStatusOK()
+}
+
+func TestGetConfigSourceHeader(t *testing.T) {
+	tenant, fallback := t.TempDir(), t.TempDir()
+	writeConfigFile(t, tenant, "routes.yaml", "routes:\n  - name: tenant\n    path: /tenant\n")
+	writeConfigFile(t, fallback, "routes.yaml", "routes:\n  - name: fallback\n    path: /fallback\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{
+			{Directory: tenant, Metadata: map[string]string{"tenant": "acme"}},
+			{Directory: fallback, Default: true},
+		},
+		CacheTTL: 50 * time.Millisecond,
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	okapitest.GET(t, app.BaseURL+"/config?tenant=acme").
+		ExpectStatusOK().
+		ExpectHeader(provider.ConfigSourceHeader, provider.SourceCache)
+	okapitest.GET(t, app.BaseURL+"/config?tenant=unknown").
+		ExpectStatusOK().
+		ExpectHeader(provider.ConfigSourceHeader, provider.SourceDefaultFallback).
+		ExpectBodyContains("/fallback")
+
+	time.Sleep(100 * time.Millisecond)
+	okapitest.GET(t, app.BaseURL+"/config?tenant=acme").
+		ExpectStatusOK().
+		ExpectHeader(provider.ConfigSourceHeader, provider.SourceLazyReload)
+	okapitest.GET(t, app.BaseURL+"/config?tenant=acme").
+		ExpectStatusOK().
+		ExpectHeader(provider.ConfigSourceHeader, provider.SourceCache)
+}
+
+func TestGetConfigJWT(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "prod"}}},
+		JWTSigningKey:  keyFile,
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	resp, body := okapitest.GET(t, app.BaseURL+"/config?env=prod").ExpectStatusOK().Execute()
+	var bundle config.ConfigBundle
+	if err := json.Unmarshal(body, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	var claims provider.ConfigClaims
+	_, err = jwt.ParseWithClaims(resp.Header.Get(provider.ConfigJWTHeader), &claims, func(*jwt.Token) (any, error) {
+		return public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithIssuer(provider.JWTIssuer), jwt.WithIssuedAt())
+	if err != nil {
+		t.Fatalf("invalid config JWT: %v", err)
+	}
+	if claims.Checksum != bundle.Checksum || claims.ConfigID != "env=prod" || claims.IssuedAt == nil {
+		t.Fatalf("claims don't match the served bundle %s: %+v", bundle.Checksum, claims)
+	}
+
+	// Without a signing key no JWT is served, and an invalid key fails the start
+	unsigned := newTestService(t, &config.ProviderConfig{Configurations: []*config.Configuration{{Directory: dir, Default: true}}})
+	if token, _ := unsigned.Provider.ConfigJWT(unsigned.Provider.Configurations()[0], &bundle); token != "" {
+		t.Fatalf("expected no JWT without a signing key, got %s", token)
+	}
+	if _, err := provider.NewHTTPProvider(&config.ProviderConfig{JWTSigningKey: filepath.Join(dir, "routes.yaml")}); err == nil {
+		t.Fatal("expected an invalid signing key to fail")
+	}
+}
+
+func TestGetConfigGoma(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", `routes:
+  - name: api
+    path: /api
+    target: http://api:8080
+    middlewares: [api-limit]
+    flag: beta
+  - name: web
+    path: /
+    hosts: [example.com]
+    backends:
+      - endpoint: http://web-1:8080
+      - endpoint: http://web-2:8080
+middlewares:
+  - name: api-limit
+    type: rateLimit
+    paths: [/]
+    rule:
+      unit: minute
+      requestsPerUnit: 60
+observability:
+  tracing: true
+`)
+	invalid := t.TempDir()
+	writeConfigFile(t, invalid, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations:    []*config.Configuration{{Directory: dir, Metadata: map[string]string{"env": "prod"}}},
+		Flags:             map[string]bool{"beta": true},
+		PassthroughFields: true,
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/goma", svc.GetConfigGoma)
+	app.Get("/invalid", newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: invalid, Default: true}},
+	}).GetConfigGoma)
+
+	// The timestamp is the load time, the rest must match the Goma schema exactly
+	var served map[string]any
+	okapitest.GET(t, app.BaseURL+"/goma?env=prod").ExpectStatusOK().ParseJSON(&served)
+	served["timestamp"] = "2025-01-01T00:00:00Z"
+	got, err := json.MarshalIndent(served, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "goma_bundle.golden.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != strings.TrimSpace(string(want)) {
+		t.Fatalf("goma bundle doesn't match the golden fixture:\n%s", got)
+	}
+
+	okapitest.GET(t, app.BaseURL+"/invalid").
+		ExpectStatus(http.StatusInternalServerError).
+		ExpectBodyContains("target or backends is required")
+}
+
+func TestGetConfigMtime(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n")
+	file := filepath.Join(dir, "routes.yaml")
+	loaded := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(file, loaded, loaded); err != nil {
+		t.Fatal(err)
+	}
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	okapitest.GET(t, app.BaseURL+"/config").
+		ExpectStatusOK().
+		ExpectHeader(provider.ConfigMtimeHeader, "2025-01-01T00:00:00Z")
+
+	// Touching a file without changing it is reported once reloaded
+	touched := loaded.Add(time.Hour)
+	if err := os.Chtimes(file, touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	okapitest.GET(t, app.BaseURL+"/config").
+		ExpectStatusOK().
+		ExpectHeader(provider.ConfigMtimeHeader, "2025-01-01T00:00:00Z")
+	if err := svc.Provider.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	okapitest.GET(t, app.BaseURL+"/config").
+		ExpectStatusOK().
+		ExpectHeader(provider.ConfigMtimeHeader, "2025-01-01T01:00:00Z")
+
+	stats := svc.Provider.GetStats("default")
+	if stats.ConfigMtime == nil || !stats.ConfigMtime.Equal(touched) {
+		t.Fatalf("expected the stats to report %s, got %v", touched, stats.ConfigMtime)
+	}
+}
+
+// largeBundle returns a config file with n routes, a multi-megabyte bundle for n in the thousands
+func largeBundle(n int) string {
+	var b strings.Builder
+	b.WriteString("routes:\n")
+	for i := range n {
+		fmt.Fprintf(&b, "  - name: route-%d\n    path: /route-%d\n    target: http://backend-%d:8080\n", i, i, i)
+		fmt.Fprintf(&b, "    hosts: [route-%d.example.com]\n    methods: [GET, POST]\n", i)
+		fmt.Fprintf(&b, "    maintenance:\n      message: %q\n", strings.Repeat("maintenance ", 20))
+	}
+	b.WriteString("middlewares:\n  - name: limit\n    type: rateLimit\n")
+	return b.String()
+}
+
+func TestMetadataConflicts(t *testing.T) {
+	acme, globex := t.TempDir(), t.TempDir()
+	writeConfigFile(t, acme, "routes.yaml", "routes:\n  - name: acme\n    path: /acme\n")
+	writeConfigFile(t, globex, "routes.yaml", "routes:\n  - name: globex\n    path: /globex\n")
+	configurations := []*config.Configuration{
+		{Directory: acme, Metadata: map[string]string{"tenant": "acme"}},
+		{Directory: globex, Metadata: map[string]string{"tenant": "globex"}},
+	}
+	lastWins := newTestService(t, &config.ProviderConfig{Configurations: configurations})
+	reject := newTestService(t, &config.ProviderConfig{
+		Configurations:    configurations,
+		MetadataConflicts: config.MetadataConflictsReject,
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/last-wins", lastWins.GetConfig)
+	app.Get("/reject", reject.GetConfig)
+
+	// The header extractor runs after the query one, so its value wins
+	okapitest.GET(t, app.BaseURL+"/last-wins?tenant=acme").
+		Header("X-Goma-Meta-Tenant", "globex").
+		ExpectStatusOK().
+		ExpectBodyContains("/globex")
+	okapitest.GET(t, app.BaseURL+"/reject?tenant=acme").
+		Header("X-Goma-Meta-Tenant", "globex").
+		ExpectStatusBadRequest().
+		ExpectBodyContains("tenant")
+
+	// Sources agreeing on a value are not a conflict
+	okapitest.GET(t, app.BaseURL+"/reject?tenant=acme").
+		Header("X-Goma-Meta-Tenant", "acme").
+		ExpectStatusOK().
+		ExpectBodyContains("/acme")
+}
+
+func TestRenderOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n    healthCheck:\n      path: /healthz\n      interval: 10s\n      timeout: 1500ms\n")
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	type rendered struct {
+		Timestamp any `json:"timestamp"`
+		Routes    []struct {
+			HealthCheck struct {
+				Interval any `json:"interval"`
+				Timeout  any `json:"timeout"`
+			} `json:"healthCheck"`
+		} `json:"routes"`
+	}
+	get := func(query, accept string) (rendered, string) {
+		t.Helper()
+		req := okapitest.GET(t, app.BaseURL+"/config"+query)
+		if accept != "" {
+			req = req.Header("Accept", accept)
+		}
+		resp, body := req.ExpectStatusOK().Execute()
+		var bundle rendered
+		if err := json.Unmarshal(body, &bundle); err != nil {
+			t.Fatal(err)
+		}
+		return bundle, resp.Header.Get("ETag")
+	}
+
+	bundle, etag := get("", "")
+	if _, ok := bundle.Timestamp.(string); !ok || bundle.Routes[0].HealthCheck.Interval != "10s" {
+		t.Fatalf("expected the default renderings, got %+v", bundle)
+	}
+	for _, tc := range []struct{ query, accept string }{
+		{query: "?timeFormat=unix&durationFormat=ms"},
+		{accept: `application/json; profile="unix-time ms-durations"`},
+	} {
+		bundle, got := get(tc.query, tc.accept)
+		if _, ok := bundle.Timestamp.(float64); !ok {
+			t.Errorf("%+v: expected a unix timestamp, got %v", tc, bundle.Timestamp)
+		}
+		if hc := bundle.Routes[0].HealthCheck; hc.Interval != float64(10000) || hc.Timeout != float64(1500) {
+			t.Errorf("%+v: expected durations in milliseconds, got %+v", tc, hc)
+		}
+		if got != etag {
+			t.Errorf("%+v: expected the ETag %s to be independent of the rendering, got %s", tc, etag, got)
+		}
+	}
+
+	okapitest.GET(t, app.BaseURL+"/config?timeFormat=epoch").ExpectStatusBadRequest()
+}
+
+func TestBrotliResponses(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", largeBundle(50))
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	decode := map[string]func(io.Reader) (io.Reader, error){
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"":     func(r io.Reader) (io.Reader, error) { return r, nil },
+	}
+	for _, tc := range []struct{ accept, want string }{
+		{accept: "gzip, deflate, br", want: "br"},
+		{accept: "br;q=0.5, gzip", want: "gzip"},
+		{accept: "gzip", want: "gzip"},
+		{accept: "br;q=0, gzip;q=0", want: ""},
+		{accept: "identity", want: ""},
+	} {
+		resp, body := okapitest.GET(t, app.BaseURL+"/config").
+			Header("Accept-Encoding", tc.accept).
+			ExpectStatusOK().
+			Execute()
+		if got := resp.Header.Get("Content-Encoding"); got != tc.want {
+			t.Fatalf("Accept-Encoding %q: expected Content-Encoding %q, got %q", tc.accept, tc.want, got)
+		}
+		r, err := decode[tc.want](bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bundle config.ConfigBundle
+		if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+			t.Fatalf("Accept-Encoding %q: failed to decode the body: %v", tc.accept, err)
+		}
+		if len(bundle.Routes) != 50 || provider.WeakETag(bundle.Checksum) != resp.Header.Get("ETag") {
+			t.Fatalf("Accept-Encoding %q: expected the full bundle, got %d routes", tc.accept, len(bundle.Routes))
+		}
+	}
+}
+
+func TestPinnedVersions(t *testing.T) {
+	dir := t.TempDir()
+	svc := newTestService(t, &config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+		BundleHistory:  2,
+	})
+	app := okapi.NewTestServer(t)
+	app.Get("/config", svc.GetConfig)
+
+	var checksums []string
+	for _, target := range []string{"http://v1", "http://v2", "http://v3"} {
+		writeConfigFile(t, dir, "routes.yaml", "routes:\n  - name: api\n    path: /api\n    target: "+target+"\n")
+		if err := svc.Provider.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		var bundle config.ConfigBundle
+		okapitest.GET(t, app.BaseURL+"/config").ExpectStatusOK().ParseJSON(&bundle)
+		checksums = append(checksums, bundle.Checksum)
+	}
+
+	get := func(version string) config.ConfigBundle {
+		t.Helper()
+		var bundle config.ConfigBundle
+		okapitest.GET(t, app.BaseURL+"/config?version="+version).ExpectStatusOK().ParseJSON(&bundle)
+		return bundle
+	}
+	if current := get("latest"); current.Checksum != checksums[2] || current.Routes[0].Target != "http://v3" {
+		t.Fatalf("expected latest to serve the current bundle, got %s", current.Checksum)
+	}
+	if old := get(checksums[1]); old.Checksum != checksums[1] || old.Routes[0].Target != "http://v2" {
+		t.Fatalf("expected the retained version to be served, got %s", old.Checksum)
+	}
+	okapitest.GET(t, app.BaseURL+"/config?version="+checksums[0]).ExpectStatusNotFound()
+	okapitest.GET(t, app.BaseURL+"/config?version=unknown").ExpectStatusNotFound()
+}
+
+func TestStreamResponses(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "routes.yaml", largeBundle(500)+"certificates:\n  - name: default\n")
+	conf := func(stream bool) *config.ProviderConfig {
+		return &config.ProviderConfig{
+			Configurations:    []*config.Configuration{{Directory: dir, Default: true}},
+			PassthroughFields: true,
+			StreamResponses:   stream,
+		}
+	}
+	streamed, buffered := newTestService(t, conf(true)), newTestService(t, conf(false))
+	app := okapi.NewTestServer(t)
+	app.Get("/v1/streamed", streamed.GetConfig)
+	app.Get("/v1/buffered", buffered.GetConfig)
+	app.Get("/v2/streamed", streamed.GetConfigV2)
+	app.Get("/v2/buffered", buffered.GetConfigV2)
+
+	for _, version := range []string{"v1", "v2"} {
+		var want, got map[string]any
+		okapitest.GET(t, app.BaseURL+"/"+version+"/buffered").ExpectStatusOK().ParseJSON(&want)
+		resp, _ := okapitest.GET(t, app.BaseURL+"/"+version+"/streamed").
+			ExpectStatusOK().
+			ExpectHeader("Content-Type", "application/json").
+			ParseJSON(&got).
+			Execute()
+		if !slices.Contains(resp.TransferEncoding, "chunked") {
+			t.Errorf("%s: expected a chunked response, got %v", version, resp.TransferEncoding)
+		}
+		// Both services loaded the same files at slightly different times
+		delete(want, "timestamp")
+		delete(got, "timestamp")
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: expected the streamed bundle to match the buffered one", version)
+		}
+		if _, ok := got["certificates"]; !ok {
+			t.Errorf("%s: expected the passthrough fields to be streamed", version)
+		}
+	}
+
+	// The ETag still gates streamed responses
+	resp, _ := okapitest.GET(t, app.BaseURL+"/v1/streamed").ExpectStatusOK().Execute()
+	okapitest.GET(t, app.BaseURL+"/v1/streamed").
+		Header("If-None-Match", resp.Header.Get("ETag")).
+		ExpectStatus(http.StatusNotModified)
+}
+
+// BenchmarkEncodeLargeBundle compares the allocations of buffered and streamed encoding
+// of a multi-megabyte bundle, the streamed encoder never holds the whole encoded bundle
+func BenchmarkEncodeLargeBundle(b *testing.B) {
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(largeBundle(5000)), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	p, err := provider.NewHTTPProvider(&config.ProviderConfig{
+		Configurations: []*config.Configuration{{Directory: dir, Default: true}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	bundle, _, err := p.GetConfig(b.Context(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := json.NewEncoder(io.Discard).Encode(bundle); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			w := bufio.NewWriterSize(io.Discard, streamBufferSize)
+			if err := bundle.EncodeJSON(w); err != nil {
+				b.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,10 +1,27 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jkaninda/goma-http-provider/internal/config"
+	"github.com/jkaninda/goma-http-provider/internal/metrics"
 	"github.com/jkaninda/goma-http-provider/internal/provider"
+	"github.com/jkaninda/goma-http-provider/utils"
 	"github.com/jkaninda/okapi"
 )
 
@@ -12,63 +29,996 @@ type ProviderService struct {
 	Provider *provider.HTTPProvider
 }
 
+// HealthCheck reports the provider is alive. With ?summary=true, or health summaries enabled,
+// it also includes a compact summary of the provider state, still answering 200 when not ready.
 func (p *ProviderService) HealthCheck(c okapi.C) error {
-	return c.OK(okapi.M{
+	response := okapi.M{
 		"status":  "healthy",
 		"service": "goma-gateway-http-provider",
-	})
+	}
+	summary, _ := strconv.ParseBool(c.Query("summary"))
+	// The liveness answer doesn't depend on the provider being set up
+	if p.Provider != nil && (summary || p.Provider.HealthSummaryEnabled()) {
+		response["summary"] = p.Provider.HealthSummary()
+	}
+	return c.OK(response)
+}
+
+// statusPage renders the provider status for browsers
+const statusPage = `<!DOCTYPE html>
+<html>
+<head><title>Goma HTTP Provider</title></head>
+<body>
+<h1>Goma HTTP Provider</h1>
+<table>
+<tr><td>Status</td><td>{{if .Ready}}Ready{{else if .Draining}}Draining{{else}}Not ready{{end}}</td></tr>
+{{if .StaleAlarms}}<tr><td>Stale</td><td>{{range .StaleAlarms}}{{.}} {{end}}</td></tr>{{end}}
+{{if .FailedConfigs}}<tr><td>Failed</td><td>{{range .FailedConfigs}}{{.}} {{end}}</td></tr>{{end}}
+<tr><td>Configurations loaded</td><td>{{.ConfigsLoaded}}</td></tr>
+<tr><td>Last reload</td><td>{{.LastReload.Format "2006-01-02T15:04:05Z07:00"}}</td></tr>
+<tr><td>Uptime</td><td>{{.Uptime}}</td></tr>
+<tr><td>Version</td><td>{{.Version}}</td></tr>
+</table>
+</body>
+</html>`
+
+// Status reports the aggregate provider state, as HTML when the client accepts it.
+// It responds 200 whether or not the provider is ready, readiness is reported in the body.
+func (p *ProviderService) Status(c okapi.C) error {
+	status := p.Provider.Status()
+	if acceptsHTML(c) {
+		return c.HTMLView(http.StatusOK, statusPage, status)
+	}
+	return c.OK(status)
+}
+
+// acceptsHTML reports whether the client accepts an HTML page, such as a browser
+func acceptsHTML(c okapi.C) bool {
+	for _, accept := range c.Accept() {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
+//go:embed templates
+var templates embed.FS
+
+// configurationsPage renders the configuration summaries for browsers
+var configurationsPage = template.Must(template.ParseFS(templates, "templates/configurations.html"))
+
+// ListConfigurations lists the configurations with their metadata, checksum and route and
+// middleware counts, as an HTML page linking to each bundle when the client accepts it.
+// The tag query parameter restricts the list to the configurations carrying it.
+func (p *ProviderService) ListConfigurations(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	summaries, err := p.Provider.Summaries()
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list configurations", err)
+	}
+	if tag := c.Query("tag"); tag != "" {
+		summaries = slices.DeleteFunc(summaries, func(summary provider.ConfigSummary) bool {
+			return !slices.Contains(summary.Tags, tag)
+		})
+	}
+	if !acceptsHTML(c) {
+		return c.OK(summaries)
+	}
+	var buf bytes.Buffer
+	if err := configurationsPage.Execute(&buf, summaries); err != nil {
+		return c.AbortInternalServerError("Failed to render configurations", err)
+	}
+	return c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
 }
 func (p *ProviderService) GetStats(c okapi.C) error {
 	_, cfg, err := p.configBundle(c)
 	if err != nil {
-		return c.AbortNotFound("Config not found", err)
+		return abortConfig(c, err)
+	}
+
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationStats); err != nil {
+		return c.AbortUnauthorized("Unauthorized", err)
+	}
+	return c.OK(p.Provider.GetStats(cfg.ID))
+}
+
+// GetSources reports the file each route and middleware of the matched bundle was loaded from
+func (p *ProviderService) GetSources(c okapi.C) error {
+	bundle, cfg, err := p.configBundle(c)
+	if err != nil {
+		return abortConfig(c, err)
+	}
+
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationRead); err != nil {
+		return c.AbortUnauthorized("Unauthorized", err)
+	}
+	sources := bundle.Sources
+	if sources == nil {
+		sources = []config.Source{}
+	}
+	return c.OK(provider.BundleSources{ConfigID: cfg.ID, Sources: sources})
+}
+
+// GetRateLimits summarizes the effective rate limits of the matched bundle per path
+func (p *ProviderService) GetRateLimits(c okapi.C) error {
+	bundle, cfg, err := p.configBundle(c)
+	if err != nil {
+		return abortConfig(c, err)
 	}
 
-	if err := p.Provider.Authenticate(c.Request(), cfg); err != nil {
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationRead); err != nil {
 		return c.AbortUnauthorized("Unauthorized", err)
 	}
-	return c.OK(p.Provider.GetStats())
+	limits, err := provider.RateLimits(bundle)
+	if err != nil {
+		return c.AbortInternalServerError("Invalid rate limit rule", err)
+	}
+	return c.OK(provider.BundleRateLimits{ConfigID: cfg.ID, RateLimits: limits})
 }
 func (p *ProviderService) ReloadConfig(c okapi.C) error {
 	_, cfg, err := p.configBundle(c)
 	if err != nil {
-		return c.AbortNotFound("Config not found", err)
+		return abortConfig(c, err)
 	}
 
-	if err := p.Provider.Authenticate(c.Request(), cfg); err != nil {
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationReload); err != nil {
 		return c.AbortUnauthorized("Unauthorized", err)
 	}
 
+	if async, _ := strconv.ParseBool(c.Query("async")); async {
+		job, started := p.Provider.ReloadAsync()
+		if started {
+			p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditReload, p.Provider.ConfigurationIDs(), map[string]string{"job": job.ID})
+		}
+		c.SetHeader("Location", strings.TrimSuffix(c.Request().URL.Path, "/")+"/"+job.ID)
+		return c.JSON(http.StatusAccepted, job)
+	}
+
 	if err := p.Provider.Reload(); err != nil {
 		return c.AbortInternalServerError("Reload failed", err)
 	}
+	p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditReload, p.Provider.ConfigurationIDs(), nil)
 	return c.OK(okapi.M{
 		"status":    "reloaded",
 		"timestamp": p.Provider.GetReloadTimestamp(),
 	})
 }
 
+// GetReloadJob reports the status of a background reload
+func (p *ProviderService) GetReloadJob(c okapi.C) error {
+	_, cfg, err := p.configBundle(c)
+	if err != nil {
+		return abortConfig(c, err)
+	}
+
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationReload); err != nil {
+		return c.AbortUnauthorized("Unauthorized", err)
+	}
+	job, ok := p.Provider.ReloadJobStatus(c.Param("id"))
+	if !ok {
+		return c.AbortNotFound("Reload job not found")
+	}
+	return c.OK(job)
+}
+
+// GetConfig serves the matched bundle in the v1 shape
 func (p *ProviderService) GetConfig(c okapi.C) error {
+	return p.serveConfig(c, func(bundle *config.ConfigBundle, _ *config.Configuration) (any, error) {
+		return bundle, nil
+	})
+}
+
+// GetConfigV2 serves the matched bundle in the v2 shape
+func (p *ProviderService) GetConfigV2(c okapi.C) error {
+	return p.serveConfig(c, func(bundle *config.ConfigBundle, cfg *config.Configuration) (any, error) {
+		v2 := &config.ConfigBundleV2{
+			ConfigBundle: bundle,
+			ConfigID:     cfg.ID,
+			Warnings:     bundle.Warnings,
+		}
+		if since := p.Provider.StaleSince(cfg.ID); !since.IsZero() {
+			v2.StaleSince = &since
+		}
+		return v2, nil
+	})
+}
+
+// GetConfigGoma serves the matched bundle in the Goma Gateway HTTP provider format,
+// after validating it against the Goma schema
+func (p *ProviderService) GetConfigGoma(c okapi.C) error {
+	return p.serveConfig(c, func(bundle *config.ConfigBundle, _ *config.Configuration) (any, error) {
+		return provider.GomaBundle(bundle)
+	})
+}
+
+// GetRoutes serves only the routes of the matched bundle, with an ETag over the routes
+func (p *ProviderService) GetRoutes(c okapi.C) error {
+	return p.serveSection(c, func(bundle *config.ConfigBundle) any {
+		return provider.RoutesSection{Routes: bundle.Routes}
+	})
+}
+
+// GetMiddlewares serves only the middlewares of the matched bundle, with an ETag over the middlewares
+func (p *ProviderService) GetMiddlewares(c okapi.C) error {
+	return p.serveSection(c, func(bundle *config.ConfigBundle) any {
+		return provider.MiddlewaresSection{Middlewares: bundle.Middlewares}
+	})
+}
+
+// serveSection resolves, authenticates and serves a subset of the matched bundle
+func (p *ProviderService) serveSection(c okapi.C, section func(*config.ConfigBundle) any) error {
+	bundle, cfg, err := p.configBundle(c)
+	if err != nil {
+		return abortConfig(c, err)
+	}
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationRead); err != nil {
+		return c.AbortUnauthorized("Unauthorized", err)
+	}
 
+	if p.Provider.ReportStale() && !p.Provider.StaleSince(cfg.ID).IsZero() {
+		c.SetHeader("X-Goma-Config-Stale", "true")
+	}
+	body := section(bundle)
+	etag := provider.SectionChecksum(body)
+	c.SetHeader("ETag", provider.WeakETag(etag))
+	c.SetHeader("Vary", "Accept, Accept-Encoding")
+	if provider.ETagMatches(c.Header("If-None-Match"), etag) {
+		return c.AbortWithStatus(http.StatusNotModified, "No change")
+	}
+	return c.OK(body)
+}
+
+// serveConfig resolves, authenticates and serves the matched bundle,
+// rendered by the given version specific shape
+func (p *ProviderService) serveConfig(c okapi.C, render func(*config.ConfigBundle, *config.Configuration) (any, error)) error {
+	start := time.Now()
+	opts, err := renderOptions(c)
+	if err != nil {
+		return c.AbortBadRequest("Invalid rendering", err)
+	}
 	bundle, cfg, err := p.configBundle(c)
 	if err != nil {
-		return c.AbortNotFound("Config not found", err)
+		metrics.GetConfigDuration.ObserveSince(start, "", "miss")
+		return abortConfig(c, err)
 	}
-	if err := p.Provider.Authenticate(c.Request(), cfg); err != nil {
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationRead); err != nil {
+		metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
 		return c.AbortUnauthorized("Unauthorized", err)
 	}
+	if version := c.Query(provider.VersionQueryParam); version != "" && version != provider.VersionLatest && version != bundle.Checksum {
+		pinned, err := p.Provider.VersionedBundle(cfg.ID, version)
+		if err != nil {
+			metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "miss")
+			return c.AbortNotFound("Version not found", err)
+		}
+		bundle = pinned
+	}
 
-	c.SetHeader("ETag", bundle.Checksum)
-	if c.Header("If-None-Match") == bundle.Checksum {
+	if p.Provider.ReportStale() && !p.Provider.StaleSince(cfg.ID).IsZero() {
+		c.SetHeader("X-Goma-Config-Stale", "true")
+	}
+	if cfg.Canary != nil {
+		c.SetHeader("X-Goma-Canary", strconv.FormatBool(bundle.Canary))
+	}
+	if source := configSource(c); source != "" {
+		c.SetHeader(provider.ConfigSourceHeader, source)
+	}
+	if mtime := p.Provider.ConfigMtime(cfg.ID); !mtime.IsZero() {
+		c.SetHeader(provider.ConfigMtimeHeader, mtime.UTC().Format(time.RFC3339Nano))
+	}
+	for _, warning := range bundle.Warnings {
+		c.ResponseWriter().Header().Add("Warning", fmt.Sprintf("299 goma-http-provider %q", warning))
+	}
+	c.SetHeader("ETag", provider.WeakETag(bundle.Checksum))
+	c.SetHeader("Vary", "Accept, Accept-Encoding")
+	if p.Provider.NotModified(cfg.ID, bundle, c.Header("If-None-Match")) {
+		metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "304")
 		return c.AbortWithStatus(http.StatusNotModified, "No change")
 	}
 
-	return c.OK(bundle)
+	token, err := p.Provider.ConfigJWT(cfg, bundle)
+	if err != nil {
+		metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+		return c.AbortInternalServerError("Failed to sign config", err)
+	}
+	if token != "" {
+		c.SetHeader(provider.ConfigJWTHeader, token)
+	}
+
+	if wantsDelta(c.Header("Prefer")) {
+		if from, ok := p.previousBundle(cfg.ID, c.Header("If-Match")); ok {
+			previous, err := render(from, cfg)
+			if err != nil {
+				metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+				return c.AbortInternalServerError("Failed to render bundle", err)
+			}
+			current, err := render(bundle, cfg)
+			if err != nil {
+				metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+				return c.AbortInternalServerError("Failed to render bundle", err)
+			}
+			if previous, err = provider.Render(previous, opts); err == nil {
+				current, err = provider.Render(current, opts)
+			}
+			if err != nil {
+				metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+				return c.AbortInternalServerError("Failed to render bundle", err)
+			}
+			ops, err := provider.Diff(previous, current)
+			if err != nil {
+				metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+				return c.AbortInternalServerError("Failed to compute delta", err)
+			}
+			data, err := json.Marshal(ops)
+			if err != nil {
+				metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+				return c.AbortInternalServerError("Failed to encode delta", err)
+			}
+			metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "delta")
+			c.SetHeader("Preference-Applied", "return=delta")
+			return writeBody(c, "application/json-patch+json", data)
+		}
+	}
+
+	body, err := render(bundle, cfg)
+	if err != nil {
+		metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+		return c.AbortInternalServerError("Failed to render bundle", err)
+	}
+	if fields := c.Query("fields"); fields != "" {
+		projected, err := provider.Project(body, strings.Split(fields, ","))
+		if err != nil {
+			metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+			return c.AbortInternalServerError("Failed to project bundle", err)
+		}
+		body = projected
+	}
+	envelope := wantsEnvelope(c)
+	if envelope {
+		body = config.ConfigEnvelope{
+			ConfigID:        cfg.ID,
+			Checksum:        bundle.Checksum,
+			ServerTime:      time.Now().UTC(),
+			ProviderVersion: utils.Version,
+			Bundle:          body,
+		}
+	}
+	if body, err = provider.Render(body, opts); err != nil {
+		metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+		return c.AbortInternalServerError("Failed to render bundle", err)
+	}
+
+	// The envelope carries the server time, so only bare bodies are worth caching compressed
+	if encoding := contentEncoding(c.Header("Accept-Encoding")); encoding != "" && !envelope {
+		data, err := json.Marshal(body)
+		if err == nil {
+			data, err = p.Provider.Encode(data, encoding)
+		}
+		if err != nil {
+			metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "error")
+			return c.AbortInternalServerError("Failed to encode bundle", err)
+		}
+		metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "hit")
+		c.SetHeader("Content-Encoding", encoding)
+		return writeBody(c, okapi.JSON, data)
+	}
+	metrics.GetConfigDuration.ObserveSince(start, cfg.ID, "hit")
+	if c.Request().Method == http.MethodHead {
+		// c.OK encodes with a json.Encoder, ending the body with a newline
+		data, err := json.Marshal(body)
+		if err != nil {
+			return c.AbortInternalServerError("Failed to encode bundle", err)
+		}
+		return writeBody(c, okapi.JSON, append(data, '\n'))
+	}
+	if stream, ok := body.(jsonStreamer); ok && p.Provider.StreamResponses() {
+		return streamJSON(c, stream)
+	}
+	return c.OK(body)
+}
+
+// writeBody writes the response body, or only the headers GET would send, Content-Length
+// included, when answering a HEAD request
+func writeBody(c okapi.C, contentType string, data []byte) error {
+	if c.Request().Method != http.MethodHead {
+		return c.Data(http.StatusOK, contentType, data)
+	}
+	c.SetHeader("Content-Type", contentType)
+	c.SetHeader("Content-Length", strconv.Itoa(len(data)))
+	c.ResponseWriter().WriteHeader(http.StatusOK)
+	return nil
+}
+
+// configMethods are the methods the config endpoints answer, listed by the Allow header
+var configMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// ConfigCapabilities summarizes what the config endpoint supports
+type ConfigCapabilities struct {
+	Methods     []string `json:"methods"`
+	Encodings   []string `json:"encodings"`
+	Profiles    []string `json:"profiles"`
+	QueryParams []string `json:"queryParams"`
+	Preferences []string `json:"preferences"`
+}
+
+// ConfigOptions answers OPTIONS on the config endpoints with the Allow header and a summary
+// of their capabilities. It serves no configuration, so it doesn't require auth.
+func (p *ProviderService) ConfigOptions(c okapi.C) error {
+	c.SetHeader("Allow", strings.Join(configMethods, ", "))
+	return c.OK(ConfigCapabilities{
+		Methods:     configMethods,
+		Encodings:   []string{provider.EncodingBrotli, provider.EncodingGzip},
+		Profiles:    []string{envelopeProfile, unixTimeProfile, msDurationsProfile},
+		QueryParams: []string{"fields", "match", "envelope", "timeFormat", "durationFormat", provider.VersionQueryParam, provider.ConfigIDQueryParam},
+		Preferences: []string{"return=delta"},
+	})
+}
+
+// jsonStreamer is a body able to encode itself to the response without buffering it whole
+type jsonStreamer interface {
+	EncodeJSON(w io.Writer) error
+}
+
+// streamBufferSize is the size of the chunks a streamed body is written in
+const streamBufferSize = 32 << 10
+
+// streamJSON writes the body in chunks as it is encoded. The response is committed
+// with the first chunk, so an encoding error can only cut it short.
+func streamJSON(c okapi.C, body jsonStreamer) error {
+	c.SetHeader("Content-Type", "application/json")
+	w := bufio.NewWriterSize(c.ResponseWriter(), streamBufferSize)
+	if err := body.EncodeJSON(w); err != nil {
+		return fmt.Errorf("failed to stream config bundle: %w", err)
+	}
+	return w.Flush()
+}
+
+// previousBundle returns the bundle of the first checksum listed by an If-Match header
+// that is still held in the bundle history of the configuration
+func (p *ProviderService) previousBundle(id, ifMatch string) (*config.ConfigBundle, bool) {
+	for _, checksum := range provider.ETagChecksums(ifMatch) {
+		if bundle, ok := p.Provider.PreviousBundle(id, checksum); ok {
+			return bundle, true
+		}
+	}
+	return nil, false
+}
+
+// Accept profiles selecting the representation of a bundle, several can be listed space separated
+const (
+	// envelopeProfile asks for an enveloped bundle
+	envelopeProfile = "envelope"
+	// unixTimeProfile asks for timestamps in seconds since the unix epoch
+	unixTimeProfile = "unix-time"
+	// msDurationsProfile asks for durations in milliseconds
+	msDurationsProfile = "ms-durations"
+)
+
+// acceptProfiles returns the profiles listed by the Accept header
+func acceptProfiles(c okapi.C) []string {
+	var profiles []string
+	for _, accept := range strings.Split(c.Header("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil {
+			profiles = append(profiles, strings.Fields(params["profile"])...)
+		}
+	}
+	return profiles
+}
+
+// wantsEnvelope reports whether the request asks for the bundle wrapped in an envelope,
+// with ?envelope=true or an Accept: application/json; profile="envelope" header
+func wantsEnvelope(c okapi.C) bool {
+	if v := c.Query("envelope"); v != "" {
+		envelope, _ := strconv.ParseBool(v)
+		return envelope
+	}
+	return slices.Contains(acceptProfiles(c), envelopeProfile)
+}
+
+// renderOptions returns the timestamp and duration renderings asked for with ?timeFormat=
+// and ?durationFormat=, or with the unix-time and ms-durations Accept profiles
+func renderOptions(c okapi.C) (provider.RenderOptions, error) {
+	opts := provider.RenderOptions{TimeFormat: c.Query("timeFormat"), DurationFormat: c.Query("durationFormat")}
+	profiles := acceptProfiles(c)
+	if opts.TimeFormat == "" && slices.Contains(profiles, unixTimeProfile) {
+		opts.TimeFormat = provider.TimeFormatUnix
+	}
+	if opts.DurationFormat == "" && slices.Contains(profiles, msDurationsProfile) {
+		opts.DurationFormat = provider.DurationFormatMillis
+	}
+	switch opts.TimeFormat {
+	case "", provider.TimeFormatRFC3339, provider.TimeFormatUnix:
+	default:
+		return opts, fmt.Errorf("timeFormat must be %s or %s", provider.TimeFormatRFC3339, provider.TimeFormatUnix)
+	}
+	switch opts.DurationFormat {
+	case "", provider.DurationFormatString, provider.DurationFormatMillis:
+	default:
+		return opts, fmt.Errorf("durationFormat must be %s or %s", provider.DurationFormatString, provider.DurationFormatMillis)
+	}
+	return opts, nil
+}
+
+// contentEncoding returns the content encoding to serve the bundle with, from the Accept-Encoding
+// header: brotli, then gzip, or none when the client accepts neither
+func contentEncoding(acceptEncoding string) string {
+	var encoding string
+	best := 0.0
+	for _, accept := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(accept), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "*":
+			name = provider.EncodingBrotli
+		case provider.EncodingBrotli, provider.EncodingGzip:
+		default:
+			continue
+		}
+		// On equal weights, brotli wins over gzip
+		if q > best || (q == best && q > 0 && name == provider.EncodingBrotli) {
+			encoding, best = name, q
+		}
+	}
+	return encoding
+}
+
+// wantsDelta reports whether the Prefer header asks for a JSON Patch delta
+func wantsDelta(prefer string) bool {
+	for _, pref := range strings.Split(prefer, ",") {
+		if strings.EqualFold(strings.TrimSpace(pref), "return=delta") {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// defaultConfigWait is how long a watch waits for a change when the client sets no wait preference
+	defaultConfigWait = 30 * time.Second
+	// maxConfigWait caps the wait preference of a watch
+	maxConfigWait = 5 * time.Minute
+)
+
+// ConfigChange is the configuration and checksum a watch request is now served
+type ConfigChange struct {
+	ConfigID string `json:"configId"`
+	Checksum string `json:"checksum"`
+}
+
+// configWait returns the wait preference of a Prefer header, such as wait=60, in seconds
+func configWait(prefer string) time.Duration {
+	for _, pref := range strings.Split(prefer, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+		if !strings.EqualFold(name, "wait") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return min(time.Duration(seconds)*time.Second, maxConfigWait)
+		}
+	}
+	return defaultConfigWait
+}
+
+// WatchConfig long-polls the config of the request. It answers right away when the If-None-Match
+// checksum is not the one served, and otherwise waits for the configuration to change, for up to
+// the Prefer wait, before answering the new checksum, 404 when the configuration was removed with
+// nothing else matching, or 304 when nothing changed.
+func (p *ProviderService) WatchConfig(c okapi.C) error {
+	_, cfg, err := p.configBundle(c)
+	if err != nil {
+		return abortConfig(c, err)
+	}
+	if err := p.Provider.Authenticate(c.Request(), cfg, config.OperationRead); err != nil {
+		return c.AbortUnauthorized("Unauthorized", err)
+	}
+	// Subscribe before resolving again, so that a change in between is not missed
+	changes, cancel := p.Provider.Subscribe(cfg.ID)
+	defer cancel()
+	timeout := time.NewTimer(configWait(c.Header("Prefer")))
+	defer timeout.Stop()
+	held := c.Header("If-None-Match")
+	for {
+		bundle, current, err := p.resolveConfig(c.Request().Context(), c)
+		if err != nil {
+			return abortConfig(c, err)
+		}
+		if current.ID != cfg.ID || !provider.ETagMatches(held, bundle.Checksum) {
+			c.SetHeader("ETag", provider.WeakETag(bundle.Checksum))
+			return c.OK(ConfigChange{ConfigID: current.ID, Checksum: bundle.Checksum})
+		}
+		select {
+		case <-changes:
+		case <-timeout.C:
+			return c.AbortWithStatus(http.StatusNotModified, "No change")
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// DrainGuard rejects config requests with 503 and Retry-After while the provider is draining
+func (p *ProviderService) DrainGuard(next okapi.HandlerFunc) okapi.HandlerFunc {
+	return func(c *okapi.Context) error {
+		if draining, retryAfter := p.Provider.Draining(); draining {
+			c.SetHeader("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			return c.AbortServiceUnavailable("Provider is draining")
+		}
+		return next(c)
+	}
+}
+
+// EnterDrain puts the provider in drain mode, with an optional retryAfter in seconds
+func (p *ProviderService) EnterDrain(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	var retryAfter time.Duration
+	if v := c.Query("retryAfter"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return c.AbortBadRequest("Invalid retryAfter", fmt.Errorf("retryAfter must be a positive number of seconds"))
+		}
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	p.Provider.SetDraining(true, retryAfter)
+	_, retryAfter = p.Provider.Draining()
+	p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditDrainEnter, nil, map[string]string{
+		"retryAfter": retryAfter.String(),
+	})
+	return c.OK(okapi.M{
+		"draining":   true,
+		"retryAfter": int(retryAfter.Seconds()),
+	})
+}
+
+// ExitDrain resumes normal service
+func (p *ProviderService) ExitDrain(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	p.Provider.SetDraining(false, 0)
+	p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditDrainExit, nil, nil)
+	return c.OK(okapi.M{"draining": false})
+}
+
+// SetFlag enables or disables a feature flag at runtime
+func (p *ProviderService) SetFlag(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	name := c.Param("name")
+	enabled, err := strconv.ParseBool(c.Query("enabled"))
+	if err != nil {
+		return c.AbortBadRequest("Invalid enabled value", err)
+	}
+	p.Provider.SetFlag(name, enabled)
+	p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditFlagSet, nil, map[string]string{
+		"flag":    name,
+		"enabled": strconv.FormatBool(enabled),
+	})
+	return c.OK(okapi.M{
+		"flag":    name,
+		"enabled": p.Provider.FlagEnabled(name),
+	})
+}
+
+// ReloadTagged reloads the configurations carrying the tag query parameter, leaving the others untouched
+func (p *ProviderService) ReloadTagged(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	tag := c.Query("tag")
+	if tag == "" {
+		return c.AbortBadRequest("Missing tag", fmt.Errorf("the tag query parameter is required"))
+	}
+	ids, err := p.Provider.ReloadTagged(tag)
+	if errors.Is(err, provider.ErrTagNotFound) {
+		return c.AbortNotFound("Tag not found", err)
+	}
+	p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditReload, ids, map[string]string{"tag": tag})
+	if err != nil {
+		return c.AbortInternalServerError("Reload failed", err)
+	}
+	return c.OK(okapi.M{
+		"status": "reloaded",
+		"tag":    tag,
+		"ids":    ids,
+	})
+}
+
+// SetRouteEnabled disables or re-enables a route until the next reload, in the configuration
+// selected by the id query parameter or in every configuration having the route
+func (p *ProviderService) SetRouteEnabled(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	name := c.Param("name")
+	enabled, err := strconv.ParseBool(c.Query("enabled"))
+	if err != nil {
+		return c.AbortBadRequest("Invalid enabled value", err)
+	}
+	toggles, err := p.Provider.SetRouteEnabled(c.Query("id"), name, enabled)
+	if err != nil {
+		if errors.Is(err, provider.ErrRouteNotFound) {
+			return c.AbortNotFound("Route not found", err)
+		}
+		return c.AbortInternalServerError("Failed to toggle route", err)
+	}
+	ids := make([]string, len(toggles))
+	for i, toggle := range toggles {
+		ids[i] = toggle.ConfigID
+	}
+	p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditRouteSet, ids, map[string]string{
+		"route":   name,
+		"enabled": strconv.FormatBool(enabled),
+	})
+	return c.OK(okapi.M{
+		"route":   name,
+		"enabled": enabled,
+		"configs": toggles,
+	})
+}
+
+// maxBatchItems bounds the metadata sets resolved by one batch request
+const maxBatchItems = 100
+
+// BatchRequest lists the metadata sets to resolve in one call
+type BatchRequest struct {
+	Items []map[string]string `json:"items"`
+	// ChecksumOnly omits the bundles, returning only their checksums
+	ChecksumOnly bool `json:"checksumOnly,omitempty"`
+}
+
+// BatchItem is the outcome of resolving one metadata set of a batch
+type BatchItem struct {
+	Index    int                  `json:"index"`
+	Status   int                  `json:"status"`
+	ConfigID string               `json:"configId,omitempty"`
+	Checksum string               `json:"checksum,omitempty"`
+	Bundle   *config.ConfigBundle `json:"bundle,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+// BatchResponse holds the outcome of each metadata set, in request order
+type BatchResponse struct {
+	Items []BatchItem `json:"items"`
+}
+
+// GetConfigBatch resolves several metadata sets in one call. Each item is matched and
+// authenticated on its own, a failing item is reported without failing the batch.
+func (p *ProviderService) GetConfigBatch(c okapi.C) error {
+	var req BatchRequest
+	if err := c.BindJSON(&req); err != nil {
+		return c.AbortBadRequest("Invalid request body", err)
+	}
+	if len(req.Items) > maxBatchItems {
+		return c.AbortBadRequest("Invalid request body", fmt.Errorf("at most %d items are allowed", maxBatchItems))
+	}
+
+	resp := BatchResponse{Items: make([]BatchItem, 0, len(req.Items))}
+	for i, metadata := range req.Items {
+		item := BatchItem{Index: i}
+		metadata = p.Provider.ResolveMetadata(c.Request(), metadata)
+		bundle, cfg, err := p.Provider.GetConfig(c.Request().Context(), metadata)
+		switch {
+		case err != nil:
+			item.Status, item.Error = http.StatusNotFound, "Config not found"
+		case p.Provider.Authenticate(c.Request(), cfg, config.OperationRead) != nil:
+			item.Status, item.Error = http.StatusUnauthorized, "Unauthorized"
+		default:
+			item.Status, item.ConfigID, item.Checksum = http.StatusOK, cfg.ID, bundle.Checksum
+			if !req.ChecksumOnly {
+				item.Bundle = bundle
+			}
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	return c.OK(resp)
+}
+
+// ValidateRemoteRequest is the candidate bundle to validate
+type ValidateRemoteRequest struct {
+	URL  string           `json:"url"`
+	Auth *config.HTTPAuth `json:"auth,omitempty"`
+	// ConfigID selects the configuration the candidate is loaded as, applying its settings and metadata
+	ConfigID string `json:"configId,omitempty"`
+}
+
+// ValidateRemote fetches and validates a candidate bundle without registering it
+func (p *ProviderService) ValidateRemote(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	var req ValidateRemoteRequest
+	if err := c.BindJSON(&req); err != nil {
+		return c.AbortBadRequest("Invalid request body", err)
+	}
+	if req.URL == "" {
+		return c.AbortBadRequest("Invalid request body", fmt.Errorf("url is required"))
+	}
+	result, err := p.Provider.ValidateRemote(c.Request().Context(), req.URL, req.Auth, req.ConfigID)
+	if err != nil {
+		return c.AbortNotFound("Configuration not found", err)
+	}
+	p.Provider.Audit(c.Request(), c.RealIP(), provider.AuditValidateRemote, nil, map[string]string{"url": redactURL(req.URL)})
+	if !result.Valid {
+		return c.JSON(http.StatusUnprocessableEntity, result)
+	}
+	return c.OK(result)
+}
+
+// DumpState returns a sanitized snapshot of the provider state: the configurations, their
+// cache entries and the recent reloads, without secrets
+func (p *ProviderService) DumpState(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	dump, err := p.Provider.DumpState()
+	if err != nil {
+		return c.AbortInternalServerError("Failed to dump the provider state", err)
+	}
+	return c.OK(dump)
+}
+
+// PreviewMetadataRequest is the metadata proposed for a new configuration
+type PreviewMetadataRequest struct {
+	Metadata      map[string]string `json:"metadata"`
+	MatchStrategy string            `json:"matchStrategy,omitempty"`
+}
+
+// PreviewMetadata reports the configurations a configuration with the proposed
+// metadata would collide with or take requests from, without adding it
+func (p *ProviderService) PreviewMetadata(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	var req PreviewMetadataRequest
+	if err := c.BindJSON(&req); err != nil {
+		return c.AbortBadRequest("Invalid request body", err)
+	}
+	preview, err := p.Provider.PreviewMetadata(req.Metadata, req.MatchStrategy)
+	if err != nil {
+		return c.AbortBadRequest("Invalid request body", err)
+	}
+	return c.OK(preview)
+}
+
+// GetEffectiveConfig returns the fully resolved bundle of the request along with
+// the transformations applied to produce it
+func (p *ProviderService) GetEffectiveConfig(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	bundle, cfg, err := p.configBundle(c)
+	if err != nil {
+		return abortConfig(c, err)
+	}
+	return c.OK(p.Provider.Effective(c.Request(), bundle, cfg, configSource(c)))
+}
+
+// dotMediaType is the media type of Graphviz DOT documents
+const dotMediaType = "text/vnd.graphviz"
+
+// GetGraph returns the graph of the routes of the matched bundle and the middlewares they
+// reference, in the Graphviz DOT language when the client accepts text/vnd.graphviz
+func (p *ProviderService) GetGraph(c okapi.C) error {
+	if err := p.Provider.AuthenticateAdmin(c.Request()); err != nil {
+		return abortAdmin(c, err)
+	}
+	bundle, cfg, err := p.configBundle(c)
+	if err != nil {
+		return abortConfig(c, err)
+	}
+	graph := provider.Graph(cfg.ID, bundle)
+	for _, accept := range c.Accept() {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == dotMediaType {
+			return c.Data(http.StatusOK, dotMediaType, []byte(graph.DOT()))
+		}
+	}
+	return c.OK(graph)
+}
+
+// redactURL hides the password of a URL for logging
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// abortConfig rejects a request whose configuration could not be resolved
+func abortConfig(c okapi.C, err error) error {
+	if errors.Is(err, provider.ErrMatchStrategy) {
+		return c.AbortBadRequest("Invalid match strategy", err)
+	}
+	if errors.Is(err, provider.ErrMetadataConflict) {
+		return c.AbortBadRequest("Conflicting metadata", err)
+	}
+	if errors.Is(err, provider.ErrStaleExpired) {
+		return c.AbortServiceUnavailable("Config is stale", err)
+	}
+	return c.AbortNotFound("Config not found", err)
+}
+
+// abortAdmin rejects a request that failed admin authentication
+func abortAdmin(c okapi.C, err error) error {
+	if errors.Is(err, provider.ErrAdminDisabled) {
+		return c.AbortForbidden("Forbidden", err)
+	}
+	return c.AbortUnauthorized("Unauthorized", err)
+}
+
+// Metrics exposes provider metrics in the Prometheus text format
+func (p *ProviderService) Metrics(c okapi.C) error {
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf); err != nil {
+		return c.AbortInternalServerError("Failed to collect metrics", err)
+	}
+	return c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
 }
+
+// resolvedConfigKey stores the config resolved for a request in its context,
+// so middlewares and handlers of the same request share one match
+const resolvedConfigKey = "goma.resolvedConfig"
+
+type resolvedConfig struct {
+	bundle *config.ConfigBundle
+	cfg    *config.Configuration
+	source string
+	err    error
+}
+
+// configBundle resolves the config of the request once, later calls reuse the first resolution
 func (p *ProviderService) configBundle(c okapi.C) (*config.ConfigBundle, *config.Configuration, error) {
+	if v, ok := c.Get(resolvedConfigKey); ok {
+		if resolved, ok := v.(resolvedConfig); ok {
+			return resolved.bundle, resolved.cfg, resolved.err
+		}
+	}
+	ctx := provider.WithConfigSource(c.Request().Context())
+	bundle, cfg, err := p.resolveConfig(ctx, c)
+	c.Set(resolvedConfigKey, resolvedConfig{bundle: bundle, cfg: cfg, source: provider.ConfigSource(ctx), err: err})
+	return bundle, cfg, err
+}
+
+// configSource returns how the config resolved for the request was produced
+func configSource(c okapi.C) string {
+	if v, ok := c.Get(resolvedConfigKey); ok {
+		if resolved, ok := v.(resolvedConfig); ok {
+			return resolved.source
+		}
+	}
+	return ""
+}
+
+func (p *ProviderService) resolveConfig(ctx context.Context, c okapi.C) (*config.ConfigBundle, *config.Configuration, error) {
+	if err := p.Provider.MetadataConflicts(c.Request()); err != nil {
+		return nil, nil, err
+	}
 	metadata := p.Provider.ExtractMetadata(c.Request())
 
-	bundle, cfg, err := p.Provider.GetConfig(c.Request().Context(), metadata)
+	var (
+		bundle *config.ConfigBundle
+		cfg    *config.Configuration
+		err    error
+	)
+	if id := p.Provider.ExtractConfigID(c.Request()); id != "" {
+		bundle, cfg, err = p.Provider.GetConfigByID(ctx, id, metadata)
+	} else {
+		bundle, cfg, err = p.Provider.GetConfigMatching(ctx, metadata, c.Query("match"))
+	}
 	if err != nil {
 		return nil, nil, err
 	}